@@ -0,0 +1,149 @@
+// BCP 14 (RFC 2119 and RFC 8174) keywords: the boilerplate directive and the
+// lint checks on their use.
+
+package mmark
+
+import (
+	"bytes"
+	"strings"
+)
+
+const bcp14Directive = "{bcp14-boilerplate}"
+
+// bcp14Boilerplate is the paragraph from section 2 of RFC 8174, inserted by
+// {bcp14-boilerplate}.
+const bcp14Boilerplate = `The key words "**MUST**", "**MUST NOT**", "**REQUIRED**", "**SHALL**",
+"**SHALL NOT**", "**SHOULD**", "**SHOULD NOT**", "**RECOMMENDED**", "**NOT RECOMMENDED**",
+"**MAY**", and "**OPTIONAL**" in this document are to be interpreted as described in
+BCP 14 [@!RFC2119] [@!RFC8174] when, and only when, they appear in all capitals, as
+shown here.
+`
+
+// lowercase2119 are the lowercase keywords that are reported when a document
+// uses BCP 14. "required", "recommended" and "optional" are left out, they are
+// too common in ordinary prose.
+var lowercase2119 = map[string]bool{
+	"must":   true,
+	"shall":  true,
+	"should": true,
+	"may":    true,
+}
+
+// bcp14 keeps track of the use of BCP 14 keywords in a document.
+type bcp14 struct {
+	used          bool         // the document uses BCP 14 keywords
+	boilerplate   bool         // the RFC 8174 boilerplate was seen
+	inBoilerplate bool         // rendering a boilerplate paragraph
+	inKeyword     bool         // rendering a keyword in bold
+	lowercase     []Diagnostic // lowercase keywords, only reported when the document uses BCP 14
+}
+
+// isBCP14Directive returns the length of a {bcp14-boilerplate} line.
+func isBCP14Directive(data []byte) int {
+	if !bytes.HasPrefix(data, []byte(bcp14Directive)) {
+		return 0
+	}
+	i := len(bcp14Directive)
+	for i < len(data) && data[i] != '\n' {
+		if !isspace(data[i]) {
+			return 0
+		}
+		i++
+	}
+	return i
+}
+
+// boilerplate renders the RFC 8174 boilerplate, the citations of RFC 2119 and
+// RFC 8174 it contains are added as normative references.
+func (p *parser) boilerplate(out *bytes.Buffer) {
+	p.renderParagraph(out, []byte(bcp14Boilerplate))
+}
+
+// isBoilerplate2119 tells if the paragraph in data is a BCP 14 boilerplate,
+// either the one from RFC 8174 or the older one from RFC 2119. The second
+// value is true for the one from RFC 8174.
+func isBoilerplate2119(data []byte) (bool, bool) {
+	text := strings.Join(strings.Fields(string(data)), " ")
+	if !strings.Contains(text, "are to be interpreted as described in") {
+		return false, false
+	}
+	return true, strings.Contains(text, "BCP 14") && strings.Contains(text, "when, and only when")
+}
+
+// lintBoilerplate is called for every paragraph before it is rendered. A
+// boilerplate paragraph quotes the keywords, so they are not checked in it.
+func (p *parser) lintBoilerplate(data []byte) {
+	ok, rfc8174 := isBoilerplate2119(data)
+	if !ok {
+		return
+	}
+	if rfc8174 {
+		p.bcp14.boilerplate = true
+	} else {
+		p.mark(data)
+		warnf(p, "bcp14", "BCP 14 boilerplate is not the one from RFC 8174, use {bcp14-boilerplate}")
+	}
+	p.bcp14.inBoilerplate = true
+}
+
+// lintKeywords checks the words in text for BCP 14 keywords that are not in bold
+// and for lowercase ones.
+func (p *parser) lintKeywords(text []byte) {
+	if p.bcp14.inBoilerplate || p.bcp14.inKeyword {
+		return
+	}
+	for i := 0; i < len(text); {
+		if !isletter(text[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(text) && isalnum(text[j]) {
+			j++
+		}
+		// only whole words
+		if i > 0 && (text[i-1] == '-' || text[i-1] == '_') || j < len(text) && (text[j] == '-' || text[j] == '_') {
+			i = j
+			continue
+		}
+		word := string(text[i:j])
+		switch {
+		case words2119[word]:
+			p.bcp14.used = true
+			p.mark(text[i:])
+			warnf(p, "bcp14", "BCP 14 keyword `%s' is not in bold", word)
+		case lowercase2119[word]:
+			p.mark(text[i:])
+			d := Diagnostic{Severity: SEVERITY_INFO, Code: "bcp14"}
+			d.File, d.Line, d.Column = p.position()
+			d.Message = "lowercase `" + word + "' is not a BCP 14 keyword"
+			p.bcp14.lowercase = append(p.bcp14.lowercase, d)
+		}
+		i = j
+	}
+}
+
+// lintBCP14 reports the problems that can only be found after the whole
+// document has been parsed.
+func (p *parser) lintBCP14() {
+	if !p.bcp14.used {
+		return
+	}
+	for _, d := range p.bcp14.lowercase {
+		p.diagnose(d)
+	}
+
+	p.offset = -1 // these apply to the whole document
+	if !p.bcp14.boilerplate {
+		warnf(p, "bcp14", "BCP 14 keywords are used, but the RFC 8174 boilerplate is missing, use {bcp14-boilerplate}")
+	}
+	for _, rfc := range []string{"RFC2119", "RFC8174"} {
+		c, ok := p.citations[rfc]
+		switch {
+		case !ok:
+			warnf(p, "bcp14", "BCP 14 keywords are used, but %s is not cited", rfc)
+		case c.typ != 'n':
+			warnf(p, "bcp14", "%s is cited, but not as a normative reference", rfc)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Unit tests for the BCP 14 boilerplate and lint checks
+
+package mmark
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBCP14Boilerplate(t *testing.T) {
+	input := "{mainmatter}\n\n# Conventions\n\n{bcp14-boilerplate}\n\nText.\n\n{backmatter}\n"
+	out := Parse([]byte(input), XmlRenderer(XML_STANDALONE), commonXmlExtensions).String()
+	for _, e := range []string{
+		"<t>\nThe key words &quot;<bcp14>MUST</bcp14>&quot;",
+		`&quot;<bcp14>NOT RECOMMENDED</bcp14>&quot;`,
+		`BCP 14 <xref target="RFC2119"/> <xref target="RFC8174"/> when, and only when`,
+		"<name>Normative References</name>\n<xi:include href=\"" + CitationsRFC + "reference.RFC.2119.xml\"/>\n" +
+			"<xi:include href=\"" + CitationsRFC + "reference.RFC.8174.xml\"/>\n</references>",
+		"shown here.\n</t>\n<t>\nText.\n</t>",
+	} {
+		if !strings.Contains(out, e) {
+			t.Errorf("expected %q in output:\n%s", e, out)
+		}
+	}
+}
+
+func TestLintBCP14(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected []string
+	}{
+		{
+			"{bcp14-boilerplate}\n\nA client **MUST** reply.\n",
+			nil,
+		},
+		{
+			"A client must reply, and may retry.\n",
+			nil,
+		},
+		{
+			"A client MUST reply and may retry, it should **SHOULD NOT** wait.\n",
+			[]string{
+				"<input>:1:10: BCP 14 keyword `MUST' is not in bold",
+				"<input>:1:25: lowercase `may' is not a BCP 14 keyword",
+				"<input>:1:39: lowercase `should' is not a BCP 14 keyword",
+				"<input>: BCP 14 keywords are used, but the RFC 8174 boilerplate is missing, use {bcp14-boilerplate}",
+				"<input>: BCP 14 keywords are used, but RFC2119 is not cited",
+				"<input>: BCP 14 keywords are used, but RFC8174 is not cited",
+			},
+		},
+		{
+			// in the order of the text, although lowercase keywords are only
+			// reported at the end
+			"A client may retry, but MUST reply.\n",
+			[]string{
+				"<input>:1:10: lowercase `may' is not a BCP 14 keyword",
+				"<input>:1:25: BCP 14 keyword `MUST' is not in bold",
+				"<input>: BCP 14 keywords are used, but the RFC 8174 boilerplate is missing, use {bcp14-boilerplate}",
+				"<input>: BCP 14 keywords are used, but RFC2119 is not cited",
+				"<input>: BCP 14 keywords are used, but RFC8174 is not cited",
+			},
+		},
+		{
+			"The key words \"MUST\" and \"MAY\" in this document are to be interpreted as\ndescribed in [@RFC2119].\n\nIt **MAY** be [@!RFC8174]. A MUST-have, `MUST`.\n",
+			[]string{
+				"<input>:1:1: BCP 14 boilerplate is not the one from RFC 8174, use {bcp14-boilerplate}",
+				"<input>: BCP 14 keywords are used, but the RFC 8174 boilerplate is missing, use {bcp14-boilerplate}",
+				"<input>: RFC2119 is cited, but not as a normative reference",
+			},
+		},
+	}
+	for i, test := range tests {
+		opts := Options{Extensions: commonXmlExtensions, Lint: true}
+		diags := ParseWithOptions([]byte(test.input), XmlRenderer(0), opts).Diagnostics
+		if len(diags) != len(test.expected) {
+			t.Errorf("test %d: expected %d diagnostics, got %d: %v", i, len(test.expected), len(diags), diags)
+			continue
+		}
+		for j, d := range diags {
+			if d.String() != test.expected[j] {
+				t.Errorf("test %d: expected %q, got %q", i, test.expected[j], d.String())
+			}
+		}
+	}
+
+	// without Lint nothing is reported
+	input := "A client MUST reply and may retry.\n"
+	if diags := ParseDocument([]byte(input), "", XmlRenderer(0), commonXmlExtensions).Diagnostics; len(diags) != 0 {
+		t.Errorf("expected no diagnostics without lint, got %v", diags)
+	}
+}
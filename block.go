@@ -24,6 +24,17 @@ func (p *parser) block(out *bytes.Buffer, data []byte) {
 
 	// parse out one block-level construct at a time
 	for len(data) > 0 {
+		p.source(data)
+
+		// BCP 14 boilerplate
+		//
+		// {bcp14-boilerplate}
+		if i := isBCP14Directive(data); i > 0 {
+			p.boilerplate(out)
+			data = data[i:]
+			continue
+		}
+
 		// IAL
 		//
 		// {.class #id key=value}
@@ -239,7 +250,7 @@ func (p *parser) block(out *bytes.Buffer, data []byte) {
 		// :   Definition c
 		if p.flags&EXTENSION_DEFINITION_LISTS != 0 {
 			if p.dliPrefix(data) > 0 {
-				data = data[p.list(out, data, _LIST_TYPE_DEFINITION, 0, nil):]
+				data = data[p.list(out, data, LIST_TYPE_DEFINITION, 0, nil):]
 				continue
 			}
 		}
@@ -265,7 +276,7 @@ func (p *parser) block(out *bytes.Buffer, data []byte) {
 				start, _ = strconv.Atoi(string(data[:i-2])) // this cannot fail because we just est. the thing *is* a number, and if it does start is zero anyway.
 			}
 
-			data = data[p.list(out, data, _LIST_TYPE_ORDERED, start, nil):]
+			data = data[p.list(out, data, LIST_TYPE_ORDERED, start, nil):]
 			continue
 		}
 
@@ -274,7 +285,7 @@ func (p *parser) block(out *bytes.Buffer, data []byte) {
 		// ii.  Item 1
 		// ii.  Item 2
 		if p.rliPrefix(data) > 0 {
-			data = data[p.list(out, data, _LIST_TYPE_ORDERED|_LIST_TYPE_ORDERED_ROMAN_LOWER, 0, nil):]
+			data = data[p.list(out, data, LIST_TYPE_ORDERED|LIST_TYPE_ORDERED_ROMAN_LOWER, 0, nil):]
 			continue
 		}
 
@@ -283,7 +294,7 @@ func (p *parser) block(out *bytes.Buffer, data []byte) {
 		// II.  Item 1
 		// II.  Item 2
 		if p.rliPrefixU(data) > 0 {
-			data = data[p.list(out, data, _LIST_TYPE_ORDERED|_LIST_TYPE_ORDERED_ROMAN_UPPER, 0, nil):]
+			data = data[p.list(out, data, LIST_TYPE_ORDERED|LIST_TYPE_ORDERED_ROMAN_UPPER, 0, nil):]
 			continue
 		}
 
@@ -292,7 +303,7 @@ func (p *parser) block(out *bytes.Buffer, data []byte) {
 		// a.  Item 1
 		// b.  Item 2
 		if p.aliPrefix(data) > 0 {
-			data = data[p.list(out, data, _LIST_TYPE_ORDERED|_LIST_TYPE_ORDERED_ALPHA_LOWER, 0, nil):]
+			data = data[p.list(out, data, LIST_TYPE_ORDERED|LIST_TYPE_ORDERED_ALPHA_LOWER, 0, nil):]
 			continue
 		}
 
@@ -301,7 +312,7 @@ func (p *parser) block(out *bytes.Buffer, data []byte) {
 		// A.  Item 1
 		// B.  Item 2
 		if p.aliPrefixU(data) > 0 {
-			data = data[p.list(out, data, _LIST_TYPE_ORDERED|_LIST_TYPE_ORDERED_ALPHA_UPPER, 0, nil):]
+			data = data[p.list(out, data, LIST_TYPE_ORDERED|LIST_TYPE_ORDERED_ALPHA_UPPER, 0, nil):]
 			continue
 		}
 
@@ -311,7 +322,7 @@ func (p *parser) block(out *bytes.Buffer, data []byte) {
 		// (@good)  Item2
 		if i := p.eliPrefix(data); i > 0 {
 			group := data[2 : i-2]
-			data = data[p.list(out, data, _LIST_TYPE_ORDERED|_LIST_TYPE_ORDERED_GROUP, 0, group):]
+			data = data[p.list(out, data, LIST_TYPE_ORDERED|LIST_TYPE_ORDERED_GROUP, 0, group):]
 			continue
 		}
 
@@ -406,6 +417,7 @@ func (p *parser) prefixHeader(out *bytes.Buffer, data []byte) int {
 		end--
 	}
 	if end > i {
+		explicit := id != ""
 		if id == "" && p.flags&EXTENSION_AUTO_HEADER_IDS != 0 {
 			id = createSanitizedAnchorName(string(data[i:end]))
 		}
@@ -413,19 +425,12 @@ func (p *parser) prefixHeader(out *bytes.Buffer, data []byte) int {
 			p.inline(out, data[i:end])
 			return true
 		}
-		if id != "" {
-			if v, ok := p.anchors[id]; ok && p.flags&EXTENSION_UNIQUE_HEADER_IDS != 0 {
-				p.anchors[id]++
-				// anchor found
-				id += "-" + strconv.Itoa(v)
-			} else {
-				p.anchors[id] = 1
-			}
-		}
+		id = p.headerAnchor(id, explicit)
 
 		p.r.SetAttr(p.ial)
 		p.ial = nil
 
+		p.lintHeader(data[i:end], false)
 		p.r.Header(out, work, level, id)
 	}
 	return skip + k
@@ -575,6 +580,7 @@ func (p *parser) specialHeader(out *bytes.Buffer, data []byte) int {
 		end--
 	}
 	if end > i {
+		explicit := id != ""
 		if id == "" && p.flags&EXTENSION_AUTO_HEADER_IDS != 0 {
 			id = createSanitizedAnchorName(string(data[i:end]))
 		}
@@ -582,25 +588,18 @@ func (p *parser) specialHeader(out *bytes.Buffer, data []byte) int {
 			p.inline(out, data[i:end])
 			return true
 		}
+		name := bytes.ToLower(data[i:end])
+		special := bytes.Equal(name, []byte("abstract")) || bytes.Equal(name, []byte("preface"))
+		if special {
+			id = p.headerAnchor(id, explicit)
+		}
 		p.r.SetAttr(p.ial)
 		p.ial = nil
 
-		name := bytes.ToLower(data[i:end])
+		p.lintHeader(name, bytes.Equal(name, []byte("abstract")))
 
 		switch {
-		case bytes.Compare(name, []byte("abstract")) == 0:
-			fallthrough
-		case bytes.Compare(name, []byte("preface")) == 0:
-			name := bytes.ToLower(data[i:end])
-			if id != "" {
-				if v, ok := p.anchors[id]; ok && p.flags&EXTENSION_UNIQUE_HEADER_IDS != 0 {
-					p.anchors[id]++
-					// anchor found
-					id += "-" + strconv.Itoa(v)
-				} else {
-					p.anchors[id] = 1
-				}
-			}
+		case special:
 			p.r.SpecialHeader(out, name, work, id)
 		default: // A note section
 			// There is no id for notes, but we still give it to the method.
@@ -669,6 +668,7 @@ func (p *parser) partHeader(out *bytes.Buffer, data []byte) int {
 		end--
 	}
 	if end > i {
+		explicit := id != ""
 		if id == "" && p.flags&EXTENSION_AUTO_HEADER_IDS != 0 {
 			id = createSanitizedAnchorName(string(data[i:end]))
 		}
@@ -676,15 +676,7 @@ func (p *parser) partHeader(out *bytes.Buffer, data []byte) int {
 			p.inline(out, data[i:end])
 			return true
 		}
-		if id != "" {
-			if v, ok := p.anchors[id]; ok && p.flags&EXTENSION_UNIQUE_HEADER_IDS != 0 {
-				p.anchors[id]++
-				// anchor found
-				id += "-" + strconv.Itoa(v)
-			} else {
-				p.anchors[id] = 1
-			}
-		}
+		id = p.headerAnchor(id, explicit)
 
 		p.r.SetAttr(p.ial)
 		p.ial = nil
@@ -749,15 +741,17 @@ func (p *parser) titleBlockBlock(out *bytes.Buffer, data []byte, doRender bool)
 }
 
 func (p *parser) documentMatter(out *bytes.Buffer, what int) int {
+	p.lint.abstract = false
 	switch what {
-	case _DOC_FRONT_MATTER:
+	case DOC_FRONT_MATTER:
 		p.r.DocumentMatter(out, what)
 		return len(front)
-	case _DOC_MAIN_MATTER:
+	case DOC_MAIN_MATTER:
 		p.r.DocumentMatter(out, what)
 		return len(main)
-	case _DOC_BACK_MATTER:
+	case DOC_BACK_MATTER:
 		p.r.DocumentMatter(out, what)
+		p.resolveCitations()
 		p.r.References(out, p.citations)
 		p.appendix = true
 		return len(back)
@@ -967,7 +961,7 @@ func (p *parser) htmlReference(out *bytes.Buffer, data []byte, doRender bool) in
 			}
 			anchorStr := string(data[anchor+7+1 : i-1])
 			if c, ok := p.citations[anchorStr]; !ok {
-				p.citations[anchorStr] = &citation{xml: data[:end]}
+				p.citations[anchorStr] = &Citation{xml: data[:end]}
 			} else {
 				c.xml = data[:end]
 			}
@@ -1540,7 +1534,7 @@ func (p *parser) tableHeader(out *bytes.Buffer, data []byte) (size int, columns
 
 		if data[i] == ':' {
 			i++
-			columns[col] |= _TABLE_ALIGNMENT_LEFT
+			columns[col] |= TABLE_ALIGNMENT_LEFT
 			dashes++
 		}
 		for data[i] == '-' {
@@ -1549,7 +1543,7 @@ func (p *parser) tableHeader(out *bytes.Buffer, data []byte) (size int, columns
 		}
 		if data[i] == ':' {
 			i++
-			columns[col] |= _TABLE_ALIGNMENT_RIGHT
+			columns[col] |= TABLE_ALIGNMENT_RIGHT
 			dashes++
 		}
 		for data[i] == ' ' {
@@ -2051,16 +2045,16 @@ func (p *parser) list(out *bytes.Buffer, data []byte, flags, start int, group []
 		p.insideList--
 	}()
 	i := 0
-	flags |= _LIST_ITEM_BEGINNING_OF_LIST
+	flags |= LIST_ITEM_BEGINNING_OF_LIST
 	work := func() bool {
 		for i < len(data) {
 			skip := p.listItem(out, data[i:], &flags)
 			i += skip
 
-			if skip == 0 || flags&_LIST_ITEM_END_OF_LIST != 0 {
+			if skip == 0 || flags&LIST_ITEM_END_OF_LIST != 0 {
 				break
 			}
-			flags &= ^_LIST_ITEM_BEGINNING_OF_LIST
+			flags &= ^LIST_ITEM_BEGINNING_OF_LIST
 		}
 		return true
 	}
@@ -2077,9 +2071,9 @@ func (p *parser) list(out *bytes.Buffer, data []byte, flags, start int, group []
 	p.ial = nil
 
 	if p.insideList > 1 {
-		flags |= _LIST_INSIDE_LIST
+		flags |= LIST_INSIDE_LIST
 	} else {
-		flags &= ^_LIST_INSIDE_LIST
+		flags &= ^LIST_INSIDE_LIST
 	}
 
 	p.r.List(out, work, flags, start, group)
@@ -2118,13 +2112,13 @@ func (p *parser) listItem(out *bytes.Buffer, data []byte, flags *int) int {
 		i = p.dliPrefix(data)
 		// reset definition term flag
 		if i > 0 {
-			*flags &= ^_LIST_TYPE_TERM
+			*flags &= ^LIST_TYPE_TERM
 		}
 	}
 	if i == 0 {
 		// if in defnition list, set term flag and continue
-		if *flags&_LIST_TYPE_DEFINITION != 0 {
-			*flags |= _LIST_TYPE_TERM
+		if *flags&LIST_TYPE_DEFINITION != 0 {
+			*flags |= LIST_TYPE_TERM
 		} else {
 			return 0
 		}
@@ -2187,36 +2181,36 @@ gatherlines:
 			p.oliPrefix(chunk) > 0 || p.eliPrefix(chunk) > 0 ||
 			p.dliPrefix(chunk) > 0:
 
-			if *flags&_LIST_TYPE_ORDERED_GROUP == 0 && p.eliPrefix(chunk) > 0 {
+			if *flags&LIST_TYPE_ORDERED_GROUP == 0 && p.eliPrefix(chunk) > 0 {
 				// This ends this list.
-				*flags |= _LIST_ITEM_END_OF_LIST
+				*flags |= LIST_ITEM_END_OF_LIST
 				break gatherlines
 			}
 
 			if containsBlankLine {
 				if indent <= itemIndent &&
-					((*flags&_LIST_TYPE_ORDERED != 0 && p.uliPrefix(chunk) > 0) ||
-						(*flags&_LIST_TYPE_ORDERED == 0 && p.oliPrefix(chunk) > 0) ||
-						(*flags&_LIST_TYPE_ORDERED == 0 && p.aliPrefix(chunk) > 0) ||
-						(*flags&_LIST_TYPE_ORDERED == 0 && p.aliPrefixU(chunk) > 0) ||
-						(*flags&_LIST_TYPE_ORDERED == 0 && p.rliPrefix(chunk) > 0) ||
-						(*flags&_LIST_TYPE_ORDERED == 0 && p.rliPrefixU(chunk) > 0)) {
-					*flags |= _LIST_ITEM_END_OF_LIST
+					((*flags&LIST_TYPE_ORDERED != 0 && p.uliPrefix(chunk) > 0) ||
+						(*flags&LIST_TYPE_ORDERED == 0 && p.oliPrefix(chunk) > 0) ||
+						(*flags&LIST_TYPE_ORDERED == 0 && p.aliPrefix(chunk) > 0) ||
+						(*flags&LIST_TYPE_ORDERED == 0 && p.aliPrefixU(chunk) > 0) ||
+						(*flags&LIST_TYPE_ORDERED == 0 && p.rliPrefix(chunk) > 0) ||
+						(*flags&LIST_TYPE_ORDERED == 0 && p.rliPrefixU(chunk) > 0)) {
+					*flags |= LIST_ITEM_END_OF_LIST
 					break gatherlines
 				}
 
 				if indent <= itemIndent &&
-					((*flags&_LIST_TYPE_DEFINITION != 0 && p.uliPrefix(chunk) > 0) ||
-						(*flags&_LIST_TYPE_DEFINITION != 0 && p.oliPrefix(chunk) > 0) ||
-						(*flags&_LIST_TYPE_DEFINITION != 0 && p.aliPrefix(chunk) > 0) ||
-						(*flags&_LIST_TYPE_DEFINITION != 0 && p.aliPrefixU(chunk) > 0) ||
-						(*flags&_LIST_TYPE_DEFINITION != 0 && p.rliPrefix(chunk) > 0) ||
-						(*flags&_LIST_TYPE_DEFINITION != 0 && p.rliPrefixU(chunk) > 0)) {
-					*flags |= _LIST_ITEM_END_OF_LIST
+					((*flags&LIST_TYPE_DEFINITION != 0 && p.uliPrefix(chunk) > 0) ||
+						(*flags&LIST_TYPE_DEFINITION != 0 && p.oliPrefix(chunk) > 0) ||
+						(*flags&LIST_TYPE_DEFINITION != 0 && p.aliPrefix(chunk) > 0) ||
+						(*flags&LIST_TYPE_DEFINITION != 0 && p.aliPrefixU(chunk) > 0) ||
+						(*flags&LIST_TYPE_DEFINITION != 0 && p.rliPrefix(chunk) > 0) ||
+						(*flags&LIST_TYPE_DEFINITION != 0 && p.rliPrefixU(chunk) > 0)) {
+					*flags |= LIST_ITEM_END_OF_LIST
 					break gatherlines
 				}
 
-				*flags |= _LIST_ITEM_CONTAINS_BLOCK
+				*flags |= LIST_ITEM_CONTAINS_BLOCK
 			}
 
 			// to be a nested list, it must be indented more
@@ -2235,16 +2229,16 @@ gatherlines:
 			// if the header is not indented, it is not nested in the list
 			// and thus ends the list
 			if containsBlankLine && indent < 4 {
-				*flags |= _LIST_ITEM_END_OF_LIST
+				*flags |= LIST_ITEM_END_OF_LIST
 				break gatherlines
 			}
-			*flags |= _LIST_ITEM_CONTAINS_BLOCK
+			*flags |= LIST_ITEM_CONTAINS_BLOCK
 
 		// anything following an empty line is only part
 		// of this item if it is indented 4 spaces
 		// (regardless of the indentation of the beginning of the item)
 		case containsBlankLine && indent < 4:
-			if *flags&_LIST_TYPE_DEFINITION != 0 && i < len(data)-1 {
+			if *flags&LIST_TYPE_DEFINITION != 0 && i < len(data)-1 {
 				// is the next item still a part of this list?
 				next := i
 				for data[next] != '\n' {
@@ -2254,20 +2248,20 @@ gatherlines:
 					next++
 				}
 				if i < len(data)-1 && data[i] != ':' && data[next] != ':' {
-					*flags |= _LIST_ITEM_END_OF_LIST
+					*flags |= LIST_ITEM_END_OF_LIST
 				}
 			} else {
-				*flags |= _LIST_ITEM_END_OF_LIST
+				*flags |= LIST_ITEM_END_OF_LIST
 			}
 			break gatherlines
 
 		// a blank line means this should be parsed as a block
 		case containsBlankLine:
-			*flags |= _LIST_ITEM_CONTAINS_BLOCK
+			*flags |= LIST_ITEM_CONTAINS_BLOCK
 
 		// CommonMark, rule breaks the list, but when indented it belong to the list
 		case p.isHRule(chunk) && indent < 4:
-			*flags |= _LIST_ITEM_END_OF_LIST
+			*flags |= LIST_ITEM_END_OF_LIST
 			break gatherlines
 
 		}
@@ -2283,7 +2277,7 @@ gatherlines:
 
 	// render the contents of the list item
 	var cooked bytes.Buffer
-	if *flags&_LIST_ITEM_CONTAINS_BLOCK != 0 && *flags&_LIST_TYPE_TERM == 0 {
+	if *flags&LIST_ITEM_CONTAINS_BLOCK != 0 && *flags&LIST_TYPE_TERM == 0 {
 		// intermediate render of block li
 		if sublist > 0 {
 			p.block(&cooked, rawBytes[:sublist])
@@ -2348,18 +2342,22 @@ func (p *parser) renderParagraph(out *bytes.Buffer, data []byte) {
 				p.displayMath = true
 			}
 		}
+		if p.opts.Lint {
+			p.lintBoilerplate(data[beg:end])
+		}
 		p.inline(out, data[beg:end])
+		p.bcp14.inBoilerplate = false
 		return true
 	}
 
 	flags := 0
 	if p.insideDefinitionList {
-		flags |= _LIST_TYPE_DEFINITION
+		flags |= LIST_TYPE_DEFINITION
 	}
 	if p.insideList > 0 {
-		flags |= _LIST_INSIDE_LIST // Not really, just in a list
+		flags |= LIST_INSIDE_LIST // Not really, just in a list
 	} else {
-		flags &= ^_LIST_INSIDE_LIST // Not really, just in a list
+		flags &= ^LIST_INSIDE_LIST // Not really, just in a list
 	}
 	p.r.Paragraph(out, work, flags)
 }
@@ -2382,7 +2380,7 @@ func (p *parser) paragraph(out *bytes.Buffer, data []byte) int {
 			// did this blank line followed by a definition list item?
 			if p.flags&EXTENSION_DEFINITION_LISTS != 0 {
 				if i < len(data)-1 && data[i+1] == ':' {
-					return p.list(out, data[prev:], _LIST_TYPE_DEFINITION, 0, nil)
+					return p.list(out, data[prev:], LIST_TYPE_DEFINITION, 0, nil)
 				}
 			}
 
@@ -2419,10 +2417,14 @@ func (p *parser) paragraph(out *bytes.Buffer, data []byte) int {
 				if p.flags&EXTENSION_AUTO_HEADER_IDS != 0 {
 					id = createSanitizedAnchorName(string(data[prev:eol]))
 				}
+				if p.ial == nil || p.ial.id == "" {
+					p.defineAnchor(id)
+				}
 
 				p.r.SetAttr(p.ial)
 				p.ial = nil
 
+				p.lintHeader(data[prev:eol], false)
 				p.r.Header(out, work, level, id)
 
 				// find the end of the underline
@@ -2459,7 +2461,7 @@ func (p *parser) paragraph(out *bytes.Buffer, data []byte) int {
 		// if there's a definition list item, prev line is a definition term
 		if p.flags&EXTENSION_DEFINITION_LISTS != 0 {
 			if p.dliPrefix(current) != 0 {
-				return p.list(out, data[prev:], _LIST_TYPE_DEFINITION, 0, nil)
+				return p.list(out, data[prev:], LIST_TYPE_DEFINITION, 0, nil)
 			}
 		}
 
@@ -2525,7 +2527,7 @@ func isMatter(text []byte) (int, int) {
 	if bytes.HasPrefix(text, []byte(front)) {
 		for i := len(front); i < len(text); i++ {
 			if text[i] == '\n' || text[i] == '\r' {
-				return i - 1, _DOC_FRONT_MATTER
+				return i - 1, DOC_FRONT_MATTER
 			}
 
 			if !isspace(text[i]) {
@@ -2533,12 +2535,12 @@ func isMatter(text []byte) (int, int) {
 			}
 		}
 
-		return len(front), _DOC_FRONT_MATTER
+		return len(front), DOC_FRONT_MATTER
 	}
 	if bytes.HasPrefix(text, []byte(main)) {
 		for i := len(main); i < len(text); i++ {
 			if text[i] == '\n' || text[i] == '\r' {
-				return i - 1, _DOC_MAIN_MATTER
+				return i - 1, DOC_MAIN_MATTER
 			}
 
 			if !isspace(text[i]) {
@@ -2547,19 +2549,19 @@ func isMatter(text []byte) (int, int) {
 
 		}
 
-		return len(main), _DOC_MAIN_MATTER
+		return len(main), DOC_MAIN_MATTER
 	}
 	if bytes.HasPrefix(text, []byte(back)) {
 		for i := len(back); i < len(text); i++ {
 			if text[i] == '\n' || text[i] == '\r' {
-				return i - 1, _DOC_BACK_MATTER
+				return i - 1, DOC_BACK_MATTER
 			}
 			if !isspace(text[i]) {
 				return 0, 0
 			}
 		}
 
-		return len(back), _DOC_BACK_MATTER
+		return len(back), DOC_BACK_MATTER
 	}
 	return 0, 0
 }
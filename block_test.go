@@ -4,13 +4,15 @@ package mmark
 
 import (
 	"io/ioutil"
+	"log"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
 
 func init() {
-	test = true
+	log.SetOutput(ioutil.Discard)
 }
 
 func runMarkdownBlock(input string, extensions int) string {
@@ -412,6 +414,130 @@ func TestUnderlineHeadersAutoIDs(t *testing.T) {
 	doTestsBlock(t, tests, EXTENSION_AUTO_HEADER_IDS)
 }
 
+func TestTableOfContents(t *testing.T) {
+	var tests = []struct {
+		input    string
+		flags    int
+		depth    int
+		expected string
+	}{
+		{
+			"# A\n\n## B\n\n# C\n",
+			HTML_TOC, 0,
+			"<nav class=\"toc\">\n<ul>\n<li><a href=\"#toc_0\">1. A</a>\n<ul>\n<li><a href=\"#toc_1\">1.1. B</a></li>\n</ul></li>\n" +
+				"<li><a href=\"#toc_2\">2. C</a></li>\n</ul>\n</nav>\n" +
+				"<h1 id=\"toc_0\">A</h1>\n\n<h2 id=\"toc_1\">B</h2>\n\n<h1 id=\"toc_2\">C</h1>\n",
+		},
+		{
+			"# A\n\n## B\n\n{backmatter}\n\n# C\n",
+			HTML_OMIT_CONTENTS, 1,
+			"<nav class=\"toc\">\n<ul>\n<li><a href=\"#toc_0\">1. A</a></li>\n<li><a href=\"#toc_2\">A. C</a></li>\n</ul>\n</nav>\n",
+		},
+		{
+			"{frontmatter}\n\n.# Abstract\n\nText.\n\n{mainmatter}\n\n# Intro\n",
+			HTML_TOC, 0,
+			"<h1 class=\"abstract\" id=\"toc_0\">Abstract</h1>\n\n<p>Text.</p>\n" +
+				"<nav class=\"toc\">\n<ul>\n<li><a href=\"#toc_0\">Abstract</a></li>\n<li><a href=\"#toc_1\">1. Intro</a></li>\n</ul>\n</nav>\n" +
+				"\n<h1 id=\"toc_1\">Intro</h1>\n",
+		},
+		{
+			// links are not nested in the link of the table of contents
+			"# A `code` [link](http://x)\n",
+			HTML_TOC, 0,
+			"<nav class=\"toc\">\n<ul>\n<li><a href=\"#toc_0\">1. A <code>code</code> link</a></li>\n</ul>\n</nav>\n" +
+				"<h1 id=\"toc_0\">A <code>code</code> <a href=\"http://x\">link</a></h1>\n",
+		},
+	}
+	for _, test := range tests {
+		renderer := HtmlRendererWithParameters(test.flags, "", "", HtmlRendererParameters{TocDepth: test.depth})
+		actual := Parse([]byte(test.input), renderer, EXTENSION_MATTER).String()
+		if actual != test.expected {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", test.input, test.expected, actual)
+		}
+	}
+}
+
+func TestNumbered(t *testing.T) {
+	input := "# Intro\n\nSee (#terms), (#fig), (#tab), (#app), [this](#app) and (#other).\n\n## Terms {#terms}\n\n" +
+		"{#fig}\n```\nart\n```\nFigure: Art.\n\n{#tab}\n| a |\n|---|\n| 1 |\nTable: Data.\n\n{backmatter}\n\n# More {#app}\n"
+	expected := []string{
+		`<p>See <a href="#terms">Section 1.1</a>, <a href="#fig">Figure 1</a>, <a href="#tab">Table 1</a>, ` +
+			`<a href="#app">Appendix A</a>, <a href="#app">this</a> and <a href="#other">other</a>.</p>`,
+		"<h1 id=\"intro\"><span class=\"section-number\">1.</span> Intro</h1>",
+		"<h2 id=\"terms\"><span class=\"section-number\">1.1.</span> Terms</h2>",
+		"<figcaption>\nFigure 1: Art.\n</figcaption>",
+		"<caption>\nTable 1: Data.\n\n</caption>",
+		"<h1 id=\"app\" class=\"appendix\"><span class=\"section-number\">A.</span> More</h1>",
+	}
+	renderer := HtmlRenderer(HTML_NUMBERED, "", "")
+	extensions := EXTENSION_MATTER | EXTENSION_SHORT_REF | EXTENSION_HEADER_IDS | EXTENSION_AUTO_HEADER_IDS |
+		EXTENSION_FENCED_CODE | EXTENSION_TABLES | EXTENSION_INLINE_ATTR
+	actual := Parse([]byte(input), renderer, extensions).String()
+	for _, e := range expected {
+		if !strings.Contains(actual, e) {
+			t.Errorf("expected %q in output:\n%s", e, actual)
+		}
+	}
+
+	// a NUL in the text is not taken for a cross reference
+	actual = Parse([]byte("# Intro\n\nx\x00y (#intro)\n"), HtmlRenderer(HTML_NUMBERED, "", ""), extensions).String()
+	if expected := "<p>x\uFFFDy <a href=\"#intro\">Section 1</a></p>"; !strings.Contains(actual, expected) {
+		t.Errorf("expected %q in output:\n%s", expected, actual)
+	}
+
+	// without HTML_NUMBERED the output is as it was
+	actual = Parse([]byte("See (#terms).\n"), HtmlRenderer(0, "", ""), EXTENSION_SHORT_REF).String()
+	if expected := "<p>See <a href=\"#terms\"></a>.</p>\n"; actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+}
+
+func TestTitleBlockHTML(t *testing.T) {
+	input := `%%%
+title = "Packet <Mood>"
+category = "info"
+docName = "draft-hay-mood-00"
+date = 2010-04-01T00:00:00Z
+keyword = ["tcp", "mood"]
+obsoletes = [1149]
+
+[[author]]
+initials = "R."
+surname = "Hay"
+fullname = "Richard Hay"
+organization = "Google"
+  [author.address]
+  email = "rhay@google.com"
+%%%
+
+Text.
+`
+	expected := []string{
+		`<meta name="author" content="Richard Hay">`,
+		`<meta name="keywords" content="tcp, mood">`,
+		`<meta name="dc.title" content="Packet &lt;Mood&gt;">`,
+		`<meta name="dc.creator" content="Hay, R.">`,
+		`<meta name="dc.date" content="2010-04-01">`,
+		`<meta name="dc.identifier" content="urn:ietf:id:draft-hay-mood-00">`,
+		`<meta name="dc.relation.replaces" content="urn:ietf:rfc:1149">`,
+		"<body>\n<header class=\"titleblock\">\n<dl class=\"docinfo\">\n",
+		"<dt>Area:</dt>\n<dd class=\"area\">Internet</dd>\n",
+		"<dt>Internet-Draft:</dt>\n<dd class=\"docname\">draft-hay-mood-00</dd>\n",
+		"<dt>Obsoletes:</dt>\n<dd class=\"obsoletes\">1149 (if approved)</dd>\n",
+		`<dd class="published"><time datetime="2010-04-01">April 1, 2010</time></dd>`,
+		"<dt>Intended Status:</dt>\n<dd class=\"status\">Informational</dd>\n",
+		"<dt>Expires:</dt>\n<dd class=\"expires\">October 3, 2010</dd>\n",
+		`<div class="author"><span class="author-name">Richard Hay</span> <span class="organization">Google</span> <a class="email" href="mailto:rhay@google.com">rhay@google.com</a></div>`,
+		"<h1 class=\"title\">Packet &lt;Mood&gt;</h1>\n</header>\n",
+	}
+	actual := Parse([]byte(input), HtmlRenderer(HTML_COMPLETE_PAGE, "", ""), EXTENSION_TITLEBLOCK_TOML).String()
+	for _, e := range expected {
+		if !strings.Contains(actual, e) {
+			t.Errorf("expected %q in output:\n%s", e, actual)
+		}
+	}
+}
+
 func TestHorizontalRule(t *testing.T) {
 	var tests = []string{
 		"-\n",
@@ -1592,9 +1718,12 @@ tedious_code = boring_function()
 interesting_code = fascinating_function()
 // END OMIT`), 0644)
 
-		t1 := "Include some code\n <{{" + f.Name() + "}}[/START OMIT/,/END OMIT/]\n"
+		t1 := "Include some code\n <{{" + filepath.Base(f.Name()) + "}}[/START OMIT/,/END OMIT/]\n"
 		e1 := "<t>\nInclude some code\n </t><artwork>\ninteresting_code = fascinating_function()\n</artwork>\n<t>\n</t>\n"
-		tests = append(tests, []string{t1, e1}...)
+		opts := Options{Extensions: commonXmlExtensions | EXTENSION_UNIQUE_HEADER_IDS | EXTENSION_INCLUDE, FileSource: RootFS(filepath.Dir(f.Name()))}
+		if actual := ParseWithOptions([]byte(t1), XmlRenderer(0), opts).Output.String(); actual != e1 {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", t1, e1, actual)
+		}
 	}
 
 	doTestsBlockXML(t, tests, EXTENSION_INCLUDE)
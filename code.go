@@ -10,7 +10,6 @@ import (
 	"bufio"
 	"bytes"
 	"errors"
-	"io/ioutil"
 	"regexp"
 	"strconv"
 	"unicode/utf8"
@@ -39,20 +38,22 @@ var SourceCodeTypes = map[string]bool{
 	"go": true,
 }
 
-// parseAddress parses a code address directive and returns the bytes.
-func parseAddress(addr []byte, file []byte) []byte {
+// parseAddress parses a code address directive and returns the bytes, the name
+// of the included file and the line number in that file they start on.
+func parseAddress(p *parser, addr []byte, file []byte) ([]byte, string, int) {
 	bytes.TrimSpace(addr)
 
-	textBytes, err := ioutil.ReadFile(string(file))
+	textBytes, name, err := p.readInclude(string(file))
 	if err != nil {
-		printf(nil, "failed: `%s': %s", string(file), err)
-		return nil
+		warnf(p, "include", "failed: `%s': %s", string(file), err)
+		return nil, name, 0
 	}
+	p.dependency(name)
 
 	lo, hi, err := addrToByteRange(string(addr), 0, textBytes)
 	if err != nil {
-		printf(nil, "code include address: %s", err.Error())
-		return textBytes
+		warnf(p, "address", "code include address: %s", err.Error())
+		return textBytes, name, 1
 	}
 
 	// Acme pattern matches can stop mid-line,
@@ -67,7 +68,7 @@ func parseAddress(addr []byte, file []byte) []byte {
 	}
 
 	lines := codeLines(textBytes, lo, hi)
-	return lines
+	return lines, name, bytes.Count(textBytes[:lo], []byte{'\n'}) + 1
 }
 
 // codeLines takes a source file and returns the lines that
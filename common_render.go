@@ -2,7 +2,13 @@
 
 package mmark
 
-import "bytes"
+import (
+	"bytes"
+	xmllib "encoding/xml"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // blockCodePrefix adds the prefix to each line of text and returns it as a byte slice.
 // If prefix is empty, text is returned as-is.
@@ -16,3 +22,210 @@ func blockCodePrefix(prefix string, text []byte) []byte {
 	prefixText = append([]byte(prefix), prefixText...)
 	return prefixText
 }
+
+// sectionNumber hands out hierarchical section numbers: 1, 1.1, 1.2, 2, etc.
+// After the appendix is started the top level is numbered with letters: A,
+// A.1, B, etc.
+type sectionNumber struct {
+	count    []int
+	appendix bool
+}
+
+// startAppendix restarts the numbering for the appendices.
+func (s *sectionNumber) startAppendix() {
+	s.count = s.count[:0]
+	s.appendix = true
+}
+
+// next returns the number of the next section on level, levels start at 1.
+func (s *sectionNumber) next(level int) string {
+	if level < 1 {
+		return ""
+	}
+	for len(s.count) < level {
+		s.count = append(s.count, 0)
+	}
+	s.count = s.count[:level]
+	s.count[level-1]++
+	return s.String()
+}
+
+// String returns the number of the current section, or the empty string
+// before the first one.
+func (s *sectionNumber) String() string {
+	var buf bytes.Buffer
+	for i, c := range s.count {
+		if i > 0 {
+			buf.WriteByte('.')
+		}
+		if i == 0 && s.appendix {
+			buf.WriteString(appendixLetter(c))
+			continue
+		}
+		buf.WriteString(strconv.Itoa(c))
+	}
+	return buf.String()
+}
+
+// appendixLetter returns the letters for appendix n: A to Z, then AA, AB, etc.
+func appendixLetter(n int) string {
+	if n < 1 {
+		return "0"
+	}
+	var b []byte
+	for ; n > 0; n = (n - 1) / 26 {
+		b = append([]byte{byte('A' + (n-1)%26)}, b...)
+	}
+	return string(b)
+}
+
+// intendedStatus returns the long form of the category in the title block.
+func intendedStatus(category string) string {
+	switch category {
+	case "std":
+		return "Standards Track"
+	case "bcp":
+		return "Best Current Practice"
+	case "info":
+		return "Informational"
+	case "exp":
+		return "Experimental"
+	case "historic":
+		return "Historic"
+	}
+	return category
+}
+
+// longDate returns d as "March 1, 2016".
+func longDate(d time.Time) string { return d.Format("January 2, 2006") }
+
+// draftExpires returns the date an Internet-Draft written on d expires.
+func draftExpires(d time.Time) string { return longDate(d.AddDate(0, 0, 185)) }
+
+// authorName returns the name of an author as it is shown in a document.
+func authorName(a Author) string {
+	if a.Fullname != "" {
+		return a.Fullname
+	}
+	if a.Initials == "" {
+		return a.Surname
+	}
+	return a.Initials + " " + a.Surname
+}
+
+// draftName returns docName if it is the name of an Internet-Draft, otherwise
+// the empty string.
+func draftName(docName string) string {
+	if strings.HasPrefix(docName, "draft-") {
+		return docName
+	}
+	return ""
+}
+
+// referenceParts returns the parts of the text of the reference for citation c,
+// in the style of RFC 7322, and the URL of the referenced document. The parts
+// are the authors, the quoted title, the series information and the date, when
+// known. Citations without reference XML only get their series information,
+// if that can be derived from the anchor.
+func referenceParts(p *parser, anchor string, c *Citation) ([]string, string) {
+	if len(c.xml) > 0 {
+		var ref refXML
+		if e := xmllib.Unmarshal(c.xml, &ref); e != nil {
+			warnf(p, "reference", "failed to unmarshal reference: `%s': %s", anchor, e)
+		} else {
+			return ref.parts(), ref.target()
+		}
+	}
+	if bytes.HasPrefix(c.link, []byte("RFC")) {
+		if n, err := strconv.Atoi(string(c.link[3:])); err == nil {
+			rfc := strconv.Itoa(n)
+			return []string{"RFC " + rfc}, "https://www.rfc-editor.org/info/rfc" + rfc
+		}
+	}
+	if f := referenceFile(p, c); f != "" {
+		return []string{anchor}, f
+	}
+	return []string{anchor}, ""
+}
+
+func (ref *refXML) parts() []string {
+	var parts []string
+	if a := ref.authors(); a != "" {
+		parts = append(parts, a)
+	}
+	if ref.Front.Title != "" {
+		parts = append(parts, "\""+ref.Front.Title+"\"")
+	}
+	for _, s := range append(ref.Front.SeriesInfo, ref.SeriesInfo...) {
+		switch s.Name {
+		case "":
+		case "Internet-Draft":
+			parts = append(parts, "Work in Progress", s.Name+", "+s.Value)
+		default:
+			parts = append(parts, s.Name+" "+s.Value)
+		}
+	}
+	if d := ref.Front.Date.String(); d != "" {
+		parts = append(parts, d)
+	}
+	return parts
+}
+
+// authors formats the authors: "Surname, I.", "Surname, I. and I. Surname" or
+// "Surname, I., I. Surname, and I. Surname".
+func (ref *refXML) authors() string {
+	names := []string{}
+	for i, a := range ref.Front.Author {
+		name := ""
+		switch {
+		case a.Surname != "" && a.Initials != "" && i == 0:
+			name = a.Surname + ", " + a.Initials
+		case a.Surname != "" && a.Initials != "":
+			name = a.Initials + " " + a.Surname
+		case a.Fullname != "":
+			name = a.Fullname
+		case a.Surname != "":
+			name = a.Surname
+		default:
+			name = strings.TrimSpace(a.Organization)
+		}
+		if name == "" {
+			continue
+		}
+		if a.Role == "editor" {
+			name += ", Ed."
+		}
+		names = append(names, name)
+	}
+	switch len(names) {
+	case 0:
+		return ""
+	case 1:
+		return names[0]
+	case 2:
+		return names[0] + " and " + names[1]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + ", and " + names[len(names)-1]
+}
+
+// target returns the URL of the referenced document.
+func (ref *refXML) target() string {
+	if ref.Target != "" {
+		return ref.Target
+	}
+	for _, f := range ref.Format {
+		if f.Target != "" {
+			return f.Target
+		}
+	}
+	return ""
+}
+
+// String returns the date as "Month Year".
+func (d refDate) String() string {
+	month := d.Month
+	if n, err := strconv.Atoi(month); err == nil && n >= 1 && n <= 12 {
+		month = time.Month(n).String()
+	}
+	return strings.TrimSpace(month + " " + d.Year)
+}
@@ -0,0 +1,449 @@
+// Syntax highlighting of code blocks in HTML.
+
+package mmark
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// The CSS classes of the highlighted tokens.
+const (
+	hlComment = "hl-comment"
+	hlKeyword = "hl-keyword"
+	hlType    = "hl-type" // types, builtins and literals like true
+	hlString  = "hl-string"
+	hlNumber  = "hl-number"
+	hlTag     = "hl-tag"  // XML tag names
+	hlAttr    = "hl-attr" // XML attribute names
+)
+
+// HighlightStyle is the style sheet for the CSS classes of HTML_HIGHLIGHT, it
+// is included in a complete page when no other style sheet is given.
+const HighlightStyle = `.hl-comment { color: #6a737d; font-style: italic; }
+.hl-keyword { color: #a626a4; font-weight: bold; }
+.hl-type { color: #c18401; }
+.hl-string { color: #50a14f; }
+.hl-number { color: #986801; }
+.hl-tag { color: #e45649; }
+.hl-attr { color: #986801; }
+`
+
+// token is a highlighted part of code, from start up to end.
+type token struct {
+	start, end int
+	class      string
+}
+
+// language describes the lexical syntax of a language, enough to find its
+// comments, strings, numbers and keywords.
+type language struct {
+	lineComment  []string
+	blockComment [][2]string
+	quotes       string // characters that start and end a string
+	triple       bool   // strings can be delimited by three quotes, as in Python
+	identChars   string // characters, apart from letters, digits and '_', allowed in identifiers
+	keywords     map[string]bool
+	types        map[string]bool
+}
+
+func words(s string) map[string]bool {
+	m := make(map[string]bool)
+	for _, w := range strings.Fields(s) {
+		m[w] = true
+	}
+	return m
+}
+
+var (
+	cKeywords = "auto break case const continue default do else enum extern for goto if inline register " +
+		"restrict return sizeof static struct switch typedef union volatile while"
+	cTypes = "bool char double float int long short signed unsigned void size_t " +
+		"int8_t int16_t int32_t int64_t uint8_t uint16_t uint32_t uint64_t NULL true false"
+	cComments = [][2]string{{"/*", "*/"}}
+)
+
+// languages are the languages that can be highlighted, the names are those of
+// SourceCodeTypes. XML and DTDs have their own highlighter.
+var languages = map[string]*language{
+	"abnf": {
+		lineComment: []string{";"},
+		quotes:      `"`,
+		identChars:  "-",
+		types:       words("ALPHA BIT CHAR CR CRLF CTL DIGIT DQUOTE HEXDIG HTAB LF LWSP OCTET SP VCHAR WSP"),
+	},
+	"asn.1": {
+		lineComment: []string{"--"},
+		quotes:      `"`,
+		identChars:  "-",
+		keywords: words("ABSENT ALL APPLICATION AUTOMATIC BEGIN BY CHOICE COMPONENTS DEFAULT DEFINITIONS END " +
+			"EXPLICIT EXPORTS EXTENSIBILITY FROM IDENTIFIER IMPLICIT IMPLIED IMPORTS INCLUDES MAX MIN OF OPTIONAL " +
+			"PRIVATE SEQUENCE SET SIZE TAGS UNIVERSAL WITH"),
+		types: words("BIT BMPString BOOLEAN ENUMERATED FALSE GeneralizedTime IA5String INTEGER NULL OBJECT OCTET " +
+			"PrintableString REAL STRING TRUE UTCTime UTF8String"),
+	},
+	"bash": {
+		lineComment: []string{"#"},
+		quotes:      `"'`,
+		keywords: words("case do done elif else esac exit export fi for function if in local return select " +
+			"then until while"),
+		types: words("cd echo eval exec printf read set shift source test trap unset"),
+	},
+	"c": {
+		lineComment:  []string{"//"},
+		blockComment: cComments,
+		quotes:       `"'`,
+		keywords:     words(cKeywords),
+		types:        words(cTypes),
+	},
+	"c++": {
+		lineComment:  []string{"//"},
+		blockComment: cComments,
+		quotes:       `"'`,
+		keywords: words(cKeywords + " catch class delete explicit friend namespace new noexcept operator " +
+			"private protected public template this throw try typename using virtual"),
+		types: words(cTypes + " auto nullptr std string vector"),
+	},
+	"cddl": {
+		lineComment: []string{";"},
+		quotes:      `"'`,
+		identChars:  "-.@$",
+		types: words("any bool bstr bytes false float float16 float32 float64 int nil nint null text true tstr " +
+			"uint undefined"),
+	},
+	"go": {
+		lineComment:  []string{"//"},
+		blockComment: cComments,
+		quotes:       "\"'`",
+		keywords: words("break case chan const continue default defer else fallthrough for func go goto if " +
+			"import interface map package range return select struct switch type var"),
+		types: words("bool byte complex128 complex64 error float32 float64 int int16 int32 int64 int8 rune " +
+			"string uint uint16 uint32 uint64 uint8 uintptr any true false nil iota append cap close copy " +
+			"delete len make new panic print println recover"),
+	},
+	"java": {
+		lineComment:  []string{"//"},
+		blockComment: cComments,
+		quotes:       `"'`,
+		keywords: words("abstract assert break case catch class continue default do else enum extends final " +
+			"finally for if implements import instanceof interface native new package private protected " +
+			"public return static super switch synchronized this throw throws try volatile while"),
+		types: words("boolean byte char double float int long short void String Object true false null"),
+	},
+	"javascript": {
+		lineComment:  []string{"//"},
+		blockComment: cComments,
+		quotes:       "\"'`",
+		keywords: words("async await break case catch class const continue default delete do else export " +
+			"extends finally for function if import in instanceof let new of return switch this throw try " +
+			"typeof var void while yield"),
+		types: words("true false null undefined NaN Infinity"),
+	},
+	"json": {
+		quotes: `"`,
+		types:  words("true false null"),
+	},
+	"mib": {
+		lineComment: []string{"--"},
+		quotes:      `"`,
+		identChars:  "-",
+		keywords: words("ACCESS AGENT-CAPABILITIES AUGMENTS BEGIN CONTACT-INFO DEFINITIONS DEFVAL DESCRIPTION " +
+			"DISPLAY-HINT END FROM GROUP IMPORTS INDEX LAST-UPDATED MAX-ACCESS MIN-ACCESS MODULE " +
+			"MODULE-COMPLIANCE MODULE-IDENTITY NOTIFICATION-GROUP NOTIFICATION-TYPE OBJECT-GROUP OBJECT-IDENTITY " +
+			"OBJECT-TYPE OBJECTS ORGANIZATION REFERENCE REVISION SEQUENCE SIZE STATUS SYNTAX TEXTUAL-CONVENTION " +
+			"UNITS"),
+		types: words("Counter32 Counter64 Gauge32 INTEGER Integer32 IpAddress OBJECT IDENTIFIER OCTET STRING " +
+			"TimeTicks Unsigned32"),
+	},
+	"perl": {
+		lineComment: []string{"#"},
+		quotes:      `"'`,
+		keywords: words("else elsif for foreach if last local my next our package require return sub unless " +
+			"until use while"),
+		types: words("chomp defined die join keys length print printf push shift split undef"),
+	},
+	"python": {
+		lineComment: []string{"#"},
+		quotes:      `"'`,
+		triple:      true,
+		keywords: words("and as assert async await break class continue def del elif else except finally for " +
+			"from global if import in is lambda nonlocal not or pass raise return try while with yield"),
+		types: words("True False None bool bytes dict float int len list print range self set str tuple"),
+	},
+	"rnc": {
+		lineComment: []string{"#"},
+		quotes:      `"'`,
+		identChars:  "-.",
+		keywords: words("attribute datatypes default div element empty external grammar include inherit list " +
+			"mixed namespace notAllowed parent start string text token"),
+	},
+}
+
+func init() {
+	languages["cbor"] = languages["cddl"]
+}
+
+// highlight returns the tokens of code in lang, the parts of code that are
+// not in a token are not highlighted. The tokens are ordered and don't overlap.
+// If lang can't be highlighted nil is returned.
+func highlight(lang string, code []byte) []token {
+	lang = strings.ToLower(lang)
+	if lang == "xml" || lang == "dtd" {
+		return highlightXML(code)
+	}
+	l, ok := languages[lang]
+	if !ok {
+		return nil
+	}
+	return l.tokens(code)
+}
+
+func (l *language) tokens(code []byte) []token {
+	var toks []token
+	i := 0
+Tokens:
+	for i < len(code) {
+		for _, c := range l.lineComment {
+			if bytes.HasPrefix(code[i:], []byte(c)) {
+				end := bytes.IndexByte(code[i:], '\n')
+				if end < 0 {
+					end = len(code) - i
+				}
+				toks = append(toks, token{i, i + end, hlComment})
+				i += end
+				continue Tokens
+			}
+		}
+		for _, c := range l.blockComment {
+			if bytes.HasPrefix(code[i:], []byte(c[0])) {
+				end := bytes.Index(code[i+len(c[0]):], []byte(c[1]))
+				if end < 0 {
+					end = len(code)
+				} else {
+					end += i + len(c[0]) + len(c[1])
+				}
+				toks = append(toks, token{i, end, hlComment})
+				i = end
+				continue Tokens
+			}
+		}
+
+		ch := code[i]
+		switch {
+		case strings.IndexByte(l.quotes, ch) >= 0:
+			end := l.stringEnd(code, i)
+			toks = append(toks, token{i, end, hlString})
+			i = end
+		case isnum(ch) || (ch == '%' && i+1 < len(code) && strings.IndexByte("bdx", code[i+1]) >= 0):
+			// ABNF has numeric values like %x41-5A
+			end := i + 1
+			for end < len(code) && (isalnum(code[end]) || code[end] == '.' || (ch == '%' && code[end] == '-')) {
+				end++
+			}
+			toks = append(toks, token{i, end, hlNumber})
+			i = end
+		case l.isIdent(ch):
+			end := i + 1
+			for end < len(code) && l.isIdent(code[end]) {
+				end++
+			}
+			word := string(code[i:end])
+			switch {
+			case l.keywords[word]:
+				toks = append(toks, token{i, end, hlKeyword})
+			case l.types[word]:
+				toks = append(toks, token{i, end, hlType})
+			}
+			i = end
+		default:
+			i++
+		}
+	}
+	return toks
+}
+
+func (l *language) isIdent(c byte) bool {
+	return isalnum(c) || c == '_' || c >= 0x80 || strings.IndexByte(l.identChars, c) >= 0
+}
+
+// stringEnd returns the end of the string that starts at code[i].
+func (l *language) stringEnd(code []byte, i int) int {
+	q := code[i]
+	if l.triple && bytes.HasPrefix(code[i:], []byte{q, q, q}) {
+		end := bytes.Index(code[i+3:], []byte{q, q, q})
+		if end < 0 {
+			return len(code)
+		}
+		return i + 3 + end + 3
+	}
+	for j := i + 1; j < len(code); j++ {
+		switch code[j] {
+		case '\\':
+			if q != '`' {
+				j++
+			}
+		case '\n':
+			if q != '`' {
+				// unterminated, don't color the rest of the code
+				return j
+			}
+		case q:
+			return j + 1
+		}
+	}
+	return len(code)
+}
+
+// highlightXML returns the tokens of XML, and of DTDs.
+func highlightXML(code []byte) []token {
+	var toks []token
+	i := 0
+	for i < len(code) {
+		switch {
+		case bytes.HasPrefix(code[i:], []byte("<!--")):
+			end := bytes.Index(code[i+4:], []byte("-->"))
+			if end < 0 {
+				end = len(code)
+			} else {
+				end += i + 4 + 3
+			}
+			toks = append(toks, token{i, end, hlComment})
+			i = end
+		case bytes.HasPrefix(code[i:], []byte("<![CDATA[")):
+			end := bytes.Index(code[i:], []byte("]]>"))
+			if end < 0 {
+				end = len(code)
+			} else {
+				end += i + 3
+			}
+			toks = append(toks, token{i, end, hlString})
+			i = end
+		case code[i] == '<':
+			// the tag name, including <, </, <? and <!
+			end := i + 1
+			for end < len(code) && (code[end] == '/' || code[end] == '?' || code[end] == '!') {
+				end++
+			}
+			for end < len(code) && !isspace(code[end]) && code[end] != '>' && code[end] != '/' {
+				end++
+			}
+			toks = append(toks, token{i, end, hlTag})
+			i = end
+			// attributes up to the end of the tag
+			for i < len(code) && code[i] != '>' && code[i] != '<' {
+				switch {
+				case code[i] == '"' || code[i] == '\'':
+					end := bytes.IndexByte(code[i+1:], code[i])
+					if end < 0 {
+						end = len(code)
+					} else {
+						end += i + 2
+					}
+					toks = append(toks, token{i, end, hlString})
+					i = end
+				case isalnum(code[i]) || code[i] == '_':
+					end := i + 1
+					for end < len(code) && (isalnum(code[end]) || strings.IndexByte("_-:.", code[end]) >= 0) {
+						end++
+					}
+					toks = append(toks, token{i, end, hlAttr})
+					i = end
+				default:
+					i++
+				}
+			}
+			if i < len(code) && code[i] == '>' {
+				if i > 0 && (code[i-1] == '/' || code[i-1] == '?') {
+					toks = append(toks, token{i - 1, i + 1, hlTag})
+				} else {
+					toks = append(toks, token{i, i + 1, hlTag})
+				}
+				i++
+			}
+		case code[i] == '&':
+			end := bytes.IndexByte(code[i:], ';')
+			if end < 0 || bytes.IndexAny(code[i:i+end], " \t\n<") >= 0 {
+				i++
+				continue
+			}
+			toks = append(toks, token{i, i + end + 1, hlType})
+			i += end + 1
+		default:
+			i++
+		}
+	}
+	return toks
+}
+
+// codeCallout is a callout in code, offset is its position in the code without
+// the callouts.
+type codeCallout struct {
+	offset int
+	id     string
+}
+
+// stripCallouts removes the callouts from src, as found by attrEscapeInCode.
+func stripCallouts(src []byte) ([]byte, []codeCallout) {
+	var (
+		code     []byte
+		callouts []codeCallout
+		prev     byte
+	)
+	for i := 0; i < len(src); i++ {
+		ch := src[i]
+		if ch == '<' && prev != '\\' {
+			if x := leftAngleCode(src[i:]); x > 0 {
+				callouts = append(callouts, codeCallout{len(code), string(src[i : i+x+1])})
+				i += x
+				prev = ch
+				continue
+			}
+		}
+		if ch == '\\' && i < len(src)-1 && src[i+1] == '<' {
+			prev = ch
+			continue
+		}
+		code = append(code, ch)
+		prev = ch
+	}
+	return code, callouts
+}
+
+// highlightCode writes the escaped src to out, with the tokens of lang in
+// <span>s. If callout is true, callouts in src are rendered with the
+// CalloutCode callback of r.
+func highlightCode(r Renderer, out *bytes.Buffer, lang string, src []byte, callout bool) {
+	code, callouts := src, []codeCallout(nil)
+	if callout {
+		code, callouts = stripCallouts(src)
+	}
+
+	j := 0
+	// text writes code[start:end] with the callouts in it.
+	text := func(start, end int) {
+		for ; j < len(callouts) && callouts[j].offset < end; j++ {
+			attrEscape(out, code[start:callouts[j].offset])
+			start = callouts[j].offset
+			r.CalloutCode(out, strconv.Itoa(j+1), callouts[j].id)
+		}
+		attrEscape(out, code[start:end])
+	}
+
+	pos := 0
+	for _, t := range highlight(lang, code) {
+		text(pos, t.start)
+		// callouts at the start of a token are written before it
+		for ; j < len(callouts) && callouts[j].offset == t.start; j++ {
+			r.CalloutCode(out, strconv.Itoa(j+1), callouts[j].id)
+		}
+		out.WriteString("<span class=\"" + t.class + "\">")
+		text(t.start, t.end)
+		out.WriteString("</span>")
+		pos = t.end
+	}
+	text(pos, len(code))
+	for ; j < len(callouts); j++ {
+		r.CalloutCode(out, strconv.Itoa(j+1), callouts[j].id)
+	}
+}
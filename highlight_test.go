@@ -0,0 +1,70 @@
+// Unit tests for syntax highlighting
+
+package mmark
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHighlight(t *testing.T) {
+	var tests = []string{
+		"```go\nfunc main() { // start\n\tfmt.Println(\"hi\", 42)\n}\n```\n",
+		"<pre><code class=\"language-go\"><span class=\"hl-keyword\">func</span> main() { <span class=\"hl-comment\">// start</span>\n\tfmt.Println(<span class=\"hl-string\">&quot;hi&quot;</span>, <span class=\"hl-number\">42</span>)\n}\n</code></pre>\n",
+
+		"```python\ndef f(x):\n    \"\"\"Doc # not a comment.\"\"\"\n    return None # done\n```\n",
+		"<pre><code class=\"language-python\"><span class=\"hl-keyword\">def</span> f(x):\n    <span class=\"hl-string\">&quot;&quot;&quot;Doc # not a comment.&quot;&quot;&quot;</span>\n    <span class=\"hl-keyword\">return</span> <span class=\"hl-type\">None</span> <span class=\"hl-comment\"># done</span>\n</code></pre>\n",
+
+		"```abnf\nrule = 1*DIGIT %x41-5A ; comment\n```\n",
+		"<pre><code class=\"language-abnf\">rule = <span class=\"hl-number\">1</span>*<span class=\"hl-type\">DIGIT</span> <span class=\"hl-number\">%x41-5A</span> <span class=\"hl-comment\">; comment</span>\n</code></pre>\n",
+
+		"```json\n{\"a\": [true, 1.5]}\n```\n",
+		"<pre><code class=\"language-json\">{<span class=\"hl-string\">&quot;a&quot;</span>: [<span class=\"hl-type\">true</span>, <span class=\"hl-number\">1.5</span>]}\n</code></pre>\n",
+
+		"```xml\n<!-- c -->\n<a href=\"x\">&amp;</a>\n```\n",
+		"<pre><code class=\"language-xml\"><span class=\"hl-comment\">&lt;!-- c --&gt;</span>\n<span class=\"hl-tag\">&lt;a</span> <span class=\"hl-attr\">href</span>=<span class=\"hl-string\">&quot;x&quot;</span><span class=\"hl-tag\">&gt;</span><span class=\"hl-type\">&amp;amp;</span><span class=\"hl-tag\">&lt;/a</span><span class=\"hl-tag\">&gt;</span>\n</code></pre>\n",
+
+		"```pseudocode\nif x then y\n```\n",
+		"<pre><code class=\"language-pseudocode\">if x then y\n</code></pre>\n",
+
+		// callouts, also in comments and strings
+		"{callout=\"true\"}\n```c\nint x; // <1>\nchar *s = \"<2>\"; <3>\n```\n",
+		"<pre><code class=\"language-c\"><span class=\"hl-type\">int</span> x; <span class=\"hl-comment\">// </span><span class=\"callout\">1</span>\n<span class=\"hl-type\">char</span> *s = <span class=\"hl-string\">&quot;<span class=\"callout\">2</span>&quot;</span>; <span class=\"callout\">3</span>\n</code></pre>\n",
+
+		"{callout=\"true\"}\n```xml\n<a/> <1>\n```\n",
+		"<pre><code class=\"language-xml\"><span class=\"hl-tag\">&lt;a</span><span class=\"hl-tag\">/&gt;</span> <span class=\"callout\">1</span>\n</code></pre>\n",
+	}
+	for i := 0; i < len(tests); i += 2 {
+		renderer := HtmlRenderer(HTML_HIGHLIGHT, "", "")
+		out := Parse([]byte(tests[i]), renderer, EXTENSION_FENCED_CODE|EXTENSION_INLINE_ATTR).String()
+		if out != tests[i+1] {
+			t.Errorf("input %q:\nexpected %q\ngot      %q", tests[i], tests[i+1], out)
+		}
+	}
+}
+
+func TestHighlightTokens(t *testing.T) {
+	// tokens must be ordered, not overlap and stay within the code, also for
+	// unterminated strings and comments
+	langs := []string{"xml"}
+	for lang := range languages {
+		langs = append(langs, lang)
+	}
+	for _, lang := range langs {
+		for _, code := range []string{"\"abc", "/* x", "'", "-- x\n1", "<a b=\"", "<!-- x", "%x"} {
+			pos := 0
+			for _, tok := range highlight(lang, []byte(code)) {
+				if tok.start < pos || tok.end < tok.start || tok.end > len(code) {
+					t.Errorf("%s: %q: bad token %v", lang, code, tok)
+				}
+				pos = tok.end
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	highlightCode(HtmlRenderer(0, "", ""), &buf, "go", []byte("x <1>"), true)
+	if buf.String() != "x <span class=\"callout\">1</span>" {
+		t.Errorf("unexpected callout rendering: %q", buf.String())
+	}
+}
@@ -4,10 +4,8 @@ package mmark
 
 import (
 	"bytes"
-	xmllib "encoding/xml"
 	"fmt"
 	"io/ioutil"
-	"sort"
 	"strconv"
 	"strings"
 )
@@ -21,7 +19,7 @@ const (
 	HTML_SAFELINK                              // only link to trusted protocols
 	HTML_NOFOLLOW_LINKS                        // only link with rel="nofollow"
 	HTML_HREF_TARGET_BLANK                     // add a blank target
-	HTML_OMIT_CONTENTS                         // skip the main contents (for a standalone table of contents)
+	HTML_OMIT_CONTENTS                         // skip the main contents (for a standalone table of contents, implies HTML_TOC)
 	HTML_COMPLETE_PAGE                         // generate a complete HTML page
 	HTML_USE_SMARTYPANTS                       // enable smart punctuation substitutions
 	HTML_SMARTYPANTS_FRACTIONS                 // enable smart fractions (with HTML_USE_SMARTYPANTS)
@@ -29,8 +27,18 @@ const (
 	HTML_SMARTYPANTS_LATEX_DASHES              // enable LaTeX-style dashes (with HTML_USE_SMARTYPANTS and HTML_SMARTYPANTS_DASHES)
 	HTML_SMARTYPANTS_ANGLED_QUOTES             // enable angled double quotes (with HTML_USE_SMARTYPANTS) for double quotes rendering
 	HTML_FOOTNOTE_RETURN_LINKS                 // generate a link at the end of a footnote to return to the source
+	HTML_DATA_SOURCE                           // add a data-source="file:line" attribute to block elements
+	HTML_TOC                                   // generate a table of contents
+	HTML_MATHML                                // render math as MathML instead of leaving it to MathJax
+	HTML_HIGHLIGHT                             // highlight the syntax of code blocks with CSS classes
+	HTML_NUMBERED                              // number sections, figures and tables and use the numbers in cross references
 )
 
+// xrefMarker delimits the anchor of a cross reference in the output until its
+// text is known in DocumentFooter. The parser replaces it in the input, so it
+// only shows up where Link writes it.
+const xrefMarker = "\x00"
+
 var (
 	alignments = []string{
 		"left",
@@ -48,6 +56,9 @@ type HtmlRendererParameters struct {
 	// HTML_FOOTNOTE_RETURN_LINKS flag is enabled. If blank, the string
 	// <sup>[return]</sup> is used.
 	FootnoteReturnLinkContents string
+	// Only put headers up to this level in the table of contents, if the
+	// HTML_TOC flag is enabled. If zero, all headers are included.
+	TocDepth int
 }
 
 // Html is a type that implements the Renderer interface for HTML output.
@@ -60,10 +71,10 @@ type html struct {
 	head     string // option html file to be included
 
 	// store the IAL we see for this block element
-	ial *inlineAttr
+	ial *Attributes
 
 	// titleBlock in TOML
-	titleBlock *title
+	titleBlock *Title
 
 	parameters HtmlRendererParameters
 
@@ -71,21 +82,34 @@ type html struct {
 	headerCount  int
 	currentLevel int
 	toc          *bytes.Buffer
-
-	appendix bool
-
-	// index, map idx to id
-	index      map[idx][]string
+	tocMarker    int // where the table of contents goes in the output
+	bodyMarker   int // start of the body, for HTML_OMIT_CONTENTS
+	section      sectionNumber
+
+	appendix    bool
+	frontMatter bool
+	references  bool // rendering the references, they are numbered before the appendices
+
+	// figure and table numbers and the text of cross references to the
+	// numbered anchors, with HTML_NUMBERED
+	figure int
+	table  int
+	labels map[string]string
+
+	// the index and the number of references to it
+	index      indexTerm
 	indexCount int
 
 	// (@good) example list group counter
 	group map[string]int
 
 	smartypants *smartypantsRenderer
-}
 
-type idx struct {
-	primary, secondary string
+	// parser that drives this renderer, used for reporting problems
+	p *parser
+
+	// source position of the block element being rendered, see HTML_DATA_SOURCE
+	source string
 }
 
 const htmlClose = ">"
@@ -118,8 +142,8 @@ func HtmlRendererWithParameters(flags int, css, head string, renderParameters Ht
 		currentLevel: 0,
 		toc:          new(bytes.Buffer),
 
-		index: make(map[idx][]string),
-		group: make(map[string]int),
+		group:  make(map[string]int),
+		labels: make(map[string]string),
 
 		smartypants: smartypants(flags),
 	}
@@ -206,7 +230,28 @@ func (options *html) Flags() int {
 	return options.flags
 }
 
-func (options *html) TitleBlockTOML(out *bytes.Buffer, block *title) {
+func (options *html) setParser(p *parser) { options.p = p }
+
+func (options *html) Source(file string, line int) {
+	options.source = ""
+	if options.flags&HTML_DATA_SOURCE == 0 || line == 0 {
+		return
+	}
+	var buf bytes.Buffer
+	attrEscape(&buf, []byte(sourcePosition(file, line)))
+	options.source = buf.String()
+}
+
+// dataSource returns the data-source attribute for the block element being
+// rendered.
+func (options *html) dataSource() string {
+	if options.source == "" {
+		return ""
+	}
+	return " data-source=\"" + options.source + "\""
+}
+
+func (options *html) TitleBlockTOML(out *bytes.Buffer, block *Title) {
 	if options.flags&HTML_COMPLETE_PAGE == 0 { // use STANDALONE
 		return
 	}
@@ -224,35 +269,171 @@ func (options *html) TitleBlockTOML(out *bytes.Buffer, block *title) {
 	out.WriteString("  <meta charset=\"utf-8\"")
 	out.WriteString(ending)
 	out.WriteString(">\n")
+	options.titleBlockMeta(out)
 	if options.css != "" {
 		out.WriteString("  <link rel=\"stylesheet\" type=\"text/css\" href=\"")
 		attrEscape(out, []byte(options.css))
 		out.WriteString("\"")
 		out.WriteString(ending)
 		out.WriteString(">\n")
+	} else if options.flags&HTML_HIGHLIGHT != 0 {
+		out.WriteString("  <style>\n")
+		out.WriteString(HighlightStyle)
+		out.WriteString("  </style>\n")
 	}
 	if options.head != "" {
+		// the head file is given by the program, not by the document, so
+		// it is not read from FileSource
 		headBytes, err := ioutil.ReadFile(options.head)
 		if err != nil {
-			printf(nil, "failed: `%s': %s", options.head, err)
+			warnf(options.p, "include", "failed: `%s': %s", options.head, err)
 		} else {
+			if options.p != nil {
+				options.p.dependency(options.head)
+			}
 			out.Write(headBytes)
 		}
 
 	}
 	out.WriteString("</head>\n")
 	out.WriteString("<body>\n")
+	options.bodyMarker = out.Len()
+	options.titleBlockHeader(out)
+	options.tocMarker = out.Len()
+}
+
+// meta writes a <meta> tag with name and content.
+func (options *html) meta(out *bytes.Buffer, name, content string) {
+	if content == "" {
+		return
+	}
+	out.WriteString("  <meta name=\"" + name + "\" content=\"")
+	attrEscape(out, []byte(content))
+	out.WriteString("\">\n")
+}
+
+// titleBlockMeta writes the <meta> tags for the title block, including Dublin
+// Core metadata.
+func (options *html) titleBlockMeta(out *bytes.Buffer) {
+	block := options.titleBlock
+	for _, a := range block.Author {
+		options.meta(out, "author", authorName(a))
+	}
+	options.meta(out, "keywords", strings.Join(block.Keyword, ", "))
+	options.meta(out, "ietf.draft", draftName(block.DocName))
+
+	out.WriteString("  <link rel=\"schema.dc\" href=\"http://purl.org/dc/elements/1.1/\">\n")
+	options.meta(out, "dc.title", block.Title)
+	for _, a := range block.Author {
+		creator := a.Surname
+		if a.Initials != "" {
+			creator += ", " + a.Initials
+		}
+		options.meta(out, "dc.creator", creator)
+	}
+	if !block.Date.IsZero() {
+		options.meta(out, "dc.date", block.Date.Format("2006-01-02"))
+	}
+	if d := draftName(block.DocName); d != "" {
+		options.meta(out, "dc.identifier", "urn:ietf:id:"+d)
+	}
+	for _, o := range block.Obsoletes {
+		options.meta(out, "dc.relation.replaces", "urn:ietf:rfc:"+strconv.Itoa(o))
+	}
+	options.meta(out, "dc.subject", strings.Join(block.Keyword, ", "))
+}
+
+// titleBlockHeader writes the document header at the start of the body: the
+// document information, the authors and the title.
+func (options *html) titleBlockHeader(out *bytes.Buffer) {
+	block := options.titleBlock
+	draft := draftName(block.DocName) != ""
+
+	item := func(class, term, value string) {
+		if value == "" {
+			return
+		}
+		out.WriteString("<dt>" + term + ":</dt>\n<dd class=\"" + class + "\">")
+		attrEscape(out, []byte(value))
+		out.WriteString("</dd>\n")
+	}
+	numbers := func(n []int) string {
+		s := make([]string, len(n))
+		for i := range n {
+			s[i] = strconv.Itoa(n[i])
+		}
+		if len(s) > 0 && draft {
+			return strings.Join(s, ", ") + " (if approved)"
+		}
+		return strings.Join(s, ", ")
+	}
 
-	// Write some elements of the TOML block in the doc as well.
+	out.WriteString("<header class=\"titleblock\">\n")
+	out.WriteString("<dl class=\"docinfo\">\n")
+	item("area", "Area", block.Area)
+	item("workgroup", "Workgroup", block.Workgroup)
+	if draft {
+		item("docname", "Internet-Draft", block.DocName)
+	} else {
+		item("docname", "Document", block.DocName)
+	}
+	item("updates", "Updates", numbers(block.Updates))
+	item("obsoletes", "Obsoletes", numbers(block.Obsoletes))
+	if !block.Date.IsZero() {
+		out.WriteString("<dt>Published:</dt>\n<dd class=\"published\"><time datetime=\"" + block.Date.Format("2006-01-02") + "\">")
+		out.WriteString(longDate(block.Date))
+		out.WriteString("</time></dd>\n")
+	}
+	if draft {
+		item("status", "Intended Status", intendedStatus(block.Category))
+		if !block.Date.IsZero() {
+			item("expires", "Expires", draftExpires(block.Date))
+		}
+	} else {
+		item("status", "Category", intendedStatus(block.Category))
+	}
+	if len(block.Author) > 0 {
+		out.WriteString("<dt>Authors:</dt>\n<dd class=\"authors\">\n")
+		for _, a := range block.Author {
+			out.WriteString("<div class=\"author\">")
+			out.WriteString("<span class=\"author-name\">")
+			attrEscape(out, []byte(authorName(a)))
+			out.WriteString("</span>")
+			if a.Organization != "" {
+				out.WriteString(" <span class=\"organization\">")
+				attrEscape(out, []byte(a.Organization))
+				out.WriteString("</span>")
+			}
+			if a.Address.Email != "" {
+				out.WriteString(" <a class=\"email\" href=\"mailto:")
+				attrEscape(out, []byte(a.Address.Email))
+				out.WriteString("\">")
+				attrEscape(out, []byte(a.Address.Email))
+				out.WriteString("</a>")
+			}
+			out.WriteString("</div>\n")
+		}
+		out.WriteString("</dd>\n")
+	}
+	out.WriteString("</dl>\n")
+	out.WriteString("<h1 class=\"title\">")
+	options.NormalText(out, []byte(block.Title))
+	out.WriteString("</h1>\n")
+	out.WriteString("</header>\n")
 }
 
 func (options *html) Part(out *bytes.Buffer, text func() bool, id string) {
+	id = options.tocAnchor(id)
 	if id != "" {
 		out.WriteString(fmt.Sprintf("<h1 class=\"part\" id=\"%s\">", id))
 	} else {
-		out.WriteString(fmt.Sprintf("<h1 class=\"part\""))
+		out.WriteString("<h1 class=\"part\">")
 	}
+	tocMarker := out.Len()
 	text()
+	if options.tocEnabled() {
+		options.TocHeaderWithAnchor(out.Bytes()[tocMarker:], 1, id)
+	}
 	out.WriteString(fmt.Sprintf("</h1>\n"))
 }
 
@@ -261,7 +442,7 @@ func (options *html) Note(out *bytes.Buffer, text func() bool, id string) {
 	if id != "" {
 		out.WriteString(fmt.Sprintf("<h1 class=\"note\" id=\"%s\">", id))
 	} else {
-		out.WriteString(fmt.Sprintf("<h1 class=\"note\""))
+		out.WriteString("<h1 class=\"note\">")
 	}
 	text()
 	out.WriteString(fmt.Sprintf("</h1>\n"))
@@ -269,12 +450,17 @@ func (options *html) Note(out *bytes.Buffer, text func() bool, id string) {
 
 func (options *html) SpecialHeader(out *bytes.Buffer, what []byte, text func() bool, id string) {
 	options.Attr() //reset the IAL
+	id = options.tocAnchor(id)
 	if id != "" {
 		out.WriteString(fmt.Sprintf("<h1 class=\""+string(what)+"\" id=\"%s\">", id))
 	} else {
-		out.WriteString(fmt.Sprintf("<h1 class=\"" + string(what) + "\""))
+		out.WriteString("<h1 class=\"" + string(what) + "\">")
 	}
+	tocMarker := out.Len()
 	text()
+	if options.tocEnabled() {
+		options.TocHeaderWithAnchor(out.Bytes()[tocMarker:], 1, id)
+	}
 	out.WriteString(fmt.Sprintf("</h1>\n"))
 }
 
@@ -287,13 +473,39 @@ func (options *html) Header(out *bytes.Buffer, text func() bool, level int, id s
 	if options.appendix {
 		ial.GetOrDefaultClass("appendix")
 	}
+	ial.GetOrDefaultId(options.tocAnchor(""))
 
-	out.WriteString(fmt.Sprintf("<h%d%s>", level, options.AttrString(ial)))
+	out.WriteString(fmt.Sprintf("<h%d%s%s>", level, options.AttrString(ial), options.dataSource()))
 
+	tocMarker := out.Len()
 	if !text() {
 		out.Truncate(marker)
 		return
 	}
+	number := ""
+	if options.appendix && !options.references && !options.section.appendix {
+		options.section.startAppendix()
+	}
+	if !options.frontMatter {
+		number = options.section.next(level)
+	}
+	title := append([]byte(nil), out.Bytes()[tocMarker:]...)
+	if options.flags&HTML_NUMBERED != 0 && number != "" {
+		out.Truncate(tocMarker)
+		out.WriteString("<span class=\"section-number\">" + number + ".</span> ")
+		out.Write(title)
+		if options.section.appendix {
+			options.label(ial.id, "Appendix "+number)
+		} else {
+			options.label(ial.id, "Section "+number)
+		}
+	}
+	if options.tocEnabled() {
+		if number != "" {
+			title = append([]byte(number+". "), title...)
+		}
+		options.TocHeaderWithAnchor(title, level, ial.id)
+	}
 	// special section closing etc. etc. TODO(miek)
 	out.WriteString(fmt.Sprintf("</h%d>\n", level))
 }
@@ -358,6 +570,8 @@ func (options *html) BlockCode(out *bytes.Buffer, text []byte, lang string, capt
 	if len(caption) > 0 {
 		if subfigure {
 			s += " role=\"group\""
+		} else {
+			caption = options.caption("Figure", &options.figure, ial.id, caption)
 		}
 		out.WriteString("<figure" + s + ">\n")
 	}
@@ -368,15 +582,18 @@ func (options *html) BlockCode(out *bytes.Buffer, text []byte, lang string, capt
 		lang = ial.Value("type")
 		ial.DropAttr("type")
 	}
+	class := ""
 	if lang != "" {
 		langOut := &bytes.Buffer{}
 		attrEscape(langOut, []byte(lang))
-		lang = " class=\"language-" + langOut.String() + "\""
+		class = " class=\"language-" + langOut.String() + "\""
 	}
 
-	out.WriteString("<pre><code" + lang + ">")
+	out.WriteString("<pre" + options.dataSource() + "><code" + class + ">")
 
-	if callout {
+	if options.flags&HTML_HIGHLIGHT != 0 {
+		highlightCode(options, out, lang, text, callout)
+	} else if callout {
 		attrEscapeInCode(options, out, text)
 	} else {
 		attrEscape(out, text)
@@ -398,7 +615,7 @@ func (options *html) BlockQuote(out *bytes.Buffer, text []byte, attribution []by
 		bytes.TrimSpace(p)
 	}
 	doubleSpace(out)
-	out.WriteString("<blockquote" + options.AttrString(ial) + ">\n")
+	out.WriteString("<blockquote" + options.AttrString(ial) + options.dataSource() + ">\n")
 	out.Write(text)
 	if len(parts) == 2 {
 		out.WriteString("<footer>")
@@ -417,7 +634,7 @@ func (options *html) BlockQuote(out *bytes.Buffer, text []byte, attribution []by
 
 func (options *html) Aside(out *bytes.Buffer, text []byte) {
 	doubleSpace(out)
-	out.WriteString("<aside>\n")
+	out.WriteString("<aside" + options.dataSource() + ">\n")
 	out.Write(text)
 	out.WriteString("</aside>\n")
 }
@@ -426,8 +643,9 @@ func (options *html) Table(out *bytes.Buffer, header []byte, body []byte, footer
 	ial := options.Attr()
 
 	doubleSpace(out)
-	out.WriteString("<table" + options.AttrString(ial) + ">\n")
+	out.WriteString("<table" + options.AttrString(ial) + options.dataSource() + ">\n")
 	if len(caption) > 0 {
+		caption = options.caption("Table", &options.table, ial.id, caption)
 		out.WriteString("<caption>\n")
 		out.Write(caption)
 		out.WriteString("\n</caption>\n")
@@ -461,11 +679,11 @@ func (options *html) TableHeaderCell(out *bytes.Buffer, text []byte, align, cols
 	}
 
 	switch align {
-	case _TABLE_ALIGNMENT_LEFT:
+	case TABLE_ALIGNMENT_LEFT:
 		out.WriteString("<th align=\"left\"" + col + ">")
-	case _TABLE_ALIGNMENT_RIGHT:
+	case TABLE_ALIGNMENT_RIGHT:
 		out.WriteString("<th align=\"right\"" + col + ">")
-	case _TABLE_ALIGNMENT_CENTER:
+	case TABLE_ALIGNMENT_CENTER:
 		out.WriteString("<th align=\"center\"" + col + ">")
 	default:
 		out.WriteString("<th" + col + ">")
@@ -484,11 +702,11 @@ func (options *html) TableCell(out *bytes.Buffer, text []byte, align, colspan in
 	}
 
 	switch align {
-	case _TABLE_ALIGNMENT_LEFT:
+	case TABLE_ALIGNMENT_LEFT:
 		out.WriteString("<td align=\"left\"" + col + ">")
-	case _TABLE_ALIGNMENT_RIGHT:
+	case TABLE_ALIGNMENT_RIGHT:
 		out.WriteString("<td align=\"right\"" + col + ">")
-	case _TABLE_ALIGNMENT_CENTER:
+	case TABLE_ALIGNMENT_CENTER:
 		out.WriteString("<td align=\"center\"" + col + ">")
 	default:
 		out.WriteString("<td" + col + ">")
@@ -500,7 +718,7 @@ func (options *html) TableCell(out *bytes.Buffer, text []byte, align, colspan in
 
 func (options *html) Footnotes(out *bytes.Buffer, text func() bool) {
 	if options.flags&HTML_COMPLETE_PAGE != 0 {
-		options.ial = &inlineAttr{class: map[string]bool{"footnotes": true}}
+		options.ial = &Attributes{class: map[string]bool{"footnotes": true}}
 		options.Header(out, func() bool { out.WriteString("Footnotes"); return true }, 1, "footnotes")
 	}
 	// reset now that the header is out
@@ -509,12 +727,12 @@ func (options *html) Footnotes(out *bytes.Buffer, text func() bool) {
 	if options.flags&HTML_COMPLETE_PAGE == 0 {
 		options.HRule(out)
 	}
-	options.List(out, text, _LIST_TYPE_ORDERED, 0, nil)
+	options.List(out, text, LIST_TYPE_ORDERED, 0, nil)
 	out.WriteString("</div>\n")
 }
 
 func (options *html) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int) {
-	if flags&_LIST_ITEM_CONTAINS_BLOCK != 0 || flags&_LIST_ITEM_BEGINNING_OF_LIST != 0 {
+	if flags&LIST_ITEM_CONTAINS_BLOCK != 0 || flags&LIST_ITEM_BEGINNING_OF_LIST != 0 {
 		doubleSpace(out)
 	}
 	slug := slugify(name)
@@ -547,17 +765,17 @@ func (options *html) List(out *bytes.Buffer, text func() bool, flags, start int,
 	}
 
 	switch {
-	case flags&_LIST_TYPE_ORDERED != 0:
+	case flags&LIST_TYPE_ORDERED != 0:
 		switch {
-		case flags&_LIST_TYPE_ORDERED_ALPHA_LOWER != 0:
+		case flags&LIST_TYPE_ORDERED_ALPHA_LOWER != 0:
 			ial.GetOrDefaultAttr("type", "a")
-		case flags&_LIST_TYPE_ORDERED_ALPHA_UPPER != 0:
+		case flags&LIST_TYPE_ORDERED_ALPHA_UPPER != 0:
 			ial.GetOrDefaultAttr("type", "A")
-		case flags&_LIST_TYPE_ORDERED_ROMAN_LOWER != 0:
+		case flags&LIST_TYPE_ORDERED_ROMAN_LOWER != 0:
 			ial.GetOrDefaultAttr("type", "i")
-		case flags&_LIST_TYPE_ORDERED_ROMAN_UPPER != 0:
+		case flags&LIST_TYPE_ORDERED_ROMAN_UPPER != 0:
 			ial.GetOrDefaultAttr("type", "I")
-		case flags&_LIST_TYPE_ORDERED_GROUP != 0:
+		case flags&LIST_TYPE_ORDERED_GROUP != 0:
 			// check start as well
 			if group != nil {
 				options.group[string(group)]++
@@ -566,20 +784,20 @@ func (options *html) List(out *bytes.Buffer, text func() bool, flags, start int,
 				ial.GetOrDefaultAttr("type", "I")
 			}
 		}
-		out.WriteString("<ol" + options.AttrString(ial) + ">")
-	case flags&_LIST_TYPE_DEFINITION != 0:
-		out.WriteString("<dl" + options.AttrString(ial) + ">")
+		out.WriteString("<ol" + options.AttrString(ial) + options.dataSource() + ">")
+	case flags&LIST_TYPE_DEFINITION != 0:
+		out.WriteString("<dl" + options.AttrString(ial) + options.dataSource() + ">")
 	default:
-		out.WriteString("<ul" + options.AttrString(ial) + ">")
+		out.WriteString("<ul" + options.AttrString(ial) + options.dataSource() + ">")
 	}
 	if !text() {
 		out.Truncate(marker)
 		return
 	}
 	switch {
-	case flags&_LIST_TYPE_ORDERED != 0:
+	case flags&LIST_TYPE_ORDERED != 0:
 		out.WriteString("</ol>\n")
-	case flags&_LIST_TYPE_DEFINITION != 0:
+	case flags&LIST_TYPE_DEFINITION != 0:
 		out.WriteString("</dl>\n")
 	default:
 		out.WriteString("</ul>\n")
@@ -587,16 +805,16 @@ func (options *html) List(out *bytes.Buffer, text func() bool, flags, start int,
 }
 
 func (options *html) ListItem(out *bytes.Buffer, text []byte, flags int) {
-	if flags&_LIST_ITEM_CONTAINS_BLOCK != 0 || flags&_LIST_ITEM_BEGINNING_OF_LIST != 0 {
+	if flags&LIST_ITEM_CONTAINS_BLOCK != 0 || flags&LIST_ITEM_BEGINNING_OF_LIST != 0 {
 		doubleSpace(out)
 	}
-	if flags&_LIST_TYPE_DEFINITION != 0 && flags&_LIST_TYPE_TERM == 0 {
+	if flags&LIST_TYPE_DEFINITION != 0 && flags&LIST_TYPE_TERM == 0 {
 		out.WriteString("<dd>")
 		out.Write(text)
 		out.WriteString("</dd>\n")
 		return
 	}
-	if flags&_LIST_TYPE_TERM != 0 {
+	if flags&LIST_TYPE_TERM != 0 {
 		out.WriteString("<dt>")
 		out.Write(text)
 		out.WriteString("</dt>")
@@ -625,7 +843,7 @@ func (options *html) Paragraph(out *bytes.Buffer, text func() bool, flags int) {
 	marker := out.Len()
 	doubleSpace(out)
 
-	out.WriteString("<p>")
+	out.WriteString("<p" + options.dataSource() + ">")
 	if !text() {
 		out.Truncate(marker)
 		return
@@ -636,6 +854,16 @@ func (options *html) Paragraph(out *bytes.Buffer, text func() bool, flags int) {
 func (options *html) Math(out *bytes.Buffer, text []byte, display bool) {
 	ial := options.Attr()
 	s := options.AttrString(ial)
+	if options.flags&HTML_MATHML != 0 {
+		block := ""
+		if display {
+			block = " display=\"block\""
+		}
+		out.WriteString("<math" + s + " class=\"math\" xmlns=\"http://www.w3.org/1998/Math/MathML\"" + block + ">")
+		out.WriteString(texToMathML(text, display))
+		out.WriteString("</math>")
+		return
+	}
 	oTag := "\\("
 	cTag := "\\)"
 	if display {
@@ -651,7 +879,7 @@ func (options *html) Math(out *bytes.Buffer, text []byte, display bool) {
 
 func (options *html) AutoLink(out *bytes.Buffer, link []byte, kind int) {
 	skipRanges := htmlEntity.FindAllIndex(link, -1)
-	if options.flags&HTML_SAFELINK != 0 && !isSafeLink(link) && kind != _LINK_TYPE_EMAIL {
+	if options.flags&HTML_SAFELINK != 0 && !isSafeLink(link) && kind != LINK_TYPE_EMAIL {
 		// mark it but don't link it if it is not a safe link
 		out.WriteString("<tt>")
 		entityEscapeWithSkip(out, link, skipRanges)
@@ -660,7 +888,7 @@ func (options *html) AutoLink(out *bytes.Buffer, link []byte, kind int) {
 	}
 
 	out.WriteString("<a href=\"")
-	if kind == _LINK_TYPE_EMAIL {
+	if kind == LINK_TYPE_EMAIL {
 		out.WriteString("mailto:")
 	} else {
 		options.maybeWriteAbsolutePrefix(out, link)
@@ -739,7 +967,8 @@ func (options *html) maybeWriteAbsolutePrefix(out *bytes.Buffer, link []byte) {
 func (options *html) Figure(out *bytes.Buffer, text []byte, caption []byte) {
 	ial := options.Attr()
 	s := options.AttrString(ial)
-	out.WriteString("<figure role=\"group\"" + s + ">\n")
+	caption = options.caption("Figure", &options.figure, ial.id, caption)
+	out.WriteString("<figure role=\"group\"" + s + options.dataSource() + ">\n")
 	out.WriteString("<figcaption>")
 	out.Write(caption)
 	out.WriteString("</figcaption>\n")
@@ -818,6 +1047,12 @@ func (options *html) Link(out *bytes.Buffer, link []byte, title []byte, content
 	}
 
 	out.WriteString("\">")
+	if options.flags&HTML_NUMBERED != 0 && len(content) == 0 && len(link) > 1 && link[0] == '#' {
+		// a cross reference, its text is filled in by DocumentFooter
+		out.WriteString(xrefMarker)
+		attrEscape(out, link[1:])
+		out.WriteString(xrefMarker)
+	}
 	out.Write(content)
 	out.WriteString("</a>")
 	return
@@ -878,18 +1113,16 @@ func (options *html) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
 	out.WriteString(`</a></sup>`)
 }
 
-func (options *html) Index(out *bytes.Buffer, primary, secondary []byte, prim bool) {
-	idx := idx{string(primary), string(secondary)}
-	id := ""
-	if ids, ok := options.index[idx]; ok {
-		// write id out and add it to the list
-		id = fmt.Sprintf("#idxref:%d-%d", options.indexCount, len(ids))
-		options.index[idx] = append(options.index[idx], id)
-	} else {
-		id = fmt.Sprintf("#idxref:%d-0", options.indexCount)
-		options.index[idx] = []string{id}
+func (options *html) Index(out *bytes.Buffer, entry IndexEntry) {
+	ref := indexRef{anchor: fmt.Sprintf("idxref:%d", options.indexCount), prim: entry.Prim}
+	if !options.frontMatter {
+		ref.section.count = append([]int(nil), options.section.count...)
+		ref.section.appendix = options.section.appendix
+	}
+	options.index.add(entry, ref)
+	if len(entry.See) == 0 {
+		out.WriteString("<span class=\"index-ref\" id=\"" + ref.anchor + "\"></span>")
 	}
-	out.WriteString("<span class=\"index-ref\" id=\"" + id[1:] + "\"></span>")
 
 	options.indexCount++
 }
@@ -907,9 +1140,11 @@ func (options *html) Citation(out *bytes.Buffer, link, title []byte) {
 // refAuthor is the reference author, exported because we need to be able to parse
 // raw XML references when included in the document.
 type refAuthor struct {
-	Fullname string `xml:"fullname,attr"`
-	Initials string `xml:"initials,attr"`
-	Surname  string `xml:"surname,attr"`
+	Fullname     string `xml:"fullname,attr"`
+	Initials     string `xml:"initials,attr"`
+	Surname      string `xml:"surname,attr"`
+	Role         string `xml:"role,attr,omitempty"`
+	Organization string `xml:"organization"`
 }
 
 // refDate is the reference date. See refAuthor.
@@ -919,11 +1154,19 @@ type refDate struct {
 	Day   string `xml:"day,attr,omitempty"`
 }
 
+// refSeriesInfo is the series a reference is part of, e.g. RFC or DOI. See
+// refAuthor.
+type refSeriesInfo struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
 // refFront the reference <front>. See refAuthor.
 type refFront struct {
-	Title  string    `xml:"title"`
-	Author refAuthor `xml:"author"`
-	Date   refDate   `xml:"date"`
+	Title      string          `xml:"title"`
+	Author     []refAuthor     `xml:"author"`
+	Date       refDate         `xml:"date"`
+	SeriesInfo []refSeriesInfo `xml:"seriesInfo"` // v3 puts these in <front>
 }
 
 // refFormat is the reference format. See refAuthor.
@@ -934,42 +1177,65 @@ type refFormat struct {
 
 // refXML is the entire structure. See refAuthor.
 type refXML struct {
-	Anchor string    `xml:"anchor,attr"`
-	Front  refFront  `xml:"front"`
-	Format refFormat `xml:"format"`
+	Anchor     string          `xml:"anchor,attr"`
+	Target     string          `xml:"target,attr"`
+	Front      refFront        `xml:"front"`
+	SeriesInfo []refSeriesInfo `xml:"seriesInfo"`
+	Format     []refFormat     `xml:"format"`
 }
 
-func (options *html) References(out *bytes.Buffer, citations map[string]*citation) {
+func (options *html) References(out *bytes.Buffer, citations map[string]*Citation) {
 	if options.flags&HTML_COMPLETE_PAGE == 0 {
 		return
 	}
-	if len(citations) == 0 {
+	refi, refn, keys := countCitationsAndSort(citations)
+	if refi+refn == 0 {
 		return
 	}
-	options.ial = &inlineAttr{class: map[string]bool{"bibliography": true}}
-	options.Header(out, func() bool { out.WriteString("Bibliography"); return true }, 1, "bibliography")
-	out.WriteString("<ol class=\"bibliography\">\n")
-
-	// [1] Haskell Authors. Haskell.  http://www.haskell.org/ , 1990
-	// <span id=anchor>[x]</span>
-	// there is a CountAndSortCitations in xml2rfc.go, but I want to keep the html.go completely
-	// separate from the xml2rfc stuff.
-	for anchor, cite := range citations {
-		if len(cite.xml) > 0 {
-			var ref refXML
-			if e := xmllib.Unmarshal(cite.xml, &ref); e != nil {
-				printf(nil, "failed to unmarshal reference: `%s': %s", anchor, e)
+	header := func(title string, level int, id string) {
+		options.ial = &Attributes{class: map[string]bool{"bibliography": true}}
+		options.Header(out, func() bool { out.WriteString(title); return true }, level, id)
+	}
+	options.references = true
+	defer func() { options.references = false }()
+	header("Bibliography", 1, "bibliography")
+
+	// [RFC2119] Bradner, S., "Key words for use in RFCs to Indicate Requirement
+	//           Levels", BCP 14, RFC 2119, March 1997, <https://...>.
+	references := func(title, id string, typ byte) {
+		header(title, 2, id)
+		out.WriteString("<dl class=\"bibliography\">\n")
+		for _, k := range keys {
+			c := citations[k]
+			if c.typ != typ {
 				continue
 			}
-			out.WriteString("<li class=\"bibliography\" id=\"" + ref.Anchor + "\">\n")
-			out.WriteString("  " + "<span class=\"bibliography-details\">" + ref.Front.Author.Fullname + ". ")
-			out.WriteString(ref.Front.Title + ". ")
-			out.WriteString("<a href=\"" + ref.Format.Target + "\">" + ref.Format.Target + "</a>\n")
-			out.WriteString("  " + ref.Front.Date.Year + ".</span>\n")
-			out.WriteString("</li>\n")
+			parts, target := referenceParts(options.p, k, c)
+			options.label(k, "["+k+"]")
+			out.WriteString("<dt id=\"")
+			attrEscape(out, bytes.ToLower([]byte(k)))
+			out.WriteString("\">[")
+			attrEscape(out, []byte(k))
+			out.WriteString("]</dt>\n")
+			out.WriteString("<dd><span class=\"bibliography-details\">")
+			attrEscape(out, []byte(strings.Join(parts, ", ")))
+			if target != "" {
+				out.WriteString(", &lt;<a href=\"")
+				attrEscape(out, []byte(target))
+				out.WriteString("\">")
+				attrEscape(out, []byte(target))
+				out.WriteString("</a>&gt;")
+			}
+			out.WriteString(".</span></dd>\n")
 		}
+		out.WriteString("</dl>\n")
+	}
+	if refn > 0 {
+		references("Normative References", "normative-references", 'n')
+	}
+	if refi > 0 {
+		references("Informative References", "informative-references", 'i')
 	}
-	out.WriteString("</ol>\n")
 }
 
 func (options *html) NormalText(out *bytes.Buffer, text []byte) {
@@ -1013,67 +1279,45 @@ func (options *html) DocumentHeader(out *bytes.Buffer, first bool) {
 	if !first {
 		return
 	}
-	if options.flags&HTML_COMPLETE_PAGE == 0 {
-		return
+	if options.flags&HTML_COMPLETE_PAGE != 0 {
+		out.WriteString("<!DOCTYPE html>\n")
+		out.WriteString("<html>\n")
 	}
-
-	out.WriteString("<!DOCTYPE html>\n")
-	out.WriteString("<html>\n")
+	options.tocMarker = out.Len()
+	options.bodyMarker = out.Len()
 }
 
 func (options *html) DocumentFooter(out *bytes.Buffer, first bool) {
 	if !first {
 		return
 	}
-	idx := make(map[string]*bytes.Buffer)
-	idxSlice := []string{}
-	if len(options.index) > 0 {
+	if options.tocEnabled() {
+		options.TocFinalize()
+		options.writeToc(out)
+	}
+	options.resolveXrefs(out)
+
+	if len(options.index.terms) > 0 && options.flags&HTML_OMIT_CONTENTS == 0 {
 		out.WriteString("<div class=\"index\">\n")
-		for k, v := range options.index {
-			prim := false
-			if _, ok := idx[k.primary]; !ok {
-				idx[k.primary] = new(bytes.Buffer)
-				idxSlice = append(idxSlice, k.primary)
-				prim = true
-			}
-			buf := idx[k.primary]
-			if prim {
-				buf.WriteString("<span class=\"index-ref-primary\">" + k.primary + "</span>\n")
-			}
-			if len(k.secondary) == 0 {
-				// if k.secondary is empty we should write the pointers here, because they are meant for
-				// the primary
-				buf.WriteString("<span class=\"index-ref-space\"> </span>")
-				for i, r := range v {
-					buf.WriteString("<a class=\"index-ref-ref\" href=\"" + r + "\">" + strconv.Itoa(i+1) + "</a>")
-					if i+1 < len(v) {
-						buf.WriteByte(',')
-					}
+		options.frontMatter = true // the index is not numbered
+		options.ial = &Attributes{class: map[string]bool{"index": true}}
+		options.Header(out, func() bool { out.WriteString("Index"); return true }, 1, "index-ref-index")
+		for _, group := range options.index.groups() {
+			out.WriteString("<h3 class=\"index-ref-char\">" + group.letter + "</h3>\n")
+			for _, term := range group.terms {
+				out.WriteString("<span class=\"index-ref-primary\">")
+				attrEscape(out, []byte(term.text))
+				out.WriteString("</span>\n")
+				if len(term.refs) > 0 || len(term.see)+len(term.seeAlso) > 0 {
+					options.indexRefs(out, term)
 				}
-				buf.WriteString("\n")
-				continue
-			}
-
-			buf.WriteString("<span class=\"index-ref-secondary\">" + k.secondary + "</span>")
-			buf.WriteString("<span class=\"index-ref-space\"> </span>")
-			for i, r := range v {
-				buf.WriteString("<a class=\"index-ref-ref\" href=\"" + r + "\">" + strconv.Itoa(i+1) + "</a>")
-				if i+1 < len(v) {
-					buf.WriteByte(',')
+				for _, secondary := range term.sorted() {
+					options.indexTerm(out, "secondary", secondary)
+					for _, tertiary := range secondary.sorted() {
+						options.indexTerm(out, "tertiary", tertiary)
+					}
 				}
 			}
-			buf.WriteString("\n")
-		}
-		sort.Strings(idxSlice)
-		options.ial = &inlineAttr{class: map[string]bool{"index": true}}
-		options.Header(out, func() bool { out.WriteString("Index"); return true }, 1, "index-ref-index")
-		char := ""
-		for _, s := range idxSlice {
-			if char != string(s[0]) {
-				out.WriteString("<h3 class=\"index-ref-char\">" + string(s[0]) + "</h3>\n")
-			}
-			out.Write(idx[s].Bytes())
-			char = string(s[0])
 		}
 		out.WriteString("</div>")
 	}
@@ -1085,12 +1329,162 @@ func (options *html) DocumentFooter(out *bytes.Buffer, first bool) {
 }
 
 func (options *html) DocumentMatter(out *bytes.Buffer, matter int) {
-	if matter == _DOC_BACK_MATTER {
+	switch matter {
+	case DOC_FRONT_MATTER:
+		options.frontMatter = true
+	case DOC_MAIN_MATTER:
+		options.frontMatter = false
+		// the table of contents goes after the front matter
+		options.tocMarker = out.Len()
+	case DOC_BACK_MATTER:
+		options.frontMatter = false
 		options.appendix = true
 	}
 }
 
+// caption returns caption prefixed with its number, like "Figure 1: ", with
+// HTML_NUMBERED. Counter is the number of the previous one and id the anchor
+// of the figure or table.
+func (options *html) caption(what string, counter *int, id string, caption []byte) []byte {
+	if options.flags&HTML_NUMBERED == 0 {
+		return caption
+	}
+	*counter++
+	number := what + " " + strconv.Itoa(*counter)
+	options.label(id, number)
+	if len(caption) == 0 {
+		return []byte(number)
+	}
+	return append([]byte(number+": "), caption...)
+}
+
+// indexTerm writes a secondary or tertiary term of the index, level is the
+// class it gets.
+func (options *html) indexTerm(out *bytes.Buffer, level string, term *indexTerm) {
+	out.WriteString("<span class=\"index-ref-" + level + "\">")
+	attrEscape(out, []byte(term.text))
+	out.WriteString("</span>")
+	options.indexRefs(out, term)
+}
+
+// indexRefs writes the references of an index term and the terms it refers to
+// with "see" and "see also". The references are numbered, or with HTML_NUMBERED
+// they show the sections they are in, references in consecutive sections are
+// merged into a range. Primary references are written in bold.
+func (options *html) indexRefs(out *bytes.Buffer, term *indexTerm) {
+	out.WriteString("<span class=\"index-ref-space\"> </span>")
+	ranges := make([]indexRange, len(term.refs))
+	for i, ref := range term.refs {
+		ranges[i] = indexRange{ref, ref}
+	}
+	numbered := options.flags&HTML_NUMBERED != 0
+	if numbered {
+		ranges = mergeRefs(term.refs)
+	}
+	for i, r := range ranges {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		text := strconv.Itoa(i + 1)
+		if numbered && len(r.first.section.count) > 0 {
+			text = r.first.section.String()
+			if !sameSection(r.first.section, r.last.section) {
+				text += "&ndash;" + r.last.section.String()
+			}
+		}
+		if r.first.prim {
+			out.WriteString("<strong>")
+		}
+		out.WriteString("<a class=\"index-ref-ref\" href=\"#" + r.first.anchor + "\">" + text + "</a>")
+		if r.first.prim {
+			out.WriteString("</strong>")
+		}
+	}
+	written := len(ranges) > 0
+	see := func(what string, terms []string) {
+		for _, t := range terms {
+			if written {
+				out.WriteString("; ")
+			}
+			written = true
+			out.WriteString("<span class=\"index-ref-see\"><em>" + what + "</em> ")
+			attrEscape(out, []byte(t))
+			out.WriteString("</span>")
+		}
+	}
+	see("see", term.see)
+	see("see also", term.seeAlso)
+	out.WriteString("\n")
+}
+
+// label sets the text of the cross references to id.
+func (options *html) label(id, text string) {
+	if id != "" {
+		options.labels[id] = text
+	}
+}
+
+// resolveXrefs fills in the text of the cross references in out: the number
+// of the section, figure or table, or the anchor when it has no number.
+func (options *html) resolveXrefs(out *bytes.Buffer) {
+	marker := []byte(xrefMarker)
+	if bytes.Index(out.Bytes(), marker) < 0 {
+		return
+	}
+	parts := bytes.Split(out.Bytes(), marker)
+	var buf bytes.Buffer
+	for i, part := range parts {
+		if i%2 == 0 {
+			buf.Write(part)
+			continue
+		}
+		if text, ok := options.labels[string(part)]; ok {
+			buf.WriteString(text)
+			continue
+		}
+		buf.Write(part)
+	}
+	out.Reset()
+	out.Write(buf.Bytes())
+}
+
+// tocEnabled returns true when we are building a table of contents.
+func (options *html) tocEnabled() bool {
+	return options.flags&(HTML_TOC|HTML_OMIT_CONTENTS) != 0
+}
+
+// tocAnchor returns id, or when id is empty, the anchor the table of contents
+// will use for the next header.
+func (options *html) tocAnchor(id string) string {
+	if id != "" || !options.tocEnabled() {
+		return id
+	}
+	return "toc_" + strconv.Itoa(options.headerCount)
+}
+
+// writeToc inserts the table of contents in out. With HTML_OMIT_CONTENTS it
+// replaces the body of the document.
+func (options *html) writeToc(out *bytes.Buffer) {
+	var rest []byte
+	if options.flags&HTML_OMIT_CONTENTS != 0 {
+		out.Truncate(options.bodyMarker)
+	} else {
+		rest = append(rest, out.Bytes()[options.tocMarker:]...)
+		out.Truncate(options.tocMarker)
+	}
+	if options.toc.Len() > 0 {
+		out.WriteString("<nav class=\"toc\">\n")
+		out.Write(options.toc.Bytes())
+		out.WriteString("</nav>\n")
+	}
+	out.Write(rest)
+}
+
 func (options *html) TocHeaderWithAnchor(text []byte, level int, anchor string) {
+	if options.parameters.TocDepth > 0 && level > options.parameters.TocDepth {
+		options.headerCount++
+		return
+	}
 	for level > options.currentLevel {
 		switch {
 		case bytes.HasSuffix(options.toc.Bytes(), []byte("</li>\n")):
@@ -1126,11 +1520,35 @@ func (options *html) TocHeaderWithAnchor(text []byte, level int, anchor string)
 	options.toc.WriteString("\">")
 	options.headerCount++
 
-	options.toc.Write(text)
+	options.toc.Write(stripLinks(text))
 
 	options.toc.WriteString("</a></li>\n")
 }
 
+// stripLinks returns text without the <a> tags in it, but with the text of the
+// links, so it can be put in a link itself.
+func stripLinks(text []byte) []byte {
+	var out bytes.Buffer
+	for {
+		i := bytes.IndexByte(text, '<')
+		if i < 0 {
+			break
+		}
+		out.Write(text[:i])
+		text = text[i:]
+		if bytes.HasPrefix(text, []byte("<a ")) || bytes.HasPrefix(text, []byte("<a>")) || bytes.HasPrefix(text, []byte("</a>")) {
+			if end := skipUntilCharIgnoreQuotes(text, 0, '>'); text[end] == '>' {
+				text = text[end+1:]
+				continue
+			}
+		}
+		out.WriteByte('<')
+		text = text[1:]
+	}
+	out.Write(text)
+	return out.Bytes()
+}
+
 func (options *html) TocHeader(text []byte, level int) {
 	options.TocHeaderWithAnchor(text, level, "")
 }
@@ -1146,18 +1564,18 @@ func (options *html) TocFinalize() {
 	}
 }
 
-func (options *html) SetAttr(i *inlineAttr) {
+func (options *html) SetAttr(i *Attributes) {
 	options.ial = i
 }
 
-func (options *html) Attr() *inlineAttr {
+func (options *html) Attr() *Attributes {
 	if options.ial == nil {
-		return newInlineAttr()
+		return NewAttributes()
 	}
 	return options.ial
 }
 
-func (options *html) AttrString(i *inlineAttr) string {
+func (options *html) AttrString(i *Attributes) string {
 	if i == nil {
 		return ""
 	}
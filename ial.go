@@ -7,15 +7,17 @@ import (
 	"sort"
 )
 
-// One or more of these can be attached to block elements
-type inlineAttr struct {
+// Attributes are the inline attributes ({#id .class key=value}). One or more of
+// these can be attached to block elements.
+type Attributes struct {
 	id    string            // #id
 	class map[string]bool   // 0 or more .class
 	attr  map[string]string // key=value pairs
 }
 
-func newInlineAttr() *inlineAttr {
-	return &inlineAttr{class: make(map[string]bool), attr: make(map[string]string)}
+// NewAttributes returns an empty set of attributes.
+func NewAttributes() *Attributes {
+	return &Attributes{class: make(map[string]bool), attr: make(map[string]string)}
 }
 
 // Parsing and thus detecting an IAL.
@@ -24,7 +26,7 @@ func (p *parser) isInlineAttr(data []byte) int {
 	esc := false
 	quote := false
 	ialB := 0
-	ial := newInlineAttr()
+	ial := NewAttributes()
 	for i := 0; i < len(data); i++ {
 		switch data[i] {
 		case ' ':
@@ -82,6 +84,7 @@ func (p *parser) isInlineAttr(data []byte) int {
 					return 0
 				}
 			}
+			p.defineAnchor(ial.id)
 			p.ial = p.ial.add(ial)
 			return i + 1
 		default:
@@ -101,7 +104,7 @@ func parseKeyValue(chunk []byte) (string, string) {
 }
 
 // Add IAL to another, overwriting the #id, collapsing classes and attributes
-func (i *inlineAttr) add(j *inlineAttr) *inlineAttr {
+func (i *Attributes) add(j *Attributes) *Attributes {
 	if i == nil {
 		return j
 	}
@@ -117,11 +120,19 @@ func (i *inlineAttr) add(j *inlineAttr) *inlineAttr {
 	return i
 }
 
-func (i *inlineAttr) SetAttr(key, value string) {
+// ID returns the #id of i.
+func (i *Attributes) ID() string { return i.id }
+
+// Class returns true if i has the class class.
+func (i *Attributes) Class(class string) bool { return i.class[class] }
+
+// SetAttr sets the attribute key to value.
+func (i *Attributes) SetAttr(key, value string) {
 	i.attr[key] = value
 }
 
-func (i *inlineAttr) SortClasses() []string {
+// SortClasses returns the classes of i sorted.
+func (i *Attributes) SortClasses() []string {
 	keys := make([]string, 0, len(i.class))
 	for k := range i.class {
 		keys = append(keys, k)
@@ -130,7 +141,8 @@ func (i *inlineAttr) SortClasses() []string {
 	return keys
 }
 
-func (i *inlineAttr) SortAttributes() []string {
+// SortAttributes returns the keys of the attributes of i sorted.
+func (i *Attributes) SortAttributes() []string {
 	keys := make([]string, 0, len(i.attr))
 	for k := range i.attr {
 		keys = append(keys, k)
@@ -142,7 +154,7 @@ func (i *inlineAttr) SortAttributes() []string {
 // GetOrDefaultAttr sets the value under key if is is not set or
 // use the value already in there. The boolean returns indicates
 // if the value has been overwritten.
-func (i *inlineAttr) GetOrDefaultAttr(key, def string) bool {
+func (i *Attributes) GetOrDefaultAttr(key, def string) bool {
 	v := i.attr[key]
 	if v != "" {
 		return false
@@ -156,7 +168,7 @@ func (i *inlineAttr) GetOrDefaultAttr(key, def string) bool {
 
 // GetOrDefaulClass sets the class class. The boolean returns indicates
 // if the value has been overwritten.
-func (i *inlineAttr) GetOrDefaultClass(class string) bool {
+func (i *Attributes) GetOrDefaultClass(class string) bool {
 	_, ok := i.class[class]
 	i.class[class] = true
 	return ok
@@ -164,7 +176,7 @@ func (i *inlineAttr) GetOrDefaultClass(class string) bool {
 
 // GetOrDefaultID sets the id in i if it is not set. The boolean
 // indicates if the id as set in i.
-func (i *inlineAttr) GetOrDefaultId(id string) bool {
+func (i *Attributes) GetOrDefaultId(id string) bool {
 	if i.id != "" {
 		return false
 	}
@@ -179,7 +191,7 @@ func (i *inlineAttr) GetOrDefaultId(id string) bool {
 
 // Key returns the value of a specific key as a ' key="value"' string. If not found
 // an string containing a space is returned.
-func (i *inlineAttr) Key(key string) string {
+func (i *Attributes) Key(key string) string {
 	if v, ok := i.attr[key]; ok {
 		return " " + key + "=\"" + v + "\""
 	}
@@ -188,7 +200,7 @@ func (i *inlineAttr) Key(key string) string {
 
 // Value returns the value of a specific key as value.  If not found
 // an empty string is returned. TODO(miek): should be " " or change Key() above.
-func (i *inlineAttr) Value(key string) string {
+func (i *Attributes) Value(key string) string {
 	if v, ok := i.attr[key]; ok {
 		return v
 	}
@@ -197,14 +209,14 @@ func (i *inlineAttr) Value(key string) string {
 
 // DropAttr will drop the attribute under key from i.
 // The returned boolean indicates if the key was found in i.
-func (i *inlineAttr) DropAttr(key string) bool {
+func (i *Attributes) DropAttr(key string) bool {
 	_, ok := i.attr[key]
 	delete(i.attr, key)
 	return ok
 }
 
 // KeepAttr will drop all attributes, except the ones listed under keys.
-func (i *inlineAttr) KeepAttr(keys []string) {
+func (i *Attributes) KeepAttr(keys []string) {
 	newattr := make(map[string]string)
 	for _, k := range keys {
 		if v, ok := i.attr[k]; ok {
@@ -215,7 +227,7 @@ func (i *inlineAttr) KeepAttr(keys []string) {
 }
 
 // KeepClass will drop all classes, except the ones listed under keys.
-func (i *inlineAttr) KeepClass(keys []string) {
+func (i *Attributes) KeepClass(keys []string) {
 	newclass := make(map[string]bool)
 	for _, k := range keys {
 		if v, ok := i.class[k]; ok {
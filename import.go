@@ -0,0 +1,1181 @@
+// Importing xml2rfc v2 and v3 documents as mmark markdown.
+
+package mmark
+
+import (
+	"bytes"
+	xmllib "encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// importWidth is the column at which imported paragraphs are wrapped.
+const importWidth = 80
+
+// importSpace is a space that wrapping must not break the line at, e.g. in a
+// citation or code span.
+const importSpace = '\x00'
+
+// xmlNode is an element of the document being imported. Character data has an
+// empty name and processing instructions the name "?".
+type xmlNode struct {
+	name     string
+	attr     map[string]string
+	children []*xmlNode
+	text     string // character data or the instruction
+	raw      []byte // the element as it is in the input
+}
+
+// child returns the first child element with the given name.
+func (n *xmlNode) child(name string) *xmlNode {
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// all returns the child elements with the given name.
+func (n *xmlNode) all(name string) []*xmlNode {
+	var nodes []*xmlNode
+	for _, c := range n.children {
+		if c.name == name {
+			nodes = append(nodes, c)
+		}
+	}
+	return nodes
+}
+
+// chardata returns the text in n and its children, with white space collapsed.
+func (n *xmlNode) chardata() string {
+	var b strings.Builder
+	var walk func(*xmlNode)
+	walk = func(n *xmlNode) {
+		if n.name == "" {
+			b.WriteString(n.text)
+			return
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	if n != nil {
+		walk(n)
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+var (
+	importEntity = regexp.MustCompile(`<!ENTITY\s+([^\s%]+)\s+(SYSTEM\s+)?("[^"]*"|'[^']*')`)
+	importPIAttr = regexp.MustCompile(`([\w-]+)\s*=\s*("[^"]*"|'[^']*')`)
+	importRef    = regexp.MustCompile(`&([\w.-]+);`)
+)
+
+// parseImport reads an XML document into a tree of xmlNodes. It returns the root
+// element, the processing instructions outside of it, and the names of the
+// external entities with the file each refers to.
+func parseImport(input []byte) (*xmlNode, []*xmlNode, map[string]string, error) {
+	d := xmllib.NewDecoder(bytes.NewReader(input))
+	d.Strict = false
+	d.CharsetReader = importCharset
+	d.Entity = make(map[string]string)
+	for k, v := range xmllib.HTMLEntity {
+		d.Entity[k] = v
+	}
+	files := make(map[string]string)
+
+	var (
+		root  *xmlNode
+		pis   []*xmlNode
+		stack []*xmlNode
+		start []int64
+	)
+	for {
+		offset := d.InputOffset()
+		token, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		switch t := token.(type) {
+		case xmllib.StartElement:
+			n := &xmlNode{name: t.Name.Local, attr: make(map[string]string)}
+			for _, a := range t.Attr {
+				n.attr[a.Name.Local] = a.Value
+			}
+			if len(stack) > 0 {
+				top := stack[len(stack)-1]
+				top.children = append(top.children, n)
+			} else if root == nil {
+				root = n
+			}
+			stack = append(stack, n)
+			start = append(start, offset)
+		case xmllib.EndElement:
+			if len(stack) == 0 {
+				continue
+			}
+			n := stack[len(stack)-1]
+			n.raw = importDedent(input, start[len(start)-1], d.InputOffset())
+			stack, start = stack[:len(stack)-1], start[:len(start)-1]
+		case xmllib.CharData:
+			if len(stack) > 0 {
+				top := stack[len(stack)-1]
+				top.children = append(top.children, &xmlNode{text: string(t)})
+			}
+		case xmllib.ProcInst:
+			if t.Target != "rfc" {
+				continue
+			}
+			n := &xmlNode{name: "?", text: string(t.Inst)}
+			if len(stack) > 0 {
+				top := stack[len(stack)-1]
+				top.children = append(top.children, n)
+				continue
+			}
+			pis = append(pis, n)
+		case xmllib.Directive:
+			for _, m := range importEntity.FindAllStringSubmatch(string(t), -1) {
+				value := m[3][1 : len(m[3])-1]
+				if m[2] != "" {
+					files[m[1]] = value
+					continue
+				}
+				d.Entity[m[1]] = value
+			}
+		}
+	}
+	if root == nil || root.name != "rfc" {
+		return nil, nil, nil, fmt.Errorf("not an xml2rfc document: no <rfc> element")
+	}
+	return root, pis, files, nil
+}
+
+// importDedent returns input[start:end] with the indentation of the line it
+// starts on removed from all lines.
+func importDedent(input []byte, start, end int64) []byte {
+	indent := 0
+	for i := start - 1; i >= 0 && (input[i] == ' ' || input[i] == '\t'); i-- {
+		indent++
+	}
+	lines := bytes.Split(input[start:end], []byte("\n"))
+	for i := 1; i < len(lines); i++ {
+		j := 0
+		for j < indent && j < len(lines[i]) && (lines[i][j] == ' ' || lines[i][j] == '\t') {
+			j++
+		}
+		lines[i] = lines[i][j:]
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
+
+// importCharset returns a reader that converts input in charset to UTF-8. Only
+// ASCII and Latin-1 are supported, as these are the ones xml2rfc documents use.
+func importCharset(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "us-ascii", "ascii", "utf-8":
+		return input, nil
+	case "iso-8859-1", "latin1":
+		data, err := ioutil.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		runes := make([]rune, len(data))
+		for i, c := range data {
+			runes[i] = rune(c)
+		}
+		return strings.NewReader(string(runes)), nil
+	}
+	return nil, fmt.Errorf("unsupported charset: %s", charset)
+}
+
+// importAttrs returns the attributes in the instruction of a processing
+// instruction, in order.
+func importAttrs(inst string) [][2]string {
+	var attrs [][2]string
+	for _, m := range importPIAttr.FindAllStringSubmatch(inst, -1) {
+		attrs = append(attrs, [2]string{m[1], m[2][1 : len(m[2])-1]})
+	}
+	return attrs
+}
+
+// importAnchor returns the anchor of the reference in the bibxml file name, e.g.
+// RFC2119 for reference.RFC.2119.xml.
+func importAnchor(file string) string {
+	name := strings.TrimSuffix(path.Base(file), ".xml")
+	name = strings.TrimPrefix(name, "reference.")
+	if strings.HasPrefix(name, "RFC.") {
+		return "RFC" + name[4:]
+	}
+	return name
+}
+
+// importer holds the state of converting an xml2rfc document to markdown.
+type importer struct {
+	files map[string]string // external entities and the file they refer to
+	refs  map[string]byte   // the references and their type: '!' or '?'
+	order []string          // the references in document order
+	raw   map[string][]byte // the <reference> XML of the references that need it
+	cited map[string]bool   // the references that are cited in the text
+	depth int               // the current section level
+	crefs []string          // comments found in the current paragraph
+	cell  bool              // converting a table cell
+}
+
+// ImportXML converts an xml2rfc v2 or v3 document to mmark markdown: the front
+// matter becomes the TOML titleblock and the abstract, the sections, lists,
+// figures and tables are converted to their markdown equivalents and the
+// references become citations. References that xml2rfc can't fetch by
+// themselves are included as raw <reference> blocks.
+func ImportXML(input []byte) ([]byte, error) {
+	root, pis, files, err := parseImport(input)
+	if err != nil {
+		return nil, err
+	}
+	im := &importer{
+		files: files,
+		refs:  make(map[string]byte),
+		raw:   make(map[string][]byte),
+		cited: make(map[string]bool),
+	}
+	for _, n := range root.all("back") {
+		for _, r := range n.all("references") {
+			im.references(r, '?')
+		}
+	}
+
+	var out bytes.Buffer
+	front := root.child("front")
+	if front == nil {
+		front = &xmlNode{name: "front"}
+	}
+	im.titleBlock(&out, root, front, pis)
+
+	if a := front.child("abstract"); a != nil {
+		im.write(&out, ".# Abstract")
+		im.write(&out, im.flow(a.children)...)
+	}
+	for _, n := range front.all("note") {
+		im.write(&out, ".# "+im.name(n))
+		im.write(&out, im.flow(n.children)...)
+	}
+
+	im.write(&out, "{mainmatter}")
+	if m := root.child("middle"); m != nil {
+		im.write(&out, im.flow(m.children)...)
+	}
+
+	// References cited in the appendices only are listed here as well: they
+	// are output when {backmatter} starts.
+	var uncited []string
+	for _, anchor := range im.order {
+		if raw, ok := im.raw[anchor]; ok {
+			im.write(&out, string(raw))
+		}
+		if !im.cited[anchor] {
+			uncited = append(uncited, "[-@"+string(im.refs[anchor])+anchor+"]")
+		}
+	}
+	if len(uncited) > 0 {
+		im.write(&out, strings.Join(uncited, "\n"))
+	}
+
+	im.write(&out, "{backmatter}")
+	for _, n := range root.all("back") {
+		im.write(&out, im.flow(n.children)...)
+	}
+	return out.Bytes(), nil
+}
+
+// write writes the blocks to out, separated by blank lines.
+func (im *importer) write(out *bytes.Buffer, blocks ...string) {
+	for _, b := range blocks {
+		if out.Len() > 0 {
+			out.WriteByte('\n')
+		}
+		out.WriteString(b)
+		out.WriteByte('\n')
+	}
+}
+
+// references records the references in the <references> element n. They are
+// normative when the title of n, or that of the section it is in, says so.
+func (im *importer) references(n *xmlNode, typ byte) {
+	title := n.attr["title"]
+	if name := n.child("name"); name != nil {
+		title = name.chardata()
+	}
+	switch t := strings.ToLower(title); {
+	case strings.Contains(t, "informative"):
+		typ = '?'
+	case strings.Contains(t, "normative"):
+		typ = '!'
+	}
+
+	add := func(anchor string, raw []byte) {
+		if anchor == "" {
+			return
+		}
+		if _, ok := im.refs[anchor]; !ok {
+			im.order = append(im.order, anchor)
+		}
+		im.refs[anchor] = typ
+		if raw != nil {
+			im.raw[anchor] = raw
+		}
+	}
+	for _, c := range n.children {
+		switch c.name {
+		case "references":
+			im.references(c, typ)
+		case "reference", "referencegroup":
+			anchor := c.attr["anchor"]
+			if isRFCAnchor(anchor) {
+				add(anchor, nil)
+				continue
+			}
+			raw := c.raw
+			if i := bytes.IndexFunc(raw, unicode.IsSpace); i > 0 {
+				// the <reference> block must start with "<reference "
+				raw = append(append(append([]byte{}, raw[:i]...), ' '), bytes.TrimLeftFunc(raw[i:], unicode.IsSpace)...)
+			}
+			add(anchor, raw)
+		case "include":
+			add(importAnchor(c.attr["href"]), nil)
+		case "?":
+			for _, a := range importAttrs(c.text) {
+				if a[0] == "include" {
+					add(importAnchor(a[1]), nil)
+				}
+			}
+		case "":
+			for _, m := range importRef.FindAllStringSubmatch(c.text, -1) {
+				if file, ok := im.files[m[1]]; ok {
+					add(importAnchor(file), nil)
+				}
+			}
+		}
+	}
+}
+
+// isRFCAnchor returns true if anchor is the default anchor of an RFC, e.g.
+// RFC2119. Their reference is fetched by xml2rfc.
+func isRFCAnchor(anchor string) bool {
+	if len(anchor) < 4 || !strings.HasPrefix(anchor, "RFC") {
+		return false
+	}
+	for _, c := range anchor[3:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// name returns the title of a section, figure or table: its title attribute in
+// v2 or its <name> in v3.
+func (im *importer) name(n *xmlNode) string {
+	if name := n.child("name"); name != nil {
+		return strings.Replace(im.inline(name.children), string(importSpace), " ", -1)
+	}
+	return im.escape(strings.Join(strings.Fields(n.attr["title"]), " "))
+}
+
+// importInline are the elements that are part of a paragraph.
+var importInline = map[string]bool{
+	"": true, "xref": true, "eref": true, "iref": true, "cref": true, "spanx": true,
+	"em": true, "strong": true, "tt": true, "sub": true, "sup": true, "bcp14": true,
+	"relref": true, "u": true,
+}
+
+// flow converts the block level content in nodes. Inline content is gathered
+// into paragraphs.
+func (im *importer) flow(nodes []*xmlNode) []string {
+	var (
+		blocks []string
+		para   []*xmlNode
+	)
+	flush := func() {
+		if p := im.paragraph(para); p != "" {
+			blocks = append(blocks, p)
+		}
+		blocks = append(blocks, im.crefs...)
+		para, im.crefs = nil, nil
+	}
+	for _, n := range nodes {
+		if importInline[n.name] {
+			para = append(para, n)
+			continue
+		}
+		flush()
+		blocks = append(blocks, im.block(n)...)
+	}
+	flush()
+	return blocks
+}
+
+// block converts a block level element.
+func (im *importer) block(n *xmlNode) []string {
+	switch n.name {
+	case "?", "name", "references", "vspace", "br", "seriesInfo":
+		return nil
+	case "section":
+		return im.section(n)
+	case "list":
+		return []string{im.list(n, "empty")}
+	case "ul", "ol":
+		return []string{im.list(n, "")}
+	case "dl":
+		return []string{im.dl(n)}
+	case "figure":
+		return im.figure(n)
+	case "artwork", "sourcecode":
+		return im.artwork(n)
+	case "texttable", "table":
+		return im.table(n)
+	case "blockquote":
+		return []string{importPrefix("> ", strings.Join(im.flow(n.children), "\n\n"))}
+	case "aside":
+		return []string{importPrefix("A> ", strings.Join(im.flow(n.children), "\n\n"))}
+	}
+	return im.flow(n.children)
+}
+
+// section converts a (sub)section and everything in it.
+func (im *importer) section(n *xmlNode) []string {
+	im.depth++
+	defer func() { im.depth-- }()
+
+	header := strings.Repeat("#", im.depth) + " " + im.name(n)
+	if anchor := n.attr["anchor"]; anchor != "" {
+		header += " {#" + anchor + "}"
+	}
+	return append([]string{header}, im.flow(n.children)...)
+}
+
+// list converts a v2 <list>, or a v3 <ul> or <ol>. Style is the style of the
+// enclosing v2 list, which nested lists inherit.
+func (im *importer) list(n *xmlNode, style string) string {
+	if s := n.attr["style"]; s != "" {
+		style = s
+	}
+	marker := "*"
+	switch {
+	case n.name == "ol":
+		marker = "1."
+		switch n.attr["type"] {
+		case "a", "A", "i", "I":
+			marker = n.attr["type"] + "."
+		}
+	case style == "numbers":
+		marker = "1."
+	case style == "letters":
+		marker = "a."
+	case strings.HasPrefix(style, "format"):
+		marker = "1."
+		switch {
+		case strings.Contains(style, "%c"):
+			marker = "a."
+		case strings.Contains(style, "%C"):
+			marker = "A."
+		case strings.Contains(style, "%i"):
+			marker = "i."
+		case strings.Contains(style, "%I"):
+			marker = "I."
+		}
+	case style == "hanging":
+		return im.hanging(n)
+	case style == "empty":
+		marker = ""
+	}
+
+	var items []string
+	tight := marker != ""
+	for _, c := range n.children {
+		if c.name != "t" && c.name != "li" {
+			continue
+		}
+		var blocks []string
+		if c.name == "t" {
+			blocks = im.listFlow(c.children, style)
+		} else {
+			blocks = im.flow(c.children)
+		}
+		if marker == "" {
+			items = append(items, blocks...)
+			continue
+		}
+		// a list is only tight when each item is a single paragraph
+		if len(blocks) > 1 || len(blocks) == 1 && strings.Contains(blocks[0], "\n\n") {
+			tight = false
+		}
+		items = append(items, importPrefix(fmt.Sprintf("%-4s", marker), strings.Join(blocks, "\n\n")))
+	}
+	if tight {
+		return strings.Join(items, "\n")
+	}
+	return strings.Join(items, "\n\n")
+}
+
+// listFlow is flow for the content of a v2 list item, where nested lists
+// inherit the style of their parent.
+func (im *importer) listFlow(nodes []*xmlNode, style string) []string {
+	var blocks []string
+	from := 0
+	for i, n := range nodes {
+		if n.name != "list" {
+			continue
+		}
+		blocks = append(blocks, im.flow(nodes[from:i])...)
+		blocks = append(blocks, im.list(n, style))
+		from = i + 1
+	}
+	return append(blocks, im.flow(nodes[from:])...)
+}
+
+// hanging converts a v2 list with hanging text to a definition list.
+func (im *importer) hanging(n *xmlNode) string {
+	var items []string
+	for _, t := range n.all("t") {
+		term := im.escape(strings.Join(strings.Fields(t.attr["hangText"]), " "))
+		items = append(items, importTerm(term, im.listFlow(t.children, "hanging")))
+	}
+	return strings.Join(items, "\n\n")
+}
+
+// dl converts a v3 <dl>.
+func (im *importer) dl(n *xmlNode) string {
+	var (
+		items []string
+		term  string
+	)
+	for _, c := range n.children {
+		switch c.name {
+		case "dt":
+			term = strings.Replace(im.inline(c.children), string(importSpace), " ", -1)
+		case "dd":
+			items = append(items, importTerm(term, im.flow(c.children)))
+			term = ""
+		}
+	}
+	return strings.Join(items, "\n\n")
+}
+
+// importTerm returns the definition list item for term and the blocks that
+// define it.
+func importTerm(term string, blocks []string) string {
+	if len(blocks) == 0 {
+		blocks = []string{""}
+	}
+	return term + "\n" + importPrefix(":   ", strings.Join(blocks, "\n\n"))
+}
+
+// importPrefix puts prefix in front of the first line of text and indents the
+// others to match, except for blockquotes and asides where prefix is repeated
+// on every line.
+func importPrefix(prefix, text string) string {
+	indent := strings.Repeat(" ", len(prefix))
+	if strings.HasSuffix(prefix, "> ") {
+		indent = prefix
+	}
+	lines := strings.Split(text, "\n")
+	for i := range lines {
+		switch {
+		case i == 0:
+			lines[i] = prefix + lines[i]
+		case lines[i] != "" || indent != strings.Repeat(" ", len(prefix)):
+			lines[i] = indent + lines[i]
+		}
+		lines[i] = strings.TrimRight(lines[i], " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// figure converts a figure: the preamble, the artwork with the title as caption
+// and the postamble.
+func (im *importer) figure(n *xmlNode) []string {
+	var blocks []string
+	if pre := n.child("preamble"); pre != nil {
+		blocks = append(blocks, im.flow(pre.children)...)
+	}
+	var art []string
+	for _, c := range n.children {
+		if c.name == "artwork" || c.name == "sourcecode" {
+			art = append(art, im.artwork(c)...)
+		}
+	}
+	if len(art) > 0 {
+		if anchor := n.attr["anchor"]; anchor != "" && !strings.HasPrefix(art[0], "{#") {
+			art[0] = "{#" + anchor + "}\n" + art[0]
+		}
+		if title := im.name(n); title != "" {
+			art[len(art)-1] += "\nFigure: " + title
+		}
+		blocks = append(blocks, art...)
+	}
+	if post := n.child("postamble"); post != nil {
+		blocks = append(blocks, im.flow(post.children)...)
+	}
+	return blocks
+}
+
+// artwork converts an <artwork> or <sourcecode> to a fenced code block.
+func (im *importer) artwork(n *xmlNode) []string {
+	var b strings.Builder
+	for _, c := range n.children {
+		if c.name == "" {
+			b.WriteString(c.text)
+		}
+	}
+	lines := strings.Split(strings.TrimRight(b.String(), " \t\n"), "\n")
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	text := strings.Join(lines, "\n")
+
+	fence := "~~~"
+	for strings.Contains(text, fence) {
+		fence += "~"
+	}
+	open := fence
+	if typ := n.attr["type"]; typ != "" && typ != "ascii-art" && !strings.ContainsAny(typ, " \t") {
+		open += " " + typ
+	}
+	block := open + "\n" + text + "\n" + fence
+	if text == "" {
+		block = open + "\n" + fence
+	}
+	if anchor := n.attr["anchor"]; anchor != "" {
+		block = "{#" + anchor + "}\n" + block
+	}
+	return []string{block}
+}
+
+// table converts a v2 <texttable> or a v3 <table> to a pipe table.
+func (im *importer) table(n *xmlNode) []string {
+	var (
+		blocks []string
+		header []string
+		align  []string
+		rows   [][]string
+	)
+	im.cell = true
+	if n.name == "texttable" {
+		if pre := n.child("preamble"); pre != nil {
+			im.cell = false
+			blocks = append(blocks, im.flow(pre.children)...)
+			im.cell = true
+		}
+		for _, c := range n.all("ttcol") {
+			header = append(header, im.tableCell(c))
+			align = append(align, c.attr["align"])
+		}
+		var row []string
+		for _, c := range n.all("c") {
+			row = append(row, im.tableCell(c))
+			if len(row) == len(header) {
+				rows = append(rows, row)
+				row = nil
+			}
+		}
+		if len(row) > 0 {
+			rows = append(rows, row)
+		}
+	} else {
+		var trs []*xmlNode
+		for _, part := range []string{"thead", "tbody", "tfoot"} {
+			for _, p := range n.all(part) {
+				trs = append(trs, p.all("tr")...)
+			}
+		}
+		trs = append(trs, n.all("tr")...)
+		for i, tr := range trs {
+			var row []string
+			for _, c := range tr.children {
+				if c.name != "th" && c.name != "td" {
+					continue
+				}
+				row = append(row, im.tableCell(c))
+				if i == 0 {
+					align = append(align, c.attr["align"])
+				}
+			}
+			if i == 0 {
+				header = row
+				continue
+			}
+			rows = append(rows, row)
+		}
+	}
+	im.cell = false
+
+	var b strings.Builder
+	if anchor := n.attr["anchor"]; anchor != "" {
+		b.WriteString("{#" + anchor + "}\n")
+	}
+	importRow(&b, header, len(header))
+	for i := range header {
+		switch align[i] {
+		case "center":
+			b.WriteString("|:-:")
+		case "right":
+			b.WriteString("|--:")
+		case "left":
+			b.WriteString("|:--")
+		default:
+			b.WriteString("|---")
+		}
+	}
+	b.WriteString("|\n")
+	for _, row := range rows {
+		importRow(&b, row, len(header))
+	}
+	if title := im.name(n); title != "" {
+		b.WriteString("Table: " + title + "\n")
+	}
+	blocks = append(blocks, strings.TrimSuffix(b.String(), "\n"))
+
+	if post := n.child("postamble"); post != nil {
+		blocks = append(blocks, im.flow(post.children)...)
+	}
+	return blocks
+}
+
+// tableCell returns the content of a table cell on a single line.
+func (im *importer) tableCell(n *xmlNode) string {
+	var nodes []*xmlNode
+	for _, c := range n.children {
+		if importInline[c.name] {
+			nodes = append(nodes, c)
+			continue
+		}
+		// the paragraphs in a v3 cell
+		nodes = append(nodes, &xmlNode{text: " "})
+		nodes = append(nodes, c.children...)
+	}
+	return strings.Replace(im.inline(nodes), string(importSpace), " ", -1)
+}
+
+// importRow writes a table row with n columns.
+func importRow(b *strings.Builder, row []string, n int) {
+	for i := 0; i < n; i++ {
+		cell := ""
+		if i < len(row) {
+			cell = row[i]
+		}
+		b.WriteString("| " + cell + " ")
+	}
+	b.WriteString("|\n")
+}
+
+// paragraph converts inline content to a paragraph, wrapped at importWidth.
+func (im *importer) paragraph(nodes []*xmlNode) string {
+	text := im.inline(nodes)
+	if text == "" {
+		return ""
+	}
+	words := strings.Split(text, " ")
+	if w := words[0]; importBlockStart(w) {
+		switch {
+		case importListItem(w):
+			words[0] = w[:len(w)-1] + "\\" + w[len(w)-1:]
+		case bytes.IndexByte(escapeChars, w[0]) >= 0:
+			words[0] = "\\" + w
+		}
+	}
+
+	var (
+		lines []string
+		line  string
+	)
+	for _, w := range words {
+		switch {
+		case line == "":
+			line = w
+		case len(line)+1+len(w) > importWidth && !importBlockStart(w):
+			lines = append(lines, line)
+			line = w
+		default:
+			line += " " + w
+		}
+	}
+	lines = append(lines, line)
+	return strings.Replace(strings.Join(lines, "\n"), string(importSpace), " ", -1)
+}
+
+// importBlockStart returns true if a line starting with word could be taken
+// for something else than the continuation of a paragraph, e.g. a header or a
+// list item.
+func importBlockStart(word string) bool {
+	if word == "" {
+		return false
+	}
+	switch word[0] {
+	case '#', '>', '+', '-', '=', ':', '|', '%', '.':
+		return true
+	case '*':
+		// a list item or a rule, but not emphasis
+		return strings.Trim(word, "*") == ""
+	}
+	if strings.HasSuffix(word, ">") || word == "Figure:" || word == "Table:" {
+		return true
+	}
+	return importListItem(word)
+}
+
+// importListItem returns true if word is an ordered list marker, e.g. 1. or iv).
+func importListItem(word string) bool {
+	i := len(word) - 1
+	if i < 1 || i > 4 || (word[i] != '.' && word[i] != ')') {
+		return false
+	}
+	for j := 0; j < i; j++ {
+		if !isalnum(word[j]) {
+			return false
+		}
+	}
+	return true
+}
+
+// inline converts the inline content in nodes to a single line. Spaces that
+// must not be broken by wrapping are importSpace.
+func (im *importer) inline(nodes []*xmlNode) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		im.inlineNode(&b, n)
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func (im *importer) inlineNode(b *strings.Builder, n *xmlNode) {
+	unbreakable := func(s string) string {
+		return strings.Replace(strings.Join(strings.Fields(s), " "), " ", string(importSpace), -1)
+	}
+	inner := func() string {
+		return im.inline(n.children)
+	}
+
+	switch n.name {
+	case "":
+		b.WriteString(im.escape(n.text))
+	case "xref", "relref":
+		target := n.attr["target"]
+		if typ, ok := im.refs[target]; ok {
+			im.cited[target] = true
+			cite := "[@" + string(typ) + target
+			if s := n.attr["section"]; s != "" {
+				cite += " " + s
+			}
+			b.WriteString(unbreakable(cite + "]"))
+			return
+		}
+		if text := inner(); text != "" {
+			b.WriteString("[" + text + "](#" + target + ")")
+			return
+		}
+		b.WriteString("(#" + target + ")")
+	case "eref":
+		target := n.attr["target"]
+		if text := inner(); text != "" && text != im.escape(target) {
+			b.WriteString("[" + text + "](" + target + ")")
+			return
+		}
+		b.WriteString("<" + target + ">")
+	case "iref":
+		index := "(((" + n.attr["item"]
+		if n.attr["primary"] == "true" {
+			index = "(((!" + n.attr["item"]
+		}
+		if sub := n.attr["subitem"]; sub != "" {
+			index += ", " + sub
+		}
+		b.WriteString(unbreakable(index + ")))"))
+	case "cref":
+		source := n.attr["source"]
+		if source == "" {
+			source = "Note"
+		}
+		im.crefs = append(im.crefs, "<!-- "+source+" -- "+n.chardata()+" -->")
+	case "spanx":
+		switch n.attr["style"] {
+		case "", "emph":
+			b.WriteString(importEmphasis("*", inner()))
+		case "strong":
+			b.WriteString(importEmphasis("**", unbreakable(inner())))
+		default:
+			b.WriteString(importCode(n.chardata()))
+		}
+	case "em":
+		b.WriteString(importEmphasis("*", inner()))
+	case "strong", "bcp14":
+		// BCP 14 keywords are bold in mmark, they are only recognized when
+		// they are on one line
+		b.WriteString(importEmphasis("**", unbreakable(inner())))
+	case "tt":
+		b.WriteString(importCode(n.chardata()))
+	case "sub":
+		b.WriteString(importEmphasis("~", unbreakable(inner())))
+	case "sup":
+		b.WriteString(importEmphasis("^", unbreakable(inner())))
+	default:
+		b.WriteString(inner())
+	}
+}
+
+// importEmphasis puts mark around text, if there is any.
+func importEmphasis(mark, text string) string {
+	if text == "" {
+		return ""
+	}
+	return mark + text + mark
+}
+
+// importCode returns text as a code span.
+func importCode(text string) string {
+	if text == "" {
+		return ""
+	}
+	tick := "`"
+	for strings.Contains(text, tick) {
+		tick += "`"
+	}
+	if strings.HasPrefix(text, "`") || strings.HasSuffix(text, "`") {
+		text = " " + text + " "
+	}
+	return strings.Replace(tick+text+tick, " ", string(importSpace), -1)
+}
+
+// escape escapes the characters in text that have a meaning in mmark.
+func (im *importer) escape(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		next := byte(0)
+		if i+1 < len(text) {
+			next = text[i+1]
+		}
+		switch {
+		case c == '\\', c == '`', c == '*', c == '_', c == '~', c == '[', c == ']',
+			c == '<', c == '^', c == '{', c == '|' && im.cell:
+			b.WriteByte('\\')
+		case c == '(' && (next == '(' || next == '#' || next == '@'):
+			b.WriteByte('\\')
+		case c == '&' && (isalnum(next) || next == '#'):
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// titleBlock writes the TOML titleblock for the document.
+func (im *importer) titleBlock(out *bytes.Buffer, root, front *xmlNode, pis []*xmlNode) {
+	var lines []string
+	str := func(key, value string) {
+		if value != "" {
+			lines = append(lines, key+" = "+tomlString(value))
+		}
+	}
+	strs := func(key string, values []string) {
+		quoted := make([]string, len(values))
+		for i := range values {
+			quoted[i] = tomlString(values[i])
+		}
+		lines = append(lines, key+" = ["+strings.Join(quoted, ", ")+"]")
+	}
+	ints := func(key, value string) {
+		numbers := regexp.MustCompile(`[0-9]+`).FindAllString(value, -1)
+		if len(numbers) == 0 {
+			return
+		}
+		for i := range numbers {
+			n, _ := strconv.Atoi(numbers[i])
+			numbers[i] = strconv.Itoa(n)
+		}
+		lines = append(lines, key+" = ["+strings.Join(numbers, ", ")+"]")
+	}
+
+	title := front.child("title")
+	str("Title", title.chardata())
+	if title != nil {
+		str("abbrev", title.attr["abbrev"])
+	}
+	docName := root.attr["docName"]
+	for _, s := range front.all("seriesInfo") {
+		if docName == "" && s.attr["name"] == "Internet-Draft" {
+			docName = s.attr["value"]
+		}
+	}
+	if docName == "" && root.attr["number"] != "" {
+		docName = "rfc-" + root.attr["number"]
+	}
+	str("docName", docName)
+	str("ipr", root.attr["ipr"])
+	str("category", root.attr["category"])
+	str("submissionType", root.attr["submissionType"])
+	ints("updates", root.attr["updates"])
+	ints("obsoletes", root.attr["obsoletes"])
+	str("area", front.child("area").chardata())
+	str("workgroup", front.child("workgroup").chardata())
+	var keywords []string
+	for _, k := range front.all("keyword") {
+		if k := k.chardata(); k != "" {
+			keywords = append(keywords, k)
+		}
+	}
+	if len(keywords) > 0 {
+		strs("keyword", keywords)
+	}
+	if date := importDate(front.child("date")); date != "" {
+		lines = append(lines, "", "date = "+date)
+	}
+
+	var pi [][2]string
+	for _, n := range append(pis, importPIs(root)...) {
+		for _, a := range importAttrs(n.text) {
+			if a[0] == "include" || a[0] == "needLines" {
+				continue
+			}
+			found := false
+			for i := range pi {
+				if pi[i][0] == a[0] {
+					pi[i][1], found = a[1], true
+				}
+			}
+			if !found {
+				pi = append(pi, a)
+			}
+		}
+	}
+	if len(pi) > 0 {
+		lines = append(lines, "", "[pi]")
+		for _, a := range pi {
+			str(a[0], a[1])
+		}
+	}
+
+	for _, a := range front.all("author") {
+		lines = append(lines, "", "[[author]]")
+		str("initials", a.attr["initials"])
+		str("surname", a.attr["surname"])
+		str("fullname", a.attr["fullname"])
+		str("ascii", a.attr["asciiFullname"])
+		str("role", a.attr["role"])
+		if org := a.child("organization"); org != nil {
+			str("organization", org.chardata())
+			str("abbrev", org.attr["abbrev"])
+		}
+		addr := a.child("address")
+		if addr == nil {
+			continue
+		}
+		lines = append(lines, "  [author.address]")
+		str("  phone", addr.child("phone").chardata())
+		str("  email", addr.child("email").chardata())
+		str("  uri", addr.child("uri").chardata())
+		postal := addr.child("postal")
+		if postal == nil {
+			continue
+		}
+		lines = append(lines, "  [author.address.postal]")
+		for _, f := range []struct{ elem, one, many string }{
+			{"street", "street", "streets"},
+			{"city", "city", "cities"},
+			{"region", "region", "regions"},
+			{"code", "code", "codes"},
+			{"country", "country", "countries"},
+			{"postalLine", "", "postalLine"},
+		} {
+			var values []string
+			for _, v := range postal.all(f.elem) {
+				if v := v.chardata(); v != "" {
+					values = append(values, v)
+				}
+			}
+			switch {
+			case len(values) == 1 && f.one != "":
+				str("  "+f.one, values[0])
+			case len(values) > 0:
+				strs("  "+f.many, values)
+			}
+		}
+	}
+
+	for _, l := range lines {
+		if l == "" {
+			out.WriteString("%\n")
+			continue
+		}
+		out.WriteString("% " + l + "\n")
+	}
+}
+
+// importPIs returns the processing instructions in n and its children.
+func importPIs(n *xmlNode) []*xmlNode {
+	var pis []*xmlNode
+	for _, c := range n.children {
+		if c.name == "?" {
+			pis = append(pis, c)
+			continue
+		}
+		pis = append(pis, importPIs(c)...)
+	}
+	return pis
+}
+
+// importDate returns the <date> as a TOML date, or the empty string if it has
+// no year.
+func importDate(n *xmlNode) string {
+	if n == nil {
+		return ""
+	}
+	year, err := strconv.Atoi(n.attr["year"])
+	if err != nil {
+		return ""
+	}
+	month := time.January
+	if m := n.attr["month"]; m != "" {
+		if i, err := strconv.Atoi(m); err == nil && i >= 1 && i <= 12 {
+			month = time.Month(i)
+		}
+		for i := time.January; i <= time.December; i++ {
+			if strings.EqualFold(m, i.String()) || strings.EqualFold(m, i.String()[:3]) {
+				month = i
+			}
+		}
+	}
+	day, err := strconv.Atoi(n.attr["day"])
+	if err != nil || day < 1 {
+		day = 1
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Format(time.RFC3339)
+}
+
+// tomlString returns s as a TOML basic string.
+func tomlString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < ' ' || r == 0x7f:
+			fmt.Fprintf(&b, "\\u%04X", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
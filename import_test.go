@@ -0,0 +1,205 @@
+// Unit tests for importing xml2rfc documents
+
+package mmark
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestImportXML(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected []string
+	}{
+		{
+			`<?xml version="1.0" encoding="US-ASCII"?>
+<!DOCTYPE rfc SYSTEM "rfc2629.dtd" [
+<!ENTITY RFC2119 SYSTEM "http://xml.resource.org/public/rfc/bibxml/reference.RFC.2119.xml">
+<!ENTITY RFC5226 SYSTEM "http://xml.resource.org/public/rfc/bibxml/reference.RFC.5226.xml">
+]>
+<?rfc toc="yes"?>
+<?rfc symrefs="yes" sortrefs='yes'?>
+<rfc category="std" docName="draft-ietf-foo-bar-03" ipr="trust200902" updates="1035, 2136">
+  <front>
+    <title abbrev="Foo">The "Foo" Protocol</title>
+    <author fullname="Jane Doe" initials="J." surname="Doe">
+      <organization>Example</organization>
+      <address>
+        <postal><street>1 Main St</street><street>Suite 2</street><city>Springfield</city></postal>
+        <email>jane@example.com</email>
+      </address>
+    </author>
+    <date month="March" year="2016" />
+    <keyword>foo</keyword>
+    <keyword>bar</keyword>
+    <abstract><t>This is *Foo*.</t></abstract>
+  </front>
+  <middle>
+    <section title="Introduction" anchor="intro">
+      <t>See <xref target="RFC2119"/>, <xref target="terms"/> and
+      <eref target="https://example.com">the site</eref>.<iref item="Foo" subitem="intro"/>
+      <cref source="jd">Fix this.</cref></t>
+      <t><list style="numbers">
+        <t>First</t>
+        <t>Second<list style="symbols"><t>nested</t></list></t>
+      </list></t>
+      <t><list style="hanging"><t hangText="Term">Definition of <spanx style="verb">term</spanx>.</t></list></t>
+      <section title="Terms" anchor="terms">
+        <figure anchor="fig" title="Box">
+          <artwork><![CDATA[
+  +---+
+  | A |
+  +---+
+]]></artwork>
+        </figure>
+        <texttable anchor="tab" title="Values">
+          <ttcol align="left">Name</ttcol>
+          <ttcol align="right">Value</ttcol>
+          <c>a|b</c><c>1</c>
+        </texttable>
+      </section>
+    </section>
+  </middle>
+  <back>
+    <references title="Normative References">
+      &RFC2119;
+    </references>
+    <references title="Informative References">
+      &RFC5226;
+      <reference anchor="DSM-IV" target="http://example.com/dsm">
+        <front><title>Diagnostic Manual</title></front>
+      </reference>
+    </references>
+    <section title="Acknowledgements">
+      <t>Thanks.</t>
+    </section>
+  </back>
+</rfc>`,
+			[]string{
+				`% Title = "The \"Foo\" Protocol"
+% abbrev = "Foo"
+% docName = "draft-ietf-foo-bar-03"
+% ipr = "trust200902"
+% category = "std"
+% updates = [1035, 2136]
+% keyword = ["foo", "bar"]
+%
+% date = 2016-03-01T00:00:00Z
+%
+% [pi]
+% toc = "yes"
+% symrefs = "yes"
+% sortrefs = "yes"
+%
+% [[author]]
+% initials = "J."
+% surname = "Doe"
+% fullname = "Jane Doe"
+% organization = "Example"
+%   [author.address]
+%   email = "jane@example.com"
+%   [author.address.postal]
+%   streets = ["1 Main St", "Suite 2"]
+%   city = "Springfield"
+`,
+				".# Abstract\n\nThis is \\*Foo\\*.\n\n{mainmatter}\n\n# Introduction {#intro}\n",
+				"See [@!RFC2119], (#terms) and [the site](https://example.com).(((Foo, intro)))\n\n<!-- jd -- Fix this. -->\n",
+				"1.  First\n\n1.  Second\n\n    *   nested\n",
+				"Term\n:   Definition of `term`.\n",
+				"## Terms {#terms}\n\n{#fig}\n~~~\n  +---+\n  | A |\n  +---+\n~~~\nFigure: Box\n",
+				"{#tab}\n| Name | Value |\n|:--|--:|\n| a\\|b | 1 |\nTable: Values\n",
+				"<reference anchor=\"DSM-IV\" target=\"http://example.com/dsm\">\n  <front><title>Diagnostic Manual</title></front>\n</reference>\n\n[-@?RFC5226]\n[-@?DSM-IV]\n\n{backmatter}\n\n# Acknowledgements\n",
+			},
+		},
+		{
+			`<rfc xmlns:xi="http://www.w3.org/2001/XInclude" category="info" obsoletes="RFC 7001" version="3">
+  <front>
+    <title>Three</title>
+    <seriesInfo name="Internet-Draft" value="draft-three-00"/>
+    <date year="2019" month="7" day="4"/>
+    <note><name>Note to Readers</name><t>Read it.</t></note>
+  </front>
+  <middle>
+    <section anchor="one"><name>One</name>
+      <t>Text with <em>em</em>, <strong>strong</strong>, <tt>a` + "`" + `b</tt>, H<sub>2</sub>O. See <xref target="RFC8174" section="2"/>.</t>
+      <ul><li>plain</li><li><t>para</t><ol type="a"><li>inner</li></ol></li></ul>
+      <dl><dt>Key</dt><dd>Value</dd></dl>
+      <sourcecode type="go">
+func main() {}
+</sourcecode>
+      <table><thead><tr><th>H1</th><th align="center">H2</th></tr></thead>
+        <tbody><tr><td><t>x</t></td><td>y</td></tr></tbody></table>
+      <blockquote><t>Quoted.</t></blockquote>
+      <t>1. not a list</t>
+      <t>Implementations <bcp14>MUST NOT</bcp14> do this.</t>
+      <t>` + strings.Repeat("word ", 14) + `<bcp14>SHOULD NOT</bcp14> be wrapped.</t>
+    </section>
+  </middle>
+  <back>
+    <references><name>References</name>
+      <references><name>Normative References</name>
+        <xi:include href="https://bib.ietf.org/public/rfc/bibxml/reference.RFC.8174.xml"/>
+      </references>
+    </references>
+  </back>
+</rfc>`,
+			[]string{
+				"% Title = \"Three\"\n% docName = \"draft-three-00\"\n% category = \"info\"\n% obsoletes = [7001]\n%\n% date = 2019-07-04T00:00:00Z\n\n",
+				".# Note to Readers\n\nRead it.\n",
+				"Text with *em*, **strong**, ``a`b``, H~2~O. See [@!RFC8174 2].\n",
+				"*   plain\n\n*   para\n\n    a.  inner\n",
+				"Key\n:   Value\n",
+				"~~~ go\nfunc main() {}\n~~~\n",
+				"| H1 | H2 |\n|---|:-:|\n| x | y |\n",
+				"> Quoted.\n\n1\\. not a list\n",
+				"Implementations **MUST NOT** do this.\n",
+				// the keyword is on the wrap column
+				"word word\n**SHOULD NOT** be wrapped.\n",
+			},
+		},
+	}
+	for _, test := range tests {
+		out, err := ImportXML([]byte(test.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range test.expected {
+			if !strings.Contains(string(out), e) {
+				t.Errorf("expected %q in output:\n%s", e, out)
+			}
+		}
+	}
+
+	if _, err := ImportXML([]byte("<html><body/></html>")); err == nil {
+		t.Errorf("expected error for a document that is not xml2rfc")
+	}
+}
+
+func TestImportXMLParse(t *testing.T) {
+	input := `<rfc docName="draft-x-00">
+<front><title>X</title><date year="2020" month="Feb"/></front>
+<middle><section title="A" anchor="a"><t>See <xref target="b"/> and <xref target="RFC1149"/>.</t>
+<t>List:<list style="symbols"><t>one</t><t>two</t></list></t></section>
+<section title="B" anchor="b"><t>Text.</t></section></middle>
+<back><references title="Normative References"><reference anchor="RFC1149"/></references></back>
+</rfc>`
+	md, err := ImportXML([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := Parse(md, Xml2Renderer(XML2_STANDALONE), EXTENSION_TITLEBLOCK_TOML|EXTENSION_CITATION|EXTENSION_HEADER_IDS|EXTENSION_SHORT_REF).String()
+	for _, e := range []string{
+		`docName="draft-x-00"`,
+		`<date year="2020" month="February" day="1"/>`,
+		`<section anchor="a" title="A">`,
+		`See <xref target="b"/> and <xref target="RFC1149"/>.`,
+		"<list style=\"symbols\">\n<t>one</t>\n<t>two</t>\n</list>",
+		`<references title="Normative References">`,
+		`reference.RFC.1149.xml`,
+	} {
+		if !strings.Contains(out, e) {
+			t.Errorf("expected %q in output:\n%s", e, out)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Reading included files.
+
+package mmark
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// errOutsideRoot is returned for files that are not in the root directory.
+var errOutsideRoot = errors.New("outside of the root directory")
+
+// rootFS is a file system confined to a directory on disk.
+type rootFS struct {
+	dir string
+}
+
+// RootFS returns a file system with the files in dir. Unlike os.DirFS, symbolic
+// links that point outside of dir can't be followed.
+func RootFS(dir string) fs.FS {
+	return rootFS{dir: dir}
+}
+
+// Open opens name with os.Root, which resolves every element of the path
+// relative to the directory it is in, so a symbolic link that is swapped in
+// while the file is opened can't lead outside of the root either.
+func (r rootFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errOutsideRoot}
+	}
+	root, err := os.OpenRoot(r.dir)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: unwrapPathError(err)}
+	}
+	defer root.Close()
+	f, err := root.Open(filepath.FromSlash(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: unwrapPathError(err)}
+	}
+	return f, nil
+}
+
+// unwrapPathError returns the error in err if it is a *fs.PathError, so the path
+// on disk doesn't end up in the diagnostics.
+func unwrapPathError(err error) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return pe.Err
+	}
+	return err
+}
+
+// readFile reads the file name from the FileSource of the options.
+func (p *parser) readFile(name string) ([]byte, error) {
+	clean := path.Clean(filepath.ToSlash(name))
+	if !fs.ValidPath(clean) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errOutsideRoot}
+	}
+	return fs.ReadFile(p.opts.FileSource, clean)
+}
+
+// includeDir returns the directory of file, the file with the include, if it is
+// in the FileSource. Otherwise it is the root.
+func includeDir(file string) string {
+	dir := path.Dir(filepath.ToSlash(file))
+	if !fs.ValidPath(dir) {
+		return "."
+	}
+	return dir
+}
+
+// readInclude reads the file name that is included in the file the parser is
+// looking at. Like #include in C, name is relative to the directory of that
+// file, or to one of the IncludePaths. It returns the data and the name of the
+// file that was read.
+func (p *parser) readInclude(name string) ([]byte, string, error) {
+	name = filepath.ToSlash(name)
+	if path.IsAbs(name) {
+		return nil, name, &fs.PathError{Op: "open", Path: name, Err: errOutsideRoot}
+	}
+	// included files are named by their path in the FileSource, the
+	// document itself is in Dir
+	here := p.opts.Dir
+	if file, _, _ := p.position(); file != p.file {
+		here = includeDir(file)
+	}
+	var firstErr error
+	for _, dir := range append([]string{here}, p.opts.IncludePaths...) {
+		f := path.Join(filepath.ToSlash(dir), name)
+		data, err := p.readFile(f)
+		if err == nil {
+			return data, f, nil
+		}
+		p.missing = appendUnique(p.missing, f)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, name, firstErr
+}
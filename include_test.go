@@ -0,0 +1,168 @@
+// Unit tests for reading included files
+
+package mmark
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFileSource(t *testing.T) {
+	files := fstest.MapFS{
+		"chapter.md":  {Data: []byte("From memory.\n")},
+		"code/main.c": {Data: []byte("int main() {}\n")},
+	}
+
+	var tests = []struct {
+		input    string
+		expected string
+		warning  string
+	}{
+		{"{{chapter.md}}\n", "From memory.", ""},
+		{"{{./code/../chapter.md}}\n", "From memory.", ""},
+		{"<{{code/main.c}}\n", "int main() {}", ""},
+		{"{{../chapter.md}}\n", "", "failed: `../chapter.md': open ../chapter.md: outside of the root directory"},
+		{"{{/etc/passwd}}\n", "", "failed: `/etc/passwd': open /etc/passwd: outside of the root directory"},
+		{"<{{code/../../main.c}}\n", "", "outside of the root directory"},
+	}
+	for _, test := range tests {
+		opts := Options{Extensions: EXTENSION_INCLUDE, Filename: "doc.md", FileSource: files}
+		r := ParseWithOptions([]byte(test.input), XmlRenderer(0), opts)
+		if out := r.Output.String(); test.expected != "" && !strings.Contains(out, test.expected) {
+			t.Errorf("expected %q in output of %q:\n%s", test.expected, test.input, out)
+		}
+		switch {
+		case test.warning == "" && len(r.Diagnostics) > 0:
+			t.Errorf("unexpected diagnostics for %q: %v", test.input, r.Diagnostics)
+		case test.warning != "" && (len(r.Diagnostics) != 1 || !strings.Contains(r.Diagnostics[0].Message, test.warning)):
+			t.Errorf("expected warning %q for %q, got %v", test.warning, test.input, r.Diagnostics)
+		}
+	}
+}
+
+func TestRootFS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mmark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	root := filepath.Join(dir, "root")
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "secret"), []byte("secret\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "sub", "a.md"), []byte("inside\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "secret"), filepath.Join(root, "escape")); err != nil {
+		t.Skip("no symbolic links:", err)
+	}
+	if err := os.Symlink("sub/a.md", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := RootFS(root)
+	for name, expected := range map[string]string{"sub/a.md": "inside\n", "link": "inside\n"} {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil || string(data) != expected {
+			t.Errorf("expected %q for %s, got %q, %v", expected, name, data, err)
+		}
+	}
+	for _, name := range []string{"escape", "../secret", "sub/../../secret"} {
+		if _, err := fs.ReadFile(fsys, name); err == nil {
+			t.Errorf("expected an error for %s", name)
+		}
+	}
+}
+
+func TestIncludeRelative(t *testing.T) {
+	files := fstest.MapFS{
+		"chapters/intro.md":      {Data: []byte("Intro.\n\n{{examples/a.md}}\n\n<{{code/main.go}}\n\n{{common.md}}\n")},
+		"chapters/examples/a.md": {Data: []byte("Example A.\n\n{{../outro.md}}\n")},
+		"chapters/outro.md":      {Data: []byte("Outro.\n")},
+		"chapters/code/main.go":  {Data: []byte("package main\n")},
+		"shared/common.md":       {Data: []byte("Common.\n")},
+	}
+	opts := Options{Extensions: EXTENSION_INCLUDE, Filename: "draft.md", FileSource: files, IncludePaths: []string{"shared"}}
+
+	r := ParseWithOptions([]byte("{{chapters/intro.md}}\n"), XmlRenderer(0), opts)
+	if len(r.Diagnostics) > 0 {
+		t.Errorf("unexpected diagnostics: %v", r.Diagnostics)
+	}
+	out := r.Output.String()
+	for _, e := range []string{"Intro.", "Example A.", "Outro.", "package main", "Common."} {
+		if !strings.Contains(out, e) {
+			t.Errorf("expected %q in output:\n%s", e, out)
+		}
+	}
+	expected := []string{"chapters/intro.md", "chapters/examples/a.md", "chapters/outro.md", "shared/common.md", "chapters/code/main.go"}
+	if !reflect.DeepEqual(r.Dependencies, expected) {
+		t.Errorf("expected dependencies %v, got %v", expected, r.Dependencies)
+	}
+
+	// the document itself is in a directory too
+	opts.Filename = "chapters/draft.md"
+	r = ParseWithOptions([]byte("{{intro.md}}\n"), XmlRenderer(0), opts)
+	if out := r.Output.String(); !strings.Contains(out, "Example A.") {
+		t.Errorf("expected include relative to the document:\n%s", out)
+	}
+}
+
+func TestIncludeOtherDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mmark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"doc/chapters/intro.md":  "Intro.\n\n{{a.md}}\n\n{{common.md}}\n",
+		"doc/chapters/a.md":      "Example A.\n",
+		"doc/snippets/common.md": "Common.\n",
+	}
+	for name, data := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "other"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// run from a directory that is not above the document
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Join(dir, "other")); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	for _, filename := range []string{filepath.Join(dir, "doc", "draft.md"), filepath.Join("..", "doc", "draft.md")} {
+		opts := Options{Extensions: EXTENSION_INCLUDE, Filename: filename, IncludePaths: []string{"snippets"}}
+		r := ParseWithOptions([]byte("{{chapters/intro.md}}\n"), XmlRenderer(0), opts)
+		if len(r.Diagnostics) > 0 {
+			t.Errorf("unexpected diagnostics for %s: %v", filename, r.Diagnostics)
+		}
+		out := r.Output.String()
+		for _, e := range []string{"Intro.", "Example A.", "Common."} {
+			if !strings.Contains(out, e) {
+				t.Errorf("expected %q in output for %s:\n%s", e, filename, out)
+			}
+		}
+	}
+}
@@ -0,0 +1,299 @@
+// The back-of-book index: parsing the entries, and sorting, grouping and
+// merging them for the renderers that build an index themselves.
+
+package mmark
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// IndexEntry is an entry in the index, (((primary, secondary, tertiary))). It is
+// handed to the Index renderer callback.
+type IndexEntry struct {
+	Primary   []byte
+	Secondary []byte // empty if not set
+	Tertiary  []byte // empty if not set, only set together with Secondary
+
+	// Prim is true for the main reference of the entry, marked with a !:
+	// (((!primary))).
+	Prim bool
+
+	// See and SeeAlso refer to another entry: (((primary|see other))) and
+	// (((primary|see also other))). An entry with See does not refer to the
+	// text.
+	See     []byte
+	SeeAlso []byte
+}
+
+// parseIndexEntry parses the text between ((( and ))) of an index entry.
+func parseIndexEntry(text []byte) (IndexEntry, bool) {
+	var e IndexEntry
+	if len(text) > 0 && text[0] == '!' {
+		e.Prim = true
+		text = text[1:]
+	}
+	if i := bytes.IndexByte(text, '|'); i >= 0 {
+		see := bytes.TrimSpace(text[i+1:])
+		switch {
+		case bytes.HasPrefix(see, []byte("see also ")):
+			e.SeeAlso = bytes.TrimSpace(see[len("see also "):])
+		case bytes.HasPrefix(see, []byte("see ")):
+			e.See = bytes.TrimSpace(see[len("see "):])
+		default:
+			return e, false
+		}
+		text = text[:i]
+	}
+
+	levels := bytes.Split(text, []byte(","))
+	if len(levels) > 3 {
+		return e, false
+	}
+	for i := range levels {
+		levels[i] = bytes.TrimSpace(levels[i])
+	}
+	e.Primary = levels[0]
+	if len(levels) > 1 {
+		e.Secondary = levels[1]
+	}
+	if len(levels) > 2 {
+		e.Tertiary = levels[2]
+	}
+	if len(e.Primary) == 0 || len(e.Secondary) == 0 && len(e.Tertiary) > 0 {
+		return e, false
+	}
+	return e, true
+}
+
+// indexRef is a reference from the index to the text.
+type indexRef struct {
+	anchor  string
+	section sectionNumber // section the reference is in, no count if unknown
+	prim    bool
+}
+
+// indexTerm is a term in the index with the references to it and its subterms.
+type indexTerm struct {
+	text    string
+	refs    []indexRef
+	see     []string
+	seeAlso []string
+	terms   map[string]*indexTerm
+}
+
+// add adds entry to the index t, with ref as the reference to the text.
+func (t *indexTerm) add(entry IndexEntry, ref indexRef) {
+	term := t.term(string(entry.Primary))
+	if len(entry.Secondary) > 0 {
+		term = term.term(string(entry.Secondary))
+	}
+	if len(entry.Tertiary) > 0 {
+		term = term.term(string(entry.Tertiary))
+	}
+	switch {
+	case len(entry.See) > 0:
+		term.see = appendUnique(term.see, string(entry.See))
+	case len(entry.SeeAlso) > 0:
+		term.seeAlso = appendUnique(term.seeAlso, string(entry.SeeAlso))
+		term.refs = append(term.refs, ref)
+	default:
+		term.refs = append(term.refs, ref)
+	}
+}
+
+// term returns the subterm text of t, it is created when needed.
+func (t *indexTerm) term(text string) *indexTerm {
+	if t.terms == nil {
+		t.terms = make(map[string]*indexTerm)
+	}
+	term, ok := t.terms[text]
+	if !ok {
+		term = &indexTerm{text: text}
+		t.terms[text] = term
+	}
+	return term
+}
+
+// sorted returns the subterms of t in collation order.
+func (t *indexTerm) sorted() []*indexTerm {
+	terms := make([]*indexTerm, 0, len(t.terms))
+	for _, term := range t.terms {
+		terms = append(terms, term)
+	}
+	sort.Slice(terms, func(i, j int) bool { return collateLess(terms[i].text, terms[j].text) })
+	return terms
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, l := range list {
+		if l == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// indexGroup is the terms of the index that start with the same letter.
+type indexGroup struct {
+	letter string
+	terms  []*indexTerm
+}
+
+// groups returns the terms of the index t sorted and grouped by their first
+// letter. Terms that do not start with a letter are grouped under "Symbols",
+// which comes first.
+func (t *indexTerm) groups() []indexGroup {
+	var groups []indexGroup
+	for _, term := range t.sorted() {
+		letter := indexLetter(term.text)
+		if len(groups) == 0 || groups[len(groups)-1].letter != letter {
+			groups = append(groups, indexGroup{letter: letter})
+		}
+		groups[len(groups)-1].terms = append(groups[len(groups)-1].terms, term)
+	}
+	return groups
+}
+
+// indexLetter returns the letter text is grouped under in the index.
+func indexLetter(text string) string {
+	for _, r := range collationKey(text) {
+		if unicode.IsLetter(r) {
+			return string(unicode.ToUpper(r))
+		}
+		break
+	}
+	return "Symbols"
+}
+
+// collationFold maps the accented Latin letters to the letters they are sorted
+// with. This is the same for every language: there is no locale, so e.g. the
+// Danish ø and å sort with o and a, not after z.
+var collationFold = func() map[rune]string {
+	fold := map[rune]string{'ß': "ss", 'æ': "ae", 'œ': "oe", 'þ': "th"}
+	for base, letters := range map[string]string{
+		"a": "àáâãäåāăą",
+		"c": "çćĉċč",
+		"d": "ďđð",
+		"e": "èéêëēĕėęě",
+		"g": "ĝğġģ",
+		"h": "ĥħ",
+		"i": "ìíîïĩīĭįı",
+		"j": "ĵ",
+		"k": "ķ",
+		"l": "ĺļľŀł",
+		"n": "ñńņňŉ",
+		"o": "òóôõöøōŏő",
+		"r": "ŕŗř",
+		"s": "śŝşš",
+		"t": "ţťŧ",
+		"u": "ùúûüũūŭůűų",
+		"w": "ŵ",
+		"y": "ýÿŷ",
+		"z": "źżž",
+	} {
+		for _, r := range letters {
+			fold[r] = base
+		}
+	}
+	return fold
+}()
+
+// collationKey returns the key text is sorted on: lowercase, without accents
+// and without punctuation. Letters outside of collationFold sort by their code
+// point.
+func collationKey(text string) string {
+	var key strings.Builder
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case collationFold[r] != "":
+			key.WriteString(collationFold[r])
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == ' ':
+			key.WriteRune(r)
+		}
+	}
+	if key.Len() == 0 {
+		return strings.ToLower(text)
+	}
+	return key.String()
+}
+
+// collateLess tells if a sorts before b. Terms that do not start with a letter
+// come first. Terms with the same key are sorted case-insensitively and then
+// bytewise, so the order is always the same.
+func collateLess(a, b string) bool {
+	if sa, sb := indexLetter(a) == "Symbols", indexLetter(b) == "Symbols"; sa != sb {
+		return sa
+	}
+	ka, kb := collationKey(a), collationKey(b)
+	if ka != kb {
+		return ka < kb
+	}
+	if la, lb := strings.ToLower(a), strings.ToLower(b); la != lb {
+		return la < lb
+	}
+	return a < b
+}
+
+// indexRange is a run of references to consecutive sections, first to last.
+type indexRange struct {
+	first, last indexRef
+}
+
+// mergeRefs merges the references to the same section, and the runs of
+// references to consecutive sections into ranges. References without a
+// section and primary references are kept as they are.
+func mergeRefs(refs []indexRef) []indexRange {
+	var ranges []indexRange
+	for _, ref := range refs {
+		if n := len(ranges); n > 0 && len(ref.section.count) > 0 && !ref.prim {
+			last := &ranges[n-1]
+			switch {
+			case !last.last.prim && sameSection(last.last.section, ref.section):
+				continue
+			case !last.last.prim && nextSection(last.last.section, ref.section):
+				last.last = ref
+				continue
+			}
+		}
+		ranges = append(ranges, indexRange{ref, ref})
+	}
+	return ranges
+}
+
+func sameSection(a, b sectionNumber) bool {
+	if a.appendix != b.appendix || len(a.count) != len(b.count) || len(a.count) == 0 {
+		return false
+	}
+	for i := range a.count {
+		if a.count[i] != b.count[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// nextSection tells if b is the section after a on the same level.
+func nextSection(a, b sectionNumber) bool {
+	if a.appendix != b.appendix || len(a.count) != len(b.count) || len(a.count) == 0 {
+		return false
+	}
+	n := len(a.count) - 1
+	for i := 0; i < n; i++ {
+		if a.count[i] != b.count[i] {
+			return false
+		}
+	}
+	return a.count[n]+1 == b.count[n]
+}
+
+// indexSubitem returns the subitem of entry for the renderers that only have two
+// levels, the tertiary item is added to the secondary one.
+func indexSubitem(entry IndexEntry) []byte {
+	if len(entry.Tertiary) == 0 {
+		return entry.Secondary
+	}
+	return bytes.Join([][]byte{entry.Secondary, entry.Tertiary}, []byte(", "))
+}
@@ -0,0 +1,106 @@
+// Unit tests for the index
+
+package mmark
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseIndexEntry(t *testing.T) {
+	var tests = []struct {
+		input string
+		ok    bool
+		entry IndexEntry
+	}{
+		{"Tiger", true, IndexEntry{Primary: []byte("Tiger")}},
+		{"!Cats ,  Tiger , Bengal", true, IndexEntry{Primary: []byte("Cats"), Secondary: []byte("Tiger"), Tertiary: []byte("Bengal"), Prim: true}},
+		{"Kitten|see Cats", true, IndexEntry{Primary: []byte("Kitten"), See: []byte("Cats")}},
+		{"Tiger | see also Cats, Lion", true, IndexEntry{Primary: []byte("Tiger"), SeeAlso: []byte("Cats, Lion")}},
+		{"", false, IndexEntry{}},
+		{", Tiger", false, IndexEntry{}},
+		{"Cats, , Bengal", false, IndexEntry{}},
+		{"a, b, c, d", false, IndexEntry{}},
+		{"Tiger|Cats", false, IndexEntry{}},
+	}
+	for i, test := range tests {
+		entry, ok := parseIndexEntry([]byte(test.input))
+		if ok != test.ok {
+			t.Errorf("test %d: expected %t, got %t", i, test.ok, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if string(entry.Primary) != string(test.entry.Primary) || string(entry.Secondary) != string(test.entry.Secondary) ||
+			string(entry.Tertiary) != string(test.entry.Tertiary) || entry.Prim != test.entry.Prim ||
+			string(entry.See) != string(test.entry.See) || string(entry.SeeAlso) != string(test.entry.SeeAlso) {
+			t.Errorf("test %d: expected %+v, got %+v", i, test.entry, entry)
+		}
+	}
+}
+
+func TestIndexCollation(t *testing.T) {
+	var index indexTerm
+	for _, term := range []string{"zebra", "Éclair", "apple", "Apple", "écru", "Ångström", "_init", "Ecology", "Straße", "strasse", "§", "42"} {
+		index.add(IndexEntry{Primary: []byte(term)}, indexRef{})
+	}
+	var groups []string
+	for _, g := range index.groups() {
+		var terms []string
+		for _, term := range g.terms {
+			terms = append(terms, term.text)
+		}
+		groups = append(groups, g.letter+": "+strings.Join(terms, " "))
+	}
+	expected := "Symbols: 42 §|A: Ångström Apple apple|E: Éclair Ecology écru|I: _init|S: strasse Straße|Z: zebra"
+	if actual := strings.Join(groups, "|"); actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+}
+
+func TestIndexHTML(t *testing.T) {
+	input := "# Cats\n\nTiger (((Cats, Tiger))). Lion (((!Cats, Lion))). Kitten (((Kitten|see Cats))).\n\n" +
+		"## Big\n\nTiger (((Cats, Tiger, Bengal))) (((Cats, Tiger))) (((Lion|see also Cats))).\n\n" +
+		"## Bigger\n\nTiger (((Cats, Tiger))). Éclair (((Éclair))) and 1 (((1 <one>))).\n"
+	expected := "<h3 class=\"index-ref-char\">Symbols</h3>\n" +
+		"<span class=\"index-ref-primary\">1 &lt;one&gt;</span>\n" +
+		"<span class=\"index-ref-space\"> </span><a class=\"index-ref-ref\" href=\"#idxref:8\">1.2</a>\n" +
+		"<h3 class=\"index-ref-char\">C</h3>\n" +
+		"<span class=\"index-ref-primary\">Cats</span>\n" +
+		"<span class=\"index-ref-secondary\">Lion</span><span class=\"index-ref-space\"> </span>" +
+		"<strong><a class=\"index-ref-ref\" href=\"#idxref:1\">1</a></strong>\n" +
+		"<span class=\"index-ref-secondary\">Tiger</span><span class=\"index-ref-space\"> </span>" +
+		"<a class=\"index-ref-ref\" href=\"#idxref:0\">1</a>,<a class=\"index-ref-ref\" href=\"#idxref:4\">1.1&ndash;1.2</a>\n" +
+		"<span class=\"index-ref-tertiary\">Bengal</span><span class=\"index-ref-space\"> </span>" +
+		"<a class=\"index-ref-ref\" href=\"#idxref:3\">1.1</a>\n" +
+		"<h3 class=\"index-ref-char\">E</h3>\n" +
+		"<span class=\"index-ref-primary\">Éclair</span>\n" +
+		"<span class=\"index-ref-space\"> </span><a class=\"index-ref-ref\" href=\"#idxref:7\">1.2</a>\n" +
+		"<h3 class=\"index-ref-char\">K</h3>\n" +
+		"<span class=\"index-ref-primary\">Kitten</span>\n" +
+		"<span class=\"index-ref-space\"> </span><span class=\"index-ref-see\"><em>see</em> Cats</span>\n" +
+		"<h3 class=\"index-ref-char\">L</h3>\n" +
+		"<span class=\"index-ref-primary\">Lion</span>\n" +
+		"<span class=\"index-ref-space\"> </span><a class=\"index-ref-ref\" href=\"#idxref:5\">1.1</a>; " +
+		"<span class=\"index-ref-see\"><em>see also</em> Cats</span>\n" +
+		"</div>"
+
+	extensions := EXTENSION_HEADER_IDS | EXTENSION_AUTO_HEADER_IDS
+	actual := Parse([]byte(input), HtmlRenderer(HTML_NUMBERED, "", ""), extensions).String()
+	if !strings.Contains(actual, expected) {
+		t.Errorf("expected %q in output:\n%s", expected, actual)
+	}
+	if strings.Contains(actual, "id=\"idxref:2\"") {
+		t.Errorf("expected no anchor for a see entry:\n%s", actual)
+	}
+
+	// without HTML_NUMBERED the references are numbered
+	actual = Parse([]byte(input), HtmlRenderer(0, "", ""), extensions).String()
+	expected = "<span class=\"index-ref-secondary\">Tiger</span><span class=\"index-ref-space\"> </span>" +
+		"<a class=\"index-ref-ref\" href=\"#idxref:0\">1</a>,<a class=\"index-ref-ref\" href=\"#idxref:4\">2</a>," +
+		"<a class=\"index-ref-ref\" href=\"#idxref:6\">3</a>\n"
+	if !strings.Contains(actual, expected) {
+		t.Errorf("expected %q in output:\n%s", expected, actual)
+	}
+}
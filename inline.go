@@ -42,6 +42,7 @@ func (p *parser) inline(out *bytes.Buffer, data []byte) {
 		i = end
 
 		// call the trigger
+		p.mark(data[i:])
 		handler := p.inlineCallback[data[end]]
 		if consumed := handler(p, out, data, i); consumed == 0 {
 			end = i + 1
@@ -165,6 +166,9 @@ func codeSpan(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 }
 
 func normalText(p *parser, out *bytes.Buffer, data []byte) {
+	if p.opts.Lint {
+		p.lintKeywords(data)
+	}
 	if len(p.abbreviations) == 0 {
 		p.r.NormalText(out, data)
 	} else {
@@ -382,19 +386,20 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 		}
 
 		if c, ok := p.citations[string(id)]; !ok {
-			p.citations[string(id)] = &citation{link: id, title: title, typ: typ, seq: seq}
+			p.citations[string(id)] = &Citation{link: id, title: title, typ: typ, seq: seq}
 		} else {
 			switch c.typ {
 			case 0:
 				c.typ = typ
 			case 'i':
 				if typ == 'n' {
-					printf(p, "upgrading citation `%s' from informative to normative", string(id))
+					infof(p, "citation", "upgrading citation `%s' from informative to normative", string(id))
 					c.typ = typ
 				}
 			}
 		}
 
+		p.lintCitation(string(id), suppress)
 		if !suppress {
 			p.r.Citation(out, id, title)
 		}
@@ -677,6 +682,10 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 	// call the relevant rendering function
 	switch t {
 	case linkNormal:
+		if uLink[0] == '#' {
+			p.mark(data)
+			p.referTo(string(uLink[1:]), false)
+		}
 		p.r.Link(out, uLink, title, content.Bytes())
 
 	case linkImg:
@@ -739,7 +748,7 @@ func leftAngle(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 	}
 
 	data = data[offset:]
-	altype := _LINK_TYPE_NOT_AUTOLINK
+	altype := LINK_TYPE_NOT_AUTOLINK
 	end := tagLength(data, &altype)
 	if size := p.inlineHTMLComment(out, data); size > 0 {
 		end = size
@@ -760,7 +769,7 @@ func leftAngle(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 			return 0
 		}
 
-		if altype != _LINK_TYPE_NOT_AUTOLINK {
+		if altype != LINK_TYPE_NOT_AUTOLINK {
 			var uLink bytes.Buffer
 			unescapeText(&uLink, data[1:end+1-2])
 			if uLink.Len() > 0 {
@@ -1094,7 +1103,7 @@ func autoLink(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 	unescapeText(&uLink, data[:linkEnd])
 
 	if uLink.Len() > 0 {
-		p.r.AutoLink(out, uLink.Bytes(), _LINK_TYPE_NORMAL)
+		p.r.AutoLink(out, uLink.Bytes(), LINK_TYPE_NORMAL)
 	}
 
 	return linkEnd - rewind
@@ -1142,7 +1151,7 @@ func tagLength(data []byte, autolink *int) int {
 	}
 
 	// scheme test
-	*autolink = _LINK_TYPE_NOT_AUTOLINK
+	*autolink = LINK_TYPE_NOT_AUTOLINK
 
 	// try to find the beginning of an URI
 	for i < len(data) && (isalnum(data[i]) || data[i] == '.' || data[i] == '+' || data[i] == '-') {
@@ -1151,20 +1160,20 @@ func tagLength(data []byte, autolink *int) int {
 
 	if i > 1 && i < len(data) && data[i] == '@' {
 		if j = isMailtoAutoLink(data[i:]); j != 0 {
-			*autolink = _LINK_TYPE_EMAIL
+			*autolink = LINK_TYPE_EMAIL
 			return i + j
 		}
 	}
 
 	if i > 2 && i < len(data) && data[i] == ':' {
-		*autolink = _LINK_TYPE_NORMAL
+		*autolink = LINK_TYPE_NORMAL
 		i++
 	}
 
 	// complete autolink test: no whitespace or ' or "
 	switch {
 	case i >= len(data):
-		*autolink = _LINK_TYPE_NOT_AUTOLINK
+		*autolink = LINK_TYPE_NOT_AUTOLINK
 	case *autolink != 0:
 		j = i
 
@@ -1187,7 +1196,7 @@ func tagLength(data []byte, autolink *int) int {
 		}
 
 		// one of the forbidden chars has been found
-		*autolink = _LINK_TYPE_NOT_AUTOLINK
+		*autolink = LINK_TYPE_NOT_AUTOLINK
 	}
 
 	// look for something looking like a tag end
@@ -1252,66 +1261,20 @@ func index(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 		// no three (((
 		return 0
 	}
-	// find closing delimeter, count commas while at it
-	// if more than 1 is found, it is a proper index.
-	i, end := 0, 0
-	comma := 0
-	for end = 3; end < len(data) && i < 3; end++ {
-		if data[end] == ')' {
-			i++
-		} else {
-			i = 0
-		}
-		if data[end] == ',' {
-			if comma != 0 {
-				// already seen comma
-				return 0
-			}
-			comma = end
-		}
-	}
-	if comma == 3 { // just (((,
+	if len(data) < 7 || data[1] != c || data[2] != c {
 		return 0
 	}
-	if i < 3 && end >= len(data) {
+	// find closing delimeter, the entry is (((!primary, secondary, tertiary|see other)))
+	end := bytes.Index(data[3:], []byte(")))"))
+	if end < 0 {
 		return 0
 	}
-	ret = end
-	if comma == 0 {
-		comma = end
-	}
-
-	// may be surrounded by whitespace, strip it
-	primary := comma - 1
-	for i := comma - 1; i >= 0; i-- {
-		if !isspace(data[i]) {
-			break
-		}
-		primary = i
-	}
-
-	secondary := comma + 1
-	for i := comma + 1; i < end; i++ {
-		if !isspace(data[i]) {
-			secondary = i
-			break
-		}
-	}
-
-	i = 3
-	prim := false
-	if data[i] == '!' {
-		// mark is primary
-		prim = true
-		i++
-	}
-
-	if secondary > end-3 {
-		p.r.Index(out, data[i:primary-2], nil, prim)
-		return ret
+	entry, ok := parseIndexEntry(data[3 : end+3])
+	if !ok {
+		return 0
 	}
-	p.r.Index(out, data[i:primary+1], data[secondary:end-3], prim)
-	return ret
+	p.r.Index(out, entry)
+	return end + 6
 }
 
 // look for the next emph char, skipping other constructs
@@ -1440,7 +1403,12 @@ func helperDoubleEmphasis(p *parser, out *bytes.Buffer, data []byte, c byte) int
 
 		if i+1 < len(data) && data[i] == c && data[i+1] == c && i > 0 && !isspace(data[i-1]) {
 			var work bytes.Buffer
+			if c != '~' && words2119[string(data[:i])] {
+				p.bcp14.used = true
+				p.bcp14.inKeyword = true
+			}
 			p.inline(&work, data[:i])
+			p.bcp14.inKeyword = false
 
 			if work.Len() > 0 {
 				// pick the right renderer
@@ -1575,6 +1543,10 @@ func exampleReference(p *parser, out *bytes.Buffer, data []byte, offset int) int
 		p.r.Example(out, e)
 		return i + 1
 	}
+	// without example lists (@r) is just text
+	if p.flags&EXTENSION_EXAMPLE_LISTS != 0 {
+		p.referTo(string(data[2:i]), true)
+	}
 	return 0
 }
 
@@ -1601,6 +1573,7 @@ func crossReference(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 		}
 		return 0
 	}
+	p.referTo(string(data[2:i]), false)
 	p.r.Link(out, data[1:i], nil, nil)
 	return i + 1
 }
@@ -1621,12 +1594,13 @@ func citationReference(p *parser, out *bytes.Buffer, data []byte, offset int) in
 		return 0
 	}
 	if c, ok := p.citations[string(data[1:i])]; ok {
+		p.lintCitation(string(data[1:i]), false)
 		p.r.Citation(out, data[1:i], c.title)
 		return i
 	}
 	// If we just see a @ it will always be normal text.
 	if len(data[:i]) > 1 {
-		printf(nil, "handling `%s' as normal text", string(data[:i]))
+		infof(p, "citation", "handling `%s' as normal text", string(data[:i]))
 	}
 	return 0
 }
@@ -1045,8 +1045,25 @@ func TestIndexXML(t *testing.T) {
 
 		"(((Tiger, Cats))\n",
 		"<t>\n(((Tiger, Cats))\n</t>\n",
+
+		"(((!Tiger)))\n",
+		"<t>\n<iref item=\"Tiger\" primary=\"true\"/>\n</t>\n",
+
+		"(((Cats, Tiger, Bengal)))\n",
+		"<t>\n<iref item=\"Cats\" subitem=\"Tiger, Bengal\"/>\n</t>\n",
+
+		"(((Kitten|see Cats)))(((Tiger|see also Cats)))\n",
+		"<t>\n<iref item=\"Tiger\"/>\n</t>\n",
+
+		"(((A&B, \"<x>\")))\n",
+		"<t>\n<iref item=\"A&amp;B\" subitem=\"&quot;&lt;x&gt;&quot;\"/>\n</t>\n",
 	}
 	doTestsInlineXML(t, tests)
+
+	actual := Parse([]byte("(((A&B, \"<x>\")))\n"), Xml2Renderer(0), commonXmlExtensions).String()
+	if expected := "<iref item=\"A&amp;B\" subitem=\"&quot;&lt;x&gt;&quot;\"/>"; !strings.Contains(actual, expected) {
+		t.Errorf("expected %q in output:\n%s", expected, actual)
+	}
 }
 
 func TestNestedFootnotes(t *testing.T) {
@@ -1246,3 +1263,115 @@ func TestDisableSmartDashes(t *testing.T) {
 		HTML_USE_SMARTYPANTS|HTML_SMARTYPANTS_LATEX_DASHES,
 		HtmlRendererParameters{})
 }
+
+type testMathSVG struct{}
+
+func (testMathSVG) SVG(tex []byte, display bool) ([]byte, error) {
+	return []byte("<?xml version=\"1.0\"?>\n<svg xmlns=\"http://www.w3.org/2000/svg\"/>"), nil
+}
+
+func TestMathXML(t *testing.T) {
+	var tests = []string{
+		"Inline $$a^2 + b^2 = c^2$$ math.\n",
+		"<t>\nInline <tt>a² + b² = c²</tt> math.\n</t>\n",
+
+		"$$\\frac{1}{2} \\leq \\log_2 n \\cdot \\alpha_{i+1}$$\n",
+		"<artwork type=\"ascii-art\">\n1/2 ≤ log₂ n · α_(i+1)\n</artwork>\n",
+
+		"{#eq1}\n$$ x \\in \\mathbb{Z}_p, \\sqrt{x+1} \\pmod{q} $$\n",
+		"<artwork anchor=\"eq1\" type=\"ascii-art\">\nx ∈ ℤ_p, √(x+1) (mod q)\n</artwork>\n",
+
+		"$$\\left( a < b \\right) \\neq \\hat{x}$$\n",
+		"<artwork type=\"ascii-art\">\n( a &lt; b ) ≠ x̂\n</artwork>\n",
+
+		"Some $$\\unknown{x}$$.\n",
+		"<t>\nSome <tt>unknownx</tt>.\n</t>\n",
+	}
+	doTestsInlineParamXML(t, tests, EXTENSION_MATH, 0)
+
+	tests = []string{
+		"$$ a < b $$\n",
+		"<artwork alt=\"a &lt; b\" type=\"svg\">\n<svg xmlns=\"http://www.w3.org/2000/svg\"/>\n</artwork>\n",
+
+		"Inline $$x^2$$ math.\n",
+		"<t>\nInline <tt>x²</tt> math.\n</t>\n",
+	}
+	opts := Options{Extensions: EXTENSION_MATH, MathSVG: testMathSVG{}}
+	for i := 0; i+1 < len(tests); i += 2 {
+		actual := ParseWithOptions([]byte(tests[i]), XmlRenderer(0), opts).Output.String()
+		if actual != tests[i+1] {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", tests[i], tests[i+1], actual)
+		}
+	}
+}
+
+func TestMathML(t *testing.T) {
+	const ns = " class=\"math\" xmlns=\"http://www.w3.org/1998/Math/MathML\""
+	var tests = []string{
+		"Inline $$x^2_i < \\frac{1}{2}$$ math.",
+		"<p>Inline <math" + ns + "><semantics><mrow><msubsup><mi>x</mi><mi>i</mi><mn>2</mn></msubsup><mo>&lt;</mo><mfrac><mn>1</mn><mn>2</mn></mfrac></mrow>" +
+			"<annotation encoding=\"application/x-tex\">x^2_i &lt; \\frac{1}{2}</annotation></semantics></math> math.</p>\n",
+
+		"{#eq1}\n$$ \\sum_{i=0}^{n} \\alpha_i $$",
+		"<p><math id=\"eq1\"" + ns + " display=\"block\"><semantics><mrow><munderover><mo>Σ</mo><mrow><mi>i</mi><mo>=</mo><mn>0</mn></mrow><mi>n</mi></munderover><msub><mi>α</mi><mi>i</mi></msub></mrow>" +
+			"<annotation encoding=\"application/x-tex\">\\sum_{i=0}^{n} \\alpha_i</annotation></semantics></math></p>\n",
+
+		"$$\\begin{pmatrix} a & b \\\\ c & \\text{d} \\end{pmatrix}$$",
+		"<p><math" + ns + " display=\"block\"><semantics><mrow><mo>(</mo><mtable><mtr><mtd><mi>a</mi></mtd><mtd><mi>b</mi></mtd></mtr><mtr><mtd><mi>c</mi></mtd><mtd><mtext>d</mtext></mtd></mtr></mtable><mo>)</mo></mrow>" +
+			"<annotation encoding=\"application/x-tex\">\\begin{pmatrix} a &amp; b \\\\ c &amp; \\text{d} \\end{pmatrix}</annotation></semantics></math></p>\n",
+
+		"Roots $$\\sqrt{x} + \\sqrt[3]{\\mathbb{Z}}$$.",
+		"<p>Roots <math" + ns + "><semantics><mrow><msqrt><mi>x</mi></msqrt><mo>+</mo><mroot><mi mathvariant=\"double-struck\">Z</mi><mn>3</mn></mroot></mrow>" +
+			"<annotation encoding=\"application/x-tex\">\\sqrt{x} + \\sqrt[3]{\\mathbb{Z}}</annotation></semantics></math>.</p>\n",
+	}
+	doTestsInlineParam(t, tests, EXTENSION_MATH, HTML_MATHML, HtmlRendererParameters{})
+}
+
+func TestFootnotesXML(t *testing.T) {
+	input := "Text with a note[^a] and^[an *inline* note].\n\n[^a]: The note.\n"
+	var tests = []struct {
+		renderer Renderer
+		expected string
+	}{
+		{
+			XmlRenderer(0),
+			"<t anchor=\"fnref-1\">\nText with a note<xref target=\"fn-1\" format=\"none\">[1]</xref> and<xref target=\"fn-2\" format=\"none\">[2]</xref>.\n</t>\n\n" +
+				"<section anchor=\"notes\">\n<name>Notes</name>\n" +
+				"<t anchor=\"fn-1\">[1] The note. (<xref target=\"fnref-1\" format=\"none\">back</xref>)</t>\n" +
+				"<t anchor=\"fn-2\">[2] an <em>inline</em> note (<xref target=\"fnref-1\" format=\"none\">back</xref>)</t>\n" +
+				"</section>\n",
+		},
+		{
+			XmlRenderer(XML_FOOTNOTES_CREF),
+			"<t>\nText with a note<cref anchor=\"fn-1\">The note.</cref> and<cref anchor=\"fn-2\">an <em>inline</em> note</cref>.\n</t>\n",
+		},
+		{
+			XmlRenderer(XML_FOOTNOTES_INLINE),
+			"<t>\nText with a note (The note.) and (an <em>inline</em> note).\n</t>\n",
+		},
+		{
+			Xml2Renderer(0),
+			"<t anchor=\"fnref-1\">Text with a note<xref target=\"fn-1\">[1]</xref> and<xref target=\"fn-2\">[2]</xref>.\n</t>\n\n" +
+				"<section anchor=\"notes\" title=\"Notes\">\n" +
+				"<t anchor=\"fn-1\">[1] The note. (<xref target=\"fnref-1\">back</xref>)</t>\n" +
+				"<t anchor=\"fn-2\">[2] an <spanx style=\"emph\">inline</spanx> note (<xref target=\"fnref-1\">back</xref>)</t>\n" +
+				"</section>\n",
+		},
+		{
+			Xml2Renderer(XML2_FOOTNOTES_CREF),
+			"<t>Text with a note<cref anchor=\"fn-1\">The note.</cref> and<cref anchor=\"fn-2\">an inline note</cref>.\n</t>\n",
+		},
+	}
+	for _, test := range tests {
+		actual := Parse([]byte(input), test.renderer, EXTENSION_FOOTNOTES).String()
+		if actual != test.expected {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, test.expected, actual)
+		}
+	}
+
+	// the Notes section goes after the references, in the back matter
+	out := Parse([]byte("# Intro\n\nText[^a], see [@RFC2119].\n\n[^a]: The note.\n"), XmlRenderer(XML_STANDALONE), EXTENSION_FOOTNOTES|EXTENSION_CITATION).String()
+	if !strings.Contains(out, "</references>\n\n<section anchor=\"notes\">") || !strings.HasSuffix(out, "</section>\n\n</back>\n</rfc>\n") {
+		t.Errorf("expected the Notes section at the end of the back matter:\n%s", out)
+	}
+}
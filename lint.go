@@ -0,0 +1,132 @@
+// Structural lint checks, the problems the IETF submission tool (idnits)
+// rejects a draft for.
+
+package mmark
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// draftExpiry is how long a draft is valid after its date.
+const draftExpiry = 185 * 24 * time.Hour
+
+var draftNameRegexp = regexp.MustCompile(`^draft-[a-z0-9-]+-[0-9][0-9]$`)
+
+// lint collects what the structural lint checks need while the document is parsed.
+type lint struct {
+	title    *Title          // the titleblock, nil if there is none
+	headers  []string        // the section names seen, in lowercase
+	abstract bool            // rendering the abstract
+	cited    map[string]bool // citations used in the text
+
+	// Citations that are only declared with [-@...] and where that was done.
+	declared []string
+	where    map[string]Diagnostic
+}
+
+// lintTitle checks the titleblock, date tells if the titleblock sets the date.
+func (p *parser) lintTitle(title *Title, date bool) {
+	if !p.opts.Lint {
+		return
+	}
+	p.lint.title = title
+
+	draft := draftName(title.DocName) != ""
+	switch {
+	case title.DocName == "":
+		warnf(p, "lint", "docName is missing from the titleblock")
+	case draft && !draftNameRegexp.MatchString(title.DocName):
+		warnf(p, "lint", "docName `%s' does not match draft-<name>-<NN>", title.DocName)
+	}
+
+	switch {
+	case !date:
+		warnf(p, "lint", "date is missing from the titleblock")
+	case draft && time.Since(title.Date) > draftExpiry:
+		warnf(p, "lint", "draft has expired on %s", title.Date.Add(draftExpiry).Format("2006-01-02"))
+	}
+}
+
+// lintHeader notes a section header, abstract tells if it is the abstract.
+func (p *parser) lintHeader(name []byte, abstract bool) {
+	if !p.opts.Lint {
+		return
+	}
+	p.lint.abstract = abstract
+	p.lint.headers = append(p.lint.headers, strings.ToLower(string(name)))
+}
+
+// lintCitation notes the use of a citation, suppress is true for a citation
+// that is only declared with [-@...].
+func (p *parser) lintCitation(id string, suppress bool) {
+	if !p.opts.Lint {
+		return
+	}
+	if p.lint.cited == nil {
+		p.lint.cited = make(map[string]bool)
+		p.lint.where = make(map[string]Diagnostic)
+	}
+	if suppress {
+		if _, ok := p.lint.where[id]; !ok {
+			d := Diagnostic{}
+			d.File, d.Line, d.Column = p.position()
+			p.lint.where[id] = d
+			p.lint.declared = append(p.lint.declared, id)
+		}
+		return
+	}
+	p.lint.cited[id] = true
+	if p.lint.abstract {
+		warnf(p, "lint", "abstract contains a citation of `%s'", id)
+	}
+}
+
+// lintDocument reports the problems that can only be found after the whole
+// document has been parsed.
+func (p *parser) lintDocument() {
+	for _, id := range p.lint.declared {
+		if p.lint.cited[id] {
+			continue
+		}
+		d := p.lint.where[id]
+		d.Severity, d.Code = SEVERITY_WARNING, "lint"
+		d.Message = "citation `" + id + "' is declared, but never used"
+		p.diagnose(d)
+	}
+
+	p.offset = -1 // the rest applies to the whole document
+	_, _, keys := countCitationsAndSort(p.citations)
+	for _, k := range keys {
+		if c := p.citations[k]; c.typ == 'n' && c.xml == nil && c.seq < 0 && strings.HasPrefix(string(c.link), "I-D.") {
+			warnf(p, "lint", "normative reference to `%s' has no draft version, use [@!%s#NN]", c.link, c.link)
+		}
+	}
+
+	if p.lint.title == nil {
+		return
+	}
+	for _, section := range []string{"Security Considerations", "IANA Considerations"} {
+		found := false
+		for _, h := range p.lint.headers {
+			if strings.Contains(h, strings.ToLower(section)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			warnf(p, "lint", "document has no %s section", section)
+		}
+	}
+	check := func(what string, rfcs []int) {
+		for _, n := range rfcs {
+			if id := "RFC" + strconv.Itoa(n); !p.lint.cited[id] {
+				warnf(p, "lint", "document %s RFC %d, but does not cite %s", what, n, id)
+			}
+		}
+	}
+	check("updates", p.lint.title.Updates)
+	check("obsoletes", p.lint.title.Obsoletes)
+}
@@ -0,0 +1,62 @@
+// Unit tests for the structural lint checks
+
+package mmark
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLint(t *testing.T) {
+	today := time.Now().Format("2006-01-02T15:04:05Z")
+	body := "{mainmatter}\n\n# Security Considerations\n\nNone.\n\n# IANA Considerations\n\nNone.\n"
+	var tests = []struct {
+		input    string
+		expected []string
+	}{
+		{
+			"%%%\ndocName = \"draft-ietf-foo-bar-00\"\ndate = " + today + "\n%%%\n\n" + body,
+			nil,
+		},
+		{
+			// no titleblock, no checks on the structure
+			"# Intro\n\nText.\n",
+			nil,
+		},
+		{
+			"%%%\ndocName = \"draft-foo\"\ndate = 2010-01-01T00:00:00Z\n%%%\n\n{mainmatter}\n\n# Intro\n\nText.\n",
+			[]string{
+				"<input>:1:1: docName `draft-foo' does not match draft-<name>-<NN>",
+				"<input>:1:1: draft has expired on 2010-07-05",
+				"<input>: document has no Security Considerations section",
+				"<input>: document has no IANA Considerations section",
+			},
+		},
+		{
+			"%%%\ntitle = \"T\"\nupdates = [1035, 2136]\nobsoletes = [3007]\n%%%\n\n.# Abstract\n\nUpdates [@RFC1035].\n\n" +
+				body + "\nSee [@!I-D.ietf-foo], [@!I-D.ietf-bar#03] and [@RFC3007]. [-@RFC2136] [-@RFC7830]\n",
+			[]string{
+				"<input>:1:1: docName is missing from the titleblock",
+				"<input>:1:1: date is missing from the titleblock",
+				"<input>:9:9: abstract contains a citation of `RFC1035'",
+				"<input>:21:59: citation `RFC2136' is declared, but never used",
+				"<input>:21:71: citation `RFC7830' is declared, but never used",
+				"<input>: normative reference to `I-D.ietf-foo' has no draft version, use [@!I-D.ietf-foo#NN]",
+				"<input>: document updates RFC 2136, but does not cite RFC2136",
+			},
+		},
+	}
+	for i, test := range tests {
+		opts := Options{Extensions: commonXmlExtensions | EXTENSION_TITLEBLOCK_TOML, Lint: true}
+		diags := ParseWithOptions([]byte(test.input), XmlRenderer(0), opts).Diagnostics
+		if len(diags) != len(test.expected) {
+			t.Errorf("test %d: expected %d diagnostics, got %d: %v", i, len(test.expected), len(diags), diags)
+			continue
+		}
+		for j, d := range diags {
+			if d.String() != test.expected[j] {
+				t.Errorf("test %d: expected %q, got %q", i, test.expected[j], d.String())
+			}
+		}
+	}
+}
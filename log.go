@@ -1,14 +1,130 @@
+// Diagnostics reported while parsing and rendering.
+
 package mmark
 
-import "log"
+import (
+	"fmt"
+	"log"
+	"sort"
+)
+
+// Severity is the severity of a Diagnostic.
+type Severity int
+
+const (
+	SEVERITY_ERROR Severity = iota
+	SEVERITY_WARNING
+	SEVERITY_INFO
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SEVERITY_ERROR:
+		return "error"
+	case SEVERITY_WARNING:
+		return "warning"
+	}
+	return "info"
+}
+
+// Diagnostic is a problem found while parsing or rendering a document.
+type Diagnostic struct {
+	Severity Severity
+	File     string // file the problem was found in, empty for the main input if it has no name
+	Line     int    // line number, starting at 1, 0 if the position is unknown
+	Column   int    // column number in bytes, starting at 1
+	Code     string // short identifier for the kind of problem, i.e. "include" or "toml"
+	Message  string
+}
+
+// String returns the diagnostic as file:line:col: message.
+func (d Diagnostic) String() string {
+	file := d.File
+	if file == "" {
+		file = "<input>"
+	}
+	if d.Line == 0 {
+		return fmt.Sprintf("%s: %s", file, d.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", file, d.Line, d.Column, d.Message)
+}
+
+// A diagnoser is a Renderer that wants to report its problems to the parser that
+// drives it.
+type diagnoser interface {
+	setParser(p *parser)
+}
+
+func errorf(p *parser, code, format string, v ...interface{}) {
+	report(p, SEVERITY_ERROR, code, format, v...)
+}
+
+func warnf(p *parser, code, format string, v ...interface{}) {
+	report(p, SEVERITY_WARNING, code, format, v...)
+}
+
+func infof(p *parser, code, format string, v ...interface{}) {
+	report(p, SEVERITY_INFO, code, format, v...)
+}
 
-func printf(p *parser, format string, v ...interface{}) {
-	if test {
+// warnAt is warnf for a problem in file at line, for the renderers that find it
+// after the parser has moved on. Line is 0 when it is not known.
+func warnAt(p *parser, file string, line int, code, format string, v ...interface{}) {
+	if p == nil {
+		report(p, SEVERITY_WARNING, code, format, v...)
 		return
 	}
-	if p != nil {
-		log.Printf("mmark: "+format, v...)
+	d := Diagnostic{Severity: SEVERITY_WARNING, Code: code, Message: fmt.Sprintf(format, v...), File: file, Line: line, Column: 1}
+	if line == 0 {
+		d.File, d.Column = p.file, 0
+	}
+	p.diagnose(d)
+}
+
+// report records a diagnostic on the parser, without a parser it is logged.
+func report(p *parser, severity Severity, code, format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	if p == nil {
+		log.Printf("mmark: %s", msg)
 		return
 	}
-	log.Printf("mmark: "+format, v...)
+
+	d := Diagnostic{Severity: severity, Code: code, Message: msg}
+	d.File, d.Line, d.Column = p.position()
+	p.diagnose(d)
+}
+
+// sortDiagnostics puts the diagnostics in the order of the positions they are
+// at in the document, some are only reported when the whole document is parsed.
+// Diagnostics without a position come last.
+func (p *parser) sortDiagnostics() {
+	order := make(map[sourceLine]int, len(p.lines))
+	for i, l := range p.lines {
+		if _, ok := order[l]; !ok {
+			order[l] = i
+		}
+	}
+	index := func(d Diagnostic) int {
+		if i, ok := order[sourceLine{d.File, d.Line}]; ok && d.Line > 0 {
+			return i
+		}
+		return len(p.lines)
+	}
+	sort.SliceStable(p.diagnostics, func(i, j int) bool {
+		a, b := p.diagnostics[i], p.diagnostics[j]
+		ia, ib := index(a), index(b)
+		if ia != ib {
+			return ia < ib
+		}
+		return ia < len(p.lines) && a.Column < b.Column
+	})
+}
+
+// diagnose records d on the parser.
+func (p *parser) diagnose(d Diagnostic) {
+	p.diagnostics = append(p.diagnostics, d)
+
+	if p.opts.Logger != nil {
+		p.opts.Logger.Printf("mmark: %s", d.Message)
+	}
 }
@@ -0,0 +1,53 @@
+// Unit tests for diagnostics
+
+package mmark
+
+import "testing"
+
+func TestDiagnostics(t *testing.T) {
+	var tests = []struct {
+		input    string
+		renderer Renderer
+		expected []Diagnostic
+	}{
+		{
+			"%%%\ntitle = \"Bad\nfoo\n%%%\n\nText.\n",
+			HtmlRenderer(0, "", ""),
+			[]Diagnostic{{SEVERITY_ERROR, "doc.md", 1, 1, "toml", ""}},
+		},
+		{
+			"# Header\n\nSome text.\n\n{{/nonexistent/include.md}}\n",
+			HtmlRenderer(0, "", ""),
+			[]Diagnostic{{SEVERITY_WARNING, "doc.md", 5, 1, "include", ""}},
+		},
+		{
+			"[1]: http://example.org\n\nSome text\nand <br> tag.\n",
+			XmlRenderer(0),
+			[]Diagnostic{{SEVERITY_WARNING, "doc.md", 4, 5, "unsupported", "syntax not supported: RawHtmlTag: <br>"}},
+		},
+	}
+
+	for _, test := range tests {
+		r := ParseDocument([]byte(test.input), "doc.md", test.renderer, extensions)
+		if len(r.Diagnostics) != len(test.expected) {
+			t.Errorf("input %q: expected %d diagnostics, got %v", test.input, len(test.expected), r.Diagnostics)
+			continue
+		}
+		for i, d := range r.Diagnostics {
+			e := test.expected[i]
+			if e.Message == "" {
+				e.Message = d.Message
+			}
+			if d != e {
+				t.Errorf("input %q: expected %+v, got %+v", test.input, e, d)
+			}
+		}
+	}
+}
+
+func TestDiagnosticString(t *testing.T) {
+	d := Diagnostic{SEVERITY_WARNING, "section3.md", 42, 7, "include", "failed"}
+	if s := d.String(); s != "section3.md:42:7: failed" {
+		t.Errorf("unexpected string: %q", s)
+	}
+}
@@ -4,14 +4,15 @@ package mmark
 
 import (
 	"bytes"
+	"io/fs"
+	"log"
 	"path"
+	"path/filepath"
 	"unicode/utf8"
 )
 
 const Version = "1.3.4"
 
-var test = false
-
 // These are the supported markdown parsing extensions.
 // OR these values together to select multiple extensions.
 const (
@@ -71,50 +72,51 @@ const (
 		EXTENSION_DEFINITION_LISTS
 )
 
-// These are the possible flag values for the link renderer.
+// These are the possible flag values for the AutoLink renderer.
 // Only a single one of these values will be used; they are not ORed together.
 // These are mostly of interest if you are writing a new output format.
 const (
-	_LINK_TYPE_NOT_AUTOLINK = iota
-	_LINK_TYPE_NORMAL
-	_LINK_TYPE_EMAIL
+	LINK_TYPE_NOT_AUTOLINK = iota
+	LINK_TYPE_NORMAL
+	LINK_TYPE_EMAIL
 )
 
-// These are the possible flag values for the ListItem renderer.
+// These are the possible flag values for the List, ListItem and Paragraph renderers.
 // Multiple flag values may be ORed together.
 // These are mostly of interest if you are writing a new output format.
 const (
-	_LIST_TYPE_ORDERED = 1 << iota
-	_LIST_TYPE_ORDERED_ROMAN_UPPER
-	_LIST_TYPE_ORDERED_ROMAN_LOWER
-	_LIST_TYPE_ORDERED_ALPHA_UPPER
-	_LIST_TYPE_ORDERED_ALPHA_LOWER
-	_LIST_TYPE_ORDERED_GROUP
-	_LIST_TYPE_DEFINITION
-	_LIST_TYPE_TERM
-	_LIST_ITEM_CONTAINS_BLOCK
-	_LIST_ITEM_BEGINNING_OF_LIST
-	_LIST_ITEM_END_OF_LIST
-	_LIST_INSIDE_LIST
-	_INSIDE_FIGURE
+	LIST_TYPE_ORDERED = 1 << iota
+	LIST_TYPE_ORDERED_ROMAN_UPPER
+	LIST_TYPE_ORDERED_ROMAN_LOWER
+	LIST_TYPE_ORDERED_ALPHA_UPPER
+	LIST_TYPE_ORDERED_ALPHA_LOWER
+	LIST_TYPE_ORDERED_GROUP
+	LIST_TYPE_DEFINITION
+	LIST_TYPE_TERM
+	LIST_ITEM_CONTAINS_BLOCK
+	LIST_ITEM_BEGINNING_OF_LIST
+	LIST_ITEM_END_OF_LIST
+	LIST_INSIDE_LIST
+	INSIDE_FIGURE
 )
 
 // These are the possible flag values for the table cell renderer.
 // Only a single one of these values will be used; they are not ORed together.
 // These are mostly of interest if you are writing a new output format.
 const (
-	_TABLE_ALIGNMENT_LEFT = 1 << iota
-	_TABLE_ALIGNMENT_RIGHT
-	_TABLE_ALIGNMENT_CENTER = (_TABLE_ALIGNMENT_LEFT | _TABLE_ALIGNMENT_RIGHT)
+	TABLE_ALIGNMENT_LEFT = 1 << iota
+	TABLE_ALIGNMENT_RIGHT
+	TABLE_ALIGNMENT_CENTER = (TABLE_ALIGNMENT_LEFT | TABLE_ALIGNMENT_RIGHT)
 )
 
 // The size of a tab stop.
 const _TAB_SIZE_DEFAULT = 4
 
+// These are the possible values for the DocumentMatter renderer.
 const (
-	_DOC_FRONT_MATTER = iota + 1 // Different divisions of the document
-	_DOC_MAIN_MATTER
-	_DOC_BACK_MATTER
+	DOC_FRONT_MATTER = iota + 1 // Different divisions of the document
+	DOC_MAIN_MATTER
+	DOC_BACK_MATTER
 	_ABSTRACT // Special headers, keep track if there are open
 	_NOTE     // Special Note headers, keep track if there are open
 	_PREFACE
@@ -199,7 +201,7 @@ type Renderer interface {
 
 	Footnotes(out *bytes.Buffer, text func() bool)
 	FootnoteItem(out *bytes.Buffer, name, text []byte, flags int)
-	TitleBlockTOML(out *bytes.Buffer, data *title)
+	TitleBlockTOML(out *bytes.Buffer, data *Title)
 	Aside(out *bytes.Buffer, text []byte)
 	Figure(out *bytes.Buffer, text []byte, caption []byte)
 
@@ -223,7 +225,7 @@ type Renderer interface {
 	TripleEmphasis(out *bytes.Buffer, text []byte)
 	StrikeThrough(out *bytes.Buffer, text []byte)
 	FootnoteRef(out *bytes.Buffer, ref []byte, id int)
-	Index(out *bytes.Buffer, primary, secondary []byte, prim bool)
+	Index(out *bytes.Buffer, entry IndexEntry)
 	Citation(out *bytes.Buffer, link, title []byte)
 	Abbreviation(out *bytes.Buffer, abbr, title []byte)
 	Example(out *bytes.Buffer, index int)
@@ -239,17 +241,17 @@ type Renderer interface {
 
 	// Frontmatter, mainmatter or backmatter
 	DocumentMatter(out *bytes.Buffer, matter int)
-	References(out *bytes.Buffer, citations map[string]*citation)
+	References(out *bytes.Buffer, citations map[string]*Citation)
 
 	// Helper functions
 	Flags() int
 
 	// Attr returns the inline attribute.
-	Attr() *inlineAttr
+	Attr() *Attributes
 	// SetAttr set the inline attribute.
-	SetAttr(*inlineAttr)
+	SetAttr(*Attributes)
 	// AttrString return the string representation of this inline attribute.
-	AttrString(*inlineAttr) string
+	AttrString(*Attributes) string
 }
 
 // Callback functions for inline parsing. One such function is defined
@@ -261,7 +263,7 @@ type inlineParser func(p *parser, out *bytes.Buffer, data []byte, offset int) in
 type parser struct {
 	r                    Renderer
 	refs                 map[string]*reference
-	citations            map[string]*citation
+	citations            map[string]*Citation
 	abbreviations        map[string]*abbreviation
 	examples             map[string]int
 	callouts             map[string][]string
@@ -289,11 +291,35 @@ type parser struct {
 	chapterCount int // TODO, keep track of chapter count (#)
 
 	// Placeholder IAL that can be added to blocklevel elements.
-	ial *inlineAttr
+	ial *Attributes
 
 	// Prevent identical header anchors by appending -<sequence_number> starting
 	// with -1, this is the same thing that pandoc does.
 	anchors map[string]int
+
+	// Diagnostics and the positions they refer to.
+	diagnostics []Diagnostic
+	file        string       // name of the input
+	pos         sourceLine   // current position during the first pass
+	input       []byte       // input of the second pass
+	offset      int          // offset in input of what is being parsed
+	lines       []sourceLine // origin of every line in input
+	lineStarts  []int        // offsets in input of every line
+
+	// Files read while parsing, in the order they were first read, and the
+	// files that were looked for, but could not be read.
+	dependencies []string
+	missing      []string
+
+	// Use of the BCP 14 keywords and the document structure, for the lint
+	// checks.
+	bcp14 bcp14
+	lint  lint
+
+	// Anchors and the references to them.
+	xrefs xrefs
+
+	opts Options
 }
 
 // Markdown is an io.Writer. Writing a buffer with markdown text will be converted to
@@ -334,7 +360,8 @@ func (m *Markdown) render() {
 // Parse is the main rendering function.
 // It parses and renders a block of markdown-encoded text.
 // The supplied Renderer is used to format the output, and extensions dictates
-// which non-standard extensions are enabled.
+// which non-standard extensions are enabled. Problems found in the input are
+// logged.
 //
 // To use the supplied Html or XML renderers, see HtmlRenderer, XmlRenderer and
 // Xml2Renderer, respectively.
@@ -344,16 +371,154 @@ func Parse(input []byte, renderer Renderer, extensions int) *bytes.Buffer {
 		return nil
 	}
 
+	return ParseWithOptions(input, renderer, Options{Extensions: extensions, Logger: log.Default()}).Output
+}
+
+// Result is the result of ParseDocument.
+type Result struct {
+	Output      *bytes.Buffer
+	Diagnostics []Diagnostic
+
+	// Dependencies are the files that were included in the document, with
+	// their names in the FileSource, and the HTML head file.
+	Dependencies []string
+
+	// Missing are the files an include looked for, but that could not be
+	// read. The output changes when one of them is created.
+	Missing []string
+}
+
+// ParseDocument parses and renders a block of markdown-encoded text, just like
+// Parse. Instead of logging the problems found, they are returned in the
+// Result. Filename is the name of the input, it is used in the diagnostics.
+func ParseDocument(input []byte, filename string, renderer Renderer, extensions int) *Result {
+	return ParseWithOptions(input, renderer, Options{Extensions: extensions, Filename: filename})
+}
+
+// Options is the configuration of a single parse. The zero value of a field
+// selects the package default.
+type Options struct {
+	Extensions int    // the EXTENSION_* flags
+	Filename   string // name of the input, used in the diagnostics
+
+	// The URLs where the citations are, defaults to CitationsRFC, CitationsID,
+	// CitationsW3C and CitationsANSI.
+	CitationsRFC  string
+	CitationsID   string
+	CitationsW3C  string
+	CitationsANSI string
+
+	// CitationsResolver is used to resolve citations without inline
+	// reference XML, see BibCache. Resolved references are included in the
+	// output by all renderers, the others are left to the xml2rfc tools.
+	// When nil, nothing is resolved.
+	CitationsResolver ReferenceResolver
+
+	// MathSVG is used to render display math to SVG in the renderers that
+	// support it. When nil, math is only converted to text.
+	MathSVG MathRenderer
+
+	// FileSource is where included files and code includes are read from.
+	// Names are resolved as in fs.FS: they are slash separated and relative
+	// to the root of FileSource. Names that are absolute or that point
+	// outside of the root are rejected. Defaults to RootFS of the directory
+	// of Filename.
+	FileSource fs.FS
+
+	// Dir is the directory of the document in FileSource, the includes of
+	// the document itself are relative to it. Defaults to the directory of
+	// Filename when FileSource is set, and to the root of FileSource when it
+	// is not.
+	Dir string
+
+	// IncludePaths are the directories that are searched for included
+	// files that are not found relative to the file that includes them.
+	// Like the names of included files, they are relative to the root of
+	// FileSource.
+	IncludePaths []string
+
+	// SourceCodeTypes are the languages of included code that are used as the
+	// type of the code, defaults to SourceCodeTypes.
+	SourceCodeTypes map[string]bool
+
+	Logger     *log.Logger // if not nil, the diagnostics are also logged here
+	TabSize    int         // size of a tab stop, defaults to 4
+	MaxNesting int         // maximum nesting of blocks and inline elements, defaults to 16
+
+	// Lint enables extra checks on the document: the use of the BCP 14
+	// keywords and the problems the IETF submission tool rejects a draft
+	// for. Their problems are added to the diagnostics.
+	Lint bool
+
+	// Strict makes references to anchors that do not exist and duplicate
+	// anchors errors instead of warnings.
+	Strict bool
+}
+
+// defaults returns o with the zero fields set to the package defaults.
+func (o Options) defaults() Options {
+	if o.CitationsRFC == "" {
+		o.CitationsRFC = CitationsRFC
+	}
+	if o.CitationsID == "" {
+		o.CitationsID = CitationsID
+	}
+	if o.CitationsW3C == "" {
+		o.CitationsW3C = CitationsW3C
+	}
+	if o.CitationsANSI == "" {
+		o.CitationsANSI = CitationsANSI
+	}
+	if o.FileSource == nil {
+		// the document is in the root
+		o.FileSource = RootFS(filepath.Dir(o.Filename))
+		if o.Dir == "" {
+			o.Dir = "."
+		}
+	}
+	if o.Dir == "" {
+		o.Dir = includeDir(o.Filename)
+	}
+	if o.SourceCodeTypes == nil {
+		o.SourceCodeTypes = SourceCodeTypes
+	}
+	if o.TabSize <= 0 {
+		o.TabSize = _TAB_SIZE_DEFAULT
+	}
+	if o.MaxNesting <= 0 {
+		o.MaxNesting = 16
+	}
+	return o
+}
+
+// ParseWithOptions parses and renders a block of markdown-encoded text, like
+// ParseDocument, configured with opts. It only reads the package variables, so
+// it may be called from many goroutines at once, as long as each of them uses
+// its own renderer and the FileSource and CitationsResolver of the options are
+// safe for concurrent use.
+func ParseWithOptions(input []byte, renderer Renderer, opts Options) *Result {
+	if renderer == nil {
+		return &Result{}
+	}
+
+	p := newParser(renderer, opts)
+	output := p.parse(input)
+	return &Result{Output: output, Diagnostics: p.diagnostics, Dependencies: p.dependencies, Missing: p.missing}
+}
+
+func newParser(renderer Renderer, opts Options) *parser {
 	// fill in the render structure
 	p := new(parser)
 	p.r = renderer
-	p.flags = extensions
+	p.opts = opts.defaults()
+	p.file = p.opts.Filename
+	p.flags = p.opts.Extensions
 	p.refs = make(map[string]*reference)
 	p.abbreviations = make(map[string]*abbreviation)
 	p.anchors = make(map[string]int)
 	p.examples = make(map[string]int)
 	// newly created in 'callouts'
-	p.maxNesting = 16
+	p.maxNesting = p.opts.MaxNesting
 	p.insideLink = false
 
 	// register inline parsers
@@ -371,6 +536,7 @@ func Parse(input []byte, renderer Renderer, extensions int) *bytes.Buffer {
 	p.inlineCallback['('] = index       // also find example list references and cross references
 	p.inlineCallback['$'] = math
 
+	extensions := p.flags
 	if extensions&EXTENSION_AUTOLINK != 0 {
 		p.inlineCallback[':'] = autoLink
 	}
@@ -381,11 +547,27 @@ func Parse(input []byte, renderer Renderer, extensions int) *bytes.Buffer {
 
 	if extensions&EXTENSION_CITATION != 0 {
 		p.inlineCallback['@'] = citationReference // @ref, short form of citations
-		p.citations = make(map[string]*citation)
+		p.citations = make(map[string]*Citation)
+	}
+	return p
+}
+
+func (p *parser) parse(input []byte) *bytes.Buffer {
+	if d, ok := p.r.(diagnoser); ok {
+		d.setParser(p)
+		defer d.setParser(nil)
 	}
 
+	p.pos = sourceLine{p.file, 1}
 	first := firstPass(p, input, 0)
-	second := secondPass(p, first.Bytes(), 0)
+	p.input = first.Bytes()
+	second := secondPass(p, p.input, 0)
+	p.checkReferences()
+	if p.opts.Lint {
+		p.lintBCP14()
+		p.lintDocument()
+	}
+	p.sortDiagnostics()
 	return second
 }
 
@@ -399,15 +581,24 @@ func Parse(input []byte, renderer Renderer, extensions int) *bytes.Buffer {
 func firstPass(p *parser, input []byte, depth int) *bytes.Buffer {
 	var out bytes.Buffer
 	if depth > 8 {
-		printf(p, "nested includes depth > 8")
+		warnf(p, "include", "nested includes depth > 8")
 		out.WriteByte('\n')
 		return &out
 	}
 
-	tabSize := _TAB_SIZE_DEFAULT
+	// NUL is not text, and the HTML renderer uses it to mark cross references
+	input = bytes.Replace(input, []byte{0}, []byte("\uFFFD"), -1)
+
+	tabSize := p.opts.TabSize
 	beg, end := 0, 0
 	lastFencedCodeBlockEnd := 0
+	line, lineBeg := p.pos.line, 0
 	for beg < len(input) { // iterate over lines
+		line += bytes.Count(input[lineBeg:beg], []byte{'\n'})
+		lineBeg = beg
+		p.pos.line = line
+		start, lines := out.Len(), len(p.lines)
+
 		if beg >= lastFencedCodeBlockEnd { // don't parse inside fenced code blocks
 			if end = isReference(p, input[beg:], tabSize); end > 0 {
 				beg += end
@@ -446,6 +637,11 @@ func firstPass(p *parser, input []byte, depth int) *bytes.Buffer {
 			}
 		}
 		out.WriteByte('\n')
+		// Included lines have been recorded by the include, the remaining
+		// ones are from this line.
+		for n := bytes.Count(out.Bytes()[start:], []byte{'\n'}) - (len(p.lines) - lines); n > 0; n-- {
+			p.lines = append(p.lines, p.pos)
+		}
 
 		if end < len(input) && input[end] == '\r' {
 			end++
@@ -459,6 +655,7 @@ func firstPass(p *parser, input []byte, depth int) *bytes.Buffer {
 	// empty input?
 	if out.Len() == 0 {
 		out.WriteByte('\n')
+		p.lines = append(p.lines, p.pos)
 	}
 
 	return &out
@@ -471,21 +668,22 @@ func secondPass(p *parser, input []byte, depth int) *bytes.Buffer {
 	p.r.DocumentHeader(&output, depth == 0)
 	p.headerLen = output.Len()
 	p.block(&output, input)
+	p.source(nil) // what follows has no position in the source
 
 	if p.flags&EXTENSION_FOOTNOTES != 0 && len(p.notes) > 0 {
 		p.r.Footnotes(&output, func() bool {
-			flags := _LIST_ITEM_BEGINNING_OF_LIST
+			flags := LIST_ITEM_BEGINNING_OF_LIST
 			for i := 0; i < len(p.notes); i += 1 {
 				var buf bytes.Buffer
 				ref := p.notes[i]
 				if ref.hasBlock {
-					flags |= _LIST_ITEM_CONTAINS_BLOCK
+					flags |= LIST_ITEM_CONTAINS_BLOCK
 					p.block(&buf, ref.title)
 				} else {
 					p.inline(&buf, ref.title)
 				}
 				p.r.FootnoteItem(&output, ref.link, buf.Bytes(), flags)
-				flags &^= _LIST_ITEM_BEGINNING_OF_LIST | _LIST_ITEM_CONTAINS_BLOCK
+				flags &^= LIST_ITEM_BEGINNING_OF_LIST | LIST_ITEM_CONTAINS_BLOCK
 			}
 
 			return true
@@ -494,7 +692,8 @@ func secondPass(p *parser, input []byte, depth int) *bytes.Buffer {
 	if !p.appendix {
 		if len(p.citations) > 0 {
 			// appendix not started in doc, start it now and output references
-			p.r.DocumentMatter(&output, _DOC_BACK_MATTER)
+			p.r.DocumentMatter(&output, DOC_BACK_MATTER)
+			p.resolveCitations()
 			p.r.References(&output, p.citations)
 		}
 		p.appendix = true
@@ -550,8 +749,9 @@ type abbreviation struct {
 	title []byte
 }
 
-// citations are parsed and stored in this struct.
-type citation struct {
+// Citation is a citation as parsed from the document. The citations of a
+// document are handed to the References renderer callback.
+type Citation struct {
 	link  []byte
 	title []byte
 	xml   []byte // raw include of reference XML
@@ -559,6 +759,21 @@ type citation struct {
 	seq   int    // sequence number for I-Ds
 }
 
+// Link returns the anchor of the cited document, i.e. RFC2119.
+func (c *Citation) Link() []byte { return c.link }
+
+// Title returns the title of the citation.
+func (c *Citation) Title() []byte { return c.title }
+
+// XML returns the reference XML of the citation or nil if there is none.
+func (c *Citation) XML() []byte { return c.xml }
+
+// Normative returns true for a normative citation.
+func (c *Citation) Normative() bool { return c.typ == 'n' }
+
+// Sequence returns the draft number of an I-D citation or -1 if not given.
+func (c *Citation) Sequence() int { return c.seq }
+
 // Check whether or not data starts with a reference link.
 // If so, it is parsed and stored in the list of references
 // (in the render struct).
@@ -892,6 +1107,11 @@ func isroman(digit byte, uppercase bool) bool {
 	return false
 }
 
+// dependency records that file was read while parsing.
+func (p *parser) dependency(file string) {
+	p.dependencies = appendUnique(p.dependencies, file)
+}
+
 // replace {{file.md}} with the contents of the file.
 func (p *parser) include(out *bytes.Buffer, data []byte, depth int) int {
 	i := 0
@@ -932,14 +1152,20 @@ func (p *parser) include(out *bytes.Buffer, data []byte, depth int) int {
 		}
 	}
 
-	input := parseAddress(address, filename)
+	input, name, line := parseAddress(p, address, filename)
 	if input == nil {
 		return end
 	}
 	if input[len(input)-1] != '\n' {
 		input = append(input, '\n')
 	}
+
+	// p.pos tells the includes in the included file where they are included from
+	pos := p.pos
+	p.pos = sourceLine{name, line}
 	first := firstPass(p, input, depth+1)
+	p.pos = pos
+
 	out.Write(first.Bytes())
 	return end
 }
@@ -979,7 +1205,7 @@ func (p *parser) codeInclude(out *bytes.Buffer, data []byte) int {
 	// language we use it as the lang (and we will emit <sourcecode>)
 	if x := path.Ext(string(filename)); x != "" {
 		// x includes the dot
-		if _, ok := SourceCodeTypes[x[1:]]; ok {
+		if _, ok := p.opts.SourceCodeTypes[x[1:]]; ok {
 			lang = x[1:]
 		}
 	}
@@ -1001,7 +1227,7 @@ func (p *parser) codeInclude(out *bytes.Buffer, data []byte) int {
 		}
 	}
 
-	code := parseAddress(address, filename)
+	code, name, codeLine := parseAddress(p, address, filename)
 
 	if len(code) == 0 {
 		code = []byte{'\n'}
@@ -1039,6 +1265,11 @@ func (p *parser) codeInclude(out *bytes.Buffer, data []byte) int {
 	p.r.SetAttr(p.ial)
 	p.ial = nil
 
+	// the code comes from the included file, point there
+	if s, ok := p.r.(SourceRenderer); ok && codeLine > 0 {
+		s.Source(name, codeLine)
+	}
+
 	if co != "" {
 		var callout bytes.Buffer
 		callouts(p, &callout, code, 0, co)
@@ -0,0 +1,137 @@
+// Unit tests for parsing with options
+
+package mmark
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseWithOptions(t *testing.T) {
+	var tests = []struct {
+		input    string
+		opts     Options
+		expected []string
+	}{
+		{
+			"{mainmatter}\n\n# A\n\nSee [@RFC2119].\n\n{backmatter}\n",
+			Options{Extensions: EXTENSION_MATTER | EXTENSION_CITATION, CitationsRFC: "https://example.org/bib/"},
+			[]string{`<xi:include href="https://example.org/bib/reference.RFC.2119.xml"/>`},
+		},
+		{
+			"{mainmatter}\n\n# A\n\nSee [@RFC2119].\n\n{backmatter}\n",
+			Options{Extensions: EXTENSION_MATTER | EXTENSION_CITATION},
+			[]string{`<xi:include href="` + CitationsRFC + `reference.RFC.2119.xml"/>`},
+		},
+		{
+			"<{{main.abc}}\n",
+			Options{Extensions: EXTENSION_INCLUDE, SourceCodeTypes: map[string]bool{"abc": true}},
+			[]string{`<sourcecode type="abc">`},
+		},
+		{
+			"<{{main.abc}}\n",
+			Options{Extensions: EXTENSION_INCLUDE},
+			[]string{`<artwork>`},
+		},
+		{
+			"{{chapter.md}}\n",
+			Options{Extensions: EXTENSION_INCLUDE, IncludePaths: []string{"shared"}},
+			[]string{`From the search path.`},
+		},
+		{
+			"\tcode\n",
+			Options{TabSize: 8},
+			[]string{"<artwork>\n    code\n</artwork>"},
+		},
+		{
+			"> > > quote\n",
+			Options{MaxNesting: 2},
+			[]string{"<blockquote>\n<blockquote>\n</blockquote>\n</blockquote>"},
+		},
+	}
+	files := fstest.MapFS{
+		"main.abc":          {Data: []byte("x := 1\n")},
+		"shared/chapter.md": {Data: []byte("From the search path.\n")},
+	}
+
+	for i, test := range tests {
+		test.opts.FileSource = files
+		out := ParseWithOptions([]byte(test.input), XmlRenderer(XML_STANDALONE), test.opts).Output.String()
+		for _, e := range test.expected {
+			if !strings.Contains(out, e) {
+				t.Errorf("test %d: expected %q in output:\n%s", i, e, out)
+			}
+		}
+	}
+}
+
+func TestParseWithOptionsLogger(t *testing.T) {
+	var buf bytes.Buffer
+	input := "{mainmatter}\n\n# A\n\n### C\n"
+	result := ParseWithOptions([]byte(input), Xml2Renderer(0), Options{Extensions: EXTENSION_MATTER, Logger: log.New(&buf, "", 0)})
+	if len(result.Diagnostics) == 0 {
+		t.Fatalf("expected diagnostics")
+	}
+	if !strings.Contains(buf.String(), result.Diagnostics[0].Message) {
+		t.Errorf("expected %q to be logged, got %q", result.Diagnostics[0].Message, buf.String())
+	}
+
+	if result := ParseWithOptions([]byte(input), Xml2Renderer(0), Options{Extensions: EXTENSION_MATTER}); len(result.Diagnostics) == 0 {
+		t.Errorf("expected diagnostics without a logger")
+	}
+}
+
+// TestParseConcurrent renders documents with different options at the same
+// time, run it with -race.
+func TestParseConcurrent(t *testing.T) {
+	input := []byte("{mainmatter}\n\n# A\n\nSee [@RFC2119] and [@!I-D.ietf-foo-bar].\n\n<{{main.abc}}\n\n{backmatter}\n")
+
+	var wg sync.WaitGroup
+	errs := make(chan string, 64)
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			opts := Options{
+				Extensions:      commonXmlExtensions | EXTENSION_INCLUDE,
+				Filename:        fmt.Sprintf("doc%d.md", i),
+				CitationsRFC:    fmt.Sprintf("https://rfc%d.example.org/", i),
+				CitationsID:     fmt.Sprintf("https://id%d.example.org/", i),
+				SourceCodeTypes: map[string]bool{"abc": i%2 == 0},
+				Logger:          log.New(&bytes.Buffer{}, "", 0),
+				TabSize:         2 + i%4,
+				MaxNesting:      8 + i,
+				// every document reads its own version of the include
+				FileSource: fstest.MapFS{"main.abc": {Data: []byte(fmt.Sprintf("x := %d\n", i))}},
+			}
+			var r Renderer
+			switch i % 4 {
+			case 0:
+				r = XmlRenderer(XML_STANDALONE)
+			case 1:
+				r = Xml2Renderer(XML2_STANDALONE)
+			case 2:
+				r = HtmlRenderer(0, "", "")
+			case 3:
+				r = TextRenderer(0)
+			}
+			out := ParseWithOptions(input, r, opts).Output.String()
+			if i%4 < 2 && !strings.Contains(out, opts.CitationsRFC) {
+				errs <- fmt.Sprintf("doc %d: expected %q in output:\n%s", i, opts.CitationsRFC, out)
+			}
+			if code := fmt.Sprintf("x := %d\n", i); !strings.Contains(out, code) {
+				errs <- fmt.Sprintf("doc %d: expected %q in output:\n%s", i, code, out)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for e := range errs {
+		t.Error(e)
+	}
+}
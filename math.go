@@ -0,0 +1,321 @@
+// Converting TeX math to plain text.
+
+package mmark
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MathRenderer renders TeX math to SVG, for renderers that can include images
+// of display math.
+type MathRenderer interface {
+	// SVG returns the SVG rendering of the TeX in tex. Display is true for
+	// display math, i.e. a paragraph that consists only of math.
+	SVG(tex []byte, display bool) ([]byte, error)
+}
+
+// texSymbols maps TeX commands to their Unicode equivalent.
+var texSymbols = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ε",
+	"varepsilon": "ε", "zeta": "ζ", "eta": "η", "theta": "θ", "vartheta": "ϑ",
+	"iota": "ι", "kappa": "κ", "lambda": "λ", "mu": "μ", "nu": "ν", "xi": "ξ",
+	"pi": "π", "varpi": "ϖ", "rho": "ρ", "varrho": "ϱ", "sigma": "σ",
+	"varsigma": "ς", "tau": "τ", "upsilon": "υ", "phi": "φ", "varphi": "φ",
+	"chi": "χ", "psi": "ψ", "omega": "ω",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π",
+	"Sigma": "Σ", "Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+
+	"cdot": "·", "times": "×", "div": "÷", "pm": "±", "mp": "∓", "ast": "*",
+	"star": "⋆", "circ": "∘", "bullet": "•", "oplus": "⊕", "ominus": "⊖",
+	"otimes": "⊗", "odot": "⊙", "wedge": "∧", "land": "∧", "vee": "∨", "lor": "∨",
+	"neg": "¬", "lnot": "¬", "cap": "∩", "cup": "∪", "setminus": "∖",
+	"sum": "Σ", "prod": "Π", "coprod": "∐", "int": "∫", "oint": "∮",
+	"bigcup": "∪", "bigcap": "∩", "bigoplus": "⊕", "bigotimes": "⊗",
+
+	"leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠", "ne": "≠",
+	"approx": "≈", "equiv": "≡", "cong": "≅", "sim": "∼", "simeq": "≃",
+	"propto": "∝", "ll": "≪", "gg": "≫", "prec": "≺", "succ": "≻",
+	"in": "∈", "notin": "∉", "ni": "∋", "subset": "⊂", "subseteq": "⊆",
+	"supset": "⊃", "supseteq": "⊇", "mid": "|", "nmid": "∤", "parallel": "∥",
+	"perp": "⊥", "vdash": "⊢", "models": "⊨",
+
+	"to": "→", "rightarrow": "→", "leftarrow": "←", "gets": "←",
+	"leftrightarrow": "↔", "Rightarrow": "⇒", "Leftarrow": "⇐",
+	"Leftrightarrow": "⇔", "implies": "⇒", "iff": "⇔", "mapsto": "↦",
+	"uparrow": "↑", "downarrow": "↓",
+
+	"forall": "∀", "exists": "∃", "nexists": "∄", "emptyset": "∅",
+	"varnothing": "∅", "infty": "∞", "partial": "∂", "nabla": "∇",
+	"prime": "′", "ell": "ℓ", "hbar": "ℏ", "aleph": "ℵ", "angle": "∠",
+	"top": "⊤", "bot": "⊥",
+
+	"ldots": "…", "dots": "…", "cdots": "⋯", "vdots": "⋮", "ddots": "⋱",
+	"langle": "⟨", "rangle": "⟩", "lfloor": "⌊", "rfloor": "⌋",
+	"lceil": "⌈", "rceil": "⌉", "vert": "|", "lvert": "|", "rvert": "|",
+	"Vert": "‖", "lVert": "‖", "rVert": "‖", "|": "‖",
+	"{": "{", "}": "}", "%": "%", "$": "$", "&": "&", "#": "#", "_": "_",
+	"backslash": "\\",
+
+	",": " ", ":": " ", ";": " ", " ": " ", "quad": " ", "qquad": "  ", "!": "",
+	"\\": "\n", "displaystyle": "", "textstyle": "", "nonumber": "", "notag": "",
+	"big": "", "Big": "", "bigg": "", "Bigg": "",
+	"bmod": " mod ", "mod": " mod ",
+}
+
+// texFunctions are the operator names TeX sets upright.
+var texFunctions = map[string]bool{
+	"arccos": true, "arcsin": true, "arctan": true, "arg": true, "cos": true,
+	"cosh": true, "cot": true, "deg": true, "det": true, "dim": true,
+	"exp": true, "gcd": true, "hom": true, "inf": true, "ker": true, "lcm": true,
+	"lg": true, "lim": true, "ln": true, "log": true, "max": true, "min": true,
+	"Pr": true, "sec": true, "sin": true, "sinh": true, "sup": true, "tan": true,
+	"tanh": true,
+}
+
+// texText are the commands whose argument is shown as is.
+var texText = map[string]bool{
+	"text": true, "textrm": true, "textit": true, "textbf": true, "texttt": true,
+	"mathrm": true, "mathit": true, "mathbf": true, "mathsf": true, "mathtt": true,
+	"mathcal": true, "mathfrak": true, "boldsymbol": true, "operatorname": true,
+	"mbox": true, "hbox": true,
+}
+
+// texAccents maps accent commands to combining characters.
+var texAccents = map[string]string{
+	"bar": "̄", "overline": "̅", "hat": "̂", "widehat": "̂",
+	"tilde": "̃", "widetilde": "̃", "vec": "⃗", "dot": "̇",
+	"ddot": "̈",
+}
+
+var texDoubleStruck = map[rune]string{
+	'C': "ℂ", 'H': "ℍ", 'N': "ℕ", 'P': "ℙ", 'Q': "ℚ", 'R': "ℝ", 'Z': "ℤ",
+}
+
+var texSuperscript = map[rune]rune{
+	'0': '⁰', '1': '¹', '2': '²', '3': '³', '4': '⁴', '5': '⁵', '6': '⁶',
+	'7': '⁷', '8': '⁸', '9': '⁹', '+': '⁺', '-': '⁻', '=': '⁼', '(': '⁽',
+	')': '⁾', 'n': 'ⁿ', 'i': 'ⁱ', '′': '′',
+}
+
+var texSubscript = map[rune]rune{
+	'0': '₀', '1': '₁', '2': '₂', '3': '₃', '4': '₄', '5': '₅', '6': '₆',
+	'7': '₇', '8': '₈', '9': '₉', '+': '₊', '-': '₋', '=': '₌', '(': '₍',
+	')': '₎',
+}
+
+// texToText converts TeX math to a readable line of text, using Unicode for
+// the symbols it knows. Commands it doesn't know are shown without the
+// backslash.
+func texToText(tex []byte) string {
+	t := &texConv{s: string(tex)}
+	s := t.expr(false)
+	lines := strings.Split(s, "\n")
+	for i := range lines {
+		lines[i] = strings.Join(strings.Fields(lines[i]), " ")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// texConv holds the state of a TeX to text conversion.
+type texConv struct {
+	s string
+	i int
+}
+
+// expr converts up to the end of the input, or the closing brace of the group
+// when group is true.
+func (t *texConv) expr(group bool) string {
+	var b strings.Builder
+	for t.i < len(t.s) {
+		c := t.s[t.i]
+		switch c {
+		case '}':
+			t.i++
+			if group {
+				return b.String()
+			}
+		case '{':
+			t.i++
+			b.WriteString(t.expr(true))
+		case '^':
+			t.i++
+			b.WriteString(texScript(t.arg(), texSuperscript, "^"))
+		case '_':
+			t.i++
+			b.WriteString(texScript(t.arg(), texSubscript, "_"))
+		case '&':
+			t.i++
+			b.WriteByte(' ')
+		case '~':
+			t.i++
+			b.WriteByte(' ')
+		case '\\':
+			b.WriteString(t.command())
+		case ' ', '\t', '\n', '\r':
+			t.i++
+			b.WriteByte(' ')
+		default:
+			_, size := utf8.DecodeRuneInString(t.s[t.i:])
+			b.WriteString(t.s[t.i : t.i+size])
+			t.i += size
+		}
+	}
+	return b.String()
+}
+
+// arg converts the argument of a command: a group, a command or a single
+// character.
+func (t *texConv) arg() string {
+	t.skipSpace()
+	if t.i >= len(t.s) {
+		return ""
+	}
+	switch t.s[t.i] {
+	case '{':
+		t.i++
+		return t.expr(true)
+	case '\\':
+		return t.command()
+	}
+	_, size := utf8.DecodeRuneInString(t.s[t.i:])
+	t.i += size
+	return t.s[t.i-size : t.i]
+}
+
+// optional returns the optional argument in square brackets, if any.
+func (t *texConv) optional() string {
+	t.skipSpace()
+	if t.i >= len(t.s) || t.s[t.i] != '[' {
+		return ""
+	}
+	end := strings.IndexByte(t.s[t.i:], ']')
+	if end < 0 {
+		return ""
+	}
+	inner := &texConv{s: t.s[t.i+1 : t.i+end]}
+	t.i += end + 1
+	return inner.expr(false)
+}
+
+func (t *texConv) skipSpace() {
+	for t.i < len(t.s) && (t.s[t.i] == ' ' || t.s[t.i] == '\t' || t.s[t.i] == '\n') {
+		t.i++
+	}
+}
+
+// command converts the command starting at the backslash at t.i.
+func (t *texConv) command() string {
+	t.i++ // backslash
+	if t.i >= len(t.s) {
+		return ""
+	}
+	j := t.i
+	for j < len(t.s) && isletter(t.s[j]) {
+		j++
+	}
+	if j == t.i {
+		// control symbol, like \{ or \,
+		_, size := utf8.DecodeRuneInString(t.s[j:])
+		j += size
+	}
+	name := t.s[t.i:j]
+	t.i = j
+
+	switch {
+	case name == "frac" || name == "dfrac" || name == "tfrac":
+		num, den := t.arg(), t.arg()
+		return texGroup(num) + "/" + texGroup(den)
+	case name == "sqrt":
+		n := strings.TrimSpace(t.optional())
+		root := "√"
+		switch n {
+		case "":
+		case "3":
+			root = "∛"
+		case "4":
+			root = "∜"
+		default:
+			root = texScript(n, texSuperscript, "^") + "√"
+		}
+		return root + texGroup(t.arg())
+	case name == "binom":
+		n, k := t.arg(), t.arg()
+		return "C(" + n + ", " + k + ")"
+	case name == "pmod":
+		return " (mod " + t.arg() + ")"
+	case name == "mathbb":
+		s := t.arg()
+		var b strings.Builder
+		for _, r := range s {
+			if d, ok := texDoubleStruck[r]; ok {
+				b.WriteString(d)
+				continue
+			}
+			b.WriteRune(r)
+		}
+		return b.String()
+	case name == "left" || name == "right":
+		// \left. and \right. are invisible delimiters
+		if t.i < len(t.s) && t.s[t.i] == '.' {
+			t.i++
+		}
+		return ""
+	case name == "begin" || name == "end":
+		t.arg() // environment name
+		return "\n"
+	case texText[name]:
+		return t.arg()
+	case texFunctions[name]:
+		t.skipSpace()
+		if t.i < len(t.s) && isletter(t.s[t.i]) {
+			return name + " "
+		}
+		return name
+	}
+	if accent, ok := texAccents[name]; ok {
+		s := t.arg()
+		if utf8.RuneCountInString(s) == 1 {
+			return s + accent
+		}
+		return s
+	}
+	if sym, ok := texSymbols[name]; ok {
+		return sym
+	}
+	return name
+}
+
+// texGroup puts parentheses around s if it is more than a single symbol or
+// number.
+func texGroup(s string) string {
+	s = strings.TrimSpace(s)
+	if utf8.RuneCountInString(s) <= 1 {
+		return s
+	}
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '.' {
+			return "(" + s + ")"
+		}
+	}
+	return s
+}
+
+// texScript writes s as a super- or subscript, using the Unicode characters in
+// script when all of s can be written that way.
+func texScript(s string, script map[rune]rune, op string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ""
+	}
+	var b strings.Builder
+	for _, r := range s {
+		m, ok := script[r]
+		if !ok {
+			return op + texGroup(s)
+		}
+		b.WriteRune(m)
+	}
+	return b.String()
+}
@@ -0,0 +1,430 @@
+// Converting TeX math to MathML.
+
+package mmark
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// texVariants maps font commands to the MathML mathvariant.
+var texVariants = map[string]string{
+	"mathrm": "normal", "mathit": "italic", "mathbf": "bold", "boldsymbol": "bold-italic",
+	"mathsf": "sans-serif", "mathtt": "monospace", "mathcal": "script",
+	"mathfrak": "fraktur", "mathbb": "double-struck", "operatorname": "normal",
+}
+
+// texMatrices maps matrix environments to their delimiters.
+var texMatrices = map[string][2]string{
+	"matrix": {"", ""}, "smallmatrix": {"", ""}, "array": {"", ""},
+	"aligned": {"", ""}, "align": {"", ""}, "align*": {"", ""},
+	"gathered": {"", ""}, "split": {"", ""},
+	"pmatrix": {"(", ")"}, "bmatrix": {"[", "]"}, "Bmatrix": {"{", "}"},
+	"vmatrix": {"|", "|"}, "Vmatrix": {"‖", "‖"}, "cases": {"{", ""},
+}
+
+// texLimits are the operators that have their scripts above and below them in
+// display math.
+var texLimits = map[string]bool{
+	"sum": true, "prod": true, "coprod": true, "bigcup": true, "bigcap": true,
+	"bigoplus": true, "bigotimes": true, "lim": true, "max": true, "min": true,
+	"sup": true, "inf": true,
+}
+
+// texToMathML converts TeX math to the content of a MathML <math> element,
+// with the TeX kept as an annotation. It supports a subset of TeX: fractions,
+// roots, sub- and superscripts, greek letters and other symbols, accents, font
+// commands, \text and matrix environments. Unknown commands are shown as text.
+func texToMathML(tex []byte, display bool) string {
+	m := &mathML{s: string(tex), display: display}
+	return "<semantics>" + m.row(m.expr(false)) +
+		"<annotation encoding=\"application/x-tex\">" + mathEscape(strings.TrimSpace(string(tex))) + "</annotation>" +
+		"</semantics>"
+}
+
+// mathML holds the state of a TeX to MathML conversion.
+type mathML struct {
+	s       string
+	i       int
+	display bool
+	depth   int // nesting level of matrix environments
+}
+
+// mathEscape escapes the characters that are special in XML.
+func mathEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\"", "&quot;").Replace(s)
+}
+
+func mathElem(tag, content string) string { return "<" + tag + ">" + content + "</" + tag + ">" }
+
+func mathOp(s string) string { return mathElem("mo", mathEscape(s)) }
+
+// row groups elements into a single one.
+func (m *mathML) row(elems []string) string {
+	if len(elems) == 1 {
+		return elems[0]
+	}
+	return mathElem("mrow", strings.Join(elems, ""))
+}
+
+// expr converts up to the end of the input or until the closing brace of the
+// group when group is true, it returns the elements found. In a matrix it also
+// stops at the end of a cell.
+func (m *mathML) expr(group bool) []string {
+	var elems []string
+	limits := false // the last element is an operator that takes limits
+	for m.i < len(m.s) {
+		c := m.s[m.i]
+		if c == '}' && group || m.endOfCell() {
+			return elems
+		}
+		switch {
+		case c == '{':
+			m.i++
+			elems = append(elems, m.row(m.expr(true)))
+			m.closeGroup()
+			limits = false
+		case c == '}':
+			// unbalanced, ignore
+			m.i++
+		case c == '^' || c == '_':
+			base := "<mrow/>"
+			if len(elems) > 0 {
+				base = elems[len(elems)-1]
+				elems = elems[:len(elems)-1]
+			}
+			elems = append(elems, m.scripts(base, limits))
+			limits = false
+		case c == '\\':
+			var elem string
+			elem, limits = m.command()
+			if elem == "\\" {
+				// only meaningful in matrices
+				continue
+			}
+			if elem != "" {
+				elems = append(elems, elem)
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			m.i++
+		case c == '&':
+			// alignment outside a matrix
+			m.i++
+		case c == '~':
+			m.i++
+			elems = append(elems, "<mspace width=\"0.333em\"/>")
+		case c >= '0' && c <= '9' || c == '.':
+			j := m.i
+			for j < len(m.s) && (m.s[j] >= '0' && m.s[j] <= '9' || m.s[j] == '.') {
+				j++
+			}
+			elems = append(elems, mathElem("mn", m.s[m.i:j]))
+			m.i = j
+			limits = false
+		default:
+			r, size := utf8.DecodeRuneInString(m.s[m.i:])
+			m.i += size
+			limits = false
+			switch {
+			case unicode.IsLetter(r):
+				elems = append(elems, mathElem("mi", string(r)))
+			case r == '-':
+				elems = append(elems, mathOp("−"))
+			case r == '\'':
+				elems = append(elems, mathOp("′"))
+			default:
+				elems = append(elems, mathOp(string(r)))
+			}
+		}
+	}
+	return elems
+}
+
+// arg converts the argument of a command or script: a group, a command or a
+// single character.
+func (m *mathML) arg() string {
+	m.skipSpace()
+	if m.i >= len(m.s) {
+		return "<mrow/>"
+	}
+	switch c := m.s[m.i]; {
+	case c == '{':
+		m.i++
+		elems := m.expr(true)
+		m.closeGroup()
+		if len(elems) == 0 {
+			return "<mrow/>"
+		}
+		return m.row(elems)
+	case c == '\\':
+		if elem, _ := m.command(); elem != "" && elem != "\\" {
+			return elem
+		}
+		return "<mrow/>"
+	case c >= '0' && c <= '9':
+		m.i++
+		return mathElem("mn", string(c))
+	}
+	sub := &mathML{s: m.s[m.i:], display: m.display}
+	_, size := utf8.DecodeRuneInString(sub.s)
+	sub.s = sub.s[:size]
+	m.i += size
+	return m.row(sub.expr(false))
+}
+
+// closeGroup skips the closing brace of a group, if it is there.
+func (m *mathML) closeGroup() {
+	if m.i < len(m.s) && m.s[m.i] == '}' {
+		m.i++
+	}
+}
+
+// endOfCell returns true if a matrix cell ends at m.i.
+func (m *mathML) endOfCell() bool {
+	if m.depth == 0 {
+		return false
+	}
+	rest := m.s[m.i:]
+	return rest[0] == '&' || strings.HasPrefix(rest, "\\\\") || strings.HasPrefix(rest, "\\end")
+}
+
+// rawArg returns the unconverted text of a group argument.
+func (m *mathML) rawArg() string {
+	m.skipSpace()
+	if m.i >= len(m.s) {
+		return ""
+	}
+	if m.s[m.i] != '{' {
+		_, size := utf8.DecodeRuneInString(m.s[m.i:])
+		m.i += size
+		return m.s[m.i-size : m.i]
+	}
+	depth := 0
+	for j := m.i; j < len(m.s); j++ {
+		switch m.s[j] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				s := m.s[m.i+1 : j]
+				m.i = j + 1
+				return s
+			}
+		}
+	}
+	s := m.s[m.i+1:]
+	m.i = len(m.s)
+	return s
+}
+
+func (m *mathML) skipSpace() {
+	for m.i < len(m.s) && (m.s[m.i] == ' ' || m.s[m.i] == '\t' || m.s[m.i] == '\n') {
+		m.i++
+	}
+}
+
+// scripts converts the sub- and superscript at m.i and attaches them to base.
+func (m *mathML) scripts(base string, limits bool) string {
+	var sub, sup string
+	for i := 0; i < 2 && m.i < len(m.s); i++ {
+		switch m.s[m.i] {
+		case '_':
+			if sub != "" {
+				return m.script(base, sub, sup, limits)
+			}
+			m.i++
+			sub = m.arg()
+		case '^':
+			if sup != "" {
+				return m.script(base, sub, sup, limits)
+			}
+			m.i++
+			sup = m.arg()
+		}
+		m.skipSpace()
+	}
+	return m.script(base, sub, sup, limits)
+}
+
+func (m *mathML) script(base, sub, sup string, limits bool) string {
+	under, over, both := "msub", "msup", "msubsup"
+	if limits && m.display {
+		under, over, both = "munder", "mover", "munderover"
+	}
+	switch {
+	case sub != "" && sup != "":
+		return mathElem(both, base+sub+sup)
+	case sub != "":
+		return mathElem(under, base+sub)
+	}
+	return mathElem(over, base+sup)
+}
+
+// command converts the command starting at the backslash at m.i. It returns
+// the element and whether it is an operator that takes limits. A line break
+// is returned as a single backslash.
+func (m *mathML) command() (string, bool) {
+	m.i++ // backslash
+	if m.i >= len(m.s) {
+		return "", false
+	}
+	j := m.i
+	for j < len(m.s) && isletter(m.s[j]) {
+		j++
+	}
+	if j == m.i {
+		_, size := utf8.DecodeRuneInString(m.s[j:])
+		j += size
+	}
+	name := m.s[m.i:j]
+	m.i = j
+
+	switch name {
+	case "frac", "dfrac", "tfrac":
+		num := m.arg()
+		return mathElem("mfrac", num+m.arg()), false
+	case "binom":
+		n := m.arg()
+		return mathElem("mrow", mathOp("(")+"<mfrac linethickness=\"0\">"+n+m.arg()+"</mfrac>"+mathOp(")")), false
+	case "sqrt":
+		m.skipSpace()
+		if m.i < len(m.s) && m.s[m.i] == '[' {
+			end := strings.IndexByte(m.s[m.i:], ']')
+			if end > 0 {
+				index := &mathML{s: m.s[m.i+1 : m.i+end]}
+				m.i += end + 1
+				return mathElem("mroot", m.arg()+index.row(index.expr(false))), false
+			}
+		}
+		return mathElem("msqrt", m.arg()), false
+	case "text", "textrm", "textit", "textbf", "texttt", "mbox", "hbox":
+		return mathElem("mtext", mathEscape(m.rawArg())), false
+	case "left", "right", "big", "Big", "bigg", "Bigg", "bigl", "bigr", "Bigl", "Bigr":
+		// the delimiter follows as a normal character, \left. is an invisible one
+		m.skipSpace()
+		if m.i < len(m.s) && m.s[m.i] == '.' {
+			m.i++
+		}
+		return "", false
+	case "pmod":
+		q := m.arg()
+		return mathElem("mrow", mathOp("(")+mathElem("mi", "mod")+"<mspace width=\"0.333em\"/>"+q+mathOp(")")), false
+	case "bmod", "mod":
+		return mathOp("mod"), false
+	case "begin":
+		return m.matrix(m.rawArg()), false
+	case "end":
+		m.rawArg()
+		return "", false
+	case "\\":
+		return "\\", false
+	case ",", ":", ";", " ", "quad", "qquad", "!":
+		width := map[string]string{",": "0.167em", ":": "0.222em", ";": "0.278em",
+			" ": "0.333em", "quad": "1em", "qquad": "2em", "!": "-0.167em"}[name]
+		return "<mspace width=\"" + width + "\"/>", false
+	}
+	if variant, ok := texVariants[name]; ok {
+		raw := m.rawArg()
+		if variant == "normal" && len(raw) > 1 && isAlnumString(raw) {
+			// multiple letters are upright by default
+			return mathElem("mi", raw), texLimits[raw]
+		}
+		if isAlnumString(raw) {
+			return "<mi mathvariant=\"" + variant + "\">" + raw + "</mi>", false
+		}
+		sub := &mathML{s: raw, display: m.display}
+		return sub.row(sub.expr(false)), false
+	}
+	if accent, ok := texAccents[name]; ok {
+		base := m.arg()
+		switch name {
+		case "bar", "overline":
+			accent = "¯"
+		case "hat", "widehat":
+			accent = "^"
+		case "tilde", "widetilde":
+			accent = "~"
+		case "vec":
+			accent = "→"
+		case "dot":
+			accent = "˙"
+		case "ddot":
+			accent = "¨"
+		}
+		return "<mover accent=\"true\">" + base + mathOp(accent) + "</mover>", false
+	}
+	if texFunctions[name] {
+		return mathElem("mi", name), texLimits[name]
+	}
+	if sym, ok := texSymbols[name]; ok {
+		if sym == "" {
+			return "", false
+		}
+		r, _ := utf8.DecodeRuneInString(sym)
+		if unicode.IsLetter(r) && name != "sum" && name != "prod" && name != "coprod" {
+			return mathElem("mi", sym), false
+		}
+		return mathOp(sym), texLimits[name]
+	}
+	return mathElem("mtext", mathEscape("\\"+name)), false
+}
+
+// matrix converts the body of the environment env to a table.
+func (m *mathML) matrix(env string) string {
+	if env == "array" {
+		m.rawArg() // column specification
+	}
+	m.depth++
+	var rows, cells []string
+	for m.i < len(m.s) {
+		cells = append(cells, mathElem("mtd", m.row(m.expr(false))))
+		rest := m.s[m.i:]
+		switch {
+		case strings.HasPrefix(rest, "&"):
+			m.i++
+			continue
+		case strings.HasPrefix(rest, "\\\\"):
+			m.i += 2
+			rows = append(rows, mathElem("mtr", strings.Join(cells, "")))
+			cells = nil
+			continue
+		case strings.HasPrefix(rest, "\\end"):
+			m.i += len("\\end")
+			m.rawArg()
+		}
+		break
+	}
+	m.depth--
+	if len(cells) > 0 {
+		rows = append(rows, mathElem("mtr", strings.Join(cells, "")))
+	}
+
+	table := mathElem("mtable", strings.Join(rows, ""))
+	delims := texMatrices[env]
+	if delims[0] == "" && delims[1] == "" {
+		return table
+	}
+	s := ""
+	if delims[0] != "" {
+		s += mathOp(delims[0])
+	}
+	s += table
+	if delims[1] != "" {
+		s += mathOp(delims[1])
+	}
+	return mathElem("mrow", s)
+}
+
+func isAlnumString(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
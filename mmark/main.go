@@ -17,6 +17,8 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/miekg/mmark"
 )
@@ -27,21 +29,42 @@ const DEFAULT_TITLE = ""
 
 func main() {
 	// parse command-line options
-	var page, xml, xml2, toml, rfc7328, version bool
-	var css, head string
+	var page, xml, xml2, text, toml, imprt, rfc7328, version, werror, source, toc, mathml, highlight, numbered, deps, validate bool
+	var tocDepth int
+	var css, head, bibCache, footnotes, serveAddr, depsFile, root string
+	var opts mmark.Options
 
 	flag.BoolVar(&page, "page", false, "generate a standalone HTML page")
 	flag.BoolVar(&xml, "xml", false, "generate xml2rfc v3 output")
 	flag.BoolVar(&xml2, "xml2", false, "generate xml2rfc v2 output")
+	flag.BoolVar(&text, "text", false, "generate RFC 7994 style plain text output")
 	flag.BoolVar(&version, "version", false, "show mmark version")
 	flag.StringVar(&css, "css", "", "link to a CSS stylesheet (implies -page)")
 	flag.StringVar(&head, "head", "", "link to HTML to be included in head (implies -page)")
+	flag.BoolVar(&toc, "toc", false, "generate a table of contents (HTML only)")
+	flag.IntVar(&tocDepth, "toc-depth", 3, "maximum header level in the table of contents (0 for all)")
+	flag.BoolVar(&mathml, "mathml", false, "render math as MathML (HTML only)")
+	flag.BoolVar(&numbered, "numbered", false, "number sections, figures and tables and use the numbers in cross references (HTML only)")
+	flag.BoolVar(&highlight, "highlight", false, "highlight the syntax of code blocks (HTML only)")
+	flag.StringVar(&serveAddr, "serve", "", "serve a live HTML preview on this address, e.g. :8080 (localhost only, unless a host is given)")
 
-	flag.StringVar(&mmark.CitationsID, "bib-id", mmark.CitationsID, "ID bibliography URL")
-	flag.StringVar(&mmark.CitationsRFC, "bib-rfc", mmark.CitationsRFC, "RFC bibliography URL")
+	flag.StringVar(&opts.CitationsID, "bib-id", mmark.CitationsID, "ID bibliography URL")
+	flag.StringVar(&opts.CitationsRFC, "bib-rfc", mmark.CitationsRFC, "RFC bibliography URL")
+	flag.StringVar(&root, "root", "", "directory included files must be in, defaults to the directory of the input file")
+	flag.Var((*stringList)(&opts.IncludePaths), "I", "directory to search for included files, must be in -root, may be repeated")
+	flag.StringVar(&bibCache, "bib-cache", "", "directory with bibxml files used to resolve citations")
 
+	flag.BoolVar(&imprt, "import", false, "input file is xml2rfc v2 or v3 XML which is converted to mmark markdown")
 	flag.BoolVar(&toml, "toml", false, "input file is xml2rfc XML which is convert to TOML titleblock")
 	flag.BoolVar(&rfc7328, "rfc7328", false, "parse RFC 7328 style input")
+	flag.BoolVar(&werror, "Werror", false, "treat warnings as errors")
+	flag.BoolVar(&source, "source", false, "annotate the output with the source position of each block")
+	flag.BoolVar(&opts.Strict, "strict", false, "treat references to unknown anchors and duplicate anchors as errors")
+	flag.BoolVar(&opts.Lint, "lint", false, "check the document for problems the IETF submission tool rejects and the use of BCP 14 keywords")
+	flag.BoolVar(&validate, "validate", false, "validate the xml2rfc v3 output against its schema (with -xml)")
+	flag.BoolVar(&deps, "M", false, "output a make rule with the files the output depends on, instead of the output")
+	flag.StringVar(&depsFile, "MF", "", "also write a make rule with the files the output depends on to this file")
+	flag.StringVar(&footnotes, "footnotes", "section", "how footnotes are rendered in XML: section, cref or inline")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Mmark Markdown Processor"+
@@ -57,7 +80,7 @@ func main() {
 	}
 	flag.Parse()
 
-	if version{
+	if version {
 		if githash != "" {
 			githash = "+" + githash
 		}
@@ -73,9 +96,16 @@ func main() {
 		page = true
 	}
 
+	switch footnotes {
+	case "section", "cref", "inline":
+	default:
+		log.Fatalf("unknown footnote strategy: %s", footnotes)
+	}
+
 	// read the input
 	var input []byte
 	var err error
+	filename := "<stdin>"
 	args := flag.Args()
 	switch len(args) {
 	case 0:
@@ -86,6 +116,7 @@ func main() {
 		if input, err = ioutil.ReadFile(args[0]); err != nil {
 			log.Fatalf("error reading from %s: %s", args[0], err)
 		}
+		filename = args[0]
 	default:
 		flag.Usage()
 		return
@@ -97,6 +128,25 @@ func main() {
 		return
 	}
 
+	// separate mode for converting XML to markdown
+	if imprt {
+		output, err := mmark.ImportXML(input)
+		if err != nil {
+			log.Fatalf("error importing %s: %s", filename, err)
+		}
+		out := os.Stdout
+		if len(args) == 2 {
+			if out, err = os.Create(args[1]); err != nil {
+				log.Fatalf("error creating %s: %v", args[1], err)
+			}
+			defer out.Close()
+		}
+		if _, err = out.Write(output); err != nil {
+			log.Fatalf("error writing output: %v", err)
+		}
+		return
+	}
+
 	// set up options
 	extensions := 0
 	extensions |= mmark.EXTENSION_TABLES
@@ -115,9 +165,75 @@ func main() {
 	extensions |= mmark.EXTENSION_ABBREVIATIONS
 	extensions |= mmark.EXTENSION_DEFINITION_LISTS
 
+	if bibCache != "" {
+		opts.CitationsResolver = mmark.BibCache(bibCache)
+	}
+
 	if rfc7328 {
 		extensions |= mmark.EXTENSION_RFC7328
 	}
+	opts.Extensions = extensions
+	opts.Filename = filename
+
+	// included files are read from the root, the input file and the -I
+	// directories are named by their path in it
+	if root == "" {
+		root = filepath.Dir(filename)
+	}
+	opts.FileSource = mmark.RootFS(root)
+	if opts.Dir, err = inRoot(root, filepath.Dir(filename)); err != nil {
+		log.Fatalf("error: %s is not in the -root directory %s", filename, root)
+	}
+	for i, dir := range opts.IncludePaths {
+		if opts.IncludePaths[i], err = inRoot(root, dir); err != nil {
+			log.Fatalf("error: -I %s is not in the -root directory %s, use -root to include from it", dir, root)
+		}
+	}
+	// paths returns the names on disk of the dependencies of a result
+	paths := func(names []string) []string {
+		files := make([]string, len(names))
+		for i, name := range names {
+			files[i] = name
+			if name != head {
+				files[i] = filepath.Join(root, filepath.FromSlash(name))
+			}
+		}
+		return files
+	}
+
+	htmlRenderer := func(htmlFlags int) mmark.Renderer {
+		if page {
+			htmlFlags |= mmark.HTML_COMPLETE_PAGE
+		}
+		if source {
+			htmlFlags |= mmark.HTML_DATA_SOURCE
+		}
+		if toc {
+			htmlFlags |= mmark.HTML_TOC
+		}
+		if mathml {
+			htmlFlags |= mmark.HTML_MATHML
+		}
+		if highlight {
+			htmlFlags |= mmark.HTML_HIGHLIGHT
+		}
+		if numbered {
+			htmlFlags |= mmark.HTML_NUMBERED
+		}
+		params := mmark.HtmlRendererParameters{TocDepth: tocDepth}
+		return mmark.HtmlRendererWithParameters(htmlFlags, css, head, params)
+	}
+
+	// separate mode for the live preview, which is always a complete HTML page
+	if serveAddr != "" {
+		if len(args) != 1 {
+			log.Fatalf("-serve needs an input file")
+		}
+		render := func(input []byte) *mmark.Result {
+			return mmark.ParseWithOptions(input, htmlRenderer(mmark.HTML_COMPLETE_PAGE), opts)
+		}
+		log.Fatal(serve(serveAddr, filename, render, paths))
+	}
 
 	var renderer mmark.Renderer
 	xmlFlags := 0
@@ -126,23 +242,103 @@ func main() {
 		if page {
 			xmlFlags = mmark.XML_STANDALONE
 		}
+		if source {
+			xmlFlags |= mmark.XML_SOURCE_COMMENTS
+		}
+		switch footnotes {
+		case "cref":
+			xmlFlags |= mmark.XML_FOOTNOTES_CREF
+		case "inline":
+			xmlFlags |= mmark.XML_FOOTNOTES_INLINE
+		}
 		renderer = mmark.XmlRenderer(xmlFlags)
 	case xml2:
 		if page {
 			xmlFlags = mmark.XML2_STANDALONE
 		}
+		if source {
+			xmlFlags |= mmark.XML2_SOURCE_COMMENTS
+		}
+		switch footnotes {
+		case "cref":
+			xmlFlags |= mmark.XML2_FOOTNOTES_CREF
+		case "inline":
+			xmlFlags |= mmark.XML2_FOOTNOTES_INLINE
+		}
 		renderer = mmark.Xml2Renderer(xmlFlags)
-	default:
-		// render the data into HTML
-		htmlFlags := 0
+	case text:
+		textFlags := 0
 		if page {
-			htmlFlags |= mmark.HTML_COMPLETE_PAGE
+			textFlags = mmark.TEXT_STANDALONE
 		}
-		renderer = mmark.HtmlRenderer(htmlFlags, css, head)
+		renderer = mmark.TextRenderer(textFlags)
+	default:
+		// render the data into HTML
+		renderer = htmlRenderer(0)
 	}
 
 	// parse and render
-	output := mmark.Parse(input, renderer, extensions).Bytes()
+	result := mmark.ParseWithOptions(input, renderer, opts)
+	output := result.Output.Bytes()
+
+	// validate a standalone copy of the output, with source comments to point
+	// to the lines in the input
+	diagnostics := result.Diagnostics
+	if validate {
+		if !xml {
+			log.Fatalf("-validate needs -xml")
+		}
+		flags := xmlFlags | mmark.XML_STANDALONE | mmark.XML_SOURCE_COMMENTS
+		doc := mmark.ParseWithOptions(input, mmark.XmlRenderer(flags), opts).Output.Bytes()
+		diagnostics = append(diagnostics, mmark.XmlSchema().Validate(doc, filename)...)
+	}
+
+	failed := false
+	for _, d := range diagnostics {
+		fmt.Fprintln(os.Stderr, d.String())
+		switch d.Severity {
+		case mmark.SEVERITY_ERROR:
+			failed = true
+		case mmark.SEVERITY_WARNING:
+			failed = failed || werror
+		}
+	}
+
+	// output the dependencies
+	if deps || depsFile != "" {
+		target := ""
+		switch {
+		case len(args) == 2:
+			target = args[1]
+		case len(args) == 1:
+			ext := ".html"
+			switch {
+			case xml, xml2:
+				ext = ".xml"
+			case text:
+				ext = ".txt"
+			}
+			target = strings.TrimSuffix(args[0], filepath.Ext(args[0])) + ext
+		default:
+			log.Fatalf("-M and -MF need an input or output file")
+		}
+		prereqs := paths(result.Dependencies)
+		if len(args) > 0 {
+			prereqs = append([]string{args[0]}, prereqs...)
+		}
+		rule := makeRule(target, prereqs)
+		if depsFile != "" {
+			if err = ioutil.WriteFile(depsFile, []byte(rule), 0644); err != nil {
+				log.Fatalf("error writing %s: %v", depsFile, err)
+			}
+		}
+		if deps {
+			if _, err = os.Stdout.WriteString(rule); err != nil {
+				log.Fatalf("error writing output: %v", err)
+			}
+			return
+		}
+	}
 
 	// output the result
 	out := os.Stdout
@@ -154,6 +350,50 @@ func main() {
 	}
 
 	if _, err = out.Write(output); err != nil {
-		log.Fatalf("error writing output:", err)
+		log.Fatalf("error writing output: %v", err)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// inRoot returns dir as a slash separated path relative to root, or an error if
+// it is not in root.
+func inRoot(root, dir string) (string, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(absRoot, absDir)
+	if err != nil {
+		return "", err
 	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not in %s", dir, root)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// makeRule returns a make rule that says target depends on prereqs.
+func makeRule(target string, prereqs []string) string {
+	escape := strings.NewReplacer(" ", "\\ ", "#", "\\#", "$", "$$")
+	rule := escape.Replace(target) + ":"
+	for _, p := range prereqs {
+		rule += " \\\n " + escape.Replace(p)
+	}
+	return rule + "\n"
+}
+
+// stringList is a flag that can be given more than once.
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ", ") }
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
 }
@@ -0,0 +1,188 @@
+package main
+
+// Live preview: serve the HTML rendering of a document and rebuild it when the
+// document or one of the files it includes changes.
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/mmark"
+)
+
+// pollInterval is how often the files of the document are checked for changes.
+const pollInterval = 500 * time.Millisecond
+
+// reloadScript makes the browser reload the page when the server sends a reload
+// event.
+const reloadScript = `<script>
+new EventSource("/events").addEventListener("reload", function() { location.reload(); });
+</script>
+`
+
+type server struct {
+	filename string
+	render   func(input []byte) *mmark.Result
+	paths    func(names []string) []string // names on disk of the files in a result
+
+	mu      sync.Mutex
+	page    []byte
+	files   map[string]time.Time // the document and its includes, with their modification time
+	clients map[chan struct{}]bool
+}
+
+// serve renders filename and serves it on addr, until the server fails. An
+// address without a host, like ":8080", is only served on localhost.
+func serve(addr, filename string, render func(input []byte) *mmark.Result, paths func([]string) []string) error {
+	s := &server{filename: filename, render: render, paths: paths, clients: make(map[chan struct{}]bool)}
+	s.build()
+	go s.watch()
+
+	if host, port, err := net.SplitHostPort(addr); err == nil && host == "" {
+		addr = net.JoinHostPort("localhost", port)
+	}
+	http.HandleFunc("/", s.servePage)
+	http.HandleFunc("/events", s.serveEvents)
+	log.Printf("serving %s on %s", filename, addr)
+	return http.ListenAndServe(addr, nil)
+}
+
+// build renders the document and records the files it was made from.
+func (s *server) build() {
+	files := map[string]time.Time{s.filename: modTime(s.filename)}
+	input, err := ioutil.ReadFile(s.filename)
+	if err != nil {
+		log.Printf("error reading from %s: %s", s.filename, err)
+		s.mu.Lock()
+		s.files = files
+		s.mu.Unlock()
+		return
+	}
+
+	result := s.render(input)
+	for _, d := range result.Diagnostics {
+		fmt.Fprintln(os.Stderr, d.String())
+	}
+	// a missing include that is created changes the output as well
+	for _, d := range s.paths(append(result.Dependencies, result.Missing...)) {
+		files[d] = modTime(d)
+	}
+	page := result.Output.Bytes()
+	if i := bytes.LastIndex(page, []byte("</body>")); i >= 0 {
+		page = append(page[:i:i], append([]byte(reloadScript), page[i:]...)...)
+	} else {
+		page = append(page, reloadScript...)
+	}
+
+	s.mu.Lock()
+	s.page = page
+	s.files = files
+	s.mu.Unlock()
+}
+
+// watch rebuilds the document when one of its files changes and tells the
+// clients to reload.
+func (s *server) watch() {
+	for range time.Tick(pollInterval) {
+		if !s.changed() {
+			continue
+		}
+		s.build()
+		log.Printf("rebuilt %s", s.filename)
+
+		s.mu.Lock()
+		for c := range s.clients {
+			select {
+			case c <- struct{}{}:
+			default:
+				// a reload is already pending
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// changed returns true if one of the files of the document was modified,
+// created or removed since the last build.
+func (s *server) changed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for f, t := range s.files {
+		if !modTime(f).Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// modTime returns the modification time of file, or the zero time if it
+// doesn't exist.
+func modTime(file string) time.Time {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+func (s *server) servePage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		// files referenced by the document, like images and style sheets,
+		// from the directory of the document, without the hidden ones
+		for _, elem := range strings.Split(r.URL.Path, "/") {
+			if strings.HasPrefix(elem, ".") {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		http.FileServer(http.Dir(filepath.Dir(s.filename))).ServeHTTP(w, r)
+		return
+	}
+	s.mu.Lock()
+	page := s.page
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page)
+}
+
+// serveEvents sends a reload event to the client every time the document is
+// rebuilt.
+func (s *server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+
+	c := make(chan struct{}, 1)
+	s.mu.Lock()
+	s.clients[c] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-c:
+			fmt.Fprint(w, "event: reload\ndata: \n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,235 @@
+// Node implements a typed document tree.
+
+package mmark
+
+// NodeType identifies the kind of element a Node represents. There is one node
+// type for every callback in the Renderer interface.
+type NodeType int
+
+const (
+	NODE_DOCUMENT NodeType = iota
+
+	// block-level nodes
+	NODE_BLOCK_CODE
+	NODE_BLOCK_QUOTE
+	NODE_BLOCK_HTML
+	NODE_COMMENT_HTML
+	NODE_SPECIAL_HEADER
+	NODE_NOTE
+	NODE_PART
+	NODE_HEADER
+	NODE_HRULE
+	NODE_LIST
+	NODE_LIST_ITEM
+	NODE_PARAGRAPH
+	NODE_TABLE
+	NODE_TABLE_HEAD
+	NODE_TABLE_BODY
+	NODE_TABLE_FOOT
+	NODE_TABLE_ROW
+	NODE_TABLE_HEADER_CELL
+	NODE_TABLE_CELL
+	NODE_FOOTNOTES
+	NODE_FOOTNOTE_ITEM
+	NODE_TITLE_BLOCK
+	NODE_ASIDE
+	NODE_FIGURE
+	NODE_CAPTION
+	NODE_DOCUMENT_MATTER
+	NODE_REFERENCES
+
+	// span-level nodes
+	NODE_AUTO_LINK
+	NODE_CODE_SPAN
+	NODE_CALLOUT_TEXT
+	NODE_CALLOUT_CODE
+	NODE_DOUBLE_EMPHASIS
+	NODE_EMPHASIS
+	NODE_SUBSCRIPT
+	NODE_SUPERSCRIPT
+	NODE_IMAGE
+	NODE_LINE_BREAK
+	NODE_LINK
+	NODE_RAW_HTML_TAG
+	NODE_TRIPLE_EMPHASIS
+	NODE_STRIKETHROUGH
+	NODE_FOOTNOTE_REF
+	NODE_INDEX
+	NODE_CITATION
+	NODE_ABBREVIATION
+	NODE_EXAMPLE
+	NODE_MATH
+	NODE_ENTITY
+	NODE_TEXT
+)
+
+var nodeTypeNames = []string{
+	NODE_DOCUMENT:          "Document",
+	NODE_BLOCK_CODE:        "BlockCode",
+	NODE_BLOCK_QUOTE:       "BlockQuote",
+	NODE_BLOCK_HTML:        "BlockHtml",
+	NODE_COMMENT_HTML:      "CommentHtml",
+	NODE_SPECIAL_HEADER:    "SpecialHeader",
+	NODE_NOTE:              "Note",
+	NODE_PART:              "Part",
+	NODE_HEADER:            "Header",
+	NODE_HRULE:             "HRule",
+	NODE_LIST:              "List",
+	NODE_LIST_ITEM:         "ListItem",
+	NODE_PARAGRAPH:         "Paragraph",
+	NODE_TABLE:             "Table",
+	NODE_TABLE_HEAD:        "TableHead",
+	NODE_TABLE_BODY:        "TableBody",
+	NODE_TABLE_FOOT:        "TableFoot",
+	NODE_TABLE_ROW:         "TableRow",
+	NODE_TABLE_HEADER_CELL: "TableHeaderCell",
+	NODE_TABLE_CELL:        "TableCell",
+	NODE_FOOTNOTES:         "Footnotes",
+	NODE_FOOTNOTE_ITEM:     "FootnoteItem",
+	NODE_TITLE_BLOCK:       "TitleBlock",
+	NODE_ASIDE:             "Aside",
+	NODE_FIGURE:            "Figure",
+	NODE_CAPTION:           "Caption",
+	NODE_DOCUMENT_MATTER:   "DocumentMatter",
+	NODE_REFERENCES:        "References",
+	NODE_AUTO_LINK:         "AutoLink",
+	NODE_CODE_SPAN:         "CodeSpan",
+	NODE_CALLOUT_TEXT:      "CalloutText",
+	NODE_CALLOUT_CODE:      "CalloutCode",
+	NODE_DOUBLE_EMPHASIS:   "DoubleEmphasis",
+	NODE_EMPHASIS:          "Emphasis",
+	NODE_SUBSCRIPT:         "Subscript",
+	NODE_SUPERSCRIPT:       "Superscript",
+	NODE_IMAGE:             "Image",
+	NODE_LINE_BREAK:        "LineBreak",
+	NODE_LINK:              "Link",
+	NODE_RAW_HTML_TAG:      "RawHtmlTag",
+	NODE_TRIPLE_EMPHASIS:   "TripleEmphasis",
+	NODE_STRIKETHROUGH:     "StrikeThrough",
+	NODE_FOOTNOTE_REF:      "FootnoteRef",
+	NODE_INDEX:             "Index",
+	NODE_CITATION:          "Citation",
+	NODE_ABBREVIATION:      "Abbreviation",
+	NODE_EXAMPLE:           "Example",
+	NODE_MATH:              "Math",
+	NODE_ENTITY:            "Entity",
+	NODE_TEXT:              "Text",
+}
+
+func (t NodeType) String() string {
+	if int(t) < 0 || int(t) >= len(nodeTypeNames) {
+		return "Unknown"
+	}
+	return nodeTypeNames[t]
+}
+
+// Node is a single element in the document tree. Which fields are set depends on
+// the Type of the node, they mirror the arguments of the matching Renderer callback:
+//
+//	Document        Children
+//	BlockCode       Children (Text and CalloutCode), Lang, Caption, Subfigure, Callouts
+//	BlockQuote      Children, Caption (the attribution)
+//	BlockHtml       Literal
+//	CommentHtml     Literal
+//	SpecialHeader   Literal (abstract or preface), Children, ID
+//	Note            Children, ID
+//	Part            Children, ID
+//	Header          Children, Level, ID
+//	List            Children, Flags, Start, Group
+//	ListItem        Children, Flags
+//	Paragraph       Children, Flags
+//	Table           Children (TableHead, TableBody and TableFoot), Columns, Caption
+//	TableHeaderCell Children, Flags, Colspan
+//	TableCell       Children, Flags, Colspan
+//	FootnoteItem    Literal (the name), Children, Flags
+//	TitleBlock      TitleBlock
+//	Figure          Children, Caption
+//	DocumentMatter  Matter
+//	References      Citations
+//	AutoLink        Link, Flags (the link type)
+//	CodeSpan        Literal
+//	CalloutText     ID, CalloutIDs
+//	CalloutCode     ID, CalloutIndex
+//	Image           Link, Caption (the title), Literal (the alt text), Subfigure
+//	Link            Link, Title, Children
+//	RawHtmlTag      Literal
+//	FootnoteRef     Link, Start (the footnote number)
+//	Index           Index
+//	Citation        Link, Title
+//	Abbreviation    Literal, Title
+//	Example         Start (the example number)
+//	Math            Literal, Display
+//	Entity          Literal
+//	Text            Literal
+//
+// Attr holds the inline attribute list of block elements that can carry one. File
+// and Line hold the source position of block-level nodes, when known.
+type Node struct {
+	Type     NodeType
+	Parent   *Node
+	Children []*Node
+
+	Literal []byte
+	Link    []byte
+	Title   []byte
+	Group   []byte
+	Caption *Node
+	ID      string
+	Lang    string
+	Level   int
+	Flags   int
+	Start   int
+	Colspan int
+	Matter  int
+	Columns []int
+
+	CalloutIndex string
+	CalloutIDs   []string
+
+	Subfigure bool
+	Callouts  bool
+	Display   bool
+
+	Index      *IndexEntry
+	TitleBlock *Title
+	Citations  map[string]*Citation
+	Attr       *Attributes
+
+	File string
+	Line int
+}
+
+// Walk traverses the tree rooted at n in document order. Visit is called when a
+// node is entered and again when it is left. If visit returns false on entering
+// a node, its children and caption are skipped.
+func Walk(n *Node, visit func(n *Node, entering bool) bool) {
+	if n == nil {
+		return
+	}
+	if visit(n, true) {
+		for _, c := range n.Children {
+			Walk(c, visit)
+		}
+		Walk(n.Caption, visit)
+	}
+	visit(n, false)
+}
+
+// Text returns the concatenated text of all Text, Entity, CodeSpan and Math nodes
+// below n.
+func (n *Node) Text() []byte {
+	var text []byte
+	Walk(n, func(c *Node, entering bool) bool {
+		if !entering {
+			return true
+		}
+		switch c.Type {
+		case NODE_TEXT, NODE_ENTITY, NODE_CODE_SPAN, NODE_MATH:
+			text = append(text, c.Literal...)
+		case NODE_CAPTION:
+			return c == n
+		}
+		return true
+	})
+	return text
+}
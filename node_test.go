@@ -0,0 +1,95 @@
+// Unit tests for the document tree
+
+package mmark
+
+import (
+	"bytes"
+	"testing"
+	"testing/fstest"
+)
+
+var treeTests = []string{
+	"# Header {#hdr}\n\nSome *emphasis*, **strong** and `code` text.\n",
+	"%%%\ntitle = \"Tree\"\n[[author]]\ninitials=\"M.\"\nsurname=\"Gieben\"\n%%%\n\n.# Abstract\n\nThis is the abstract.\n\n{mainmatter}\n\n# Introduction\n\nWe cite [@RFC2119] and [@!RFC8174].\n",
+	"* one\n* two\n    1. nested\n    2. list\n\nA paragraph with a footnote[^1].\n\n[^1]: The note.\n",
+	"Name    | Age\n--------|-----:\nBob     | 27\nAlice   | 23\nTable: People\n",
+	"{#fig-code callout=\"true\"}\n```go\nfunc main() { <1>\n}\n```\nFigure: Code\n\nAs shown in <1>.\n",
+	"> A quote\n\nQuote: Someone\n\nA> An aside.\n\n(((Cats, Tiger)))\n\nA [link](http://example.org \"title\") and ![image](img.png \"Picture\").\n",
+	"Math $$x^2$$ inline.\n\n$$ y = x $$\n\nH~2~O and 2^10^ and ~~strike~~ &amp; <span>html</span>.\n",
+	"-# Part\n\n# Chapter\n\n(@good) An example.\n\nSee (@good) and (#chapter).\n\n{backmatter}\n\n# Appendix\n",
+}
+
+func TestTreeRender(t *testing.T) {
+	ext := extensions | EXTENSION_MATH | EXTENSION_EXAMPLE_LISTS | EXTENSION_AUTO_HEADER_IDS | EXTENSION_UNIQUE_HEADER_IDS
+	for _, input := range treeTests {
+		for name, renderer := range map[string]func() Renderer{
+			"html": func() Renderer { return HtmlRenderer(HTML_COMPLETE_PAGE|HTML_FOOTNOTE_RETURN_LINKS, "", "") },
+			"xml":  func() Renderer { return XmlRenderer(XML_STANDALONE) },
+			"xml2": func() Renderer { return Xml2Renderer(XML2_STANDALONE) },
+		} {
+			expected := Parse([]byte(input), renderer(), ext).String()
+			actual := Render(ParseTree([]byte(input), ext), renderer()).String()
+			if actual != expected {
+				t.Errorf("\n%s: Input [%#v]\nExpected[%#v]\nActual  [%#v]", name, input, expected, actual)
+			}
+		}
+	}
+}
+
+func TestTreeNodes(t *testing.T) {
+	doc := ParseTree([]byte("# Header {#hdr}\n\nSome *emphasis* [@RFC2119].\n"), extensions)
+
+	var types []NodeType
+	Walk(doc, func(n *Node, entering bool) bool {
+		if entering {
+			types = append(types, n.Type)
+		}
+		return true
+	})
+	expected := []NodeType{NODE_DOCUMENT, NODE_HEADER, NODE_TEXT, NODE_PARAGRAPH, NODE_TEXT, NODE_EMPHASIS, NODE_TEXT,
+		NODE_TEXT, NODE_CITATION, NODE_TEXT, NODE_DOCUMENT_MATTER, NODE_REFERENCES}
+	if len(types) != len(expected) {
+		t.Fatalf("expected nodes %v, got %v", expected, types)
+	}
+	for i := range types {
+		if types[i] != expected[i] {
+			t.Fatalf("expected nodes %v, got %v", expected, types)
+		}
+	}
+
+	h := doc.Children[0]
+	if h.ID != "hdr" || h.Level != 1 || string(h.Text()) != "Header" {
+		t.Errorf("unexpected header: id %q, level %d, text %q", h.ID, h.Level, h.Text())
+	}
+	if c := doc.Children[1].Children[3]; string(c.Link) != "RFC2119" || c.Parent != doc.Children[1] {
+		t.Errorf("unexpected citation: %q", c.Link)
+	}
+}
+
+func TestTreeTransform(t *testing.T) {
+	doc := ParseTree([]byte("Some *emphasis*.\n"), 0)
+	Walk(doc, func(n *Node, entering bool) bool {
+		if entering && n.Type == NODE_EMPHASIS {
+			n.Type = NODE_DOUBLE_EMPHASIS
+		}
+		return true
+	})
+	out := Render(doc, HtmlRenderer(0, "", ""))
+	if !bytes.Equal(out.Bytes(), []byte("<p>Some <strong>emphasis</strong>.</p>\n")) {
+		t.Errorf("unexpected output: %q", out.Bytes())
+	}
+}
+
+func TestTreeOptions(t *testing.T) {
+	files := fstest.MapFS{"chapter.md": {Data: []byte("Included, see (#nope).\n")}}
+	opts := Options{Extensions: extensions | EXTENSION_INCLUDE, Filename: "doc.md", FileSource: files}
+	doc, diags := ParseTreeWithOptions([]byte("# Header\n\n{{chapter.md}}\n"), opts)
+
+	p := doc.Children[1]
+	if p.Type != NODE_PARAGRAPH || p.File != "chapter.md" || p.Line != 1 {
+		t.Errorf("expected a paragraph at chapter.md:1, got %v at %s:%d", p.Type, p.File, p.Line)
+	}
+	if len(diags) != 1 || diags[0].String() != "chapter.md:1:15: reference to unknown anchor `nope'" {
+		t.Errorf("unexpected diagnostics: %v", diags)
+	}
+}
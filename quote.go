@@ -48,6 +48,7 @@ func (p *parser) aside(out *bytes.Buffer, data []byte) int {
 	p.r.SetAttr(p.ial)
 	p.ial = nil
 
+	p.source(data) // the contents are rendered, back to the aside itself
 	p.r.Aside(out, cooked.Bytes())
 	return end
 }
@@ -141,6 +142,7 @@ func (p *parser) quote(out *bytes.Buffer, data []byte) int {
 
 	p.r.SetAttr(ials)
 
+	p.source(data)
 	p.r.BlockQuote(out, cooked.Bytes(), attribution.Bytes())
 	return j
 }
@@ -215,6 +217,7 @@ func (p *parser) figure(out *bytes.Buffer, data []byte) int {
 	p.r.SetAttr(p.ial)
 	p.ial = nil
 
+	p.source(data)
 	p.r.Figure(out, cooked.Bytes(), caption.Bytes())
 	return j
 }
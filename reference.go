@@ -0,0 +1,106 @@
+// Resolving citations to reference XML.
+
+package mmark
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ReferenceResolver looks up the <reference> XML of citations that have none in
+// the document itself.
+type ReferenceResolver interface {
+	// Resolve returns the reference XML for the citation with the given
+	// anchor, e.g. "RFC2119" or "I-D.ietf-dane-openpgpkey", and draft sequence
+	// number, -1 if none was given. A nil slice and nil error means the
+	// reference is unknown.
+	Resolve(anchor string, seq int) ([]byte, error)
+}
+
+// bibCache resolves references from a local directory of bibxml files.
+type bibCache struct {
+	dir string
+}
+
+// BibCache returns a ReferenceResolver that reads references from dir. The
+// files have the same names as on the bibxml servers, e.g.
+// reference.RFC.2119.xml, and are either stored in dir itself or in a
+// subdirectory named after the server's directory, e.g. bibxml3 for I-Ds. It
+// never uses the network.
+func BibCache(dir string) ReferenceResolver {
+	return &bibCache{dir: dir}
+}
+
+func (b *bibCache) Resolve(anchor string, seq int) ([]byte, error) {
+	url := referenceFile(nil, &Citation{link: []byte(anchor), seq: seq})
+	if url == "" {
+		return nil, nil
+	}
+	name := path.Base(url)
+	for _, f := range []string{
+		filepath.Join(b.dir, name),
+		filepath.Join(b.dir, bibDir(anchor), name),
+	} {
+		data, err := ioutil.ReadFile(f)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return stripXMLDeclaration(data), nil
+	}
+	return nil, nil
+}
+
+// bibDir returns the directory the bibxml servers keep the reference of anchor
+// in. It depends on the kind of reference, not on the configured URLs.
+func bibDir(anchor string) string {
+	switch {
+	case strings.HasPrefix(anchor, "RFC"):
+		return "bibxml"
+	case strings.HasPrefix(anchor, "I-D"):
+		return "bibxml3"
+	case strings.HasPrefix(anchor, "W3C"):
+		return "bibxml4"
+	}
+	return "bibxml2"
+}
+
+// stripXMLDeclaration removes the XML declaration and surrounding white space
+// from data, so it can be included in another document.
+func stripXMLDeclaration(data []byte) []byte {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("<?xml ")) {
+		if i := bytes.Index(data, []byte("?>")); i > 0 {
+			data = bytes.TrimSpace(data[i+2:])
+		}
+	}
+	return data
+}
+
+// resolveCitations fills in the reference XML of the citations that don't have
+// any, using the CitationsResolver of the options.
+func (p *parser) resolveCitations() {
+	resolver := p.opts.CitationsResolver
+	if resolver == nil {
+		return
+	}
+	for anchor, c := range p.citations {
+		if c.xml != nil || len(c.link) == 0 {
+			continue
+		}
+		data, err := resolver.Resolve(string(c.link), c.seq)
+		if err != nil {
+			warnf(p, "reference", "failed to resolve reference: `%s': %s", anchor, err)
+			continue
+		}
+		if data != nil {
+			c.xml = data
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Unit tests for the reference resolver
+
+package mmark
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBibCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mmark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rfc := `<?xml version='1.0' encoding='UTF-8'?>
+<reference anchor="RFC2119" target="https://www.rfc-editor.org/info/rfc2119">
+<front>
+<title>Key words for use in RFCs to Indicate Requirement Levels</title>
+<author initials="S." surname="Bradner" fullname="S. Bradner"/>
+<date year="1997" month="March"/>
+</front>
+</reference>
+`
+	id := `<reference anchor="I-D.ietf-dane-openpgpkey"><front><title>OPENPGPKEY</title></front></reference>`
+	if err := ioutil.WriteFile(filepath.Join(dir, "reference.RFC.2119.xml"), []byte(rfc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "bibxml3"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "bibxml3", "reference.I-D.ietf-dane-openpgpkey.xml"), []byte(id), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Options{Extensions: EXTENSION_CITATION, CitationsResolver: BibCache(dir)}
+	input := "[@!RFC2119], [@?I-D.ietf-dane-openpgpkey] and [@?RFC1149].\n"
+	var tests = []struct {
+		renderer Renderer
+		expected []string
+	}{
+		{
+			XmlRenderer(XML_STANDALONE),
+			[]string{
+				"<name>Normative References</name>\n<reference anchor=\"RFC2119\"",
+				"</reference>\n</references>",
+				"<name>Informative References</name>\n" + id + "\n<xi:include href=\"http://xml2rfc.ietf.org/public/rfc/bibxml/reference.RFC.1149.xml\"/>\n",
+			},
+		},
+		{
+			Xml2Renderer(XML2_STANDALONE),
+			[]string{"<references title=\"Normative References\">\n<reference anchor=\"RFC2119\"", "<?rfc include=\"http://xml2rfc.ietf.org/public/rfc/bibxml/reference.RFC.1149.xml\"?>"},
+		},
+		{
+			HtmlRenderer(HTML_COMPLETE_PAGE, "", ""),
+			[]string{"<dt id=\"rfc2119\">[RFC2119]</dt>\n<dd><span class=\"bibliography-details\">Bradner, S., &quot;Key words for use in RFCs"},
+		},
+	}
+	for _, test := range tests {
+		out := ParseWithOptions([]byte(input), test.renderer, opts).Output.String()
+		if strings.Contains(out, "<?xml version='1.0'") {
+			t.Errorf("XML declaration included in output:\n%s", out)
+		}
+		for _, e := range test.expected {
+			if !strings.Contains(out, e) {
+				t.Errorf("expected %q in output:\n%s", e, out)
+			}
+		}
+	}
+
+	// the subdirectory does not depend on the URLs
+	defer func(url string) { CitationsID = url }(CitationsID)
+	CitationsID = "https://example.org/drafts/"
+	opts.CitationsID = CitationsID
+	if out := ParseWithOptions([]byte(input), XmlRenderer(XML_STANDALONE), opts).Output.String(); !strings.Contains(out, id) {
+		t.Errorf("expected %q in output:\n%s", id, out)
+	}
+}
+
+func TestReferencesHTML(t *testing.T) {
+	input := `[@!RFC5841] and [@Unicode] and [@!RFC1149].
+
+<reference anchor="RFC5841" target="https://www.rfc-editor.org/info/rfc5841">
+<front>
+<title>TCP Option to Denote Packet Mood</title>
+<author initials="R." surname="Hay" fullname="R. Hay"/>
+<author initials="W." surname="Turkal" fullname="W. Turkal"/>
+<date year="2010" month="April"/>
+</front>
+<seriesInfo name="RFC" value="5841"/>
+<seriesInfo name="DOI" value="10.17487/RFC5841"/>
+</reference>
+
+<reference anchor="Unicode">
+<front>
+<title>The Unicode Standard</title>
+<author><organization>The Unicode Consortium</organization></author>
+<author initials="A." surname="Editor" role="editor"/>
+<author fullname="Somebody Else"/>
+<date year="2017" month="6"/>
+</front>
+<format type="HTML" target="https://www.unicode.org/versions/Unicode10.0.0/"/>
+</reference>
+
+Text.
+`
+	expected := "<h2 id=\"normative-references\" class=\"appendix bibliography\">Normative References</h2>\n" +
+		"<dl class=\"bibliography\">\n" +
+		"<dt id=\"rfc1149\">[RFC1149]</dt>\n" +
+		"<dd><span class=\"bibliography-details\">RFC 1149, &lt;<a href=\"https://www.rfc-editor.org/info/rfc1149\">https://www.rfc-editor.org/info/rfc1149</a>&gt;.</span></dd>\n" +
+		"<dt id=\"rfc5841\">[RFC5841]</dt>\n" +
+		"<dd><span class=\"bibliography-details\">Hay, R. and W. Turkal, &quot;TCP Option to Denote Packet Mood&quot;, RFC 5841, DOI 10.17487/RFC5841, April 2010, " +
+		"&lt;<a href=\"https://www.rfc-editor.org/info/rfc5841\">https://www.rfc-editor.org/info/rfc5841</a>&gt;.</span></dd>\n" +
+		"</dl>\n\n" +
+		"<h2 id=\"informative-references\" class=\"appendix bibliography\">Informative References</h2>\n" +
+		"<dl class=\"bibliography\">\n" +
+		"<dt id=\"unicode\">[Unicode]</dt>\n" +
+		"<dd><span class=\"bibliography-details\">The Unicode Consortium, A. Editor, Ed., and Somebody Else, &quot;The Unicode Standard&quot;, June 2017, " +
+		"&lt;<a href=\"https://www.unicode.org/versions/Unicode10.0.0/\">https://www.unicode.org/versions/Unicode10.0.0/</a>&gt;.</span></dd>\n" +
+		"</dl>\n"
+
+	// the order must not depend on map iteration
+	for i := 0; i < 5; i++ {
+		out := Parse([]byte(input), HtmlRenderer(HTML_COMPLETE_PAGE, "", ""), EXTENSION_CITATION).String()
+		if !strings.Contains(out, expected) {
+			t.Fatalf("expected %q in output:\n%s", expected, out)
+		}
+	}
+}
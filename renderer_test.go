@@ -0,0 +1,187 @@
+// Unit tests for a renderer outside of the mmark package
+
+package mmark_test
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/miekg/mmark"
+)
+
+// outline is a minimal renderer that only writes the structure of the document.
+type outline struct {
+	ial *mmark.Attributes
+}
+
+func (o *outline) BlockCode(out *bytes.Buffer, text []byte, lang string, caption []byte, subfigure bool, callouts bool) {
+	fmt.Fprintf(out, "code %s\n", lang)
+}
+func (o *outline) BlockQuote(out *bytes.Buffer, text []byte, attribution []byte) { out.Write(text) }
+func (o *outline) BlockHtml(out *bytes.Buffer, text []byte)                      {}
+func (o *outline) CommentHtml(out *bytes.Buffer, text []byte)                    {}
+func (o *outline) SpecialHeader(out *bytes.Buffer, what []byte, text func() bool, id string) {
+	fmt.Fprintf(out, "special %s\n", what)
+}
+func (o *outline) Note(out *bytes.Buffer, text func() bool, id string) {}
+func (o *outline) Part(out *bytes.Buffer, text func() bool, id string) {}
+func (o *outline) Header(out *bytes.Buffer, text func() bool, level int, id string) {
+	if o.Attr().ID() != "" {
+		id = o.Attr().ID()
+	}
+	fmt.Fprintf(out, "header %d %s", level, id)
+	if o.Attr().Class("x") {
+		out.WriteString(" .x")
+	}
+	out.WriteString("\n")
+	o.SetAttr(nil)
+}
+func (o *outline) HRule(out *bytes.Buffer) {}
+func (o *outline) List(out *bytes.Buffer, text func() bool, flags, start int, group []byte) {
+	if flags&mmark.LIST_TYPE_ORDERED != 0 {
+		out.WriteString("ordered ")
+	}
+	out.WriteString("list\n")
+	text()
+}
+func (o *outline) ListItem(out *bytes.Buffer, text []byte, flags int) {
+	out.WriteString("item ")
+	out.Write(text)
+	out.WriteString("\n")
+}
+func (o *outline) Paragraph(out *bytes.Buffer, text func() bool, flags int) {
+	text()
+	out.WriteString("\n")
+}
+func (o *outline) Table(out *bytes.Buffer, header []byte, body []byte, footer []byte, columnData []int, caption []byte) {
+	out.WriteString("table")
+	for _, c := range columnData {
+		if c == mmark.TABLE_ALIGNMENT_RIGHT {
+			out.WriteString(" right")
+		}
+	}
+	out.WriteString("\n")
+}
+func (o *outline) TableRow(out *bytes.Buffer, text []byte)                            {}
+func (o *outline) TableHeaderCell(out *bytes.Buffer, text []byte, flags, colspan int) {}
+func (o *outline) TableCell(out *bytes.Buffer, text []byte, flags, colspan int)       {}
+func (o *outline) Footnotes(out *bytes.Buffer, text func() bool)                      {}
+func (o *outline) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int)       {}
+func (o *outline) Aside(out *bytes.Buffer, text []byte)                               {}
+func (o *outline) Figure(out *bytes.Buffer, text []byte, caption []byte)              {}
+func (o *outline) AutoLink(out *bytes.Buffer, link []byte, kind int)                  {}
+func (o *outline) CodeSpan(out *bytes.Buffer, text []byte)                            {}
+func (o *outline) CalloutText(out *bytes.Buffer, id string, ids []string)             {}
+func (o *outline) CalloutCode(out *bytes.Buffer, index, id string)                    {}
+func (o *outline) DoubleEmphasis(out *bytes.Buffer, text []byte)                      {}
+func (o *outline) Emphasis(out *bytes.Buffer, text []byte)                            {}
+func (o *outline) Subscript(out *bytes.Buffer, text []byte)                           {}
+func (o *outline) Superscript(out *bytes.Buffer, text []byte)                         {}
+func (o *outline) Image(out *bytes.Buffer, link, title, alt []byte, subfigure bool)   {}
+func (o *outline) LineBreak(out *bytes.Buffer)                                        {}
+func (o *outline) Link(out *bytes.Buffer, link, title, content []byte)                {}
+func (o *outline) RawHtmlTag(out *bytes.Buffer, tag []byte)                           {}
+func (o *outline) TripleEmphasis(out *bytes.Buffer, text []byte)                      {}
+func (o *outline) StrikeThrough(out *bytes.Buffer, text []byte)                       {}
+func (o *outline) FootnoteRef(out *bytes.Buffer, ref []byte, id int)                  {}
+func (o *outline) Index(out *bytes.Buffer, entry mmark.IndexEntry)                    {}
+func (o *outline) Citation(out *bytes.Buffer, link, title []byte)                     {}
+func (o *outline) Abbreviation(out *bytes.Buffer, abbr, title []byte)                 {}
+func (o *outline) Example(out *bytes.Buffer, index int)                               {}
+func (o *outline) Math(out *bytes.Buffer, text []byte, display bool)                  {}
+func (o *outline) Entity(out *bytes.Buffer, entity []byte)                            {}
+func (o *outline) NormalText(out *bytes.Buffer, text []byte)                          { out.Write(text) }
+func (o *outline) DocumentHeader(out *bytes.Buffer, start bool)                       {}
+func (o *outline) DocumentFooter(out *bytes.Buffer, start bool)                       {}
+func (o *outline) Flags() int                                                         { return 0 }
+func (o *outline) SetAttr(i *mmark.Attributes)                                        { o.ial = i }
+func (o *outline) AttrString(i *mmark.Attributes) string                              { return "" }
+
+func (o *outline) TitleBlockTOML(out *bytes.Buffer, data *mmark.Title) {
+	fmt.Fprintf(out, "title %s", data.Title)
+	for _, a := range data.Author {
+		fmt.Fprintf(out, " by %s", a.Surname)
+	}
+	out.WriteString("\n")
+}
+
+func (o *outline) DocumentMatter(out *bytes.Buffer, matter int) {
+	switch matter {
+	case mmark.DOC_MAIN_MATTER:
+		out.WriteString("main\n")
+	case mmark.DOC_BACK_MATTER:
+		out.WriteString("back\n")
+	}
+}
+
+func (o *outline) References(out *bytes.Buffer, citations map[string]*mmark.Citation) {
+	keys := []string{}
+	for k := range citations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		c := citations[k]
+		fmt.Fprintf(out, "ref %s %t\n", c.Link(), c.Normative())
+	}
+}
+
+func (o *outline) Attr() *mmark.Attributes {
+	if o.ial == nil {
+		return mmark.NewAttributes()
+	}
+	return o.ial
+}
+
+func TestRendererOutside(t *testing.T) {
+	input := `%%%
+title = "Outline"
+[[author]]
+surname = "Gieben"
+%%%
+
+.# Abstract
+
+Short.
+
+{mainmatter}
+
+{#intro .x}
+# Introduction
+
+See [@RFC2119] and [@!RFC8174].
+
+1. one
+2. two
+
+Name | Age
+-----|---:
+Bob  | 27
+
+{backmatter}
+
+# Appendix
+`
+	expected := `title Outline by Gieben
+special abstract
+Short.
+main
+header 1 intro .x
+See  and .
+ordered list
+item one
+item two
+table right
+back
+ref RFC2119 false
+ref RFC8174 true
+header 1 appendix
+`
+	out := mmark.Parse([]byte(input), &outline{}, mmark.EXTENSION_TABLES|mmark.EXTENSION_TITLEBLOCK_TOML|
+		mmark.EXTENSION_MATTER|mmark.EXTENSION_CITATION|mmark.EXTENSION_INLINE_ATTR|mmark.EXTENSION_AUTO_HEADER_IDS)
+	if out.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out)
+	}
+}
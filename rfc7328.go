@@ -41,12 +41,12 @@ func (p *parser) rfc7328Index(out *bytes.Buffer, text []byte) int {
 
 	subItemStart := i
 	if subItemStart != len(text) {
-		printf(p, "rfc 7328 style index parsed to: ((%s, %s))", string(text[1:itemEnd]), text[subItemStart:])
-		p.r.Index(out, text[1:itemEnd], text[subItemStart:], false)
+		infof(p, "rfc7328", "rfc 7328 style index parsed to: ((%s, %s))", string(text[1:itemEnd]), text[subItemStart:])
+		p.r.Index(out, IndexEntry{Primary: text[1:itemEnd], Secondary: text[subItemStart:]})
 		return len(text)
 	}
-	printf(p, "rfc 7328 style index parsed to: ((%s))", string(text[1:itemEnd]))
-	p.r.Index(out, text[1:itemEnd], nil, false)
+	infof(p, "rfc7328", "rfc 7328 style index parsed to: ((%s))", string(text[1:itemEnd]))
+	p.r.Index(out, IndexEntry{Primary: text[1:itemEnd]})
 	return len(text)
 }
 
@@ -82,10 +82,10 @@ func (p *parser) rfc7328Caption(out *bytes.Buffer, text []byte) int {
 	// For now just log that we have seen this line and return a positive integer
 	// indicating this wasn't a footnote.
 	if len(anchor) > 0 {
-		printf(p, "rfc 7328 style anchor seen: consider adding '{#%s}' IAL before the figure/table", string(anchor))
+		infof(p, "rfc7328", "rfc 7328 style anchor seen: consider adding '{#%s}' IAL before the figure/table", string(anchor))
 	}
 	if len(caption) > 0 {
-		printf(p, "rfc 7328 style caption seen: consider adding 'Figure: %s' or 'Table: %s' after the figure/table", string(caption), string(caption))
+		infof(p, "rfc7328", "rfc 7328 style caption seen: consider adding 'Figure: %s' or 'Table: %s' after the figure/table", string(caption), string(caption))
 	}
 	return len(text)
 }
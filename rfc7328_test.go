@@ -4,10 +4,6 @@ package mmark
 
 import "testing"
 
-func init() {
-	test = true
-}
-
 func runMarkdownBlockXML_rfc7328(input string, extensions int) string {
 	xmlFlags := 0
 
@@ -69,7 +65,7 @@ func TestConversionFromRFC7328(t *testing.T) {
 		"<t>\nAnd another one. An index <iref item=\"itemindex\" subitem=\"subitem\"/>\n</t>\n",
 
 		"Index ^[ ^indexer^   ]",
-		"<t>\nIndex <iref item=\"indexer\" subitem=\"\"/>\n</t>\n",
+		"<t>\nIndex <iref item=\"indexer\"/>\n</t>\n",
 	}
 	doTestsBlockXML_rfc7328(t, tests, 0)
 }
@@ -0,0 +1,693 @@
+// Parsing RELAX NG schemas in the compact syntax.
+
+package mmark
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// patternKind is the kind of a RELAX NG pattern.
+type patternKind int
+
+const (
+	patternNotAllowed patternKind = iota
+	patternEmpty
+	patternText
+	patternChoice
+	patternInterleave
+	patternGroup
+	patternOneOrMore
+	patternElement
+	patternAttribute
+	patternValue
+	patternData
+	patternAfter // only used while validating, see validate.go
+	patternRef   // only used while parsing
+)
+
+// pattern is a RELAX NG pattern. Once a schema has been parsed, the patterns
+// are interned: two patterns are the same when their pointers are equal.
+type pattern struct {
+	kind     patternKind
+	p1, p2   *pattern
+	name     nameClass // of an element or attribute
+	value    string    // of a value, the datatype of data or the name of a ref
+	element  *element  // of an element
+	nullable bool
+}
+
+// element holds the content of an element pattern, the content is set after
+// the pattern is made, so elements can refer to themselves.
+type element struct {
+	content *pattern
+}
+
+// nameClass is the name of an element or attribute, an empty local name matches
+// any name in space, and an any nameClass matches all names.
+type nameClass struct {
+	any          bool
+	space, local string
+}
+
+func (n nameClass) contains(space, local string) bool {
+	if n.any {
+		return true
+	}
+	return n.space == space && (n.local == "" || n.local == local)
+}
+
+func (n nameClass) String() string {
+	switch {
+	case n.any:
+		return "*"
+	case n.local == "":
+		return "{" + n.space + "}*"
+	case n.space == xmlURL:
+		return "xml:" + n.local
+	case n.space != "":
+		return "{" + n.space + "}" + n.local
+	}
+	return n.local
+}
+
+// xmlURL is the namespace of the xml prefix.
+const xmlURL = "http://www.w3.org/XML/1998/namespace"
+
+// patternKey identifies an interned pattern.
+type patternKey struct {
+	kind    patternKind
+	p1, p2  *pattern
+	name    nameClass
+	value   string
+	element *element
+}
+
+// patterns makes interned patterns and simplifies them along the way, like
+// notAllowed in a group making the group notAllowed.
+type patterns struct {
+	interned   map[patternKey]*pattern
+	notAllowed *pattern
+	empty      *pattern
+	text       *pattern
+}
+
+func newPatterns() *patterns {
+	ps := &patterns{interned: make(map[patternKey]*pattern)}
+	ps.notAllowed = ps.intern(patternKey{kind: patternNotAllowed})
+	ps.empty = ps.intern(patternKey{kind: patternEmpty})
+	ps.text = ps.intern(patternKey{kind: patternText})
+	return ps
+}
+
+func (ps *patterns) intern(k patternKey) *pattern {
+	if p, ok := ps.interned[k]; ok {
+		return p
+	}
+	p := &pattern{kind: k.kind, p1: k.p1, p2: k.p2, name: k.name, value: k.value, element: k.element}
+	switch k.kind {
+	case patternEmpty, patternText:
+		p.nullable = true
+	case patternChoice:
+		p.nullable = k.p1.nullable || k.p2.nullable
+	case patternGroup, patternInterleave:
+		p.nullable = k.p1.nullable && k.p2.nullable
+	case patternOneOrMore:
+		p.nullable = k.p1.nullable
+	}
+	ps.interned[k] = p
+	return p
+}
+
+func (ps *patterns) choice(p1, p2 *pattern) *pattern {
+	switch {
+	case p1 == p2 || p2.kind == patternNotAllowed:
+		return p1
+	case p1.kind == patternNotAllowed:
+		return p2
+	}
+	// don't repeat a choice that is already made
+	if p2.kind == patternChoice && (p2.p1 == p1 || p2.p2 == p1) {
+		return p2
+	}
+	if p1.kind == patternChoice && (p1.p1 == p2 || p1.p2 == p2) {
+		return p1
+	}
+	return ps.intern(patternKey{kind: patternChoice, p1: p1, p2: p2})
+}
+
+func (ps *patterns) group(p1, p2 *pattern) *pattern {
+	switch {
+	case p1.kind == patternNotAllowed || p2.kind == patternNotAllowed:
+		return ps.notAllowed
+	case p1.kind == patternEmpty:
+		return p2
+	case p2.kind == patternEmpty:
+		return p1
+	}
+	return ps.intern(patternKey{kind: patternGroup, p1: p1, p2: p2})
+}
+
+func (ps *patterns) interleave(p1, p2 *pattern) *pattern {
+	switch {
+	case p1.kind == patternNotAllowed || p2.kind == patternNotAllowed:
+		return ps.notAllowed
+	case p1.kind == patternEmpty:
+		return p2
+	case p2.kind == patternEmpty:
+		return p1
+	}
+	return ps.intern(patternKey{kind: patternInterleave, p1: p1, p2: p2})
+}
+
+func (ps *patterns) oneOrMore(p *pattern) *pattern {
+	if p.kind == patternNotAllowed || p.kind == patternEmpty {
+		return p
+	}
+	return ps.intern(patternKey{kind: patternOneOrMore, p1: p})
+}
+
+func (ps *patterns) after(p1, p2 *pattern) *pattern {
+	if p1.kind == patternNotAllowed || p2.kind == patternNotAllowed {
+		return ps.notAllowed
+	}
+	return ps.intern(patternKey{kind: patternAfter, p1: p1, p2: p2})
+}
+
+// Schema is a RELAX NG schema, see ParseSchema.
+type Schema struct {
+	mu    sync.Mutex
+	ps    *patterns
+	start *pattern
+}
+
+// ParseSchema parses a RELAX NG schema in the compact syntax, like the
+// xml2rfc v3 schema. Includes, external references, lists and name class
+// choices are not supported. Of the datatypes, only xsd:ID, xsd:IDREF and
+// xsd:NCName are checked, all others allow any value.
+func ParseSchema(data []byte) (*Schema, error) {
+	r := &rncParser{
+		lex:        &rncLexer{data: data, line: 1},
+		namespaces: map[string]string{"xml": xmlURL, "": ""},
+		defs:       make(map[string]*pattern),
+	}
+	if err := r.parse(); err != nil {
+		return nil, err
+	}
+	return r.resolve()
+}
+
+// rncLexer splits a schema in the compact syntax into tokens.
+type rncLexer struct {
+	data []byte
+	pos  int
+	line int
+}
+
+// rncToken is a token of the compact syntax, literals keep their quotes and
+// identifiers their backslash, so they can't be mistaken for keywords.
+type rncToken struct {
+	text string
+	line int
+}
+
+func (l *rncLexer) next() (rncToken, error) {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		switch {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+		case c == '#':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			return l.token()
+		}
+	}
+	return rncToken{line: l.line}, nil
+}
+
+func (l *rncLexer) token() (rncToken, error) {
+	start, c := l.pos, l.data[l.pos]
+	switch {
+	case c == '"' || c == '\'':
+		end := strings.IndexByte(string(l.data[start+1:]), c)
+		if end < 0 {
+			return rncToken{}, fmt.Errorf("line %d: unterminated literal", l.line)
+		}
+		l.pos = start + end + 2
+	case (c == '|' || c == '&') && l.pos+1 < len(l.data) && l.data[l.pos+1] == '=':
+		l.pos += 2
+	case strings.IndexByte("=|&{}()[],?*+~-", c) >= 0:
+		l.pos++
+	default:
+		if c == '\\' {
+			l.pos++
+		}
+		for l.pos < len(l.data) {
+			r, size := utf8.DecodeRune(l.data[l.pos:])
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && strings.IndexRune("_-.:", r) < 0 {
+				break
+			}
+			l.pos += size
+		}
+		// prefix:*
+		if l.pos < len(l.data) && l.data[l.pos] == '*' && l.data[l.pos-1] == ':' {
+			l.pos++
+		}
+		if l.pos == start || (c == '\\' && l.pos == start+1) {
+			return rncToken{}, fmt.Errorf("line %d: unexpected character %q", l.line, c)
+		}
+	}
+	return rncToken{text: string(l.data[start:l.pos]), line: l.line}, nil
+}
+
+// rncParser parses a schema in the compact syntax into definitions of raw
+// patterns, that still contain references.
+type rncParser struct {
+	lex        *rncLexer
+	tok        rncToken
+	peeked     bool
+	namespaces map[string]string
+	defs       map[string]*pattern
+	start      *pattern
+}
+
+func (r *rncParser) peek() (rncToken, error) {
+	if !r.peeked {
+		t, err := r.lex.next()
+		if err != nil {
+			return t, err
+		}
+		r.tok, r.peeked = t, true
+	}
+	return r.tok, nil
+}
+
+func (r *rncParser) next() (rncToken, error) {
+	t, err := r.peek()
+	r.peeked = false
+	return t, err
+}
+
+func (r *rncParser) expect(text string) error {
+	t, err := r.next()
+	if err != nil {
+		return err
+	}
+	if t.text != text {
+		return fmt.Errorf("line %d: expected %q, found %q", t.line, text, t.text)
+	}
+	return nil
+}
+
+func (r *rncParser) literal() (string, error) {
+	t, err := r.next()
+	if err != nil {
+		return "", err
+	}
+	if len(t.text) < 2 || (t.text[0] != '"' && t.text[0] != '\'') {
+		return "", fmt.Errorf("line %d: expected a literal, found %q", t.line, t.text)
+	}
+	return t.text[1 : len(t.text)-1], nil
+}
+
+// annotations skips the annotations in [ ] before a definition or pattern.
+func (r *rncParser) annotations() error {
+	for {
+		t, err := r.peek()
+		if err != nil || t.text != "[" {
+			return err
+		}
+		r.next()
+		for depth := 1; depth > 0; {
+			t, err := r.next()
+			if err != nil {
+				return err
+			}
+			switch t.text {
+			case "":
+				return fmt.Errorf("line %d: unterminated annotation", t.line)
+			case "[":
+				depth++
+			case "]":
+				depth--
+			}
+		}
+	}
+}
+
+func (r *rncParser) parse() error {
+	for {
+		if err := r.annotations(); err != nil {
+			return err
+		}
+		t, err := r.next()
+		if err != nil {
+			return err
+		}
+		switch t.text {
+		case "":
+			if r.start == nil {
+				return fmt.Errorf("line %d: no start pattern", t.line)
+			}
+			return nil
+		case "namespace", "datatypes":
+			prefix, err := r.next()
+			if err != nil {
+				return err
+			}
+			if err := r.expect("="); err != nil {
+				return err
+			}
+			uri, err := r.literal()
+			if err != nil {
+				return err
+			}
+			if t.text == "namespace" {
+				r.namespaces[prefix.text] = uri
+			}
+		case "default":
+			if err := r.expect("namespace"); err != nil {
+				return err
+			}
+			if t, _ := r.peek(); t.text != "=" {
+				r.next()
+			}
+			if err := r.expect("="); err != nil {
+				return err
+			}
+			uri, err := r.literal()
+			if err != nil {
+				return err
+			}
+			r.namespaces[""] = uri
+		case "include", "div", "grammar", "external":
+			return fmt.Errorf("line %d: %s is not supported", t.line, t.text)
+		default:
+			if err := r.expect("="); err != nil {
+				return err
+			}
+			p, err := r.pattern()
+			if err != nil {
+				return err
+			}
+			name := strings.TrimPrefix(t.text, "\\")
+			if t.text == "start" {
+				r.start = p
+				continue
+			}
+			if _, ok := r.defs[name]; ok {
+				return fmt.Errorf("line %d: %s is defined twice", t.line, name)
+			}
+			r.defs[name] = p
+		}
+	}
+}
+
+// pattern parses a pattern: particles joined by one of ",", "|" or "&".
+func (r *rncParser) pattern() (*pattern, error) {
+	p, err := r.particle()
+	if err != nil {
+		return nil, err
+	}
+	op := ""
+	for {
+		t, err := r.peek()
+		if err != nil {
+			return nil, err
+		}
+		if t.text != "," && t.text != "|" && t.text != "&" {
+			return p, nil
+		}
+		if op != "" && t.text != op {
+			return nil, fmt.Errorf("line %d: mixing %q and %q needs parentheses", t.line, op, t.text)
+		}
+		op = t.text
+		r.next()
+		q, err := r.particle()
+		if err != nil {
+			return nil, err
+		}
+		kind := map[string]patternKind{",": patternGroup, "|": patternChoice, "&": patternInterleave}[op]
+		p = &pattern{kind: kind, p1: p, p2: q}
+	}
+}
+
+// particle parses a primary pattern, with an optional ?, * or +.
+func (r *rncParser) particle() (*pattern, error) {
+	p, err := r.primary()
+	if err != nil {
+		return nil, err
+	}
+	t, err := r.peek()
+	if err != nil {
+		return nil, err
+	}
+	switch t.text {
+	case "?":
+		r.next()
+		return &pattern{kind: patternChoice, p1: p, p2: &pattern{kind: patternEmpty}}, nil
+	case "*":
+		r.next()
+		return &pattern{kind: patternChoice, p1: &pattern{kind: patternOneOrMore, p1: p}, p2: &pattern{kind: patternEmpty}}, nil
+	case "+":
+		r.next()
+		return &pattern{kind: patternOneOrMore, p1: p}, nil
+	}
+	return p, nil
+}
+
+func (r *rncParser) primary() (*pattern, error) {
+	if err := r.annotations(); err != nil {
+		return nil, err
+	}
+	t, err := r.next()
+	if err != nil {
+		return nil, err
+	}
+	switch t.text {
+	case "element", "attribute":
+		n, err := r.nameClass(t.text == "attribute")
+		if err != nil {
+			return nil, err
+		}
+		if err := r.expect("{"); err != nil {
+			return nil, err
+		}
+		content, err := r.pattern()
+		if err != nil {
+			return nil, err
+		}
+		if err := r.expect("}"); err != nil {
+			return nil, err
+		}
+		if t.text == "element" {
+			return &pattern{kind: patternElement, name: n, p1: content}, nil
+		}
+		return &pattern{kind: patternAttribute, name: n, p1: content}, nil
+	case "mixed":
+		if err := r.expect("{"); err != nil {
+			return nil, err
+		}
+		content, err := r.pattern()
+		if err != nil {
+			return nil, err
+		}
+		if err := r.expect("}"); err != nil {
+			return nil, err
+		}
+		return &pattern{kind: patternInterleave, p1: content, p2: &pattern{kind: patternText}}, nil
+	case "text":
+		return &pattern{kind: patternText}, nil
+	case "empty":
+		return &pattern{kind: patternEmpty}, nil
+	case "notAllowed":
+		return &pattern{kind: patternNotAllowed}, nil
+	case "string", "token":
+		// a built-in datatype, possibly followed by a value
+		if next, _ := r.peek(); strings.HasPrefix(next.text, "\"") || strings.HasPrefix(next.text, "'") {
+			return r.primary()
+		}
+		return &pattern{kind: patternData, value: t.text}, nil
+	case "(":
+		p, err := r.pattern()
+		if err != nil {
+			return nil, err
+		}
+		return p, r.expect(")")
+	case "", "list", "parent", "external", "grammar":
+		return nil, fmt.Errorf("line %d: unexpected %q", t.line, t.text)
+	}
+	switch {
+	case t.text[0] == '"' || t.text[0] == '\'':
+		return &pattern{kind: patternValue, value: t.text[1 : len(t.text)-1]}, nil
+	case strings.Contains(t.text, ":"):
+		// a datatype, parameters are not supported
+		if next, _ := r.peek(); next.text == "{" {
+			return nil, fmt.Errorf("line %d: datatype parameters are not supported", next.line)
+		}
+		return &pattern{kind: patternData, value: t.text}, nil
+	}
+	return &pattern{kind: patternRef, value: strings.TrimPrefix(t.text, "\\")}, nil
+}
+
+func (r *rncParser) nameClass(attribute bool) (nameClass, error) {
+	t, err := r.next()
+	if err != nil {
+		return nameClass{}, err
+	}
+	name := strings.TrimPrefix(t.text, "\\")
+	if name == "*" {
+		return nameClass{any: true}, nil
+	}
+	prefix, local := "", name
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		prefix, local = name[:i], name[i+1:]
+	} else if attribute {
+		// unprefixed attributes are in no namespace, not in the default one
+		return nameClass{local: local}, nil
+	}
+	space, ok := r.namespaces[prefix]
+	if !ok {
+		return nameClass{}, fmt.Errorf("line %d: undeclared namespace prefix %q", t.line, prefix)
+	}
+	if local == "*" {
+		local = ""
+	}
+	return nameClass{space: space, local: local}, nil
+}
+
+// resolve replaces the references in the raw patterns with the patterns they
+// refer to and interns the patterns.
+func (r *rncParser) resolve() (*Schema, error) {
+	s := &Schema{ps: newPatterns()}
+	resolved := make(map[string]*pattern)
+	busy := make(map[string]bool)
+	type todo struct {
+		el      *element
+		content *pattern
+	}
+	var elements []todo
+
+	var resolve func(p *pattern) (*pattern, error)
+	resolve = func(p *pattern) (*pattern, error) {
+		switch p.kind {
+		case patternNotAllowed:
+			return s.ps.notAllowed, nil
+		case patternEmpty:
+			return s.ps.empty, nil
+		case patternText:
+			return s.ps.text, nil
+		case patternValue, patternData:
+			return s.ps.intern(patternKey{kind: p.kind, value: p.value}), nil
+		case patternRef:
+			if q, ok := resolved[p.value]; ok {
+				return q, nil
+			}
+			def, ok := r.defs[p.value]
+			if !ok {
+				return nil, fmt.Errorf("reference to undefined pattern %s", p.value)
+			}
+			if busy[p.value] {
+				return nil, fmt.Errorf("recursive reference to %s outside of an element", p.value)
+			}
+			busy[p.value] = true
+			q, err := resolve(def)
+			if err != nil {
+				return nil, err
+			}
+			resolved[p.value] = q
+			return q, nil
+		case patternElement:
+			// the content is resolved later, it may refer to this element
+			el := &element{}
+			elements = append(elements, todo{el, p.p1})
+			return s.ps.intern(patternKey{kind: patternElement, name: p.name, element: el}), nil
+		case patternOneOrMore:
+			q, err := resolve(p.p1)
+			if err != nil {
+				return nil, err
+			}
+			return s.ps.oneOrMore(q), nil
+		case patternAttribute:
+			q, err := resolve(p.p1)
+			if err != nil {
+				return nil, err
+			}
+			return s.ps.intern(patternKey{kind: patternAttribute, name: p.name, p1: q}), nil
+		}
+		p1, err := resolve(p.p1)
+		if err != nil {
+			return nil, err
+		}
+		p2, err := resolve(p.p2)
+		if err != nil {
+			return nil, err
+		}
+		switch p.kind {
+		case patternChoice:
+			return s.ps.choice(p1, p2), nil
+		case patternGroup:
+			return s.ps.group(p1, p2), nil
+		}
+		return s.ps.interleave(p1, p2), nil
+	}
+
+	start, err := resolve(r.start)
+	if err != nil {
+		return nil, err
+	}
+	for len(elements) > 0 {
+		t := elements[0]
+		elements = elements[1:]
+		if t.el.content, err = resolve(t.content); err != nil {
+			return nil, err
+		}
+	}
+	s.start = start
+	return s, nil
+}
+
+// names returns the names of the elements or attributes that p can start
+// with, sorted.
+func (p *pattern) names(kind patternKind) []string {
+	seen := make(map[*pattern]bool)
+	set := make(map[string]bool)
+	var walk func(p *pattern)
+	walk = func(p *pattern) {
+		if seen[p] {
+			return
+		}
+		seen[p] = true
+		switch p.kind {
+		case kind:
+			set[p.name.String()] = true
+		case patternChoice, patternInterleave:
+			walk(p.p1)
+			walk(p.p2)
+		case patternGroup:
+			walk(p.p1)
+			if p.p1.nullable || kind == patternAttribute {
+				walk(p.p2)
+			}
+		case patternOneOrMore, patternAfter:
+			walk(p.p1)
+		}
+	}
+	walk(p)
+	names := make([]string, 0, len(set))
+	for n := range set {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,101 @@
+// Source positions of the parsed text.
+
+package mmark
+
+import (
+	"sort"
+	"strconv"
+)
+
+// SourceRenderer is implemented by renderers that can annotate their output with
+// the position of an element in the source. Right before the callback of a
+// block-level element, the parser calls Source with the file and line the
+// element starts on; for elements that contain other blocks that is after their
+// contents have been rendered. Line is 0 when the position is not known.
+// Included files are reported with the name used in the include.
+type SourceRenderer interface {
+	Source(file string, line int)
+}
+
+// sourceLine is the origin of a line in the input of the second pass.
+type sourceLine struct {
+	file string
+	line int
+}
+
+// mark records the position of data in the input of the second pass. It returns
+// false if data is not part of that input, i.e. when it is a copy made by the
+// parser.
+func (p *parser) mark(data []byte) bool {
+	off := offsetOf(p.input, data)
+	if off < 0 {
+		return false
+	}
+	p.offset = off
+	return true
+}
+
+// source marks the position of data and tells the renderer that the element
+// it renders next comes from there. If data is not part of the input the
+// renderer is told the position is not known.
+func (p *parser) source(data []byte) {
+	s, ok := p.r.(SourceRenderer)
+	if !p.mark(data) {
+		if ok {
+			s.Source("", 0)
+		}
+		return
+	}
+	if ok {
+		file, line, _ := p.position()
+		s.Source(file, line)
+	}
+}
+
+// sourcePosition formats a position as file:line, or just the line when the
+// input has no name.
+func sourcePosition(file string, line int) string {
+	if file == "" {
+		return strconv.Itoa(line)
+	}
+	return file + ":" + strconv.Itoa(line)
+}
+
+// offsetOf returns the offset of data in base, or -1 when data does not point
+// into base.
+func offsetOf(base, data []byte) int {
+	if len(data) == 0 || len(base) == 0 {
+		return -1
+	}
+	off := cap(base) - cap(data)
+	if off < 0 || off >= len(base) || &base[off] != &data[0] {
+		return -1
+	}
+	return off
+}
+
+// position returns the file, line and column the parser is looking at.
+func (p *parser) position() (string, int, int) {
+	if p.input == nil {
+		// still in the first pass
+		return p.pos.file, p.pos.line, 1
+	}
+	if p.offset < 0 || p.offset > len(p.input) {
+		return p.file, 0, 0
+	}
+	if p.lineStarts == nil {
+		p.lineStarts = []int{0}
+		for i, c := range p.input {
+			if c == '\n' {
+				p.lineStarts = append(p.lineStarts, i+1)
+			}
+		}
+	}
+	// index of the last line starting at or before offset
+	nl := sort.SearchInts(p.lineStarts, p.offset+1) - 1
+	if nl < 0 || nl >= len(p.lines) {
+		return p.file, 0, 0
+	}
+	col := p.offset - p.lineStarts[nl] + 1
+	return p.lines[nl].file, p.lines[nl].line, col
+}
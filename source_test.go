@@ -0,0 +1,134 @@
+// Unit tests for source positions and dependencies
+
+package mmark
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSourceRenderers(t *testing.T) {
+	input := "# Header\n\nSome text\non two lines.\n\n* item\n"
+	var tests = []struct {
+		renderer Renderer
+		expected []string
+	}{
+		{
+			HtmlRenderer(HTML_DATA_SOURCE, "", ""),
+			[]string{`<h1 id="header" data-source="doc.md:1">`, `<p data-source="doc.md:3">`, `<ul data-source="doc.md:6">`},
+		},
+		{
+			XmlRenderer(XML_SOURCE_COMMENTS),
+			[]string{"<!-- doc.md:1 -->\n<section", "<!-- doc.md:3 -->\n<t>", "<!-- doc.md:6 -->\n<ul>"},
+		},
+		{
+			Xml2Renderer(XML2_SOURCE_COMMENTS),
+			[]string{"<!-- doc.md:1 -->\n<section", "<!-- doc.md:3 -->\n<t>", "<!-- doc.md:6 -->\n<t>\n<list"},
+		},
+	}
+	for _, test := range tests {
+		out := ParseDocument([]byte(input), "doc.md", test.renderer, extensions).Output.String()
+		for _, e := range test.expected {
+			if !strings.Contains(out, e) {
+				t.Errorf("expected %q in output:\n%s", e, out)
+			}
+		}
+	}
+
+	out := Render(ParseTree([]byte(input), extensions), HtmlRenderer(HTML_DATA_SOURCE, "", "")).String()
+	if !strings.Contains(out, `<p data-source="3">`) {
+		t.Errorf("expected source position in tree output:\n%s", out)
+	}
+}
+
+func TestSourceNested(t *testing.T) {
+	input := "> A quote.\n\nA> An aside.\n\nF> ![x](x.png)\n\nAfter.\n"
+	expected := []string{
+		"<blockquote data-source=\"doc.md:1\">\n<p>A quote.</p>",
+		"<aside data-source=\"doc.md:3\">\n<p>An aside.</p>",
+		"<figure role=\"group\" data-source=\"doc.md:5\">",
+		"<p data-source=\"doc.md:7\">After.</p>",
+	}
+	out := ParseDocument([]byte(input), "doc.md", HtmlRenderer(HTML_DATA_SOURCE, "", ""), extensions).Output.String()
+	for _, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Errorf("expected %q in output:\n%s", e, out)
+		}
+	}
+}
+
+func TestSourceInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mmark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := RootFS(dir)
+
+	inc := "section3.md"
+	if err := ioutil.WriteFile(filepath.Join(dir, inc), []byte("Included.\n\nSome <br> tag.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	input := "# Header\n\n{{" + inc + "}}\n\nAfter.\n"
+
+	r := ParseWithOptions([]byte(input), XmlRenderer(XML_SOURCE_COMMENTS), Options{Extensions: extensions, Filename: "doc.md", FileSource: files})
+	if len(r.Diagnostics) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %v", r.Diagnostics)
+	}
+	if d := r.Diagnostics[0]; d.File != inc || d.Line != 3 || d.Column != 6 {
+		t.Errorf("expected diagnostic at %s:3:6, got %s", inc, d)
+	}
+	out := r.Output.String()
+	for _, e := range []string{"<!-- " + inc + ":1 -->\n<t>\nIncluded.", "<!-- doc.md:5 -->\n<t>\nAfter."} {
+		if !strings.Contains(out, e) {
+			t.Errorf("expected %q in output:\n%s", e, out)
+		}
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mmark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := RootFS(dir)
+
+	inc, code, missing, head := "section.md", "main.go", "missing.md", "head.html"
+	if err := ioutil.WriteFile(filepath.Join(dir, inc), []byte("Included.\n\n<{{"+code+"}}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, code), []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	input := "{{" + inc + "}}\n\n{{" + missing + "}}\n\n<{{" + code + "}}\n"
+
+	opts := Options{Extensions: extensions, Filename: "doc.md", FileSource: files}
+	r := ParseWithOptions([]byte(input), HtmlRenderer(0, "", ""), opts)
+	expected := []string{inc, code}
+	if !reflect.DeepEqual(r.Dependencies, expected) {
+		t.Errorf("expected dependencies %v, got %v", expected, r.Dependencies)
+	}
+	if expected := []string{missing}; !reflect.DeepEqual(r.Missing, expected) {
+		t.Errorf("expected missing files %v, got %v", expected, r.Missing)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, head), []byte("<meta name=\"x\">\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the head file is given by the program and not read from FileSource
+	head = filepath.Join(dir, head)
+	input = "% title = \"Title\"\n\n{{" + inc + "}}\n"
+	opts.Extensions |= EXTENSION_TITLEBLOCK_TOML
+	r = ParseWithOptions([]byte(input), HtmlRenderer(HTML_COMPLETE_PAGE, "", head), opts)
+	expected = []string{inc, head, code}
+	if !reflect.DeepEqual(r.Dependencies, expected) {
+		t.Errorf("expected dependencies %v, got %v", expected, r.Dependencies)
+	}
+}
@@ -0,0 +1,1116 @@
+// Plain text rendering backend, RFC 7994 style
+
+package mmark
+
+import (
+	"bytes"
+	"fmt"
+	stdhtml "html"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Text renderer configuration options.
+const (
+	TEXT_STANDALONE = 1 << iota // create standalone document: title page, table of contents, references and pages
+)
+
+const (
+	textWidth      = 72                 // characters on a line
+	textPageLength = 58                 // lines on a page
+	textPageBody   = textPageLength - 6 // lines of text on a page, the rest is used for the header and footer
+	textTocDepth   = 3                  // deepest section level in the table of contents
+	textIndent     = "   "
+)
+
+// The callbacks write lines that are laid out when the document is complete. A
+// line can start with one of these to tell the layout what to do with it.
+// Containers, like lists, put their prefix in front of the lines of their
+// contents, the layout uses the prefix as the indentation of filled text.
+const (
+	textFlow   = '\x01' // rest of the line is text, filled to the available width
+	textBreak  = '\x02' // forced line break in filled text
+	textHeader = '\x03' // section header: no indentation, kept together with the next line
+	textCenter = '\x04' // centered line
+	textRaw    = '\x05' // line without indentation
+	textKeep   = '\x06' // line is kept on the same page as the next one
+	textSource = '\x07' // line with the index in sources of the lines that follow, not output
+
+	textCell = '\x1f' // end of a table cell
+	textRow  = '\x1e' // end of a table row
+)
+
+// Txt is a type that implements the Renderer interface for plain text output.
+//
+// Do not create this directly, instead use the TextRenderer function.
+type txt struct {
+	flags int // TEXT_* options
+
+	// store the IAL we see for this block element
+	ial *Attributes
+
+	// titleBlock in TOML
+	titleBlock *Title
+
+	// section numbering and table of contents
+	section     sectionNumber
+	toc         []textTocEntry
+	tocMarker   int // where the table of contents goes in the output, -1 if not known yet
+	frontMatter bool
+	backMatter  bool
+
+	// open lists, the number of the next item in each
+	lists []int
+
+	// (@good) example list group counter
+	group map[string]int
+
+	figure   int // figure counter
+	table    int // table counter
+	footnote int // footnote counter
+
+	// source positions of the blocks, the layout reports lines that are too
+	// long with them
+	at      sourceLine // position of the block being rendered
+	sources []sourceLine
+
+	// parser that drives this renderer, used for reporting problems
+	p *parser
+}
+
+type textTocEntry struct {
+	level  int
+	number string
+	title  string
+}
+
+// TextRenderer creates and configures a Txt object, which satisfies the
+// Renderer interface. It renders 72 column plain text as described in RFC 7994.
+// The IETF boilerplate is not generated.
+//
+// flags is a set of TEXT_* options ORed together.
+func TextRenderer(flags int) Renderer {
+	return &txt{flags: flags, group: make(map[string]int), tocMarker: -1}
+}
+
+func (options *txt) Flags() int { return options.flags }
+
+func (options *txt) setParser(p *parser) { options.p = p }
+
+func (options *txt) Source(file string, line int) { options.at = sourceLine{file, line} }
+
+// source writes a line that tells the layout the lines that follow come from
+// the block being rendered.
+func (options *txt) source(out *bytes.Buffer) {
+	out.WriteByte(textSource)
+	out.WriteString(strconv.Itoa(len(options.sources)))
+	out.WriteByte('\n')
+	options.sources = append(options.sources, options.at)
+}
+
+func (options *txt) SetAttr(i *Attributes) {
+	options.ial = i
+}
+
+func (options *txt) Attr() *Attributes {
+	if options.ial == nil {
+		return NewAttributes()
+	}
+	return options.ial
+}
+
+func (options *txt) AttrString(i *Attributes) string { return "" }
+
+// textClean writes text to out, the bytes we use to direct the layout are
+// replaced by spaces.
+func textClean(out *bytes.Buffer, text []byte) {
+	for _, c := range text {
+		switch c {
+		case textFlow, textBreak, textHeader, textCenter, textRaw, textKeep, textSource, textCell, textRow, '\f':
+			c = ' '
+		}
+		out.WriteByte(c)
+	}
+}
+
+// textMarker returns true if c tells the layout how to treat a line.
+func textMarker(c byte) bool {
+	return c == textHeader || c == textCenter || c == textRaw || c == textKeep
+}
+
+// textFill returns text as a single line of filled text.
+func textFill(text []byte) string {
+	s := strings.Replace(string(text), "\n", " ", -1)
+	return strings.TrimSpace(s)
+}
+
+// textPrefix writes the lines of text to out with prefix put in front of the
+// first line that is not empty and rest in front of the others. Empty lines
+// stay empty, the markers at the start of a line stay in front.
+func textPrefix(out *bytes.Buffer, text []byte, prefix, rest string) {
+	text = bytes.TrimRight(text, "\n")
+	for _, line := range bytes.Split(text, []byte("\n")) {
+		if len(line) == 0 || line[0] == textSource {
+			out.Write(line)
+			out.WriteByte('\n')
+			continue
+		}
+		for len(line) > 0 && textMarker(line[0]) {
+			out.WriteByte(line[0])
+			line = line[1:]
+		}
+		out.WriteString(prefix)
+		out.Write(line)
+		out.WriteByte('\n')
+		prefix = rest
+	}
+}
+
+func (options *txt) BlockCode(out *bytes.Buffer, text []byte, lang string, caption []byte, subfigure bool, callouts bool) {
+	ial := options.Attr()
+	text = blockCodePrefix(ial.Value("prefix"), text)
+
+	out.WriteByte('\n')
+	options.source(out)
+	lines := bytes.Split(bytes.TrimRight(text, "\n"), []byte("\n"))
+	for i, line := range lines {
+		if i < len(lines)-1 || len(caption) > 0 {
+			out.WriteByte(textKeep)
+		}
+		out.Write(bytes.TrimRight(line, " \t"))
+		out.WriteByte('\n')
+	}
+	out.WriteByte('\n')
+	if len(caption) > 0 && !subfigure {
+		options.caption(out, "Figure", &options.figure, caption)
+	}
+}
+
+// caption writes a centered caption, like "Figure 1: caption", and counts it.
+func (options *txt) caption(out *bytes.Buffer, what string, counter *int, caption []byte) {
+	*counter++
+	out.WriteByte(textCenter)
+	out.WriteString(what + " " + strconv.Itoa(*counter) + ": " + textFill(caption))
+	out.WriteString("\n\n")
+}
+
+func (options *txt) BlockQuote(out *bytes.Buffer, text []byte, attribution []byte) {
+	options.Attr()
+	out.WriteByte('\n')
+	textPrefix(out, text, textIndent, textIndent)
+	if len(attribution) > 0 {
+		out.WriteString(textIndent)
+		out.WriteByte(textFlow)
+		out.WriteString("-- " + textFill(attribution))
+		out.WriteByte('\n')
+	}
+	out.WriteByte('\n')
+}
+
+func (options *txt) Aside(out *bytes.Buffer, text []byte) {
+	options.BlockQuote(out, text, nil)
+}
+
+func (options *txt) BlockHtml(out *bytes.Buffer, text []byte) {
+	warnf(options.p, "unsupported", "syntax not supported: BlockHtml")
+}
+
+func (options *txt) CommentHtml(out *bytes.Buffer, text []byte) {
+	options.Attr()
+}
+
+// header writes a header and returns its text, it returns false if the text
+// could not be rendered.
+func (options *txt) header(out *bytes.Buffer, text func() bool, number string) (string, bool) {
+	marker := out.Len()
+	if !text() {
+		out.Truncate(marker)
+		return "", false
+	}
+	title := textFill(out.Bytes()[marker:])
+	out.Truncate(marker)
+
+	out.WriteString("\n")
+	options.source(out)
+	out.WriteByte(textHeader)
+	if number != "" {
+		out.WriteString(number + "  ")
+	}
+	out.WriteByte(textFlow)
+	out.WriteString(title)
+	out.WriteString("\n\n")
+	return title, true
+}
+
+func (options *txt) SpecialHeader(out *bytes.Buffer, what []byte, text func() bool, id string) {
+	options.Attr()
+	options.header(out, text, "")
+}
+
+func (options *txt) Note(out *bytes.Buffer, text func() bool, id string) {
+	options.Attr()
+	options.header(out, text, "")
+}
+
+func (options *txt) Part(out *bytes.Buffer, text func() bool, id string) {
+	options.Attr()
+	if title, ok := options.header(out, text, ""); ok {
+		options.toc = append(options.toc, textTocEntry{1, "", title})
+	}
+}
+
+func (options *txt) Header(out *bytes.Buffer, text func() bool, level int, id string) {
+	options.Attr()
+	if options.frontMatter {
+		options.header(out, text, "")
+		return
+	}
+	if options.tocMarker < 0 {
+		options.tocMarker = out.Len()
+	}
+	if options.backMatter && !options.section.appendix {
+		options.section.startAppendix()
+	}
+
+	// the number is only taken when the header is rendered
+	section := options.section
+	section.count = append([]int(nil), section.count...)
+	number := section.next(level) + "."
+	if section.appendix && level == 1 {
+		number = "Appendix " + number
+	}
+	if title, ok := options.header(out, text, number); ok {
+		options.section = section
+		options.toc = append(options.toc, textTocEntry{level, number, title})
+	}
+}
+
+func (options *txt) HRule(out *bytes.Buffer) {
+	out.WriteString("\n")
+	out.WriteByte(textCenter)
+	out.WriteString("* * *\n\n")
+}
+
+func (options *txt) List(out *bytes.Buffer, text func() bool, flags, start int, group []byte) {
+	options.Attr()
+	marker := out.Len()
+
+	switch {
+	case flags&LIST_TYPE_ORDERED_GROUP != 0 && group != nil:
+		start = options.group[string(group)] + 1
+	case start < 1:
+		start = 1
+	}
+	options.lists = append(options.lists, start)
+	defer func() { options.lists = options.lists[:len(options.lists)-1] }()
+
+	out.WriteByte('\n')
+	options.source(out)
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+	if flags&LIST_TYPE_ORDERED_GROUP != 0 && group != nil {
+		options.group[string(group)] = options.lists[len(options.lists)-1] - 1
+	}
+	out.WriteByte('\n')
+}
+
+// bullet returns the bullet or number for the next item of the current list.
+func (options *txt) bullet(flags int) string {
+	if flags&LIST_TYPE_ORDERED == 0 || len(options.lists) == 0 {
+		return "o  "
+	}
+	n := options.lists[len(options.lists)-1]
+	options.lists[len(options.lists)-1]++
+	switch {
+	case flags&LIST_TYPE_ORDERED_ALPHA_LOWER != 0:
+		return strings.ToLower(appendixLetter(n)) + ".  "
+	case flags&LIST_TYPE_ORDERED_ALPHA_UPPER != 0:
+		return appendixLetter(n) + ".  "
+	case flags&LIST_TYPE_ORDERED_ROMAN_LOWER != 0:
+		return strings.ToLower(romanNumeral(n)) + ".  "
+	case flags&LIST_TYPE_ORDERED_ROMAN_UPPER != 0:
+		return romanNumeral(n) + ".  "
+	case flags&LIST_TYPE_ORDERED_GROUP != 0:
+		return "(" + strconv.Itoa(n) + ")  "
+	}
+	return strconv.Itoa(n) + ".  "
+}
+
+// romanNumeral returns n in roman numerals.
+func romanNumeral(n int) string {
+	numerals := []struct {
+		value int
+		digit string
+	}{
+		{1000, "M"}, {900, "CM"}, {500, "D"}, {400, "CD"}, {100, "C"}, {90, "XC"},
+		{50, "L"}, {40, "XL"}, {10, "X"}, {9, "IX"}, {5, "V"}, {4, "IV"}, {1, "I"},
+	}
+	s := ""
+	for _, r := range numerals {
+		for n >= r.value {
+			s += r.digit
+			n -= r.value
+		}
+	}
+	return s
+}
+
+func (options *txt) ListItem(out *bytes.Buffer, text []byte, flags int) {
+	if flags&LIST_TYPE_TERM != 0 {
+		out.WriteByte(textFlow)
+		out.WriteString(textFill(text))
+		out.WriteByte('\n')
+		return
+	}
+
+	var item bytes.Buffer
+	if flags&LIST_ITEM_CONTAINS_BLOCK == 0 {
+		// Inline text, possibly followed by a nested list.
+		inline, blocks := text, []byte(nil)
+		if i := bytes.Index(text, []byte("\n\n")); i >= 0 {
+			inline, blocks = text[:i], text[i+2:]
+		}
+		item.WriteByte(textFlow)
+		item.WriteString(textFill(inline))
+		item.WriteByte('\n')
+		item.Write(bytes.TrimLeft(blocks, "\n"))
+	} else {
+		item.Write(bytes.TrimLeft(text, "\n"))
+	}
+
+	if flags&LIST_TYPE_DEFINITION != 0 {
+		textPrefix(out, item.Bytes(), textIndent, textIndent)
+	} else {
+		bullet := options.bullet(flags)
+		textPrefix(out, item.Bytes(), bullet, strings.Repeat(" ", len(bullet)))
+	}
+	if flags&LIST_ITEM_CONTAINS_BLOCK != 0 {
+		out.WriteByte('\n')
+	}
+}
+
+func (options *txt) Paragraph(out *bytes.Buffer, text func() bool, flags int) {
+	marker := out.Len()
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+	para := textFill(out.Bytes()[marker:])
+	out.Truncate(marker)
+	if para == "" {
+		return
+	}
+	options.source(out)
+	out.WriteByte(textFlow)
+	out.WriteString(para)
+	out.WriteString("\n\n")
+}
+
+func (options *txt) Table(out *bytes.Buffer, header []byte, body []byte, footer []byte, columnData []int, caption []byte) {
+	options.Attr()
+	options.source(out)
+
+	rows := func(data []byte) [][]string {
+		var r [][]string
+		for _, row := range bytes.Split(data, []byte{textRow}) {
+			if len(row) == 0 {
+				continue
+			}
+			var cells []string
+			for _, cell := range bytes.Split(row, []byte{textCell}) {
+				cells = append(cells, textFill(cell))
+			}
+			r = append(r, cells[:len(cells)-1])
+		}
+		return r
+	}
+	parts := [][][]string{rows(header), rows(body), rows(footer)}
+
+	width := make([]int, len(columnData))
+	for _, part := range parts {
+		for _, row := range part {
+			for i, cell := range row {
+				if i >= len(width) {
+					width = append(width, 0)
+				}
+				if n := utf8.RuneCountInString(cell); n > width[i] {
+					width[i] = n
+				}
+			}
+		}
+	}
+
+	rule := func(c string) {
+		out.WriteByte(textKeep)
+		out.WriteString("+")
+		for _, w := range width {
+			out.WriteString(strings.Repeat(c, w+2) + "+")
+		}
+		out.WriteByte('\n')
+	}
+
+	out.WriteByte('\n')
+	rule("-")
+	for p, part := range parts {
+		for _, row := range part {
+			out.WriteByte(textKeep)
+			out.WriteString("|")
+			for i, w := range width {
+				cell := ""
+				if i < len(row) {
+					cell = row[i]
+				}
+				align := 0
+				if i < len(columnData) {
+					align = columnData[i]
+				}
+				out.WriteString(" " + textAlign(cell, w, align) + " |")
+			}
+			out.WriteByte('\n')
+		}
+		if len(part) > 0 {
+			if p == 0 {
+				rule("=")
+				continue
+			}
+			rule("-")
+		}
+	}
+	out.WriteByte('\n')
+	if len(caption) > 0 {
+		options.caption(out, "Table", &options.table, caption)
+	}
+}
+
+// textAlign pads s to width according to the table alignment.
+func textAlign(s string, width, align int) string {
+	pad := width - utf8.RuneCountInString(s)
+	if pad <= 0 {
+		return s
+	}
+	switch align {
+	case TABLE_ALIGNMENT_RIGHT:
+		return strings.Repeat(" ", pad) + s
+	case TABLE_ALIGNMENT_CENTER:
+		return strings.Repeat(" ", pad/2) + s + strings.Repeat(" ", pad-pad/2)
+	}
+	return s + strings.Repeat(" ", pad)
+}
+
+func (options *txt) TableRow(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+	out.WriteByte(textRow)
+}
+
+func (options *txt) TableHeaderCell(out *bytes.Buffer, text []byte, align, colspan int) {
+	options.TableCell(out, text, align, colspan)
+}
+
+func (options *txt) TableCell(out *bytes.Buffer, text []byte, align, colspan int) {
+	if colspan > 1 {
+		warnf(options.p, "unsupported", "syntax not supported: TableCell: colspan=%d", colspan)
+	}
+	out.Write(text)
+	out.WriteByte(textCell)
+}
+
+func (options *txt) Footnotes(out *bytes.Buffer, text func() bool) {
+	marker := out.Len()
+	options.header(out, func() bool { out.WriteString("Footnotes"); return true }, "")
+	if !text() {
+		out.Truncate(marker)
+	}
+}
+
+func (options *txt) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int) {
+	options.footnote++
+	var item bytes.Buffer
+	if flags&LIST_ITEM_CONTAINS_BLOCK == 0 {
+		item.WriteByte(textFlow)
+		item.WriteString(textFill(text))
+	} else {
+		item.Write(bytes.TrimLeft(text, "\n"))
+	}
+	ref := "[" + strconv.Itoa(options.footnote) + "]  "
+	textPrefix(out, item.Bytes(), ref, strings.Repeat(" ", len(ref)))
+	out.WriteByte('\n')
+}
+
+func (options *txt) TitleBlockTOML(out *bytes.Buffer, block *Title) {
+	options.titleBlock = block
+	if options.flags&TEXT_STANDALONE == 0 {
+		return
+	}
+
+	var left, right []string
+	workgroup := block.Workgroup
+	if workgroup == "" {
+		workgroup = "Network Working Group"
+	}
+	left = append(left, workgroup, options.runningHeader())
+	if len(block.Updates) > 0 {
+		left = append(left, "Updates: "+textNumbers(block.Updates)+" (if approved)")
+	}
+	if len(block.Obsoletes) > 0 {
+		left = append(left, "Obsoletes: "+textNumbers(block.Obsoletes)+" (if approved)")
+	}
+	if block.Category != "" {
+		left = append(left, "Intended status: "+intendedStatus(block.Category))
+	}
+	if options.draft() {
+		left = append(left, "Expires: "+draftExpires(block.Date))
+	}
+
+	for _, a := range block.Author {
+		right = append(right, a.Initials+" "+a.Surname)
+		org := a.Organization
+		if a.OrganizationAbbrev != "" {
+			org = a.OrganizationAbbrev
+		}
+		if org != "" {
+			right = append(right, org)
+		}
+	}
+	right = append(right, longDate(block.Date))
+
+	for i := 0; i < len(left) || i < len(right); i++ {
+		l, r := "", ""
+		if i < len(left) {
+			l = left[i]
+		}
+		if i < len(right) {
+			r = right[i]
+		}
+		out.WriteByte(textRaw)
+		out.WriteString(textSplit(l, "", r))
+		out.WriteByte('\n')
+	}
+	out.WriteString("\n\n")
+	out.WriteByte(textCenter)
+	out.WriteString(block.Title)
+	out.WriteByte('\n')
+	if block.DocName != "" {
+		out.WriteByte(textCenter)
+		out.WriteString(block.DocName)
+		out.WriteByte('\n')
+	}
+	out.WriteByte('\n')
+}
+
+// draft returns true when the document is an Internet-Draft.
+func (options *txt) draft() bool {
+	return options.titleBlock != nil && draftName(options.titleBlock.DocName) != ""
+}
+
+// runningHeader returns the text for the top left of each page.
+func (options *txt) runningHeader() string {
+	if options.titleBlock == nil {
+		return ""
+	}
+	if h := options.titleBlock.PI.Header; h != piNotSet && h != "" {
+		return h
+	}
+	return "Internet-Draft"
+}
+
+// runningFooter returns the text for the bottom center of each page.
+func (options *txt) runningFooter() string {
+	if options.titleBlock == nil {
+		return ""
+	}
+	if f := options.titleBlock.PI.Footer; f != piNotSet {
+		return f
+	}
+	if options.draft() {
+		return "Expires " + draftExpires(options.titleBlock.Date)
+	}
+	return ""
+}
+
+// authors returns the surnames of the authors for the bottom left of each page.
+func (options *txt) authors() string {
+	if options.titleBlock == nil {
+		return ""
+	}
+	a := options.titleBlock.Author
+	switch len(a) {
+	case 0:
+		return ""
+	case 1:
+		return a[0].Surname
+	case 2:
+		return a[0].Surname + " & " + a[1].Surname
+	}
+	return a[0].Surname + ", et al."
+}
+
+func textNumbers(n []int) string {
+	s := make([]string, len(n))
+	for i := range n {
+		s[i] = strconv.Itoa(n[i])
+	}
+	return strings.Join(s, ", ")
+}
+
+// textSplit returns a line with left on the left, center in the middle and
+// right on the right.
+func textSplit(left, center, right string) string {
+	l, c, r := utf8.RuneCountInString(left), utf8.RuneCountInString(center), utf8.RuneCountInString(right)
+	line := left
+	if c > 0 {
+		pos := (textWidth - c) / 2
+		if pos < l+1 {
+			pos = l + 1
+		}
+		line += strings.Repeat(" ", pos-l) + center
+		l = pos + c
+	}
+	if r > 0 {
+		pad := textWidth - l - r
+		if pad < 1 {
+			pad = 1
+		}
+		line += strings.Repeat(" ", pad) + right
+	}
+	return line
+}
+
+func (options *txt) AutoLink(out *bytes.Buffer, link []byte, kind int) {
+	out.WriteByte('<')
+	if kind == LINK_TYPE_EMAIL {
+		out.WriteString("mailto:")
+	}
+	textClean(out, link)
+	out.WriteByte('>')
+}
+
+func (options *txt) CodeSpan(out *bytes.Buffer, text []byte) {
+	textClean(out, text)
+}
+
+func (options *txt) CalloutCode(out *bytes.Buffer, index, id string) {
+	out.WriteString("<" + index + ">")
+}
+
+func (options *txt) CalloutText(out *bytes.Buffer, index string, id []string) {
+	out.WriteString("(" + strings.Join(id, ", ") + ")")
+}
+
+func (options *txt) DoubleEmphasis(out *bytes.Buffer, text []byte) {
+	// Check for 2119 Keywords, strip emphasis from them.
+	if _, ok := words2119[string(text)]; ok {
+		out.Write(text)
+		return
+	}
+	out.WriteByte('*')
+	out.Write(text)
+	out.WriteByte('*')
+}
+
+func (options *txt) Emphasis(out *bytes.Buffer, text []byte) {
+	out.WriteByte('_')
+	out.Write(text)
+	out.WriteByte('_')
+}
+
+func (options *txt) TripleEmphasis(out *bytes.Buffer, text []byte) {
+	out.WriteString("*_")
+	out.Write(text)
+	out.WriteString("_*")
+}
+
+func (options *txt) StrikeThrough(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (options *txt) Subscript(out *bytes.Buffer, text []byte) {
+	// There is no subscript
+	out.WriteByte('~')
+	out.Write(text)
+	out.WriteByte('~')
+}
+
+func (options *txt) Superscript(out *bytes.Buffer, text []byte) {
+	// There is no superscript
+	out.WriteByte('^')
+	out.Write(text)
+	out.WriteByte('^')
+}
+
+func (options *txt) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte, subfigure bool) {
+	options.Attr()
+	out.Write(alt)
+}
+
+func (options *txt) Figure(out *bytes.Buffer, text []byte, caption []byte) {
+	options.Attr()
+	out.Write(text)
+	if len(caption) > 0 {
+		options.caption(out, "Figure", &options.figure, caption)
+	}
+}
+
+func (options *txt) LineBreak(out *bytes.Buffer) {
+	out.WriteByte(textBreak)
+}
+
+func (options *txt) Link(out *bytes.Buffer, link []byte, title []byte, content []byte) {
+	if len(link) > 0 && link[0] == '#' {
+		if len(content) == 0 {
+			content = link[1:]
+		}
+		out.Write(content)
+		return
+	}
+	if len(content) > 0 && !bytes.Equal(content, link) {
+		out.Write(content)
+		out.WriteByte(' ')
+	}
+	out.WriteByte('<')
+	textClean(out, link)
+	out.WriteByte('>')
+}
+
+func (options *txt) RawHtmlTag(out *bytes.Buffer, tag []byte) {
+	if bytes.Equal(tag, []byte("<br/>")) || bytes.Equal(tag, []byte("<br>")) {
+		out.WriteByte(textBreak)
+	}
+}
+
+func (options *txt) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
+	out.WriteString("[" + strconv.Itoa(id) + "]")
+}
+
+func (options *txt) Index(out *bytes.Buffer, entry IndexEntry) {}
+
+func (options *txt) Citation(out *bytes.Buffer, link, title []byte) {
+	out.WriteByte('[')
+	textClean(out, link)
+	out.WriteByte(']')
+}
+
+func (options *txt) Abbreviation(out *bytes.Buffer, abbr, title []byte) {
+	out.Write(abbr)
+}
+
+func (options *txt) Example(out *bytes.Buffer, index int) {
+	out.WriteString("(" + strconv.Itoa(index) + ")")
+}
+
+func (options *txt) Math(out *bytes.Buffer, text []byte, display bool) {
+	options.Attr()
+	if !display {
+		textClean(out, text)
+		return
+	}
+	var buf bytes.Buffer
+	textClean(&buf, bytes.TrimSpace(text))
+	out.WriteByte(textBreak)
+	out.Write(buf.Bytes())
+	out.WriteByte(textBreak)
+}
+
+func (options *txt) Entity(out *bytes.Buffer, entity []byte) {
+	out.WriteString(stdhtml.UnescapeString(string(entity)))
+}
+
+func (options *txt) NormalText(out *bytes.Buffer, text []byte) {
+	textClean(out, text)
+}
+
+func (options *txt) DocumentHeader(out *bytes.Buffer, first bool) {}
+
+func (options *txt) DocumentMatter(out *bytes.Buffer, matter int) {
+	switch matter {
+	case DOC_FRONT_MATTER:
+		options.frontMatter = true
+	case DOC_MAIN_MATTER:
+		options.frontMatter = false
+		options.tocMarker = out.Len()
+	case DOC_BACK_MATTER:
+		options.frontMatter = false
+		options.backMatter = true
+	}
+}
+
+func (options *txt) References(out *bytes.Buffer, citations map[string]*Citation) {
+	if options.flags&TEXT_STANDALONE == 0 {
+		return
+	}
+	refi, refn, keys := countCitationsAndSort(citations)
+	if refi+refn == 0 {
+		return
+	}
+	options.at = sourceLine{} // the references are not in the source
+
+	// the references are numbered as the last section of the main matter
+	number := options.section.next(1) + "."
+	width := 0
+	for _, k := range keys {
+		if len(k)+4 > width {
+			width = len(k) + 4
+		}
+	}
+
+	references := func(level int, number, title string, typ byte) {
+		options.header(out, func() bool { out.WriteString(title); return true }, number)
+		options.toc = append(options.toc, textTocEntry{level, number, title})
+		for _, k := range keys {
+			c := citations[k]
+			if c.typ != typ {
+				continue
+			}
+			ref := "[" + k + "]"
+			out.WriteString(ref + strings.Repeat(" ", width-len(ref)))
+			out.WriteByte(textFlow)
+			out.WriteString(options.reference(k, c))
+			out.WriteString("\n\n")
+		}
+	}
+
+	switch {
+	case refn > 0 && refi > 0:
+		options.header(out, func() bool { out.WriteString("References"); return true }, number)
+		options.toc = append(options.toc, textTocEntry{1, number, "References"})
+		options.section.next(2)
+		references(2, number+"1.", "Normative References", 'n')
+		references(2, number+"2.", "Informative References", 'i')
+	case refn > 0:
+		references(1, number, "Normative References", 'n')
+	default:
+		references(1, number, "Informative References", 'i')
+	}
+}
+
+// reference returns the text of a reference.
+func (options *txt) reference(anchor string, c *Citation) string {
+	parts, target := referenceParts(options.p, anchor, c)
+	s := strings.Join(parts, ", ")
+	if target != "" {
+		s += ", <" + target + ">"
+	}
+	return s + "."
+}
+
+func (options *txt) DocumentFooter(out *bytes.Buffer, first bool) {
+	if !first {
+		return
+	}
+	standalone := options.flags&TEXT_STANDALONE != 0
+
+	body := append([]byte(nil), out.Bytes()...)
+	if standalone && (options.titleBlock == nil || options.titleBlock.PI.Toc != "no") && len(options.toc) > 0 {
+		marker := options.tocMarker
+		if marker < 0 || marker > len(body) {
+			marker = len(body)
+		}
+		var toc bytes.Buffer
+		toc.Write(body[:marker])
+		options.writeToc(&toc)
+		toc.Write(body[marker:])
+		body = toc.Bytes()
+	}
+
+	lines := options.layout(body)
+	out.Reset()
+	if !standalone {
+		for _, l := range lines {
+			out.WriteString(l.text)
+			out.WriteByte('\n')
+		}
+		return
+	}
+	options.paginate(out, lines)
+}
+
+func (options *txt) writeToc(out *bytes.Buffer) {
+	out.WriteString("\n")
+	options.at = sourceLine{}
+	options.source(out)
+	out.WriteByte(textHeader)
+	out.WriteString("Table of Contents\n\n")
+	for _, e := range options.toc {
+		if e.level > textTocDepth {
+			continue
+		}
+		prefix := ""
+		if e.level > 1 {
+			prefix = strings.Repeat("  ", e.level-1)
+		}
+		if e.number != "" {
+			prefix += e.number + "  "
+		}
+		out.WriteString(prefix)
+		out.WriteByte(textFlow)
+		out.WriteString(e.title)
+		out.WriteByte('\n')
+	}
+	out.WriteByte('\n')
+}
+
+// textLine is a line of laid out text.
+type textLine struct {
+	text string
+	keep bool // keep this line on the same page as the next one
+}
+
+// layout turns the output of the callbacks into lines of at most textWidth
+// characters.
+func (options *txt) layout(body []byte) []textLine {
+	var (
+		lines []textLine
+		at    sourceLine // where the lines come from, line 0 if not known
+	)
+	add := func(s string, keep bool) {
+		s = strings.TrimRight(s, " ")
+		if s == "" {
+			// empty lines are collapsed, unless they are part of
+			// something that is kept together
+			if len(lines) == 0 || !keep && lines[len(lines)-1].text == "" {
+				return
+			}
+			keep = keep || lines[len(lines)-1].keep
+		}
+		if n := utf8.RuneCountInString(s); n > textWidth {
+			warnAt(options.p, at.file, at.line, "width", "line is %d characters long: %q", n, s)
+		}
+		lines = append(lines, textLine{s, keep})
+	}
+
+	for _, line := range strings.Split(string(body), "\n") {
+		if len(line) > 0 && line[0] == textSource {
+			if i, err := strconv.Atoi(line[1:]); err == nil && i < len(options.sources) {
+				at = options.sources[i]
+			}
+			continue
+		}
+		indent, keep, center := textIndent, false, false
+		for len(line) > 0 && textMarker(line[0]) {
+			switch line[0] {
+			case textHeader:
+				indent, keep = "", true
+			case textRaw:
+				indent = ""
+			case textCenter:
+				indent, center = "", true
+			case textKeep:
+				keep = true
+			}
+			line = line[1:]
+		}
+		if line == "" {
+			add("", keep)
+			continue
+		}
+		if center {
+			line = strings.Replace(line, string(textFlow), "", -1)
+			for _, l := range textWrap(line, textWidth) {
+				pad := (textWidth - utf8.RuneCountInString(l)) / 2
+				if pad < 0 {
+					pad = 0
+				}
+				add(strings.Repeat(" ", pad)+l, keep)
+			}
+			continue
+		}
+
+		i := strings.IndexByte(line, textFlow)
+		if i < 0 {
+			add(indent+line, keep)
+			continue
+		}
+		prefix := indent + line[:i]
+		hanging := strings.Repeat(" ", utf8.RuneCountInString(prefix))
+		for j, l := range textWrap(line[i+1:], textWidth-len(hanging)) {
+			if j > 0 {
+				prefix = hanging
+			}
+			add(prefix+l, keep)
+		}
+	}
+	for len(lines) > 0 && lines[len(lines)-1].text == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// textWrap fills s into lines of at most width characters. Words longer than
+// width get a line of their own.
+func textWrap(s string, width int) []string {
+	var lines []string
+	for _, part := range strings.Split(s, string(textBreak)) {
+		line, n := "", 0
+		for _, w := range strings.Fields(part) {
+			wn := utf8.RuneCountInString(w)
+			switch {
+			case n == 0:
+				line, n = w, wn
+			case n+1+wn > width:
+				lines = append(lines, line)
+				line, n = w, wn
+			default:
+				line += " " + w
+				n += 1 + wn
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// paginate writes the lines to out as pages with a header and footer.
+func (options *txt) paginate(out *bytes.Buffer, lines []textLine) {
+	var pages [][]string
+	var page []string
+	for i := 0; i < len(lines); {
+		// lines that are kept together move to the next page if they
+		// don't fit on this one
+		j := i
+		for j < len(lines)-1 && lines[j].keep {
+			j++
+		}
+		if n := j - i + 1; len(page) > 0 && len(page)+n > textPageBody && n <= textPageBody {
+			pages, page = append(pages, page), nil
+		}
+		for _, l := range lines[i : j+1] {
+			if len(page) == 0 && l.text == "" {
+				continue
+			}
+			page = append(page, l.text)
+			if len(page) == textPageBody {
+				pages, page = append(pages, page), nil
+			}
+		}
+		i = j + 1
+	}
+	if len(page) > 0 {
+		pages = append(pages, page)
+	}
+
+	title := ""
+	date := ""
+	if t := options.titleBlock; t != nil {
+		title = t.Abbrev
+		if title == "" {
+			title = t.Title
+		}
+		date = t.Date.Format("January 2006")
+	}
+	for i, page := range pages {
+		if i > 0 {
+			out.WriteString("\f\n")
+			out.WriteString(textSplit(options.runningHeader(), title, date))
+		}
+		out.WriteString("\n\n\n")
+		for _, l := range page {
+			out.WriteString(l + "\n")
+		}
+		out.WriteString(strings.Repeat("\n", textPageBody-len(page)))
+		out.WriteString("\n\n")
+		out.WriteString(textSplit(options.authors(), options.runningFooter(), fmt.Sprintf("[Page %d]", i+1)))
+		out.WriteByte('\n')
+	}
+}
@@ -0,0 +1,72 @@
+// Unit tests for the text renderer
+
+package mmark
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	input := "# Introduction\n\nThis is a paragraph that is long enough to be wrapped at the seventy-two column boundary of the text output.\n\n" +
+		"* one\n* two\n    1. nested\n    2. list\n\nName | Age\n-----|----:\nBob  | 27\n\n~~~\ncode\n\n\nblock\n~~~\nFigure: Code\n\n{backmatter}\n\n# Extra\n"
+	expected := "1.  Introduction\n\n" +
+		"   This is a paragraph that is long enough to be wrapped at the\n   seventy-two column boundary of the text output.\n\n" +
+		"   o  one\n   o  two\n      1.  nested\n      2.  list\n\n" +
+		"   +------+-----+\n   | Name | Age |\n   +======+=====+\n   | Bob  |  27 |\n   +------+-----+\n\n" +
+		"   code\n\n\n   block\n\n                             Figure 1: Code\n\n" +
+		"Appendix A.  Extra\n"
+
+	actual := Parse([]byte(input), TextRenderer(0), extensions).String()
+	if actual != expected {
+		t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+	}
+}
+
+func TestTextStandalone(t *testing.T) {
+	input := "%%%\ntitle = \"A Test\"\nabbrev = \"Test\"\ndocName = \"draft-test-00\"\ndate = 2016-03-01T00:00:00Z\ncategory = \"info\"\n" +
+		"[[author]]\ninitials=\"M.\"\nsurname=\"Gieben\"\norganization=\"Example\"\n%%%\n\n" +
+		".# Abstract\n\nThe abstract.\n\n{mainmatter}\n\n# Intro\n\nWe cite [@RFC2119].\n\n" +
+		strings.Repeat("A paragraph.\n\n", 30) + "# Second\n\nText.\n"
+
+	actual := Parse([]byte(input), TextRenderer(TEXT_STANDALONE), extensions).String()
+	for _, e := range []string{
+		"Network Working Group                                          M. Gieben\n" +
+			"Internet-Draft                                                   Example\n" +
+			"Intended status: Informational                             March 1, 2016\n" +
+			"Expires: September 2, 2016\n",
+		"                                 A Test\n                             draft-test-00\n",
+		"Table of Contents\n\n   1.  Intro\n   2.  Second\n   3.  Informative References\n\n1.  Intro\n",
+		"Gieben                 Expires September 2, 2016                [Page 1]\n\f\n" +
+			"Internet-Draft                    Test                        March 2016\n",
+		"[Page 2]\n",
+		"3.  Informative References\n\n   [RFC2119]  RFC 2119, <https://www.rfc-editor.org/info/rfc2119>.\n",
+	} {
+		if !strings.Contains(actual, e) {
+			t.Errorf("expected %q in output:\n%s", e, actual)
+		}
+	}
+
+	for i, page := range strings.Split(actual, "\f\n") {
+		if n := strings.Count(page, "\n"); n != textPageLength {
+			t.Errorf("page %d has %d lines, expected %d", i+1, n, textPageLength)
+		}
+	}
+}
+
+func TestTextWidth(t *testing.T) {
+	long := strings.Repeat("x", 80)
+	input := "# Intro\n\nText.\n\n    " + long + "\n\nMore text.\n\n* " + long + "\n\nThe end.\n"
+	opts := Options{Extensions: extensions, Filename: "t.md"}
+	var actual []string
+	for _, d := range ParseWithOptions([]byte(input), TextRenderer(0), opts).Diagnostics {
+		if d.Code == "width" {
+			actual = append(actual, d.String()[:len("t.md:5:1")])
+		}
+	}
+	expected := []string{"t.md:5:1", "t.md:9:1"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected long lines at %v, got %v", expected, actual)
+	}
+}
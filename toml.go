@@ -15,7 +15,8 @@ const (
 	DefaultArea = "Internet"
 )
 
-type author struct {
+// Author is an author of the document.
+type Author struct {
 	Initials           string
 	Surname            string
 	Fullname           string
@@ -23,17 +24,19 @@ type author struct {
 	OrganizationAbbrev string `toml:"abbrev"`
 	Role               string
 	Ascii              string
-	Address            address
+	Address            Address
 }
 
-type address struct {
+// Address is the address of an author.
+type Address struct {
 	Phone  string
 	Email  string
 	Uri    string
-	Postal addressPostal
+	Postal AddressPostal
 }
 
-type addressPostal struct {
+// AddressPostal is the postal address of an author.
+type AddressPostal struct {
 	Street     string
 	City       string
 	Code       string
@@ -52,7 +55,8 @@ type addressPostal struct {
 // PIs the processing instructions.
 var PIs = []string{"toc", "symrefs", "sortrefs", "compact", "subcompact", "private", "topblock", "header", "footer", "comments"}
 
-type pi struct {
+// PI holds the processing instructions for xml2rfc.
+type PI struct {
 	Toc        string
 	Symrefs    string
 	Sortrefs   string
@@ -65,7 +69,9 @@ type pi struct {
 	Footer     string // Bottom-Center footer, usually Expires ...
 }
 
-type title struct {
+// Title is the TOML titleblock of the document, it is handed to the
+// TitleBlockTOML renderer callback.
+type Title struct {
 	Title  string
 	Abbrev string
 
@@ -74,31 +80,36 @@ type title struct {
 	Category       string
 	Obsoletes      []int
 	Updates        []int
-	PI             pi // Processing Instructions
+	PI             PI // Processing Instructions
 	SubmissionType string
 
 	Date      time.Time
 	Area      string
 	Workgroup string
 	Keyword   []string
-	Author    []author
+	Author    []Author
 }
 
-func (p *parser) titleBlockTOML(out *bytes.Buffer, data []byte) title {
+func (p *parser) titleBlockTOML(out *bytes.Buffer, data []byte) Title {
 	data = bytes.TrimPrefix(data, []byte("%"))
 	data = bytes.Replace(data, []byte("\n%"), []byte("\n"), -1)
 
 	// Set some sentinels and defaults.
-	var block title
+	var block Title
 	block.PI.Header = piNotSet
 	block.PI.Footer = piNotSet
 	block.Area = DefaultArea
 	block.Ipr = DefaultIpr
-	block.Date = time.Now()
 
-	if _, err := toml.Decode(string(data), &block); err != nil {
-		printf(p, "error in TOML titleblock: %s", err.Error())
+	_, err := toml.Decode(string(data), &block)
+	date := !block.Date.IsZero()
+	if !date {
+		block.Date = time.Now()
+	}
+	if err != nil {
+		errorf(p, "toml", "error in TOML titleblock: %s", err.Error())
 		return block // never an error when encoding markdown
 	}
+	p.lintTitle(&block, date)
 	return block
 }
@@ -0,0 +1,580 @@
+// Build a document tree from the parser and render it again.
+
+package mmark
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// ParseTree parses a block of markdown-encoded text into a document tree. The
+// tree can be inspected or changed and then be handed to Render.
+func ParseTree(input []byte, extensions int) *Node {
+	doc, _ := ParseTreeWithOptions(input, Options{Extensions: extensions})
+	return doc
+}
+
+// ParseTreeWithOptions is ParseTree with the configuration in opts, e.g. where
+// included files are read from. It also returns the problems found in the
+// document.
+func ParseTreeWithOptions(input []byte, opts Options) (*Node, []Diagnostic) {
+	// A NUL byte is used to mark nodes in the output, see treeRenderer. The
+	// parser replaces them in the input.
+	t := &treeRenderer{}
+	r := ParseWithOptions(input, t, opts)
+
+	doc := &Node{Type: NODE_DOCUMENT}
+	doc.Children = t.children(doc, r.Output.Bytes())
+	return doc, r.Diagnostics
+}
+
+// Render walks the document tree and calls the Renderer for each node, just as
+// Parse would have done for the original text.
+func Render(doc *Node, renderer Renderer) *bytes.Buffer {
+	var out bytes.Buffer
+	if renderer == nil || doc == nil {
+		return &out
+	}
+
+	renderer.DocumentHeader(&out, true)
+	w := &treeWalker{r: renderer}
+	w.children(&out, doc)
+	renderer.DocumentFooter(&out, true)
+	return &out
+}
+
+// treeRenderer is a Renderer that builds a tree. Every callback creates a
+// node and writes a reference to it into the output buffer: a NUL byte, the
+// index of the node and another NUL byte. The parser hands the (rendered)
+// contents of an element to its callback, which turns the references and the
+// text surrounding them back into the children of the new node. Text is written
+// as is, because the parser sometimes trims or rewinds the text it has written.
+type treeRenderer struct {
+	nodes []*Node
+	ial   *Attributes
+
+	// position of the last block element seen by the parser
+	file string
+	line int
+}
+
+func (t *treeRenderer) add(out *bytes.Buffer, n *Node) *Node {
+	if n.Type < NODE_AUTO_LINK && n.Line == 0 {
+		// Containers are only seen after their contents, they start
+		// where their first block starts.
+		n.File, n.Line = t.file, t.line
+		if len(n.Children) > 0 && n.Children[0].Line > 0 {
+			n.File, n.Line = n.Children[0].File, n.Children[0].Line
+		}
+	}
+	t.nodes = append(t.nodes, n)
+	out.WriteByte(0)
+	out.WriteString(strconv.Itoa(len(t.nodes) - 1))
+	out.WriteByte(0)
+	return n
+}
+
+// children converts the data written for parent back into nodes.
+func (t *treeRenderer) children(parent *Node, data []byte) []*Node {
+	var nodes []*Node
+	text := func(b []byte) {
+		if len(b) == 0 {
+			return
+		}
+		if l := len(nodes); l > 0 && nodes[l-1].Type == NODE_TEXT {
+			nodes[l-1].Literal = append(nodes[l-1].Literal, b...)
+			return
+		}
+		nodes = append(nodes, &Node{Type: NODE_TEXT, Parent: parent, Literal: append([]byte(nil), b...)})
+	}
+
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, 0)
+		if i < 0 {
+			text(data)
+			break
+		}
+		text(data[:i])
+		data = data[i+1:]
+
+		j := bytes.IndexByte(data, 0)
+		if j < 0 {
+			break
+		}
+		k, err := strconv.Atoi(string(data[:j]))
+		data = data[j+1:]
+		if err != nil || k < 0 || k >= len(t.nodes) {
+			continue
+		}
+		n := t.nodes[k]
+		n.Parent = parent
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+// capture runs text and returns everything it wrote to out, out itself is left
+// untouched. If text fails, nil and false are returned.
+func capture(out *bytes.Buffer, text func() bool) ([]byte, bool) {
+	marker := out.Len()
+	ok := text()
+	data := append([]byte(nil), out.Bytes()[marker:]...)
+	out.Truncate(marker)
+	return data, ok
+}
+
+func (t *treeRenderer) caption(parent *Node, data []byte) *Node {
+	if len(data) == 0 {
+		return nil
+	}
+	c := &Node{Type: NODE_CAPTION, Parent: parent}
+	c.Children = t.children(c, data)
+	return c
+}
+
+func (t *treeRenderer) block(out *bytes.Buffer, n *Node, text func() bool) {
+	n.File, n.Line = t.file, t.line
+	data, ok := capture(out, text)
+	if !ok {
+		return
+	}
+	n.Children = t.children(n, data)
+	t.add(out, n)
+}
+
+func (t *treeRenderer) BlockCode(out *bytes.Buffer, text []byte, lang string, caption []byte, subfigure bool, callouts bool) {
+	n := &Node{Type: NODE_BLOCK_CODE, Lang: lang, Subfigure: subfigure, Callouts: callouts, Attr: t.ial}
+	n.Children = t.children(n, text)
+	n.Caption = t.caption(n, caption)
+	t.add(out, n)
+}
+
+func (t *treeRenderer) BlockQuote(out *bytes.Buffer, text []byte, attribution []byte) {
+	n := &Node{Type: NODE_BLOCK_QUOTE, Attr: t.ial}
+	n.Children = t.children(n, text)
+	n.Caption = t.caption(n, attribution)
+	t.add(out, n)
+}
+
+func (t *treeRenderer) BlockHtml(out *bytes.Buffer, text []byte) {
+	t.add(out, &Node{Type: NODE_BLOCK_HTML, Literal: text})
+}
+
+func (t *treeRenderer) CommentHtml(out *bytes.Buffer, text []byte) {
+	t.add(out, &Node{Type: NODE_COMMENT_HTML, Literal: text, Attr: t.ial})
+}
+
+func (t *treeRenderer) SpecialHeader(out *bytes.Buffer, what []byte, text func() bool, id string) {
+	t.block(out, &Node{Type: NODE_SPECIAL_HEADER, Literal: what, ID: id, Attr: t.ial}, text)
+}
+
+func (t *treeRenderer) Note(out *bytes.Buffer, text func() bool, id string) {
+	t.block(out, &Node{Type: NODE_NOTE, ID: id, Attr: t.ial}, text)
+}
+
+func (t *treeRenderer) Part(out *bytes.Buffer, text func() bool, id string) {
+	t.block(out, &Node{Type: NODE_PART, ID: id, Attr: t.ial}, text)
+}
+
+func (t *treeRenderer) Header(out *bytes.Buffer, text func() bool, level int, id string) {
+	t.block(out, &Node{Type: NODE_HEADER, Level: level, ID: id, Attr: t.ial}, text)
+}
+
+func (t *treeRenderer) HRule(out *bytes.Buffer) {
+	t.add(out, &Node{Type: NODE_HRULE})
+}
+
+func (t *treeRenderer) List(out *bytes.Buffer, text func() bool, flags, start int, group []byte) {
+	t.block(out, &Node{Type: NODE_LIST, Flags: flags, Start: start, Group: group, Attr: t.ial}, text)
+}
+
+func (t *treeRenderer) ListItem(out *bytes.Buffer, text []byte, flags int) {
+	n := &Node{Type: NODE_LIST_ITEM, Flags: flags}
+	n.Children = t.children(n, text)
+	t.add(out, n)
+}
+
+func (t *treeRenderer) Paragraph(out *bytes.Buffer, text func() bool, flags int) {
+	t.block(out, &Node{Type: NODE_PARAGRAPH, Flags: flags}, text)
+}
+
+func (t *treeRenderer) Table(out *bytes.Buffer, header []byte, body []byte, footer []byte, columnData []int, caption []byte) {
+	n := &Node{Type: NODE_TABLE, Columns: columnData, Attr: t.ial}
+	for i, part := range [][]byte{header, body, footer} {
+		c := &Node{Type: NODE_TABLE_HEAD + NodeType(i), Parent: n}
+		c.Children = t.children(c, part)
+		n.Children = append(n.Children, c)
+	}
+	n.Caption = t.caption(n, caption)
+	t.add(out, n)
+}
+
+func (t *treeRenderer) TableRow(out *bytes.Buffer, text []byte) {
+	n := &Node{Type: NODE_TABLE_ROW}
+	n.Children = t.children(n, text)
+	t.add(out, n)
+}
+
+func (t *treeRenderer) TableHeaderCell(out *bytes.Buffer, text []byte, flags, colspan int) {
+	n := &Node{Type: NODE_TABLE_HEADER_CELL, Flags: flags, Colspan: colspan}
+	n.Children = t.children(n, text)
+	t.add(out, n)
+}
+
+func (t *treeRenderer) TableCell(out *bytes.Buffer, text []byte, flags, colspan int) {
+	n := &Node{Type: NODE_TABLE_CELL, Flags: flags, Colspan: colspan}
+	n.Children = t.children(n, text)
+	t.add(out, n)
+}
+
+func (t *treeRenderer) Footnotes(out *bytes.Buffer, text func() bool) {
+	t.block(out, &Node{Type: NODE_FOOTNOTES}, text)
+}
+
+func (t *treeRenderer) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int) {
+	n := &Node{Type: NODE_FOOTNOTE_ITEM, Literal: name, Flags: flags}
+	n.Children = t.children(n, text)
+	t.add(out, n)
+}
+
+func (t *treeRenderer) TitleBlockTOML(out *bytes.Buffer, data *Title) {
+	t.add(out, &Node{Type: NODE_TITLE_BLOCK, TitleBlock: data})
+}
+
+func (t *treeRenderer) Aside(out *bytes.Buffer, text []byte) {
+	n := &Node{Type: NODE_ASIDE, Attr: t.ial}
+	n.Children = t.children(n, text)
+	t.add(out, n)
+}
+
+func (t *treeRenderer) Figure(out *bytes.Buffer, text []byte, caption []byte) {
+	n := &Node{Type: NODE_FIGURE, Attr: t.ial}
+	n.Children = t.children(n, text)
+	n.Caption = t.caption(n, caption)
+	t.add(out, n)
+}
+
+func (t *treeRenderer) AutoLink(out *bytes.Buffer, link []byte, kind int) {
+	t.add(out, &Node{Type: NODE_AUTO_LINK, Link: link, Flags: kind})
+}
+
+func (t *treeRenderer) CodeSpan(out *bytes.Buffer, text []byte) {
+	t.add(out, &Node{Type: NODE_CODE_SPAN, Literal: text})
+}
+
+func (t *treeRenderer) CalloutText(out *bytes.Buffer, id string, ids []string) {
+	t.add(out, &Node{Type: NODE_CALLOUT_TEXT, ID: id, CalloutIDs: ids})
+}
+
+func (t *treeRenderer) CalloutCode(out *bytes.Buffer, index, id string) {
+	t.add(out, &Node{Type: NODE_CALLOUT_CODE, ID: id, CalloutIndex: index})
+}
+
+func (t *treeRenderer) span(out *bytes.Buffer, typ NodeType, text []byte) {
+	n := &Node{Type: typ}
+	n.Children = t.children(n, text)
+	t.add(out, n)
+}
+
+func (t *treeRenderer) DoubleEmphasis(out *bytes.Buffer, text []byte) {
+	t.span(out, NODE_DOUBLE_EMPHASIS, text)
+}
+
+func (t *treeRenderer) Emphasis(out *bytes.Buffer, text []byte) {
+	t.span(out, NODE_EMPHASIS, text)
+}
+
+func (t *treeRenderer) Subscript(out *bytes.Buffer, text []byte) {
+	t.span(out, NODE_SUBSCRIPT, text)
+}
+
+func (t *treeRenderer) Superscript(out *bytes.Buffer, text []byte) {
+	t.span(out, NODE_SUPERSCRIPT, text)
+}
+
+func (t *treeRenderer) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte, subfigure bool) {
+	n := &Node{Type: NODE_IMAGE, Link: link, Literal: alt, Subfigure: subfigure, Attr: t.ial}
+	n.Caption = t.caption(n, title)
+	t.add(out, n)
+}
+
+func (t *treeRenderer) LineBreak(out *bytes.Buffer) {
+	t.add(out, &Node{Type: NODE_LINE_BREAK})
+}
+
+func (t *treeRenderer) Link(out *bytes.Buffer, link []byte, title []byte, content []byte) {
+	n := &Node{Type: NODE_LINK, Link: link, Title: title}
+	n.Children = t.children(n, content)
+	t.add(out, n)
+}
+
+func (t *treeRenderer) RawHtmlTag(out *bytes.Buffer, tag []byte) {
+	t.add(out, &Node{Type: NODE_RAW_HTML_TAG, Literal: tag})
+}
+
+func (t *treeRenderer) TripleEmphasis(out *bytes.Buffer, text []byte) {
+	t.span(out, NODE_TRIPLE_EMPHASIS, text)
+}
+
+func (t *treeRenderer) StrikeThrough(out *bytes.Buffer, text []byte) {
+	t.span(out, NODE_STRIKETHROUGH, text)
+}
+
+func (t *treeRenderer) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
+	t.add(out, &Node{Type: NODE_FOOTNOTE_REF, Link: ref, Start: id})
+}
+
+func (t *treeRenderer) Index(out *bytes.Buffer, entry IndexEntry) {
+	t.add(out, &Node{Type: NODE_INDEX, Index: &entry})
+}
+
+func (t *treeRenderer) Citation(out *bytes.Buffer, link, title []byte) {
+	t.add(out, &Node{Type: NODE_CITATION, Link: link, Title: title})
+}
+
+func (t *treeRenderer) Abbreviation(out *bytes.Buffer, abbr, title []byte) {
+	t.add(out, &Node{Type: NODE_ABBREVIATION, Literal: abbr, Title: title})
+}
+
+func (t *treeRenderer) Example(out *bytes.Buffer, index int) {
+	t.add(out, &Node{Type: NODE_EXAMPLE, Start: index})
+}
+
+func (t *treeRenderer) Math(out *bytes.Buffer, text []byte, display bool) {
+	t.add(out, &Node{Type: NODE_MATH, Literal: text, Display: display, Attr: t.ial})
+}
+
+func (t *treeRenderer) Entity(out *bytes.Buffer, entity []byte) {
+	t.add(out, &Node{Type: NODE_ENTITY, Literal: entity})
+}
+
+func (t *treeRenderer) NormalText(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (t *treeRenderer) DocumentHeader(out *bytes.Buffer, start bool) {}
+func (t *treeRenderer) DocumentFooter(out *bytes.Buffer, start bool) {}
+
+func (t *treeRenderer) DocumentMatter(out *bytes.Buffer, matter int) {
+	t.add(out, &Node{Type: NODE_DOCUMENT_MATTER, Matter: matter})
+}
+
+func (t *treeRenderer) References(out *bytes.Buffer, citations map[string]*Citation) {
+	// Later citations are added to the same map, take a snapshot of what we
+	// have now.
+	c := make(map[string]*Citation, len(citations))
+	for k, v := range citations {
+		c[k] = v
+	}
+	t.add(out, &Node{Type: NODE_REFERENCES, Citations: c})
+}
+
+func (t *treeRenderer) Source(file string, line int)    { t.file, t.line = file, line }
+func (t *treeRenderer) Flags() int                      { return 0 }
+func (t *treeRenderer) SetAttr(i *Attributes)           { t.ial = i }
+func (t *treeRenderer) AttrString(i *Attributes) string { return "" }
+
+func (t *treeRenderer) Attr() *Attributes {
+	if t.ial == nil {
+		return NewAttributes()
+	}
+	return t.ial
+}
+
+// treeWalker replays a tree into a Renderer.
+type treeWalker struct {
+	r Renderer
+}
+
+// setAttr hands a copy of the node's IAL to the renderer, renderers are
+// allowed to change the IAL they are given.
+func (w *treeWalker) setAttr(n *Node) {
+	if n.Attr == nil {
+		w.r.SetAttr(nil)
+		return
+	}
+	w.r.SetAttr(NewAttributes().add(n.Attr))
+}
+
+// source tells the renderer where n came from, if it wants to know.
+func (w *treeWalker) source(n *Node) {
+	if s, ok := w.r.(SourceRenderer); ok {
+		s.Source(n.File, n.Line)
+	}
+}
+
+func (w *treeWalker) children(out *bytes.Buffer, n *Node) {
+	for _, c := range n.Children {
+		w.render(out, c)
+	}
+}
+
+// bytes renders the children of n into a new buffer.
+func (w *treeWalker) bytes(n *Node) []byte {
+	if n == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	w.children(&buf, n)
+	return buf.Bytes()
+}
+
+// literal renders the children of a code block, text is copied verbatim.
+func (w *treeWalker) literal(n *Node) []byte {
+	var buf bytes.Buffer
+	for _, c := range n.Children {
+		if c.Type == NODE_TEXT {
+			buf.Write(c.Literal)
+			continue
+		}
+		w.render(&buf, c)
+	}
+	return buf.Bytes()
+}
+
+func (w *treeWalker) block(out *bytes.Buffer, n *Node) func() bool {
+	return func() bool {
+		w.children(out, n)
+		return true
+	}
+}
+
+func (w *treeWalker) render(out *bytes.Buffer, n *Node) {
+	r := w.r
+	switch n.Type {
+	case NODE_BLOCK_CODE:
+		caption := w.bytes(n.Caption)
+		text := w.literal(n)
+		w.setAttr(n)
+		w.source(n)
+		r.BlockCode(out, text, n.Lang, caption, n.Subfigure, n.Callouts)
+	case NODE_BLOCK_QUOTE:
+		text := w.bytes(n)
+		attribution := w.bytes(n.Caption)
+		w.setAttr(n)
+		w.source(n)
+		r.BlockQuote(out, text, attribution)
+	case NODE_BLOCK_HTML:
+		r.BlockHtml(out, n.Literal)
+	case NODE_COMMENT_HTML:
+		w.setAttr(n)
+		r.CommentHtml(out, n.Literal)
+	case NODE_SPECIAL_HEADER:
+		w.setAttr(n)
+		w.source(n)
+		r.SpecialHeader(out, n.Literal, w.block(out, n), n.ID)
+	case NODE_NOTE:
+		w.setAttr(n)
+		w.source(n)
+		r.Note(out, w.block(out, n), n.ID)
+	case NODE_PART:
+		w.setAttr(n)
+		w.source(n)
+		r.Part(out, w.block(out, n), n.ID)
+	case NODE_HEADER:
+		w.setAttr(n)
+		w.source(n)
+		r.Header(out, w.block(out, n), n.Level, n.ID)
+	case NODE_HRULE:
+		r.HRule(out)
+	case NODE_LIST:
+		w.setAttr(n)
+		w.source(n)
+		r.List(out, w.block(out, n), n.Flags, n.Start, n.Group)
+	case NODE_LIST_ITEM:
+		// The parser strips the trailing newlines of a list item, the
+		// rendered nested lists are only seen here.
+		r.ListItem(out, bytes.TrimRight(w.bytes(n), "\n"), n.Flags)
+	case NODE_PARAGRAPH:
+		w.source(n)
+		r.Paragraph(out, w.block(out, n), n.Flags)
+	case NODE_TABLE:
+		var parts [3][]byte
+		for _, c := range n.Children {
+			if i := int(c.Type - NODE_TABLE_HEAD); i >= 0 && i < len(parts) {
+				parts[i] = w.bytes(c)
+			}
+		}
+		caption := w.bytes(n.Caption)
+		w.setAttr(n)
+		w.source(n)
+		r.Table(out, parts[0], parts[1], parts[2], n.Columns, caption)
+	case NODE_TABLE_HEAD, NODE_TABLE_BODY, NODE_TABLE_FOOT, NODE_CAPTION:
+		w.children(out, n)
+	case NODE_TABLE_ROW:
+		r.TableRow(out, w.bytes(n))
+	case NODE_TABLE_HEADER_CELL:
+		r.TableHeaderCell(out, w.bytes(n), n.Flags, n.Colspan)
+	case NODE_TABLE_CELL:
+		r.TableCell(out, w.bytes(n), n.Flags, n.Colspan)
+	case NODE_FOOTNOTES:
+		r.Footnotes(out, w.block(out, n))
+	case NODE_FOOTNOTE_ITEM:
+		r.FootnoteItem(out, n.Literal, w.bytes(n), n.Flags)
+	case NODE_TITLE_BLOCK:
+		r.TitleBlockTOML(out, n.TitleBlock)
+	case NODE_ASIDE:
+		text := w.bytes(n)
+		w.setAttr(n)
+		w.source(n)
+		r.Aside(out, text)
+	case NODE_FIGURE:
+		text := w.bytes(n)
+		caption := w.bytes(n.Caption)
+		w.setAttr(n)
+		w.source(n)
+		r.Figure(out, text, caption)
+	case NODE_DOCUMENT_MATTER:
+		r.DocumentMatter(out, n.Matter)
+	case NODE_REFERENCES:
+		r.References(out, n.Citations)
+
+	case NODE_AUTO_LINK:
+		r.AutoLink(out, n.Link, n.Flags)
+	case NODE_CODE_SPAN:
+		r.CodeSpan(out, n.Literal)
+	case NODE_CALLOUT_TEXT:
+		r.CalloutText(out, n.ID, n.CalloutIDs)
+	case NODE_CALLOUT_CODE:
+		r.CalloutCode(out, n.CalloutIndex, n.ID)
+	case NODE_DOUBLE_EMPHASIS:
+		r.DoubleEmphasis(out, w.bytes(n))
+	case NODE_EMPHASIS:
+		r.Emphasis(out, w.bytes(n))
+	case NODE_SUBSCRIPT:
+		r.Subscript(out, w.bytes(n))
+	case NODE_SUPERSCRIPT:
+		r.Superscript(out, w.bytes(n))
+	case NODE_IMAGE:
+		title := w.bytes(n.Caption)
+		w.setAttr(n)
+		r.Image(out, n.Link, title, n.Literal, n.Subfigure)
+	case NODE_LINE_BREAK:
+		r.LineBreak(out)
+	case NODE_LINK:
+		r.Link(out, n.Link, n.Title, w.bytes(n))
+	case NODE_RAW_HTML_TAG:
+		r.RawHtmlTag(out, n.Literal)
+	case NODE_TRIPLE_EMPHASIS:
+		r.TripleEmphasis(out, w.bytes(n))
+	case NODE_STRIKETHROUGH:
+		r.StrikeThrough(out, w.bytes(n))
+	case NODE_FOOTNOTE_REF:
+		r.FootnoteRef(out, n.Link, n.Start)
+	case NODE_INDEX:
+		r.Index(out, *n.Index)
+	case NODE_CITATION:
+		r.Citation(out, n.Link, n.Title)
+	case NODE_ABBREVIATION:
+		r.Abbreviation(out, n.Literal, n.Title)
+	case NODE_EXAMPLE:
+		r.Example(out, n.Start)
+	case NODE_MATH:
+		w.setAttr(n)
+		r.Math(out, n.Literal, n.Display)
+	case NODE_ENTITY:
+		r.Entity(out, n.Literal)
+	case NODE_TEXT:
+		r.NormalText(out, n.Literal)
+	}
+}
@@ -0,0 +1,433 @@
+// Validating XML against a RELAX NG schema, with the derivative algorithm of
+// James Clark: https://relaxng.org/jclark/derivative.html.
+
+package mmark
+
+import (
+	_ "embed"
+	xmllib "encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+//go:embed xml2rfcv3.rnc
+var xml2rfcv3RNC []byte
+
+var (
+	xmlSchema     *Schema
+	xmlSchemaOnce sync.Once
+)
+
+// XmlSchema returns the xml2rfc v3 schema, that the output of XmlRenderer
+// should conform to.
+func XmlSchema() *Schema {
+	xmlSchemaOnce.Do(func() {
+		s, err := ParseSchema(xml2rfcv3RNC)
+		if err != nil {
+			panic("mmark: xml2rfcv3.rnc: " + err.Error())
+		}
+		xmlSchema = s
+	})
+	return xmlSchema
+}
+
+// xincludeURL is the namespace of XInclude, includes are not expanded when
+// validating.
+const xincludeURL = "http://www.w3.org/2001/XInclude"
+
+// Validate validates doc, an XML document, against the schema. The violations
+// are returned as diagnostics with the path of the element they were found in.
+// When doc has source comments (see XML_SOURCE_COMMENTS), the diagnostics
+// refer to the source line of the block they are in, otherwise they refer to
+// the line in doc, with filename as the file. XIncludes are not expanded, the
+// xi:include elements are skipped.
+func (s *Schema) Validate(doc []byte, filename string) []Diagnostic {
+	// validating interns new patterns
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v := &validator{ps: s.ps, filename: filename}
+	d := xmllib.NewDecoder(strings.NewReader(string(doc)))
+	d.Entity = xmllib.HTMLEntity
+
+	p := s.start
+	var (
+		stack []*validatorFrame
+		text  strings.Builder
+
+		textLine, textColumn int
+	)
+	top := &validatorFrame{counts: make(map[string]int)}
+	for {
+		v.line, v.column = d.InputPos()
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			v.errorf(top, "xml", "%s", err)
+			return v.diags
+		}
+
+		switch tok := tok.(type) {
+		case xmllib.CharData:
+			if text.Len() == 0 {
+				textLine, textColumn = v.line, v.column
+			}
+			text.Write(tok)
+			continue
+		case xmllib.Comment:
+			v.sourceComment(string(tok))
+			continue
+		case xmllib.ProcInst, xmllib.Directive:
+			continue
+		}
+		if text.Len() > 0 {
+			line, column := v.line, v.column
+			v.line, v.column = textLine, textColumn
+			p = v.text(top, p, text.String())
+			v.line, v.column = line, column
+			text.Reset()
+		}
+
+		switch tok := tok.(type) {
+		case xmllib.StartElement:
+			if tok.Name.Space == xincludeURL {
+				d.Skip()
+				continue
+			}
+			name := elementName(tok.Name)
+			top.counts[name]++
+			frame := &validatorFrame{
+				path:   top.path + "/" + name + "[" + strconv.Itoa(top.counts[name]) + "]",
+				counts: make(map[string]int),
+			}
+
+			q := v.startTagOpen(p, tok.Name)
+			if q.kind == patternNotAllowed {
+				v.errorf(frame, "validate", "element %s not allowed here%s", name, expected(p.names(patternElement)))
+				d.Skip()
+				continue
+			}
+			for _, a := range tok.Attr {
+				if a.Name.Space == "xmlns" || a.Name.Space == "" && a.Name.Local == "xmlns" {
+					continue
+				}
+				r := v.attribute(q, a)
+				if r.kind == patternNotAllowed {
+					v.errorf(frame, "validate", "attribute %s=%q not allowed", elementName(a.Name), a.Value)
+					continue
+				}
+				q = r
+			}
+			r := v.startTagClose(q, false)
+			if r.kind == patternNotAllowed {
+				v.errorf(frame, "validate", "missing attribute%s", expected(unique(v.required(q))))
+				r = v.startTagClose(q, true)
+			}
+			p = r
+			stack = append(stack, top)
+			top = frame
+
+		case xmllib.EndElement:
+			q := v.endTag(p)
+			if q.kind == patternNotAllowed {
+				v.errorf(top, "validate", "element is incomplete%s", expected(p.names(patternElement)))
+				q = v.recoverEndTag(p)
+			}
+			p = q
+			top = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+		}
+	}
+	if !p.nullable {
+		v.errorf(top, "validate", "document is incomplete%s", expected(p.names(patternElement)))
+	}
+	return v.diags
+}
+
+// expected returns the names formatted for a diagnostic.
+func expected(names []string) string {
+	switch {
+	case len(names) == 0:
+		return ""
+	case len(names) > 8:
+		names = append(names[:8], "...")
+	}
+	return ", expected " + strings.Join(names, ", ")
+}
+
+// unique returns the sorted names, without duplicates.
+func unique(names []string) []string {
+	sort.Strings(names)
+	var u []string
+	for i, n := range names {
+		if i == 0 || n != names[i-1] {
+			u = append(u, n)
+		}
+	}
+	return u
+}
+
+// elementName returns the name of an element or attribute, as it is used in a
+// diagnostic.
+func elementName(n xmllib.Name) string {
+	return nameClass{space: n.Space, local: n.Local}.String()
+}
+
+// validatorFrame is an element being validated.
+type validatorFrame struct {
+	path   string
+	counts map[string]int // number of children seen with each name
+}
+
+type validator struct {
+	ps    *patterns
+	diags []Diagnostic
+
+	filename   string
+	line       int // position in the document
+	column     int
+	sourceFile string // source position of the last source comment
+	sourceLine int
+}
+
+func (v *validator) errorf(f *validatorFrame, code, format string, a ...interface{}) {
+	d := Diagnostic{Severity: SEVERITY_ERROR, Code: code, File: v.filename, Line: v.line, Column: v.column}
+	if v.sourceLine > 0 {
+		d.File, d.Line, d.Column = v.sourceFile, v.sourceLine, 1
+	}
+	d.Message = fmtPath(f.path) + ": " + fmt.Sprintf(format, a...)
+	v.diags = append(v.diags, d)
+}
+
+// fmtPath returns the path of an element for a diagnostic.
+func fmtPath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// sourceComment records the position in a source comment, as written by
+// sourceComment.
+func (v *validator) sourceComment(comment string) {
+	comment = strings.Replace(strings.TrimSpace(comment), "-\\-", "--", -1)
+	file, line := "", comment
+	if i := strings.LastIndexByte(comment, ':'); i >= 0 {
+		file, line = comment[:i], comment[i+1:]
+	}
+	if n, err := strconv.Atoi(line); err == nil && n > 0 {
+		v.sourceFile, v.sourceLine = file, n
+	}
+}
+
+// text validates the text s in p.
+func (v *validator) text(f *validatorFrame, p *pattern, s string) *pattern {
+	if strings.TrimSpace(s) == "" {
+		return v.ps.choice(p, v.textDeriv(p, s))
+	}
+	q := v.textDeriv(p, s)
+	if q.kind == patternNotAllowed {
+		s = strings.TrimSpace(s)
+		if len(s) > 20 {
+			s = s[:20] + "..."
+		}
+		v.errorf(f, "validate", "text %q not allowed here", s)
+		return p
+	}
+	return q
+}
+
+func (v *validator) textDeriv(p *pattern, s string) *pattern {
+	ps := v.ps
+	switch p.kind {
+	case patternChoice:
+		return ps.choice(v.textDeriv(p.p1, s), v.textDeriv(p.p2, s))
+	case patternInterleave:
+		return ps.choice(ps.interleave(v.textDeriv(p.p1, s), p.p2), ps.interleave(p.p1, v.textDeriv(p.p2, s)))
+	case patternGroup:
+		q := ps.group(v.textDeriv(p.p1, s), p.p2)
+		if p.p1.nullable {
+			return ps.choice(q, v.textDeriv(p.p2, s))
+		}
+		return q
+	case patternAfter:
+		return ps.after(v.textDeriv(p.p1, s), p.p2)
+	case patternOneOrMore:
+		return ps.group(v.textDeriv(p.p1, s), ps.choice(p, ps.empty))
+	case patternText:
+		return p
+	case patternValue:
+		if strings.Join(strings.Fields(s), " ") == strings.Join(strings.Fields(p.value), " ") {
+			return ps.empty
+		}
+	case patternData:
+		if datatypeAllows(p.value, s) {
+			return ps.empty
+		}
+	}
+	return ps.notAllowed
+}
+
+// datatypeAllows returns true if s is a valid value of datatype.
+func datatypeAllows(datatype, s string) bool {
+	switch datatype {
+	case "xsd:ID", "xsd:IDREF", "xsd:NCName":
+		return isNCName(strings.TrimSpace(s))
+	}
+	return true
+}
+
+// isNCName returns true if s is an XML name without a colon.
+func isNCName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if unicode.IsLetter(r) || r == '_' {
+			continue
+		}
+		if i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.' || unicode.Is(unicode.Mn, r)) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// applyAfter applies f to the patterns that follow the After patterns in p.
+func (v *validator) applyAfter(f func(*pattern) *pattern, p *pattern) *pattern {
+	switch p.kind {
+	case patternAfter:
+		return v.ps.after(p.p1, f(p.p2))
+	case patternChoice:
+		return v.ps.choice(v.applyAfter(f, p.p1), v.applyAfter(f, p.p2))
+	}
+	return v.ps.notAllowed
+}
+
+func (v *validator) startTagOpen(p *pattern, n xmllib.Name) *pattern {
+	ps := v.ps
+	switch p.kind {
+	case patternChoice:
+		return ps.choice(v.startTagOpen(p.p1, n), v.startTagOpen(p.p2, n))
+	case patternElement:
+		if p.name.contains(n.Space, n.Local) {
+			return ps.after(p.element.content, ps.empty)
+		}
+	case patternInterleave:
+		return ps.choice(
+			v.applyAfter(func(q *pattern) *pattern { return ps.interleave(q, p.p2) }, v.startTagOpen(p.p1, n)),
+			v.applyAfter(func(q *pattern) *pattern { return ps.interleave(p.p1, q) }, v.startTagOpen(p.p2, n)))
+	case patternOneOrMore:
+		return v.applyAfter(func(q *pattern) *pattern { return ps.group(q, ps.choice(p, ps.empty)) }, v.startTagOpen(p.p1, n))
+	case patternGroup:
+		q := v.applyAfter(func(q *pattern) *pattern { return ps.group(q, p.p2) }, v.startTagOpen(p.p1, n))
+		if p.p1.nullable {
+			return ps.choice(q, v.startTagOpen(p.p2, n))
+		}
+		return q
+	case patternAfter:
+		return v.applyAfter(func(q *pattern) *pattern { return ps.after(q, p.p2) }, v.startTagOpen(p.p1, n))
+	}
+	return ps.notAllowed
+}
+
+func (v *validator) attribute(p *pattern, a xmllib.Attr) *pattern {
+	ps := v.ps
+	switch p.kind {
+	case patternAfter:
+		return ps.after(v.attribute(p.p1, a), p.p2)
+	case patternChoice:
+		return ps.choice(v.attribute(p.p1, a), v.attribute(p.p2, a))
+	case patternGroup:
+		return ps.choice(ps.group(v.attribute(p.p1, a), p.p2), ps.group(p.p1, v.attribute(p.p2, a)))
+	case patternInterleave:
+		return ps.choice(ps.interleave(v.attribute(p.p1, a), p.p2), ps.interleave(p.p1, v.attribute(p.p2, a)))
+	case patternOneOrMore:
+		return ps.group(v.attribute(p.p1, a), ps.choice(p, ps.empty))
+	case patternAttribute:
+		if p.name.contains(a.Name.Space, a.Name.Local) && v.valueMatch(p.p1, a.Value) {
+			return ps.empty
+		}
+	}
+	return ps.notAllowed
+}
+
+func (v *validator) valueMatch(p *pattern, s string) bool {
+	return (p.nullable && strings.TrimSpace(s) == "") || v.textDeriv(p, s).nullable
+}
+
+// startTagClose returns p without its attributes, which are missing. If
+// lenient is true, missing attributes are ignored.
+func (v *validator) startTagClose(p *pattern, lenient bool) *pattern {
+	ps := v.ps
+	switch p.kind {
+	case patternAfter:
+		return ps.after(v.startTagClose(p.p1, lenient), p.p2)
+	case patternChoice:
+		return ps.choice(v.startTagClose(p.p1, lenient), v.startTagClose(p.p2, lenient))
+	case patternGroup:
+		return ps.group(v.startTagClose(p.p1, lenient), v.startTagClose(p.p2, lenient))
+	case patternInterleave:
+		return ps.interleave(v.startTagClose(p.p1, lenient), v.startTagClose(p.p2, lenient))
+	case patternOneOrMore:
+		return ps.oneOrMore(v.startTagClose(p.p1, lenient))
+	case patternAttribute:
+		if lenient {
+			return ps.empty
+		}
+		return ps.notAllowed
+	}
+	return p
+}
+
+// required returns the names of the attributes that p requires.
+func (v *validator) required(p *pattern) []string {
+	var names []string
+	switch p.kind {
+	case patternAttribute:
+		return []string{p.name.String()}
+	case patternChoice:
+		n1, n2 := v.required(p.p1), v.required(p.p2)
+		if len(n1) == 0 || len(n2) == 0 {
+			return nil
+		}
+		names = append(n1, n2...)
+	case patternGroup, patternInterleave:
+		names = append(v.required(p.p1), v.required(p.p2)...)
+	case patternOneOrMore, patternAfter:
+		return v.required(p.p1)
+	}
+	return names
+}
+
+func (v *validator) endTag(p *pattern) *pattern {
+	switch p.kind {
+	case patternChoice:
+		return v.ps.choice(v.endTag(p.p1), v.endTag(p.p2))
+	case patternAfter:
+		if p.p1.nullable {
+			return p.p2
+		}
+	}
+	return v.ps.notAllowed
+}
+
+// recoverEndTag returns what follows the element that ends, even though it
+// is incomplete.
+func (v *validator) recoverEndTag(p *pattern) *pattern {
+	switch p.kind {
+	case patternChoice:
+		return v.ps.choice(v.recoverEndTag(p.p1), v.recoverEndTag(p.p2))
+	case patternAfter:
+		return p.p2
+	}
+	return v.ps.notAllowed
+}
@@ -0,0 +1,135 @@
+// Unit tests for validating xml2rfc v3 output
+
+package mmark
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	var tests = []struct {
+		doc      string
+		expected []string
+	}{
+		{
+			`<rfc><front><title>x</title><author/></front><middle><section><t>x</t></section></middle></rfc>`,
+			nil,
+		},
+		{
+			`<rfc xmlns:xi="http://www.w3.org/2001/XInclude"><front><title>x</title><author/></front><middle><section/></middle>
+<back><references><xi:include href="reference.RFC.2119.xml"/></references></back></rfc>`,
+			nil,
+		},
+		{
+			`<rfc><front><title>x</title></front></rfc>`,
+			[]string{
+				"doc.xml:1:29: /rfc[1]/front[1]: element is incomplete, expected author",
+				"doc.xml:1:37: /rfc[1]: element is incomplete, expected middle",
+			},
+		},
+		{
+			`<rfc ipr="bogus"><front><title>x</title><author/><bogus/></front>
+<middle><section anchor="a b"><t>x</t></section></middle></rfc>`,
+			[]string{
+				`doc.xml:1:1: /rfc[1]: attribute ipr="bogus" not allowed`,
+				"doc.xml:1:50: /rfc[1]/front[1]/bogus[1]: element bogus not allowed here, expected abstract, area, author, boilerplate, date, keyword, note, seriesInfo, ...",
+				`doc.xml:2:9: /rfc[1]/middle[1]/section[1]: attribute anchor="a b" not allowed`,
+			},
+		},
+		{
+			`<rfc><front><title>x</title><author/></front><middle><section>text<t/></section><section/></middle></rfc>`,
+			[]string{
+				`doc.xml:1:63: /rfc[1]/middle[1]/section[1]: text "text" not allowed here`,
+			},
+		},
+		{
+			`<rfc><front><title>x</title><author/></front><middle>
+<!-- doc.md:12 -->
+<section><xref/></section></middle></rfc>`,
+			[]string{
+				"doc.md:12:1: /rfc[1]/middle[1]/section[1]/xref[1]: element xref not allowed here, expected artwork, aside, blockquote, dl, figure, iref, name, ol, ...",
+			},
+		},
+		{
+			`<rfc><front><title>x</title><author/></front><middle><section><t><xref/></t></section></middle></rfc>`,
+			[]string{
+				"doc.xml:1:66: /rfc[1]/middle[1]/section[1]/t[1]/xref[1]: missing attribute, expected target",
+			},
+		},
+		{
+			`<rfc><front>`,
+			[]string{
+				"doc.xml:1:13: /rfc[1]/front[1]: XML syntax error on line 1: unexpected EOF",
+			},
+		},
+	}
+	for _, test := range tests {
+		diags := XmlSchema().Validate([]byte(test.doc), "doc.xml")
+		if len(diags) != len(test.expected) {
+			t.Errorf("expected %d diagnostics, got %d: %v", len(test.expected), len(diags), diags)
+			continue
+		}
+		for i, d := range diags {
+			if d.String() != test.expected[i] {
+				t.Errorf("expected %q, got %q", test.expected[i], d.String())
+			}
+		}
+	}
+}
+
+func TestValidateRFCs(t *testing.T) {
+	files, err := filepath.Glob("rfc/*.md")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no samples found: %v", err)
+	}
+	extensions := commonXmlExtensions | EXTENSION_TITLEBLOCK_TOML | EXTENSION_INCLUDE | EXTENSION_TABLES | EXTENSION_FENCED_CODE
+	for _, f := range files {
+		input, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc := ParseDocument(input, f, XmlRenderer(XML_STANDALONE|XML_SOURCE_COMMENTS), extensions).Output.Bytes()
+		for _, d := range XmlSchema().Validate(doc, f) {
+			t.Errorf("%s", d)
+		}
+	}
+}
+
+func TestValidateTitleBlock(t *testing.T) {
+	input := "%%%\ntitle = \"T\"\nupdates = [1035]\nobsoletes = [2136, 3007]\n[[author]]\nsurname = \"S\"\n%%%\n\n{mainmatter}\n\n# Intro\n\nText.\n"
+	doc := ParseDocument([]byte(input), "", XmlRenderer(XML_STANDALONE), commonXmlExtensions|EXTENSION_TITLEBLOCK_TOML).Output.String()
+	if !strings.Contains(doc, ` updates="1035" obsoletes="2136, 3007">`) {
+		t.Errorf("expected updates and obsoletes in the rfc tag:\n%s", doc)
+	}
+	for _, d := range XmlSchema().Validate([]byte(doc), "") {
+		t.Errorf("%s", d)
+	}
+}
+
+func TestParseSchema(t *testing.T) {
+	var tests = []struct {
+		schema string
+		err    string
+	}{
+		{`start = a a = element a { empty }`, ""},
+		{`start = a`, "reference to undefined pattern a"},
+		{`a = element a { empty }`, "line 1: no start pattern"},
+		{`start = a a = a | b b = a`, "recursive reference to a outside of an element"},
+		{`start = element a { p:x }`, ""},
+		{`start = element p:a { empty }`, `line 1: undeclared namespace prefix "p"`},
+		{`start = element a { text, b | c }`, `line 1: mixing "," and "|" needs parentheses`},
+		{`include "x.rnc"`, "line 1: include is not supported"},
+	}
+	for _, test := range tests {
+		_, err := ParseSchema([]byte(test.schema))
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%q: unexpected error: %s", test.schema, err)
+		case test.err != "" && (err == nil || err.Error() != test.err):
+			t.Errorf("%q: expected error %q, got %v", test.schema, test.err, err)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -41,22 +42,27 @@ const (
 // create http://<CitationsID>/reference.I-D.draft-ietf-dane-openpgpkey-02.xml
 // without an sequence number it becomes:
 // http://<CitationsID>/reference.I-D.ietf-dane-openpgpkey.xml
-func referenceFile(c *citation) string {
+// The URLs are taken from the options of p, or the defaults if p is nil.
+func referenceFile(p *parser, c *Citation) string {
 	if len(c.link) < 4 {
 		return ""
 	}
+	o := Options{}.defaults()
+	if p != nil {
+		o = p.opts
+	}
 	switch {
 	case bytes.HasPrefix(c.link, []byte("RFC")):
-		return CitationsRFC + referenceRFC + string(c.link[3:]) + ext
+		return o.CitationsRFC + referenceRFC + string(c.link[3:]) + ext
 	case bytes.HasPrefix(c.link, []byte("I-D")):
 		seq := ""
 		if c.seq != -1 {
 			seq = "-" + fmt.Sprintf("%02d", c.seq)
-			return CitationsID + referenceID + string(c.link[4:]) + seq + ext
+			return o.CitationsID + referenceID + string(c.link[4:]) + seq + ext
 		}
-		return CitationsID + referenceIDLatest + string(c.link[4:]) + ext
+		return o.CitationsID + referenceIDLatest + string(c.link[4:]) + ext
 	case bytes.HasPrefix(c.link, []byte("W3C")):
-		return CitationsW3C + referenceW3C + string(c.link) + ext
+		return o.CitationsW3C + referenceW3C + string(c.link) + ext
 	case bytes.HasPrefix(c.link, []byte("ANSI")):
 		fallthrough
 	case bytes.HasPrefix(c.link, []byte("CCITT")):
@@ -70,7 +76,7 @@ func referenceFile(c *citation) string {
 	case bytes.HasPrefix(c.link, []byte("ITU")):
 		fallthrough
 	case bytes.HasPrefix(c.link, []byte("PKCS")):
-		return CitationsANSI + referenceANSI + string(c.link) + ext
+		return o.CitationsANSI + referenceANSI + string(c.link) + ext
 
 	}
 	return ""
@@ -78,7 +84,7 @@ func referenceFile(c *citation) string {
 
 // countCitationsAndSort returns the number of informative and normative
 // references and a string slice with the sorted keys.
-func countCitationsAndSort(citations map[string]*citation) (int, int, []string) {
+func countCitationsAndSort(citations map[string]*Citation) (int, int, []string) {
 	keys := make([]string, 0, len(citations))
 	refi, refn := 0, 0
 	for k, c := range citations {
@@ -154,7 +160,7 @@ func writeSanitizeXML(out *bytes.Buffer, s []byte) {
 }
 
 // titleBlockTOMLAuthor outputs the author from the TOML title block.
-func titleBlockTOMLAuthor(out *bytes.Buffer, a author) {
+func titleBlockTOMLAuthor(out *bytes.Buffer, a Author) {
 	out.WriteString("<author")
 
 	out.WriteString(" initials=\"")
@@ -261,10 +267,17 @@ func titleBlockTOMLKeyword(out *bytes.Buffer, keywords []string) {
 	}
 }
 
+// sourceComment returns an XML comment holding the source position of an
+// element, used by the *_SOURCE_COMMENTS flags.
+func sourceComment(file string, line int) string {
+	// "--" is not allowed inside a comment
+	return "<!-- " + strings.Replace(sourcePosition(file, line), "--", "-\\-", -1) + " -->\n"
+}
+
 // titleBlockTOMLPI returns "yes" or "no" or a stringified number
 // for use as process instruction. If version is 3 they are returned
 // as attributes for use *inside* the <rfc> tag.
-func titleBlockTOMLPI(pi pi, name string, version int) string {
+func titleBlockTOMLPI(p *parser, pi PI, name string, version int) string {
 	if version == 2 {
 		switch name {
 		case "toc":
@@ -294,7 +307,7 @@ func titleBlockTOMLPI(pi pi, name string, version int) string {
 			}
 			return "<?rfc footer=\"" + pi.Footer + "\"?>\n"
 		default:
-			printf(nil, "unhandled or unknown PI seen: %s", name)
+			warnf(p, "pi", "unhandled or unknown PI seen: %s", name)
 			return ""
 		}
 	}
@@ -311,3 +324,186 @@ func yesno(s, def string) string {
 	}
 	return "no"
 }
+
+// What the xml2rfc renderers do with footnotes, as these have no footnote
+// element.
+const (
+	footnoteSection = iota // collect the notes in a Notes section in the back matter
+	footnoteCref           // turn each note into a <cref> where it is referenced
+	footnoteInline         // put the note in parentheses where it is referenced
+)
+
+// footnotes collects the footnotes of a document for the xml2rfc renderers.
+// The notes are rendered after the text that references them, so for the cref
+// and inline strategies references are written as a marker that is replaced
+// when the document is finished.
+type footnotes struct {
+	strategy int
+	notes    [][]byte       // rendered notes, in order of their number
+	blocks   []bool         // note contains block elements
+	backlink map[int]string // anchor of the paragraph with the first reference to a note
+	pending  []int          // notes referenced in the current paragraph
+}
+
+func newFootnotes(strategy int) *footnotes {
+	return &footnotes{strategy: strategy, backlink: make(map[int]string)}
+}
+
+func footnoteAnchor(id int) string { return "fn-" + strconv.Itoa(id) }
+
+func footnoteMarker(id int) string { return "\x00fn" + strconv.Itoa(id) + "\x00" }
+
+// ref writes the reference to note id, xref is the cross reference to use
+// with the Notes section.
+func (f *footnotes) ref(out *bytes.Buffer, id int, xref string) {
+	if f.strategy == footnoteSection {
+		f.pending = append(f.pending, id)
+		out.WriteString(xref)
+		return
+	}
+	out.WriteString(footnoteMarker(id))
+}
+
+// paragraph is called at the end of a paragraph, at is the offset in out just
+// after the "<t" of its start tag, or -1 if it has none. If the paragraph references notes that have
+// no back link yet, the paragraph gets an anchor for the Notes section to link
+// back to.
+func (f *footnotes) paragraph(out *bytes.Buffer, at int) {
+	pending := f.pending
+	f.pending = nil
+	if at < 0 || at > out.Len() {
+		return
+	}
+	anchor := ""
+	for _, id := range pending {
+		if _, ok := f.backlink[id]; ok {
+			continue
+		}
+		if anchor == "" {
+			anchor = "fnref-" + strconv.Itoa(id)
+		}
+		f.backlink[id] = anchor
+	}
+	if anchor == "" {
+		return
+	}
+	tail := append([]byte(nil), out.Bytes()[at:]...)
+	out.Truncate(at)
+	out.WriteString(" anchor=\"" + anchor + "\"")
+	out.Write(tail)
+}
+
+// item records the rendered text of the next note.
+func (f *footnotes) item(text []byte, flags int) {
+	f.notes = append(f.notes, append([]byte(nil), bytes.TrimSpace(text)...))
+	f.blocks = append(f.blocks, flags&LIST_ITEM_CONTAINS_BLOCK != 0)
+}
+
+// flat returns note id as a single line of text, without markup.
+func (f *footnotes) flat(id int) []byte {
+	text := append([]byte(nil), f.notes[id-1]...)
+	return bytes.Join(bytes.Fields(sanitizeXML(text)), []byte(" "))
+}
+
+// section writes the Notes section, v3 selects the xml2rfc v3 vocabulary.
+func (f *footnotes) section(out *bytes.Buffer, v3 bool) {
+	if f.strategy != footnoteSection || len(f.notes) == 0 {
+		return
+	}
+	format := ""
+	if v3 {
+		out.WriteString("\n<section anchor=\"notes\">\n<name>Notes</name>\n")
+		format = " format=\"none\""
+	} else {
+		out.WriteString("\n<section anchor=\"notes\" title=\"Notes\">\n")
+	}
+	for i, note := range f.notes {
+		id := i + 1
+		back := ""
+		if anchor, ok := f.backlink[id]; ok {
+			back = " (<xref target=\"" + anchor + "\"" + format + ">back</xref>)"
+		}
+		out.WriteString("<t anchor=\"" + footnoteAnchor(id) + "\">[" + strconv.Itoa(id) + "]")
+		if f.blocks[i] {
+			out.WriteString(back + "</t>\n")
+			out.Write(note)
+			out.WriteByte('\n')
+			continue
+		}
+		out.WriteByte(' ')
+		out.Write(note)
+		out.WriteString(back + "</t>\n")
+	}
+	out.WriteString("</section>\n")
+}
+
+// replace replaces the note markers in out with the notes, v3 selects the
+// xml2rfc v3 vocabulary. Markers in the notes themselves are dropped.
+func (f *footnotes) replace(out *bytes.Buffer, v3 bool) {
+	if f.strategy == footnoteSection || !bytes.Contains(out.Bytes(), []byte("\x00fn")) {
+		return
+	}
+	data := append([]byte(nil), out.Bytes()...)
+	out.Reset()
+	seen := make(map[int]bool)
+	for {
+		i := bytes.Index(data, []byte("\x00fn"))
+		if i < 0 {
+			out.Write(data)
+			return
+		}
+		out.Write(data[:i])
+		data = data[i+3:]
+		end := bytes.IndexByte(data, 0)
+		if end < 0 {
+			out.Write(data)
+			return
+		}
+		id, err := strconv.Atoi(string(data[:end]))
+		data = data[end+1:]
+		if err != nil || id < 1 || id > len(f.notes) {
+			continue
+		}
+
+		note := f.notes[id-1]
+		if f.blocks[id-1] || !v3 {
+			note = f.flat(id)
+		}
+		if bytes.Contains(note, []byte("\x00fn")) {
+			note = dropFootnoteMarkers(f.flat(id))
+		}
+		switch f.strategy {
+		case footnoteCref:
+			out.WriteString("<cref")
+			if !seen[id] {
+				out.WriteString(" anchor=\"" + footnoteAnchor(id) + "\"")
+			}
+			out.WriteString(">")
+			out.Write(note)
+			out.WriteString("</cref>")
+		case footnoteInline:
+			if b := out.Bytes(); len(b) > 0 && b[len(b)-1] != ' ' && b[len(b)-1] != '\n' {
+				out.WriteByte(' ')
+			}
+			out.WriteString("(")
+			out.Write(note)
+			out.WriteString(")")
+		}
+		seen[id] = true
+	}
+}
+
+// dropFootnoteMarkers removes the note markers from text.
+func dropFootnoteMarkers(text []byte) []byte {
+	for {
+		i := bytes.Index(text, []byte("\x00fn"))
+		if i < 0 {
+			return text
+		}
+		end := bytes.IndexByte(text[i+1:], 0)
+		if end < 0 {
+			return text[:i]
+		}
+		text = append(text[:i], text[i+1+end+1:]...)
+	}
+}
@@ -12,7 +12,10 @@ import (
 
 // XML renderer configuration options.
 const (
-	XML2_STANDALONE = 1 << iota // create standalone document
+	XML2_STANDALONE       = 1 << iota // create standalone document
+	XML2_SOURCE_COMMENTS              // add a comment with the source position before block elements
+	XML2_FOOTNOTES_CREF               // render footnotes as <cref> instead of a Notes section
+	XML2_FOOTNOTES_INLINE             // render footnotes in parentheses instead of a Notes section
 )
 
 // Xml2 is a type that implements the Renderer interface for XML2RFV3 output.
@@ -27,13 +30,22 @@ type xml2 struct {
 	paraInList     bool // subsequent paras in lists are faked with vspace
 
 	// store the IAL we see for this block element
-	ial *inlineAttr
+	ial *Attributes
 
 	// titleBlock in TOML
-	titleBlock *title
+	titleBlock *Title
+
+	// parser that drives this renderer, used for reporting problems
+	p *parser
+
+	// source position comment for the next block element, see XML2_SOURCE_COMMENTS
+	source string
 
 	// (@good) example list group counter
 	group map[string]int
+
+	// footnotes, see XML2_FOOTNOTES_*
+	footnotes *footnotes
 }
 
 // Xml2Renderer creates and configures a Xml2 object, which
@@ -41,23 +53,46 @@ type xml2 struct {
 //
 // flags is a set of XML2_* options ORed together
 func Xml2Renderer(flags int) Renderer {
-	return &xml2{flags: flags, group: make(map[string]int)}
+	strategy := footnoteSection
+	switch {
+	case flags&XML2_FOOTNOTES_CREF != 0:
+		strategy = footnoteCref
+	case flags&XML2_FOOTNOTES_INLINE != 0:
+		strategy = footnoteInline
+	}
+	return &xml2{flags: flags, group: make(map[string]int), footnotes: newFootnotes(strategy)}
 }
 func (options *xml2) Flags() int { return options.flags }
 func (options *xml2) State() int { return 0 }
 
-func (options *xml2) SetAttr(i *inlineAttr) {
+func (options *xml2) setParser(p *parser) { options.p = p }
+
+func (options *xml2) Source(file string, line int) {
+	options.source = ""
+	if options.flags&XML2_SOURCE_COMMENTS != 0 && line > 0 {
+		options.source = sourceComment(file, line)
+	}
+}
+
+// writeSource writes the source position comment for the block element being
+// rendered.
+func (options *xml2) writeSource(out *bytes.Buffer) {
+	out.WriteString(options.source)
+	options.source = ""
+}
+
+func (options *xml2) SetAttr(i *Attributes) {
 	options.ial = i
 }
 
-func (options *xml2) Attr() *inlineAttr {
+func (options *xml2) Attr() *Attributes {
 	if options.ial == nil {
-		return newInlineAttr()
+		return NewAttributes()
 	}
 	return options.ial
 }
 
-func (options *xml2) AttrString(i *inlineAttr) string {
+func (options *xml2) AttrString(i *Attributes) string {
 	if i == nil {
 		return ""
 	}
@@ -85,10 +120,11 @@ func (options *xml2) AttrString(i *inlineAttr) string {
 
 // render code chunks using verbatim, or listings if we have a language
 func (options *xml2) BlockCode(out *bytes.Buffer, text []byte, lang string, caption []byte, subfigure, callout bool) {
+	options.writeSource(out)
 	ial := options.Attr()
 	ial.GetOrDefaultAttr("align", "center")
 
-	ialArtwork := newInlineAttr()
+	ialArtwork := NewAttributes()
 
 	prefix := ial.Value("prefix")
 
@@ -137,7 +173,7 @@ func (options *xml2) CalloutText(out *bytes.Buffer, index string, id []string) {
 	out.WriteByte(')')
 }
 
-func (options *xml2) TitleBlockTOML(out *bytes.Buffer, block *title) {
+func (options *xml2) TitleBlockTOML(out *bytes.Buffer, block *Title) {
 	if options.flags&XML2_STANDALONE == 0 {
 		return
 	}
@@ -164,11 +200,11 @@ func (options *xml2) TitleBlockTOML(out *bytes.Buffer, block *title) {
 
 	// Default processing instructions
 	for _, p := range PIs {
-		out.WriteString(titleBlockTOMLPI(options.titleBlock.PI, p, 2))
+		out.WriteString(titleBlockTOMLPI(options.p, options.titleBlock.PI, p, 2))
 	}
 
 	out.WriteString("<front>\n")
-	options.docLevel = _DOC_FRONT_MATTER
+	options.docLevel = DOC_FRONT_MATTER
 	out.WriteString("<title abbrev=\"" + options.titleBlock.Abbrev + "\">")
 	out.WriteString(options.titleBlock.Title + "</title>\n\n")
 
@@ -191,6 +227,7 @@ func (options *xml2) BlockQuote(out *bytes.Buffer, text []byte, attribution []by
 	// TODO(miek): IAL, clear them for now
 	options.Attr()
 
+	options.writeSource(out)
 	out.WriteString("<t><list style=\"empty\">\n")
 	out.Write(text)
 
@@ -259,11 +296,11 @@ func (options *xml2) CommentHtml(out *bytes.Buffer, text []byte) {
 }
 
 func (options *xml2) BlockHtml(out *bytes.Buffer, text []byte) {
-	printf(nil, "syntax not supported: BlockHtml")
+	warnf(options.p, "unsupported", "syntax not supported: BlockHtml")
 }
 
 func (options *xml2) Part(out *bytes.Buffer, text func() bool, id string) {
-	printf(nil, "syntax not supported: Part")
+	warnf(options.p, "unsupported", "syntax not supported: Part")
 }
 
 func (options *xml2) Note(out *bytes.Buffer, text func() bool, id string) {
@@ -295,7 +332,7 @@ func (options *xml2) Note(out *bytes.Buffer, text func() bool, id string) {
 
 func (options *xml2) SpecialHeader(out *bytes.Buffer, what []byte, text func() bool, id string) {
 	if string(what) == "preface" {
-		printf(nil, "handling preface like abstract")
+		infof(options.p, "section", "handling preface like abstract")
 		what = []byte("abstract")
 	}
 	switch options.specialSection {
@@ -330,7 +367,7 @@ func (options *xml2) Header(out *bytes.Buffer, text func() bool, level int, id s
 	}
 
 	if level > options.sectionLevel+1 {
-		printf(nil, "section jump from H%d to H%d, id: \"%s\"", options.sectionLevel, level, id)
+		warnf(options.p, "section", "section jump from H%d to H%d, id: \"%s\"", options.sectionLevel, level, id)
 	}
 
 	if level <= options.sectionLevel {
@@ -346,7 +383,9 @@ func (options *xml2) Header(out *bytes.Buffer, text func() bool, level int, id s
 	ial.KeepClass(nil)
 
 	// new section
-	out.WriteString("\n<section" + options.AttrString(ial))
+	out.WriteString("\n")
+	options.writeSource(out)
+	out.WriteString("<section" + options.AttrString(ial))
 	out.WriteString(" title=\"")
 	text()
 	out.WriteString("\">\n")
@@ -356,13 +395,14 @@ func (options *xml2) Header(out *bytes.Buffer, text func() bool, level int, id s
 }
 
 func (options *xml2) HRule(out *bytes.Buffer) {
-	printf(nil, "syntax not supported: HRule")
+	warnf(options.p, "unsupported", "syntax not supported: HRule")
 }
 
 func (options *xml2) List(out *bytes.Buffer, text func() bool, flags, start int, group []byte) {
 	marker := out.Len()
+	options.writeSource(out)
 	// inside lists we must drop the paragraph
-	if flags&_LIST_INSIDE_LIST == 0 {
+	if flags&LIST_INSIDE_LIST == 0 {
 		out.WriteString("<t>\n")
 	}
 
@@ -375,17 +415,17 @@ func (options *xml2) List(out *bytes.Buffer, text func() bool, flags, start int,
 	// group -> current number in options
 
 	switch {
-	case flags&_LIST_TYPE_ORDERED != 0:
+	case flags&LIST_TYPE_ORDERED != 0:
 		switch {
-		case flags&_LIST_TYPE_ORDERED_ALPHA_LOWER != 0:
+		case flags&LIST_TYPE_ORDERED_ALPHA_LOWER != 0:
 			ial.GetOrDefaultAttr("style", "format %c")
-		case flags&_LIST_TYPE_ORDERED_ALPHA_UPPER != 0:
+		case flags&LIST_TYPE_ORDERED_ALPHA_UPPER != 0:
 			ial.GetOrDefaultAttr("style", "format %C")
-		case flags&_LIST_TYPE_ORDERED_ROMAN_LOWER != 0:
+		case flags&LIST_TYPE_ORDERED_ROMAN_LOWER != 0:
 			ial.GetOrDefaultAttr("style", "format %i")
-		case flags&_LIST_TYPE_ORDERED_ROMAN_UPPER != 0:
+		case flags&LIST_TYPE_ORDERED_ROMAN_UPPER != 0:
 			ial.GetOrDefaultAttr("style", "format %I")
-		case flags&_LIST_TYPE_ORDERED_GROUP != 0:
+		case flags&LIST_TYPE_ORDERED_GROUP != 0:
 
 			if group != nil {
 				// don't think we need ++ this.
@@ -396,7 +436,7 @@ func (options *xml2) List(out *bytes.Buffer, text func() bool, flags, start int,
 		default:
 			ial.GetOrDefaultAttr("style", "numbers")
 		}
-	case flags&_LIST_TYPE_DEFINITION != 0:
+	case flags&LIST_TYPE_DEFINITION != 0:
 		ial.GetOrDefaultAttr("style", "hanging")
 	default:
 		ial.GetOrDefaultAttr("style", "symbols")
@@ -409,26 +449,26 @@ func (options *xml2) List(out *bytes.Buffer, text func() bool, flags, start int,
 		return
 	}
 	switch {
-	case flags&_LIST_TYPE_ORDERED != 0:
+	case flags&LIST_TYPE_ORDERED != 0:
 		out.WriteString("</list>\n")
-	case flags&_LIST_TYPE_DEFINITION != 0:
+	case flags&LIST_TYPE_DEFINITION != 0:
 		out.WriteString("</t>\n</list>\n")
 	default:
 		out.WriteString("</list>\n")
 	}
 
-	if flags&_LIST_INSIDE_LIST == 0 {
+	if flags&LIST_INSIDE_LIST == 0 {
 		out.WriteString("</t>\n")
 	}
 }
 
 func (options *xml2) ListItem(out *bytes.Buffer, text []byte, flags int) {
-	if flags&_LIST_TYPE_DEFINITION != 0 && flags&_LIST_TYPE_TERM == 0 {
+	if flags&LIST_TYPE_DEFINITION != 0 && flags&LIST_TYPE_TERM == 0 {
 		out.Write(text)
 		return
 	}
-	if flags&_LIST_TYPE_TERM != 0 {
-		if flags&_LIST_ITEM_BEGINNING_OF_LIST == 0 {
+	if flags&LIST_TYPE_TERM != 0 {
+		if flags&LIST_ITEM_BEGINNING_OF_LIST == 0 {
 			out.WriteString("</t>\n")
 		}
 		// close previous one?/
@@ -436,7 +476,7 @@ func (options *xml2) ListItem(out *bytes.Buffer, text []byte, flags int) {
 		n := out.Len()
 		writeSanitizeXML(out, text)
 		if n == out.Len() {
-			printf(nil, "no text remained after sanitizing XML for definition term: '"+string(text)+"'")
+			warnf(options.p, "sanitize", "no text remained after sanitizing XML for definition term: '%s'", text)
 		}
 		out.WriteString("\">\n")
 		out.WriteString("<vspace />\n") // Align HTML and XML2 output, but inserting a new line (vspace here)
@@ -456,10 +496,15 @@ func (options *xml2) Example(out *bytes.Buffer, index int) {
 
 func (options *xml2) Paragraph(out *bytes.Buffer, text func() bool, flags int) {
 	marker := out.Len()
-	if flags&_LIST_TYPE_DEFINITION == 0 && flags&_LIST_INSIDE_LIST == 0 {
+	tag := -1 // offset of the end of "<t", if written
+	options.footnotes.pending = nil
+	if flags&LIST_TYPE_DEFINITION == 0 && flags&LIST_INSIDE_LIST == 0 {
+		options.writeSource(out)
+		marker = out.Len()
 		out.WriteString("<t>")
+		tag = marker + len("<t")
 	} else {
-		if options.paraInList && flags&_LIST_ITEM_BEGINNING_OF_LIST != 0 {
+		if options.paraInList && flags&LIST_ITEM_BEGINNING_OF_LIST != 0 {
 			out.WriteString("<vspace blankLines=\"1\" />\n")
 		}
 	}
@@ -472,11 +517,12 @@ func (options *xml2) Paragraph(out *bytes.Buffer, text func() bool, flags int) {
 		return
 	}
 	out.WriteByte('\n')
-	if flags&_LIST_TYPE_DEFINITION == 0 && flags&_LIST_INSIDE_LIST == 0 {
+	if flags&LIST_TYPE_DEFINITION == 0 && flags&LIST_INSIDE_LIST == 0 {
 		out.WriteString("</t>\n")
 	} else {
 		options.paraInList = true
 	}
+	options.footnotes.paragraph(out, tag)
 }
 
 func (options *xml2) Math(out *bytes.Buffer, text []byte, display bool) {
@@ -491,6 +537,7 @@ func (options *xml2) Table(out *bytes.Buffer, header []byte, body []byte, footer
 	}
 
 	s := options.AttrString(ial)
+	options.writeSource(out)
 	out.WriteString("<texttable" + s + ">\n")
 	out.Write(header)
 	out.Write(body)
@@ -505,13 +552,13 @@ func (options *xml2) TableRow(out *bytes.Buffer, text []byte) {
 
 func (options *xml2) TableHeaderCell(out *bytes.Buffer, text []byte, align, colspan int) {
 	if colspan > 1 {
-		printf(nil, "syntax not supported: TableHeaderCell: colspan=%d", colspan)
+		warnf(options.p, "unsupported", "syntax not supported: TableHeaderCell: colspan=%d", colspan)
 	}
 	a := ""
 	switch align {
-	case _TABLE_ALIGNMENT_LEFT:
+	case TABLE_ALIGNMENT_LEFT:
 		a = " align=\"left\""
-	case _TABLE_ALIGNMENT_RIGHT:
+	case TABLE_ALIGNMENT_RIGHT:
 		a = " align=\"right\""
 	default:
 		a = " align=\"center\""
@@ -523,28 +570,35 @@ func (options *xml2) TableHeaderCell(out *bytes.Buffer, text []byte, align, cols
 
 func (options *xml2) TableCell(out *bytes.Buffer, text []byte, align, colspan int) {
 	if colspan > 1 {
-		printf(nil, "syntax not supported: TableCell: colspan=%d", colspan)
+		warnf(options.p, "unsupported", "syntax not supported: TableCell: colspan=%d", colspan)
 	}
 	out.WriteString("<c>")
 	out.Write(text)
 	out.WriteString("</c>")
 }
 
+// Footnotes collects the notes, they are written out in DocumentFooter.
 func (options *xml2) Footnotes(out *bytes.Buffer, text func() bool) {
-	printf(nil, "syntax not supported: Footnotes")
+	text()
 }
 
 func (options *xml2) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int) {
-	printf(nil, "syntax not supported: FootnoteItem")
+	options.footnotes.item(text, flags)
 }
 
-func (options *xml2) Index(out *bytes.Buffer, primary, secondary []byte, prim bool) {
+func (options *xml2) Index(out *bytes.Buffer, entry IndexEntry) {
+	if len(entry.See) > 0 {
+		return
+	}
 	p := ""
-	if prim {
+	if entry.Prim {
 		p = " primary=\"true\""
 	}
-	out.WriteString("<iref item=\"" + string(primary) + "\"" + p)
-	out.WriteString(" subitem=\"" + string(secondary) + "\"" + "/>")
+	out.WriteString("<iref item=\"")
+	attrEscape(out, entry.Primary)
+	out.WriteString("\"" + p + " subitem=\"")
+	attrEscape(out, indexSubitem(entry))
+	out.WriteString("\"/>")
 }
 
 func (options *xml2) Citation(out *bytes.Buffer, link, title []byte) {
@@ -555,7 +609,7 @@ func (options *xml2) Citation(out *bytes.Buffer, link, title []byte) {
 	out.WriteString("<xref target=\"" + string(link) + "\"/>")
 }
 
-func (options *xml2) References(out *bytes.Buffer, citations map[string]*citation) {
+func (options *xml2) References(out *bytes.Buffer, citations map[string]*Citation) {
 	if options.flags&XML2_STANDALONE == 0 {
 		return
 	}
@@ -565,16 +619,16 @@ func (options *xml2) References(out *bytes.Buffer, citations map[string]*citatio
 		options.sectionLevel--
 	}
 	switch options.docLevel {
-	case _DOC_FRONT_MATTER:
+	case DOC_FRONT_MATTER:
 		out.WriteString("</front>\n")
 		out.WriteString("<back>\n")
-	case _DOC_MAIN_MATTER:
+	case DOC_MAIN_MATTER:
 		out.WriteString("</middle>\n")
 		out.WriteString("<back>\n")
-	case _DOC_BACK_MATTER:
+	case DOC_BACK_MATTER:
 		// nothing to do
 	}
-	options.docLevel = _DOC_BACK_MATTER
+	options.docLevel = DOC_BACK_MATTER
 
 	keys := []string{}
 	refi, refn, keys := countCitationsAndSort(citations)
@@ -592,7 +646,7 @@ func (options *xml2) References(out *bytes.Buffer, citations map[string]*citatio
 						out.WriteByte('\n')
 						continue
 					}
-					f := referenceFile(c)
+					f := referenceFile(options.p, c)
 					out.WriteString("<?rfc include=\"" + f + "\"?>\n")
 				}
 			}
@@ -609,7 +663,7 @@ func (options *xml2) References(out *bytes.Buffer, citations map[string]*citatio
 						out.WriteByte('\n')
 						continue
 					}
-					f := referenceFile(c)
+					f := referenceFile(options.p, c)
 					out.WriteString("<?rfc include=\"" + f + "\"?>\n")
 				}
 			}
@@ -620,7 +674,7 @@ func (options *xml2) References(out *bytes.Buffer, citations map[string]*citatio
 
 func (options *xml2) AutoLink(out *bytes.Buffer, link []byte, kind int) {
 	out.WriteString("<eref target=\"")
-	if kind == _LINK_TYPE_EMAIL {
+	if kind == LINK_TYPE_EMAIL {
 		out.WriteString("mailto:")
 	}
 	out.Write(link)
@@ -727,7 +781,7 @@ func (options *xml2) RawHtmlTag(out *bytes.Buffer, tag []byte) {
 		return
 	}
 
-	printf(nil, "syntax not supported: RawHtmlTag: %s", string(tag))
+	warnf(options.p, "unsupported", "syntax not supported: RawHtmlTag: %s", string(tag))
 }
 
 func (options *xml2) TripleEmphasis(out *bytes.Buffer, text []byte) {
@@ -741,7 +795,7 @@ func (options *xml2) StrikeThrough(out *bytes.Buffer, text []byte) {
 }
 
 func (options *xml2) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
-	printf(nil, "syntax not supported: FootnoteRef")
+	options.footnotes.ref(out, id, "<xref target=\""+footnoteAnchor(id)+"\">["+strconv.Itoa(id)+"]</xref>")
 }
 
 func (options *xml2) Entity(out *bytes.Buffer, entity []byte) {
@@ -776,15 +830,22 @@ func (options *xml2) DocumentFooter(out *bytes.Buffer, first bool) {
 		out.WriteString("</section>\n")
 		options.sectionLevel--
 	}
+	defer options.footnotes.replace(out, false)
+	if options.footnotes.strategy == footnoteSection && len(options.footnotes.notes) > 0 {
+		if options.flags&XML2_STANDALONE != 0 && options.docLevel != DOC_BACK_MATTER {
+			options.DocumentMatter(out, DOC_BACK_MATTER)
+		}
+		options.footnotes.section(out, false)
+	}
 	if options.flags&XML2_STANDALONE == 0 {
 		return
 	}
 	switch options.docLevel {
-	case _DOC_FRONT_MATTER:
+	case DOC_FRONT_MATTER:
 		out.WriteString("\n</front>\n")
-	case _DOC_MAIN_MATTER:
+	case DOC_MAIN_MATTER:
 		out.WriteString("\n</middle>\n")
-	case _DOC_BACK_MATTER:
+	case DOC_BACK_MATTER:
 		out.WriteString("\n</back>\n")
 	}
 	// Only write closing rfc if it is opened in the tite block
@@ -809,17 +870,17 @@ func (options *xml2) DocumentMatter(out *bytes.Buffer, matter int) {
 		options.sectionLevel--
 	}
 	switch matter {
-	case _DOC_FRONT_MATTER:
+	case DOC_FRONT_MATTER:
 		if options.docLevel == 0 {
 			out.WriteString("<front>\n")
 		}
-	case _DOC_MAIN_MATTER:
-		if options.docLevel == _DOC_FRONT_MATTER {
+	case DOC_MAIN_MATTER:
+		if options.docLevel == DOC_FRONT_MATTER {
 			out.WriteString("\n</front>\n")
 		}
 		out.WriteString("\n<middle>\n")
-	case _DOC_BACK_MATTER:
-		if options.docLevel == _DOC_MAIN_MATTER {
+	case DOC_BACK_MATTER:
+		if options.docLevel == DOC_MAIN_MATTER {
 			out.WriteString("\n</middle>\n")
 		}
 		out.WriteString("<back>\n")
@@ -11,20 +11,24 @@ import (
 
 // XML renderer configuration options.
 const (
-	XML_STANDALONE = 1 << iota // create standalone document
+	XML_STANDALONE       = 1 << iota // create standalone document
+	XML_SOURCE_COMMENTS              // add a comment with the source position before block elements
+	XML_FOOTNOTES_CREF               // render footnotes as <cref> instead of a Notes section
+	XML_FOOTNOTES_INLINE             // render footnotes in parentheses instead of a Notes section
 )
 
 var words2119 = map[string]bool{
-	"MUST":        true,
-	"MUST NOT":    true,
-	"REQUIRED":    true,
-	"SHALL":       true,
-	"SHALL NOT":   true,
-	"SHOULD":      true,
-	"SHOULD NOT":  true,
-	"RECOMMENDED": true,
-	"MAY":         true,
-	"OPTIONAL":    true,
+	"MUST":            true,
+	"MUST NOT":        true,
+	"REQUIRED":        true,
+	"SHALL":           true,
+	"SHALL NOT":       true,
+	"SHOULD":          true,
+	"SHOULD NOT":      true,
+	"RECOMMENDED":     true,
+	"NOT RECOMMENDED": true,
+	"MAY":             true,
+	"OPTIONAL":        true,
 }
 
 // Xml is a type that implements the Renderer interface for XML2RFV3 output.
@@ -37,34 +41,70 @@ type xml struct {
 	part           bool // parts cannot nest, if true a part has been opened
 	specialSection int
 	para           bool // when true we're in a para, artworks need to close it first then.
+	paraStart      int  // offset of the text of the current para
+	paraDone       bool // the para was replaced by display math and is already closed
 
 	// Store the IAL we see for this block element
-	ial *inlineAttr
+	ial *Attributes
 
 	// TitleBlock in TOML
-	titleBlock *title
+	titleBlock *Title
+
+	// parser that drives this renderer, used for reporting problems
+	p *parser
+
+	// source position comment for the next block element, see XML_SOURCE_COMMENTS
+	source string
+
+	// footnotes, see XML_FOOTNOTES_*
+	footnotes *footnotes
 }
 
 // XmlRenderer creates and configures a Xml object, which
 // satisfies the Renderer interface.
 //
 // flags is a set of XML_* options ORed together
-func XmlRenderer(flags int) Renderer { return &xml{flags: flags} }
-func (options *xml) Flags() int      { return options.flags }
-func (options *xml) State() int      { return 0 }
+func XmlRenderer(flags int) Renderer {
+	strategy := footnoteSection
+	switch {
+	case flags&XML_FOOTNOTES_CREF != 0:
+		strategy = footnoteCref
+	case flags&XML_FOOTNOTES_INLINE != 0:
+		strategy = footnoteInline
+	}
+	return &xml{flags: flags, footnotes: newFootnotes(strategy)}
+}
+func (options *xml) Flags() int { return options.flags }
+func (options *xml) State() int { return 0 }
+
+func (options *xml) setParser(p *parser) { options.p = p }
 
-func (options *xml) SetAttr(i *inlineAttr) {
+func (options *xml) Source(file string, line int) {
+	options.source = ""
+	if options.flags&XML_SOURCE_COMMENTS != 0 && line > 0 {
+		options.source = sourceComment(file, line)
+	}
+}
+
+// writeSource writes the source position comment for the block element being
+// rendered.
+func (options *xml) writeSource(out *bytes.Buffer) {
+	out.WriteString(options.source)
+	options.source = ""
+}
+
+func (options *xml) SetAttr(i *Attributes) {
 	options.ial = i
 }
 
-func (options *xml) Attr() *inlineAttr {
+func (options *xml) Attr() *Attributes {
 	if options.ial == nil {
-		return newInlineAttr()
+		return NewAttributes()
 	}
 	return options.ial
 }
 
-func (options *xml) AttrString(i *inlineAttr) string {
+func (options *xml) AttrString(i *Attributes) string {
 	if i == nil {
 		return ""
 	}
@@ -97,6 +137,7 @@ func (options *xml) BlockCode(out *bytes.Buffer, text []byte, lang string, capti
 		out.WriteString("</t>")
 		defer out.WriteString("<t>")
 	}
+	options.writeSource(out)
 
 	// Tick of language for sourcecode...
 	ial := options.Attr()
@@ -137,14 +178,14 @@ func (options *xml) BlockCode(out *bytes.Buffer, text []byte, lang string, capti
 }
 
 func (options *xml) CalloutCode(out *bytes.Buffer, index, id string) {
-	printf(nil, "TODO implement: CalloutCode")
+	warnf(options.p, "unsupported", "TODO implement: CalloutCode")
 }
 
 func (options *xml) CalloutText(out *bytes.Buffer, index string, id []string) {
-	printf(nil, "TODO implement: CalloutText")
+	warnf(options.p, "unsupported", "TODO implement: CalloutText")
 }
 
-func (options *xml) TitleBlockTOML(out *bytes.Buffer, block *title) {
+func (options *xml) TitleBlockTOML(out *bytes.Buffer, block *Title) {
 	if options.flags&XML_STANDALONE == 0 {
 		return
 	}
@@ -153,7 +194,7 @@ func (options *xml) TitleBlockTOML(out *bytes.Buffer, block *title) {
 	out.WriteString("<rfc xmlns:xi=\"http://www.w3.org/2001/XInclude\"")
 	out.WriteString(" ipr=\"" + options.titleBlock.Ipr + "\"")
 	out.WriteString(" category=\"" + options.titleBlock.Category + "\"")
-	out.WriteString(" docName=\"" + options.titleBlock.DocName + "\">")
+	out.WriteString(" docName=\"" + options.titleBlock.DocName + "\"")
 	if len(options.titleBlock.Updates) > 0 {
 		updates := make([]string, len(options.titleBlock.Updates))
 		for i := range updates {
@@ -168,7 +209,7 @@ func (options *xml) TitleBlockTOML(out *bytes.Buffer, block *title) {
 		}
 		out.WriteString(" obsoletes=\"" + strings.Join(obsoletes, ", ") + "\"")
 	}
-	out.WriteString("\n")
+	out.WriteString(">\n")
 	out.WriteString("<front>\n")
 	out.WriteString("<title abbrev=\"" + options.titleBlock.Abbrev + "\">")
 	out.WriteString(options.titleBlock.Title + "</title>\n\n")
@@ -200,6 +241,7 @@ func (options *xml) BlockQuote(out *bytes.Buffer, text []byte, attribution []byt
 		}
 	}
 
+	options.writeSource(out)
 	out.WriteString("<blockquote" + options.AttrString(ial) + ">\n")
 	out.Write(text)
 	out.WriteString("</blockquote>\n")
@@ -208,6 +250,7 @@ func (options *xml) BlockQuote(out *bytes.Buffer, text []byte, attribution []byt
 func (options *xml) Aside(out *bytes.Buffer, text []byte) {
 	ial := options.Attr()
 	s := options.AttrString(ial)
+	options.writeSource(out)
 	out.WriteString("<aside" + s + ">\n")
 	out.Write(text)
 	out.WriteString("</aside>\n")
@@ -255,7 +298,7 @@ func (options *xml) BlockHtml(out *bytes.Buffer, text []byte) {
 }
 
 func (options *xml) Part(out *bytes.Buffer, text func() bool, id string) {
-	printf(nil, "syntax not supported: Part")
+	warnf(options.p, "unsupported", "syntax not supported: Part")
 }
 
 func (options *xml) Note(out *bytes.Buffer, text func() bool, id string) {
@@ -286,7 +329,7 @@ func (options *xml) Note(out *bytes.Buffer, text func() bool, id string) {
 
 func (options *xml) SpecialHeader(out *bytes.Buffer, what []byte, text func() bool, id string) {
 	if string(what) == "preface" {
-		printf(nil, "handling preface like abstract")
+		infof(options.p, "section", "handling preface like abstract")
 		what = []byte("abstract")
 	}
 	switch options.specialSection {
@@ -329,7 +372,9 @@ func (options *xml) Header(out *bytes.Buffer, text func() bool, level int, id st
 	ial.GetOrDefaultId(id)
 
 	// new section
-	out.WriteString("\n<section" + options.AttrString(ial) + ">\n")
+	out.WriteString("\n")
+	options.writeSource(out)
+	out.WriteString("<section" + options.AttrString(ial) + ">\n")
 	out.WriteString("<name>")
 	text()
 	out.WriteString("</name>\n")
@@ -339,7 +384,7 @@ func (options *xml) Header(out *bytes.Buffer, text func() bool, level int, id st
 }
 
 func (options *xml) HRule(out *bytes.Buffer) {
-	printf(nil, "syntax not supported: HRule")
+	warnf(options.p, "unsupported", "syntax not supported: HRule")
 }
 
 func (options *xml) List(out *bytes.Buffer, text func() bool, flags, start int, group []byte) {
@@ -355,17 +400,17 @@ func (options *xml) List(out *bytes.Buffer, text func() bool, flags, start int,
 		ial.GetOrDefaultAttr("group", string(group))
 	}
 
-	if flags&_LIST_TYPE_ORDERED != 0 {
+	if flags&LIST_TYPE_ORDERED != 0 {
 		switch {
-		case flags&_LIST_TYPE_ORDERED_ALPHA_LOWER != 0:
+		case flags&LIST_TYPE_ORDERED_ALPHA_LOWER != 0:
 			ial.GetOrDefaultAttr("type", "%c")
-		case flags&_LIST_TYPE_ORDERED_ALPHA_UPPER != 0:
+		case flags&LIST_TYPE_ORDERED_ALPHA_UPPER != 0:
 			ial.GetOrDefaultAttr("type", "%C")
-		case flags&_LIST_TYPE_ORDERED_ROMAN_LOWER != 0:
+		case flags&LIST_TYPE_ORDERED_ROMAN_LOWER != 0:
 			ial.GetOrDefaultAttr("type", "%i")
-		case flags&_LIST_TYPE_ORDERED_ROMAN_UPPER != 0:
+		case flags&LIST_TYPE_ORDERED_ROMAN_UPPER != 0:
 			ial.GetOrDefaultAttr("type", "%I")
-		case flags&_LIST_TYPE_ORDERED_GROUP != 0:
+		case flags&LIST_TYPE_ORDERED_GROUP != 0:
 			// ? TODO(miek)
 			// Handled above, don't need to do anything because v3 format will take care of this.
 		}
@@ -373,10 +418,11 @@ func (options *xml) List(out *bytes.Buffer, text func() bool, flags, start int,
 
 	s := options.AttrString(ial)
 
+	options.writeSource(out)
 	switch {
-	case flags&_LIST_TYPE_ORDERED != 0:
+	case flags&LIST_TYPE_ORDERED != 0:
 		out.WriteString("<ol" + s + ">\n")
-	case flags&_LIST_TYPE_DEFINITION != 0:
+	case flags&LIST_TYPE_DEFINITION != 0:
 		out.WriteString("<dl" + s + ">\n")
 	default:
 		out.WriteString("<ul" + s + ">\n")
@@ -387,9 +433,9 @@ func (options *xml) List(out *bytes.Buffer, text func() bool, flags, start int,
 		return
 	}
 	switch {
-	case flags&_LIST_TYPE_ORDERED != 0:
+	case flags&LIST_TYPE_ORDERED != 0:
 		out.WriteString("</ol>\n")
-	case flags&_LIST_TYPE_DEFINITION != 0:
+	case flags&LIST_TYPE_DEFINITION != 0:
 		out.WriteString("</dl>\n")
 	default:
 		out.WriteString("</ul>\n")
@@ -397,13 +443,13 @@ func (options *xml) List(out *bytes.Buffer, text func() bool, flags, start int,
 }
 
 func (options *xml) ListItem(out *bytes.Buffer, text []byte, flags int) {
-	if flags&_LIST_TYPE_DEFINITION != 0 && flags&_LIST_TYPE_TERM == 0 {
+	if flags&LIST_TYPE_DEFINITION != 0 && flags&LIST_TYPE_TERM == 0 {
 		out.WriteString("<dd>")
 		out.Write(text)
 		out.WriteString("</dd>\n")
 		return
 	}
-	if flags&_LIST_TYPE_TERM != 0 {
+	if flags&LIST_TYPE_TERM != 0 {
 		out.WriteString("<dt>")
 		out.Write(text)
 		out.WriteString("</dt>\n")
@@ -422,28 +468,77 @@ func (options *xml) Example(out *bytes.Buffer, index int) {
 
 func (options *xml) Paragraph(out *bytes.Buffer, text func() bool, flags int) {
 	marker := out.Len()
+	options.writeSource(out)
+	start := out.Len()
 	options.para = true
 	defer func() { options.para = false }()
 	out.WriteString("<t>\n")
+	options.paraStart = out.Len()
+	options.paraDone = false
+	options.footnotes.pending = nil
 	if !text() {
 		out.Truncate(marker)
 		return
 	}
-	if marker+3 == out.Len() { // empty paragraph, suppress
+	if options.paraDone {
+		return
+	}
+	if start+3 == out.Len() { // empty paragraph, suppress
 		out.Truncate(marker)
 		return
 	}
 	out.WriteByte('\n')
 	out.WriteString("</t>\n")
+	options.footnotes.paragraph(out, start+len("<t"))
 }
 
+// Math renders inline math as text in <tt>. Display math becomes an ascii-art
+// artwork, or an svg one when the MathSVG option is set.
 func (options *xml) Math(out *bytes.Buffer, text []byte, display bool) {
-	printf(nil, "syntax not supported: Math")
+	ascii := texToText(text)
+	if !display {
+		out.WriteString("<tt>")
+		writeEntity(out, []byte(ascii))
+		out.WriteString("</tt>")
+		return
+	}
+
+	if options.para {
+		if out.Len() == options.paraStart {
+			// the math is the entire paragraph, replace the <t>
+			out.Truncate(options.paraStart - len("<t>\n"))
+			options.paraDone = true
+		} else {
+			out.WriteString("</t>\n")
+			defer out.WriteString("<t>")
+		}
+	}
+
+	ial := options.Attr()
+	if options.p != nil && options.p.opts.MathSVG != nil {
+		svg, err := options.p.opts.MathSVG.SVG(text, display)
+		if err == nil {
+			ial.GetOrDefaultAttr("type", "svg")
+			var alt bytes.Buffer
+			attrEscape(&alt, []byte(ascii))
+			ial.GetOrDefaultAttr("alt", alt.String())
+			out.WriteString("<artwork" + options.AttrString(ial) + ">\n")
+			out.Write(stripXMLDeclaration(svg))
+			out.WriteString("\n</artwork>\n")
+			return
+		}
+		warnf(options.p, "math", "failed to render math to SVG: %s", err)
+	}
+	ial.GetOrDefaultAttr("type", "ascii-art")
+	out.WriteString("<artwork" + options.AttrString(ial) + ">\n")
+	writeEntity(out, []byte(ascii))
+	out.WriteString("\n</artwork>\n")
 }
 
 func (options *xml) Table(out *bytes.Buffer, header []byte, body []byte, footer []byte, columnData []int, caption []byte) {
 	ial := options.Attr()
 	s := options.AttrString(ial)
+	options.writeSource(out)
 	out.WriteString("<table" + s + ">\n")
 	if caption != nil {
 		out.WriteString("<name>")
@@ -474,9 +569,9 @@ func (options *xml) TableHeaderCell(out *bytes.Buffer, text []byte, align, colsp
 	}
 
 	switch align {
-	case _TABLE_ALIGNMENT_LEFT:
+	case TABLE_ALIGNMENT_LEFT:
 		a += " align=\"left\""
-	case _TABLE_ALIGNMENT_RIGHT:
+	case TABLE_ALIGNMENT_RIGHT:
 		a += " align=\"right\""
 	default:
 		a += " align=\"center\""
@@ -496,21 +591,33 @@ func (options *xml) TableCell(out *bytes.Buffer, text []byte, align, colspan int
 	out.WriteString("</td>")
 }
 
+// Footnotes collects the notes, they are written out in DocumentFooter.
 func (options *xml) Footnotes(out *bytes.Buffer, text func() bool) {
-	printf(nil, "syntax not supported: Footnotes")
+	text()
 }
 
 func (options *xml) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int) {
-	printf(nil, "syntax not supported: FootnoteItem")
+	options.footnotes.item(text, flags)
 }
 
-func (options *xml) Index(out *bytes.Buffer, primary, secondary []byte, prim bool) {
-	p := ""
-	if prim {
-		p = " primary=\"true\""
+// Index writes an iref, entries that only refer to another entry with "see"
+// are not supported by xml2rfc and are dropped.
+func (options *xml) Index(out *bytes.Buffer, entry IndexEntry) {
+	if len(entry.See) > 0 {
+		return
+	}
+	out.WriteString("<iref item=\"")
+	attrEscape(out, entry.Primary)
+	out.WriteString("\"")
+	if entry.Prim {
+		out.WriteString(" primary=\"true\"")
 	}
-	out.WriteString("<iref item=\"" + string(primary) + "\"" + p)
-	out.WriteString(" subitem=\"" + string(secondary) + "\"" + "/>")
+	if subitem := indexSubitem(entry); len(subitem) > 0 {
+		out.WriteString(" subitem=\"")
+		attrEscape(out, subitem)
+		out.WriteString("\"")
+	}
+	out.WriteString("/>")
 }
 
 func (options *xml) Citation(out *bytes.Buffer, link, title []byte) {
@@ -521,7 +628,7 @@ func (options *xml) Citation(out *bytes.Buffer, link, title []byte) {
 	out.WriteString("<xref target=\"" + string(link) + "\" section=\"" + string(title) + "\"/>")
 }
 
-func (options *xml) References(out *bytes.Buffer, citations map[string]*citation) {
+func (options *xml) References(out *bytes.Buffer, citations map[string]*Citation) {
 	if options.flags&XML_STANDALONE == 0 {
 		return
 	}
@@ -531,16 +638,16 @@ func (options *xml) References(out *bytes.Buffer, citations map[string]*citation
 		options.sectionLevel--
 	}
 	switch options.docLevel {
-	case _DOC_FRONT_MATTER:
+	case DOC_FRONT_MATTER:
 		out.WriteString("</front>\n")
 		out.WriteString("<back>\n")
-	case _DOC_MAIN_MATTER:
+	case DOC_MAIN_MATTER:
 		out.WriteString("</middle>\n")
 		out.WriteString("<back>\n")
-	case _DOC_BACK_MATTER:
+	case DOC_BACK_MATTER:
 		// nothing to do
 	}
-	options.docLevel = _DOC_BACK_MATTER
+	options.docLevel = DOC_BACK_MATTER
 
 	refi, refn, keys := countCitationsAndSort(citations)
 
@@ -558,7 +665,7 @@ func (options *xml) References(out *bytes.Buffer, citations map[string]*citation
 						out.WriteByte('\n')
 						continue
 					}
-					f := referenceFile(c)
+					f := referenceFile(options.p, c)
 					out.WriteString("<xi:include href=\"" + f + "\"/>\n")
 				}
 			}
@@ -577,7 +684,7 @@ func (options *xml) References(out *bytes.Buffer, citations map[string]*citation
 						out.WriteByte('\n')
 						continue
 					}
-					f := referenceFile(c)
+					f := referenceFile(options.p, c)
 					out.WriteString("<xi:include href=\"" + f + "\"/>\n")
 				}
 			}
@@ -588,7 +695,7 @@ func (options *xml) References(out *bytes.Buffer, citations map[string]*citation
 
 func (options *xml) AutoLink(out *bytes.Buffer, link []byte, kind int) {
 	out.WriteString("<eref target=\"")
-	if kind == _LINK_TYPE_EMAIL {
+	if kind == LINK_TYPE_EMAIL {
 		out.WriteString("mailto:")
 	}
 	out.Write(link)
@@ -637,6 +744,7 @@ func (options *xml) Figure(out *bytes.Buffer, text []byte, caption []byte) {
 	// add figure and typeset the caption
 	ial := options.Attr()
 	s := options.AttrString(ial)
+	options.writeSource(out)
 	out.WriteString("<figure" + s + ">\n")
 	out.WriteString("<name>")
 	out.Write(caption)
@@ -706,7 +814,7 @@ func (options *xml) RawHtmlTag(out *bytes.Buffer, tag []byte) {
 		out.WriteString("<vspace/>")
 		return
 	}
-	printf(nil, "syntax not supported: RawHtmlTag: %s", string(tag))
+	warnf(options.p, "unsupported", "syntax not supported: RawHtmlTag: %s", string(tag))
 }
 
 func (options *xml) TripleEmphasis(out *bytes.Buffer, text []byte) {
@@ -720,7 +828,7 @@ func (options *xml) StrikeThrough(out *bytes.Buffer, text []byte) {
 }
 
 func (options *xml) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
-	printf(nil, "syntax not supported: FootnoteRef")
+	options.footnotes.ref(out, id, "<xref target=\""+footnoteAnchor(id)+"\" format=\"none\">["+strconv.Itoa(id)+"]</xref>")
 }
 
 func (options *xml) Entity(out *bytes.Buffer, entity []byte) {
@@ -754,15 +862,22 @@ func (options *xml) DocumentFooter(out *bytes.Buffer, first bool) {
 		out.WriteString("</section>\n")
 		options.sectionLevel--
 	}
+	defer options.footnotes.replace(out, true)
+	if options.footnotes.strategy == footnoteSection && len(options.footnotes.notes) > 0 {
+		if options.flags&XML_STANDALONE != 0 && options.docLevel != DOC_BACK_MATTER {
+			options.DocumentMatter(out, DOC_BACK_MATTER)
+		}
+		options.footnotes.section(out, true)
+	}
 	if options.flags&XML_STANDALONE == 0 {
 		return
 	}
 	switch options.docLevel {
-	case _DOC_FRONT_MATTER:
+	case DOC_FRONT_MATTER:
 		out.WriteString("\n</front>\n")
-	case _DOC_MAIN_MATTER:
+	case DOC_MAIN_MATTER:
 		out.WriteString("\n</middle>\n")
-	case _DOC_BACK_MATTER:
+	case DOC_BACK_MATTER:
 		out.WriteString("\n</back>\n")
 	}
 	out.WriteString("</rfc>\n")
@@ -784,12 +899,12 @@ func (options *xml) DocumentMatter(out *bytes.Buffer, matter int) {
 		options.sectionLevel--
 	}
 	switch matter {
-	case _DOC_FRONT_MATTER:
+	case DOC_FRONT_MATTER:
 		// already open
-	case _DOC_MAIN_MATTER:
+	case DOC_MAIN_MATTER:
 		out.WriteString("</front>\n")
 		out.WriteString("\n<middle>\n")
-	case _DOC_BACK_MATTER:
+	case DOC_BACK_MATTER:
 		out.WriteString("\n</middle>\n")
 		out.WriteString("<back>\n")
 	}
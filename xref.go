@@ -0,0 +1,114 @@
+// Anchors and the cross references to them.
+
+package mmark
+
+import (
+	"strconv"
+)
+
+// xrefs collects the anchors of a document and the references to them, they
+// are checked after the document has been parsed.
+type xrefs struct {
+	anchors map[string]Diagnostic // where each anchor is defined
+	refs    []xref
+}
+
+// xref is a reference to an anchor, or to an example when example is true.
+type xref struct {
+	target  string
+	example bool
+	at      Diagnostic
+}
+
+// here returns a diagnostic at the current position.
+func (p *parser) here() Diagnostic {
+	d := Diagnostic{Code: "xref", Severity: SEVERITY_WARNING}
+	if p.opts.Strict {
+		d.Severity = SEVERITY_ERROR
+	}
+	d.File, d.Line, d.Column = p.position()
+	return d
+}
+
+// defineAnchor records the anchor id and reports it when it is already
+// defined.
+func (p *parser) defineAnchor(id string) {
+	if id == "" {
+		return
+	}
+	if p.xrefs.anchors == nil {
+		p.xrefs.anchors = make(map[string]Diagnostic)
+	}
+	d := p.here()
+	if first, ok := p.xrefs.anchors[id]; ok {
+		file := first.File
+		if file == "" {
+			file = "<input>"
+		}
+		d.Message = "duplicate anchor `" + id + "', first defined at " + sourcePosition(file, first.Line)
+		p.diagnose(d)
+		return
+	}
+	p.xrefs.anchors[id] = d
+}
+
+// headerAnchor returns the anchor of a header with id, with a sequence number
+// added when EXTENSION_UNIQUE_HEADER_IDS is set and the id is already used by
+// another header. Explicit tells if the id was given with {#id}, only those
+// are reported when they are not unique.
+func (p *parser) headerAnchor(id string, explicit bool) string {
+	if id == "" {
+		return ""
+	}
+	anchor := id
+	if v, ok := p.anchors[id]; ok && p.flags&EXTENSION_UNIQUE_HEADER_IDS != 0 {
+		p.anchors[id]++
+		// anchor found
+		anchor += "-" + strconv.Itoa(v)
+	} else {
+		p.anchors[id] = 1
+	}
+	// an IAL #id replaces the anchor of the header
+	if p.ial == nil || p.ial.id == "" {
+		if explicit {
+			p.defineAnchor(id)
+		} else {
+			p.defineAnchor(anchor)
+		}
+	}
+	return anchor
+}
+
+// referTo records a reference to the anchor target, or to an example. An empty
+// target, like in [text](#), refers to the top of the page and is not checked.
+func (p *parser) referTo(target string, example bool) {
+	if target == "" {
+		return
+	}
+	p.xrefs.refs = append(p.xrefs.refs, xref{target: target, example: example, at: p.here()})
+}
+
+// checkReferences reports the references to anchors that are not defined.
+// Citations are anchors as well.
+func (p *parser) checkReferences() {
+	for _, r := range p.xrefs.refs {
+		d := r.at
+		switch {
+		case r.example:
+			if _, ok := p.examples[r.target]; ok {
+				d.Message = "example `(@" + r.target + ")' is referenced before it is defined"
+			} else {
+				d.Message = "example `(@" + r.target + ")' is not defined"
+			}
+		default:
+			if _, ok := p.xrefs.anchors[r.target]; ok {
+				continue
+			}
+			if _, ok := p.citations[r.target]; ok {
+				continue
+			}
+			d.Message = "reference to unknown anchor `" + r.target + "'"
+		}
+		p.diagnose(d)
+	}
+}
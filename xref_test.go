@@ -0,0 +1,82 @@
+// Unit tests for checking cross references
+
+package mmark
+
+import (
+	"testing"
+)
+
+func TestCheckReferences(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected []string
+	}{
+		{
+			"# Intro\n\nSee (#intro), [here](#fig) and (#RFC2119) [@RFC2119].\n\n{#fig}\n    art\n\n(@good)  example\n\nAs (@good).\n",
+			nil,
+		},
+		{
+			"# Intro\n\nSee (#nope) and [there](#gone).\n",
+			[]string{
+				"<input>:3:5: reference to unknown anchor `nope'",
+				"<input>:3:17: reference to unknown anchor `gone'",
+			},
+		},
+		{
+			"# Intro\n\n# Intro\n\n# Other {#intro}\n\n{#intro-1}\nText.\n",
+			[]string{
+				"<input>:5:1: duplicate anchor `intro', first defined at <input>:1",
+				"<input>:7:1: duplicate anchor `intro-1', first defined at <input>:3",
+			},
+		},
+		{
+			// the top of the page
+			"# Intro\n\nBack to [top](#).\n",
+			nil,
+		},
+		{
+			// an IAL id replaces the one of the header
+			"{#a}\n# Intro\n\n# Intro\n",
+			nil,
+		},
+		{
+			"See (@later) and (@never).\n\n(@later)  example\n",
+			[]string{
+				"<input>:1:5: example `(@later)' is referenced before it is defined",
+				"<input>:1:18: example `(@never)' is not defined",
+			},
+		},
+	}
+	for i, test := range tests {
+		opts := Options{Extensions: commonXmlExtensions | EXTENSION_HEADER_IDS}
+		var diags []Diagnostic
+		for _, d := range ParseWithOptions([]byte(test.input), XmlRenderer(0), opts).Diagnostics {
+			if d.Code == "xref" {
+				diags = append(diags, d)
+			}
+		}
+		if len(diags) != len(test.expected) {
+			t.Errorf("test %d: expected %d diagnostics, got %d: %v", i, len(test.expected), len(diags), diags)
+			continue
+		}
+		for j, d := range diags {
+			if d.String() != test.expected[j] || d.Severity != SEVERITY_WARNING {
+				t.Errorf("test %d: expected %q, got %q (%s)", i, test.expected[j], d.String(), d.Severity)
+			}
+		}
+	}
+
+	// without example lists (@ex1) is text
+	opts := Options{Extensions: commonXmlExtensions &^ EXTENSION_EXAMPLE_LISTS, Strict: true}
+	for _, d := range ParseWithOptions([]byte("(@ex1) example\n"), XmlRenderer(0), opts).Diagnostics {
+		if d.Code == "xref" {
+			t.Errorf("unexpected diagnostic without example lists: %v", d)
+		}
+	}
+
+	opts = Options{Extensions: commonXmlExtensions, Strict: true}
+	diags := ParseWithOptions([]byte("See (#nope).\n"), XmlRenderer(0), opts).Diagnostics
+	if len(diags) != 1 || diags[0].Severity != SEVERITY_ERROR {
+		t.Errorf("expected an error in strict mode, got %v", diags)
+	}
+}